@@ -1,18 +1,22 @@
 module github.com/leandrosilva/mercurio
 
-go 1.14
+go 1.16
 
 require (
 	github.com/auth0/go-jwt-middleware v1.0.0
 	github.com/codegangsta/negroni v1.0.0 // indirect
 	github.com/form3tech-oss/jwt-go v3.2.2+incompatible
+	github.com/go-sql-driver/mysql v1.5.0
 	github.com/google/uuid v1.2.0
 	github.com/gorilla/mux v1.8.0
 	github.com/joho/godotenv v1.3.0
 	github.com/mattn/go-sqlite3 v1.14.6 // indirect
 	github.com/rs/cors v1.7.0
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.0
 	github.com/streadway/amqp v1.0.0
 	github.com/urfave/negroni v1.0.0
+	gorm.io/driver/mysql v1.0.4
+	gorm.io/driver/postgres v1.0.8
 	gorm.io/driver/sqlite v1.1.4
 	gorm.io/gorm v1.20.12
 )
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AdminAPI is the HTTP interface for administrative tasks, which are only reachable by administrators
+type AdminAPI struct {
+	Broker      *Broker
+	APIKeys     APIKeyRepository
+	Revocations RevocationRepository
+	Audit       AuditRepository
+	EventTypes  EventTypeRepository
+	Templates   NotificationTemplateRepository
+}
+
+// NewAdminAPI creates an instance of the AdminAPI
+func NewAdminAPI(broker *Broker, apiKeys APIKeyRepository, revocations RevocationRepository, audit AuditRepository, eventTypes EventTypeRepository, templates NotificationTemplateRepository) (api AdminAPI) {
+	api = AdminAPI{
+		Broker:      broker,
+		APIKeys:     apiKeys,
+		Revocations: revocations,
+		Audit:       audit,
+		EventTypes:  eventTypes,
+		Templates:   templates,
+	}
+	return
+}
+
+type createAPIKeyRequest struct {
+	TenantID string   `json:"tenantID"`
+	Name     string   `json:"name"`
+	SourceID string   `json:"sourceID"`
+	Scopes   []string `json:"scopes"`
+}
+
+type createAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKeyHandler creates a new API key and responds with its plain value, which won't be seen again
+func (api *AdminAPI) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var request createAPIKeyRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	// Unless told otherwise, the key belongs to the same tenant of the administrator
+	if request.TenantID == "" {
+		request.TenantID = authorizedTenant(r).ID
+	}
+
+	apiKey, plainKey, err := NewAPIKey(request.TenantID, request.Name, request.SourceID, request.Scopes)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	err = api.APIKeys.Add(apiKey)
+	if err != nil {
+		respondWithInternalServerError(w, err.Error())
+		return
+	}
+
+	log.Printf("Created API key %d (%s) for source %s of tenant %s", apiKey.ID, apiKey.Name, apiKey.SourceID, apiKey.TenantID)
+
+	recordAudit(api.Audit, r, AuditActionCreateAPIKey, strconv.Itoa(int(apiKey.ID)), map[string]interface{}{
+		"tenantID": apiKey.TenantID,
+		"name":     apiKey.Name,
+		"sourceID": apiKey.SourceID,
+		"scopes":   apiKey.Scopes,
+	})
+
+	response := createAPIKeyResponse{
+		APIKey: *apiKey,
+		Key:    plainKey,
+	}
+
+	respondWithCreated(w, response)
+}
+
+type apiKeysResponse struct {
+	APIKeys []APIKey `json:"apiKeys"`
+}
+
+// GetAPIKeysHandler responds with all API keys, revoked ones included, but never their secrets
+func (api *AdminAPI) GetAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	apiKeys, err := api.APIKeys.GetAll()
+	if err != nil {
+		respondWithInternalServerError(w, err.Error())
+		return
+	}
+
+	response := apiKeysResponse{
+		APIKeys: apiKeys,
+	}
+
+	respondWithSuccess(w, response)
+}
+
+// RevokeAPIKeyHandler revokes an API key by its id, so it can't be used anymore
+func (api *AdminAPI) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	apiKeyID, _ := strconv.Atoi(vars["apiKeyID"])
+
+	apiKey, err := RevokeAPIKey(api.APIKeys, uint(apiKeyID))
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			respondWithNotFound(w, err.Error())
+			return
+		}
+		respondWithInternalServerError(w, err.Error())
+		return
+	}
+
+	log.Printf("Revoked API key %d (%s)", apiKey.ID, apiKey.Name)
+
+	recordAudit(api.Audit, r, AuditActionRevokeAPIKey, strconv.Itoa(int(apiKey.ID)), nil)
+
+	respondWithSuccess(w, apiKey)
+}
+
+type createRevocationRequest struct {
+	TenantID  string     `json:"tenantID"`
+	JTI       string     `json:"jti"`
+	Subject   string     `json:"subject"`
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// CreateRevocationHandler revokes a token (by jti) or every token of a subject (by user_id) and kills any open stream
+// session of either on every service node
+func (api *AdminAPI) CreateRevocationHandler(w http.ResponseWriter, r *http.Request) {
+	var request createRevocationRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	// Unless told otherwise, the revocation is for the same tenant of the administrator
+	if request.TenantID == "" {
+		request.TenantID = authorizedTenant(r).ID
+	}
+
+	revocation, err := NewRevocation(request.TenantID, request.JTI, request.Subject, request.Reason, request.ExpiresAt)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	// Takes the chance to get rid of revocations that are no longer of any use
+	err = api.Revocations.DeleteExpired(time.Now())
+	if err != nil {
+		log.Printf("Failed to delete expired revocations due to: %s", err)
+	}
+
+	err = api.Revocations.Add(revocation)
+	if err != nil {
+		respondWithInternalServerError(w, err.Error())
+		return
+	}
+
+	log.Printf("Revoked tokens of jti '%s' / subject '%s' of tenant %s until %s", revocation.JTI, revocation.Subject, revocation.TenantID, revocation.ExpiresAt)
+
+	recordAudit(api.Audit, r, AuditActionRevokeToken, strconv.Itoa(int(revocation.ID)), map[string]interface{}{
+		"tenantID":  revocation.TenantID,
+		"jti":       revocation.JTI,
+		"subject":   revocation.Subject,
+		"reason":    revocation.Reason,
+		"expiresAt": revocation.ExpiresAt,
+	})
+
+	if revocation.JTI != "" {
+		err = api.Broker.DisconnectToken(revocation.TenantID, revocation.JTI)
+		if err != nil {
+			respondWithInternalServerError(w, err.Error())
+			return
+		}
+	}
+
+	if revocation.Subject != "" {
+		err = api.Broker.DisconnectClient(revocation.TenantID, revocation.Subject)
+		if err != nil {
+			respondWithInternalServerError(w, err.Error())
+			return
+		}
+	}
+
+	respondWithCreated(w, revocation)
+}
+
+type revocationsResponse struct {
+	Revocations []Revocation `json:"revocations"`
+}
+
+// GetRevocationsHandler responds with revocations still in effect
+func (api *AdminAPI) GetRevocationsHandler(w http.ResponseWriter, r *http.Request) {
+	revocations, err := api.Revocations.GetActive(time.Now())
+	if err != nil {
+		respondWithInternalServerError(w, err.Error())
+		return
+	}
+
+	response := revocationsResponse{
+		Revocations: revocations,
+	}
+
+	respondWithSuccess(w, response)
+}
+
+type auditResponse struct {
+	Entries []AuditEntry `json:"entries"`
+}
+
+// GetAuditHandler responds with the audit trail, filtered by the optional query strings tenantID, action, actorID,
+// since, until (both RFC 3339) and limit. With format=jsonl it is exported as JSON Lines, one entry per line
+func (api *AdminAPI) GetAuditHandler(w http.ResponseWriter, r *http.Request) {
+	filter := AuditFilter{
+		TenantID: r.FormValue("tenantID"),
+		Action:   r.FormValue("action"),
+		ActorID:  r.FormValue("actorID"),
+	}
+
+	since, err := parseOptionalTime(r.FormValue("since"))
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+	filter.Since = since
+
+	until, err := parseOptionalTime(r.FormValue("until"))
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+	filter.Until = until
+
+	if limit := r.FormValue("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 0 {
+			respondWithBadRequest(w, fmt.Sprintf("%s is not a valid limit", limit))
+			return
+		}
+		filter.Limit = parsed
+	}
+
+	format := r.FormValue("format")
+	if format != "" && format != "json" && format != "jsonl" {
+		respondWithBadRequest(w, fmt.Sprintf("%s is not a valid format", format))
+		return
+	}
+
+	entries, err := api.Audit.Find(filter)
+	if err != nil {
+		respondWithInternalServerError(w, err.Error())
+		return
+	}
+
+	if format == "jsonl" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"audit.jsonl\"")
+		w.WriteHeader(http.StatusOK)
+
+		encoder := json.NewEncoder(w)
+		for _, entry := range entries {
+			err := encoder.Encode(entry)
+			if err != nil {
+				log.Printf("Failed to export audit entry %d due to: %s", entry.ID, err)
+				return
+			}
+		}
+		return
+	}
+
+	response := auditResponse{
+		Entries: entries,
+	}
+
+	respondWithSuccess(w, response)
+}
+
+func parseOptionalTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid RFC 3339 time", value)
+	}
+
+	return &parsed, nil
+}
+
+type clientUsageResponse struct {
+	TenantID         string `json:"tenantID"`
+	ClientID         string `json:"clientID"`
+	Notifications    int64  `json:"notifications"`
+	DataSize         int64  `json:"dataSize"`
+	MaxNotifications int    `json:"maxNotifications,omitempty"`
+	MaxDataSize      int    `json:"maxDataSize,omitempty"`
+	Policy           string `json:"policy,omitempty"`
+}
+
+// GetClientUsageHandler responds with how much of the datastore a client takes, next to the quota it is capped at.
+// The client is of the same tenant of the administrator, unless the query string tenantID tells otherwise
+func (api *AdminAPI) GetClientUsageHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID := vars["clientID"]
+
+	tenantID := r.FormValue("tenantID")
+	if tenantID == "" {
+		tenantID = authorizedTenant(r).ID
+	}
+
+	usage, err := api.Broker.ClientUsage(r.Context(), tenantID, clientID)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	response := clientUsageResponse{
+		TenantID:      tenantID,
+		ClientID:      clientID,
+		Notifications: usage.Notifications,
+		DataSize:      usage.DataSize,
+	}
+
+	quota := api.Broker.Quota()
+	if quota.Enabled() {
+		response.MaxNotifications = quota.MaxNotifications
+		response.MaxDataSize = quota.MaxDataSize
+		response.Policy = quota.Policy
+	}
+
+	respondWithSuccess(w, response)
+}
+
+type saveEventTypeRequest struct {
+	TenantID    string   `json:"tenantID"`
+	Description string   `json:"description"`
+	Schema      JSONData `json:"schema"`
+}
+
+// SaveEventTypeHandler registers an event type by its name, or replaces the description and schema of the one of the
+// same name. It is of the same tenant of the administrator, unless told otherwise
+func (api *AdminAPI) SaveEventTypeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	var request saveEventTypeRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	if request.TenantID == "" {
+		request.TenantID = authorizedTenant(r).ID
+	}
+
+	eventType, err := NewEventType(name, request.Description, request.Schema)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	err = api.EventTypes.ForTenant(request.TenantID).Save(r.Context(), eventType)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	log.Printf("Saved event type %s of tenant %s", eventType.Name, eventType.TenantID)
+
+	recordAudit(api.Audit, r, AuditActionSaveEventType, eventType.Name, map[string]interface{}{
+		"tenantID":    eventType.TenantID,
+		"description": eventType.Description,
+		"schema":      eventType.Schema,
+	})
+
+	respondWithSuccess(w, eventType)
+}
+
+type eventTypesResponse struct {
+	EventTypes []EventType `json:"eventTypes"`
+}
+
+// GetEventTypesHandler responds with every event type of the same tenant of the administrator, unless the query
+// string tenantID tells otherwise
+func (api *AdminAPI) GetEventTypesHandler(w http.ResponseWriter, r *http.Request) {
+	eventTypes, err := api.EventTypes.ForTenant(eventTypeTenant(r)).GetAll(r.Context())
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	response := eventTypesResponse{
+		EventTypes: eventTypes,
+	}
+
+	respondWithSuccess(w, response)
+}
+
+// GetEventTypeHandler responds with an event type by its name
+func (api *AdminAPI) GetEventTypeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	eventType, err := api.EventTypes.ForTenant(eventTypeTenant(r)).Get(r.Context(), vars["name"])
+	if err != nil {
+		if errors.Is(err, ErrEventTypeNotFound) {
+			respondWithNotFound(w, err.Error())
+			return
+		}
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	respondWithSuccess(w, eventType)
+}
+
+// DeleteEventTypeHandler deletes an event type by its name, along with its templates, so events of such type are
+// refused from then on
+func (api *AdminAPI) DeleteEventTypeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	repository := api.EventTypes.ForTenant(eventTypeTenant(r))
+
+	eventType, err := repository.Get(r.Context(), vars["name"])
+	if err == nil {
+		err = repository.Delete(r.Context(), eventType.Name)
+	}
+	if err == nil {
+		err = api.Templates.ForTenant(eventType.TenantID).DeleteAll(r.Context(), eventType.Name)
+	}
+	if err != nil {
+		if errors.Is(err, ErrEventTypeNotFound) {
+			respondWithNotFound(w, err.Error())
+			return
+		}
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	log.Printf("Deleted event type %s of tenant %s", eventType.Name, eventType.TenantID)
+
+	recordAudit(api.Audit, r, AuditActionDeleteEventType, eventType.Name, map[string]interface{}{
+		"tenantID": eventType.TenantID,
+	})
+
+	respondWithSuccess(w, eventType)
+}
+
+type saveTemplateRequest struct {
+	TenantID  string `json:"tenantID"`
+	Title     string `json:"title"`
+	Body      string `json:"body"`
+	ActionURL string `json:"actionURL"`
+}
+
+// SaveTemplateHandler registers the template of an event type in a locale, or replaces the one there was. The event
+// type must be registered already
+func (api *AdminAPI) SaveTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var request saveTemplateRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	if request.TenantID == "" {
+		request.TenantID = authorizedTenant(r).ID
+	}
+
+	eventType, err := api.EventTypes.ForTenant(request.TenantID).Get(r.Context(), vars["name"])
+	if err != nil {
+		if errors.Is(err, ErrEventTypeNotFound) {
+			respondWithNotFound(w, err.Error())
+			return
+		}
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	notificationTemplate, err := NewNotificationTemplate(eventType.Name, vars["locale"], request.Title, request.Body, request.ActionURL)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	err = api.Templates.ForTenant(request.TenantID).Save(r.Context(), notificationTemplate)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	log.Printf("Saved %s template of event type %s of tenant %s", notificationTemplate.Locale, notificationTemplate.EventType, notificationTemplate.TenantID)
+
+	recordAudit(api.Audit, r, AuditActionSaveTemplate, notificationTemplate.EventType+"/"+notificationTemplate.Locale, map[string]interface{}{
+		"tenantID":  notificationTemplate.TenantID,
+		"title":     notificationTemplate.Title,
+		"body":      notificationTemplate.Body,
+		"actionURL": notificationTemplate.ActionURL,
+	})
+
+	respondWithSuccess(w, notificationTemplate)
+}
+
+type templatesResponse struct {
+	EventType string                 `json:"eventType"`
+	Templates []NotificationTemplate `json:"templates"`
+}
+
+// GetTemplatesHandler responds with the templates of an event type, one per locale
+func (api *AdminAPI) GetTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventType := vars["name"]
+
+	templates, err := api.Templates.ForTenant(eventTypeTenant(r)).GetAll(r.Context(), eventType)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	response := templatesResponse{
+		EventType: eventType,
+		Templates: templates,
+	}
+
+	respondWithSuccess(w, response)
+}
+
+// DeleteTemplateHandler deletes the template of an event type in a locale, so notifications fall back to another one
+func (api *AdminAPI) DeleteTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	repository := api.Templates.ForTenant(eventTypeTenant(r))
+
+	templates, err := repository.Find(r.Context(), vars["name"], []string{canonicalLocale(vars["locale"])})
+	if err == nil && len(templates) == 0 {
+		err = ErrNotificationTemplateNotFound
+	}
+	if err == nil {
+		err = repository.Delete(r.Context(), templates[0].EventType, templates[0].Locale)
+	}
+	if err != nil {
+		if errors.Is(err, ErrNotificationTemplateNotFound) {
+			respondWithNotFound(w, err.Error())
+			return
+		}
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	notificationTemplate := templates[0]
+	log.Printf("Deleted %s template of event type %s of tenant %s", notificationTemplate.Locale, notificationTemplate.EventType, notificationTemplate.TenantID)
+
+	recordAudit(api.Audit, r, AuditActionDeleteTemplate, notificationTemplate.EventType+"/"+notificationTemplate.Locale, map[string]interface{}{
+		"tenantID": notificationTemplate.TenantID,
+	})
+
+	respondWithSuccess(w, notificationTemplate)
+}
+
+// eventTypeTenant is the tenant an event type belongs to, which is the same of the administrator unless the query
+// string tenantID tells otherwise
+func eventTypeTenant(r *http.Request) string {
+	tenantID := r.FormValue("tenantID")
+	if tenantID == "" {
+		tenantID = authorizedTenant(r).ID
+	}
+	return tenantID
+}
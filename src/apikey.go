@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// APIKeyPrefix is prepended to every API key so they are easy to spot (e.g. on leaked logs or config files)
+const APIKeyPrefix = "mk_"
+
+var (
+	// ScopeEventsUnicast allows publishing events to one destination
+	ScopeEventsUnicast = "events:unicast"
+
+	// ScopeEventsBroadcast allows publishing events to many destinations
+	ScopeEventsBroadcast = "events:broadcast"
+
+	// ScopeEventsSchedule allows rescheduling or canceling events published for later, as well as managing recurring
+	// schedules
+	ScopeEventsSchedule = "events:schedule"
+)
+
+// IsValidAPIKeyScope tells whether a given scope string is a known one
+func IsValidAPIKeyScope(scope string) bool {
+	return scope == ScopeEventsUnicast || scope == ScopeEventsBroadcast || scope == ScopeEventsSchedule
+}
+
+// APIKey is the persistent record of a server-to-server publisher credential. Only a hash of the secret
+// part is kept at rest; the plain key is given away once, on creation, and never again
+type APIKey struct {
+	ID         uint       `json:"id,omitempty" gorm:"primaryKey"`
+	TenantID   string     `json:"tenantID,omitempty" gorm:"not null;index"`
+	Name       string     `json:"name,omitempty" gorm:"not null"`
+	LookupID   string     `json:"lookupID,omitempty" gorm:"not null;uniqueIndex"`
+	Hash       string     `json:"-" gorm:"not null"`
+	Scopes     string     `json:"scopes,omitempty" gorm:"not null"`
+	SourceID   string     `json:"sourceID,omitempty" gorm:"not null"`
+	CreatedAt  time.Time  `json:"createdAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// NewAPIKey creates a new API key record and also gives back its plain text value, which is
+// the only chance to get it. When no tenant is given, the key belongs to the default one
+func NewAPIKey(tenantID string, name string, sourceID string, scopes []string) (*APIKey, string, error) {
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+
+	if name == "" {
+		return nil, "", errors.New("API key name must be provided")
+	}
+
+	if sourceID == "" {
+		return nil, "", errors.New("API key sourceID must be provided")
+	}
+
+	if len(scopes) == 0 {
+		return nil, "", errors.New("API key must have at least one scope")
+	}
+
+	for _, scope := range scopes {
+		if !IsValidAPIKeyScope(scope) {
+			return nil, "", errors.New(scope + " is not a valid scope")
+		}
+	}
+
+	lookupID, err := randomHex(6)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := randomHex(24)
+	if err != nil {
+		return nil, "", err
+	}
+
+	plainKey := APIKeyPrefix + lookupID + "." + secret
+
+	apiKey := &APIKey{
+		TenantID: tenantID,
+		Name:     name,
+		LookupID: lookupID,
+		Hash:     HashAPIKey(plainKey),
+		Scopes:   strings.Join(scopes, ","),
+		SourceID: sourceID,
+	}
+
+	return apiKey, plainKey, nil
+}
+
+// HashAPIKey is a one-way hash of a plain API key. As keys are long random strings, a plain SHA-256 is fine here
+func HashAPIKey(plainKey string) string {
+	hash := sha256.Sum256([]byte(plainKey))
+	return hex.EncodeToString(hash[:])
+}
+
+// ParseAPIKeyLookupID extracts the public lookup ID from a plain API key
+func ParseAPIKeyLookupID(plainKey string) (string, error) {
+	if !strings.HasPrefix(plainKey, APIKeyPrefix) {
+		return "", ErrAPIKeyInvalid
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(plainKey, APIKeyPrefix), ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", ErrAPIKeyInvalid
+	}
+
+	return parts[0], nil
+}
+
+// Matches tells whether a plain key corresponds to this API key
+func (k *APIKey) Matches(plainKey string) bool {
+	return subtle.ConstantTimeCompare([]byte(k.Hash), []byte(HashAPIKey(plainKey))) == 1
+}
+
+// IsRevoked tells whether the API key was revoked
+func (k *APIKey) IsRevoked() bool {
+	return k.RevokedAt != nil
+}
+
+// HasScope tells whether the API key was granted a given scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, granted := range strings.Split(k.Scopes, ",") {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RevokeAPIKey flags an API key as revoked, if it is not already
+func RevokeAPIKey(apiKeys APIKeyRepository, id uint) (APIKey, error) {
+	apiKey, err := apiKeys.Get(id)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	if apiKey.IsRevoked() {
+		return apiKey, nil
+	}
+
+	revokedAt := time.Now()
+	apiKey.RevokedAt = &revokedAt
+
+	err = apiKeys.Update(&apiKey)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return apiKey, nil
+}
+
+func randomHex(size int) (string, error) {
+	bytes := make([]byte, size)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+var (
+	// ErrAPIKeyNotFound is returned when an API key doesn't exist in database
+	ErrAPIKeyNotFound = errors.New("API key not found")
+
+	// ErrAPIKeyInvalid is returned when an API key is malformed, unknown, or doesn't match
+	ErrAPIKeyInvalid = errors.New("API key is invalid")
+
+	// ErrAPIKeyRevoked is returned when an API key was already revoked
+	ErrAPIKeyRevoked = errors.New("API key is revoked")
+)
+
+// APIKeyRepository is the interface to API key datastore
+type APIKeyRepository interface {
+	Add(apiKey *APIKey) error
+	Update(apiKey *APIKey) error
+	Get(id uint) (APIKey, error)
+	GetByLookupID(lookupID string) (APIKey, error)
+	GetAll() ([]APIKey, error)
+	Touch(id uint, usedAt time.Time) error
+}
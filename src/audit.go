@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/urfave/negroni"
+)
+
+var (
+	// AuditActionUnicast stands for an event published to one destination
+	AuditActionUnicast = "event.unicast"
+
+	// AuditActionBroadcast stands for an event published to many destinations
+	AuditActionBroadcast = "event.broadcast"
+
+	// AuditActionMarkRead stands for a notification marked as read
+	AuditActionMarkRead = "notification.read"
+
+	// AuditActionMarkUnread stands for a notification marked back as unread
+	AuditActionMarkUnread = "notification.unread"
+
+	// AuditActionMarkBroadcastRead stands for a broadcast marked as read by one client
+	AuditActionMarkBroadcastRead = "broadcast.read"
+
+	// AuditActionMarkBroadcastUnread stands for a broadcast marked back as unread by one client
+	AuditActionMarkBroadcastUnread = "broadcast.unread"
+
+	// AuditActionDismissBroadcast stands for a broadcast dismissed by one client, which no longer sees it
+	AuditActionDismissBroadcast = "broadcast.dismiss"
+
+	// AuditActionUpdatePreferences stands for the preferences of a client replaced, e.g. to mute a source
+	AuditActionUpdatePreferences = "preferences.update"
+
+	// AuditActionUpdateQuietHours stands for the quiet hours of a client changed
+	AuditActionUpdateQuietHours = "quiethours.update"
+
+	// AuditActionSnooze stands for a client snoozing every notification for a while
+	AuditActionSnooze = "snooze.start"
+
+	// AuditActionUnsnooze stands for a client done snoozing before it was over
+	AuditActionUnsnooze = "snooze.end"
+
+	// AuditActionUpdateDigests stands for the digests of a client replaced, e.g. to get a category hourly
+	AuditActionUpdateDigests = "digests.update"
+
+	// AuditActionCancelJob stands for a broadcast job canceled before it went through every destination
+	AuditActionCancelJob = "job.cancel"
+
+	// AuditActionReschedule stands for an event published for later whose notifications were rescheduled
+	AuditActionReschedule = "event.reschedule"
+
+	// AuditActionCancelScheduled stands for an event published for later whose notifications were canceled
+	AuditActionCancelScheduled = "event.cancel"
+
+	// AuditActionCreateSchedule stands for a recurring schedule created by a publisher
+	AuditActionCreateSchedule = "schedule.create"
+
+	// AuditActionUpdateSchedule stands for a recurring schedule changed by a publisher
+	AuditActionUpdateSchedule = "schedule.update"
+
+	// AuditActionDeleteSchedule stands for a recurring schedule deleted by a publisher
+	AuditActionDeleteSchedule = "schedule.delete"
+
+	// AuditActionSubscribe stands for a client subscribed to a topic
+	AuditActionSubscribe = "topic.subscribe"
+
+	// AuditActionUnsubscribe stands for a client unsubscribed from a topic
+	AuditActionUnsubscribe = "topic.unsubscribe"
+
+	// AuditActionCreateAPIKey stands for an API key created by an administrator
+	AuditActionCreateAPIKey = "apikey.create"
+
+	// AuditActionRevokeAPIKey stands for an API key revoked by an administrator
+	AuditActionRevokeAPIKey = "apikey.revoke"
+
+	// AuditActionRevokeToken stands for a token (or subject) revoked by an administrator
+	AuditActionRevokeToken = "token.revoke"
+
+	// AuditActionSaveEventType stands for an event type registered, or changed, by an administrator
+	AuditActionSaveEventType = "eventtype.save"
+
+	// AuditActionDeleteEventType stands for an event type deleted by an administrator
+	AuditActionDeleteEventType = "eventtype.delete"
+
+	// AuditActionSaveTemplate stands for a notification template registered, or changed, by an administrator
+	AuditActionSaveTemplate = "template.save"
+
+	// AuditActionDeleteTemplate stands for a notification template deleted by an administrator
+	AuditActionDeleteTemplate = "template.delete"
+)
+
+var (
+	// AuditActorUser stands for an actor authenticated by JWT
+	AuditActorUser = "user"
+
+	// AuditActorAPIKey stands for an actor authenticated by API key
+	AuditActorAPIKey = "apikey"
+
+	// AuditActorCLI stands for an operator running administrative commands
+	AuditActorCLI = "cli"
+)
+
+// AuditEntry is the persistent record of who did what, when and from where. Entries are append-only
+type AuditEntry struct {
+	ID        uint      `json:"id,omitempty" gorm:"primaryKey"`
+	TenantID  string    `json:"tenantID,omitempty" gorm:"not null;index"`
+	Action    string    `json:"action,omitempty" gorm:"not null;index"`
+	ActorType string    `json:"actorType,omitempty" gorm:"not null"`
+	ActorID   string    `json:"actorID,omitempty" gorm:"not null;index"`
+	SourceIP  string    `json:"sourceIP,omitempty"`
+	Target    string    `json:"target,omitempty"`
+	Details   string    `json:"details,omitempty" gorm:"size:65536"`
+	CreatedAt time.Time `json:"createdAt,omitempty" gorm:"index"`
+}
+
+// NewAuditEntry creates a new audit entry for an action taken by whoever is authorized on the request
+func NewAuditEntry(r *http.Request, action string, target string, details map[string]interface{}) *AuditEntry {
+	entry := &AuditEntry{
+		TenantID: authorizedTenant(r).ID,
+		Action:   action,
+		SourceIP: requestSourceIP(r),
+		Target:   target,
+	}
+
+	if len(details) > 0 {
+		jsonDetails, err := json.Marshal(details)
+		if err == nil {
+			entry.Details = string(jsonDetails)
+		}
+	}
+
+	entry.ActorType, entry.ActorID = authorizedActor(r)
+
+	return entry
+}
+
+// recordAudit appends an entry to the audit trail. Failing to do so doesn't fail the request, since the action
+// has already been taken, but it is logged out loud
+func recordAudit(audit AuditRepository, r *http.Request, action string, target string, details map[string]interface{}) {
+	entry := NewAuditEntry(r, action, target, details)
+
+	err := audit.Add(entry)
+	if err != nil {
+		log.Printf("AUDIT FAILURE: could not record %s of %s by %s %s due to: %s", entry.Action, entry.Target, entry.ActorType, entry.ActorID, err)
+	}
+}
+
+// requestSourceIP is the address SourceIPMiddleware told for a request, or its remote address when it didn't
+func requestSourceIP(r *http.Request) string {
+	sourceIP, ok := r.Context().Value(sourceIPContextKey).(string)
+	if ok {
+		return sourceIP
+	}
+	return remoteIP(r)
+}
+
+// SourceIPMiddleware tells where every request comes from. X-Forwarded-For is only taken into account when the
+// request comes through a trusted proxy, since anyone else may make it up
+func SourceIPMiddleware(trustedProxies []*net.IPNet) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		ctx := context.WithValue(r.Context(), sourceIPContextKey, sourceIP(r, trustedProxies))
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// sourceIP of a request, going through X-Forwarded-For from the right (i.e. the nearest hop) for as long as it is
+// the address of a trusted proxy
+func sourceIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	sourceIP := remoteIP(r)
+	if !isTrustedProxy(sourceIP, trustedProxies) {
+		return sourceIP
+	}
+
+	forwardedFor := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwardedFor[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		sourceIP = hop
+		if !isTrustedProxy(hop, trustedProxies) {
+			break
+		}
+	}
+
+	return sourceIP
+}
+
+// isTrustedProxy tells whether an address belongs to any of the trusted proxies
+func isTrustedProxy(address string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, trustedProxy := range trustedProxies {
+		if trustedProxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP is the address the request came from, without its port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AuditFilter is the criteria to look for audit entries. Zero values match anything
+type AuditFilter struct {
+	TenantID string
+	Action   string
+	ActorID  string
+	Since    *time.Time
+	Until    *time.Time
+	Limit    int
+}
+
+// AuditRepository is the interface to the audit trail datastore. There is no way to change or delete entries
+type AuditRepository interface {
+	Add(entry *AuditEntry) error
+	Find(filter AuditFilter) ([]AuditEntry, error)
+}
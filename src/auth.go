@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	jwtmiddleware "github.com/auth0/go-jwt-middleware"
 	jwt "github.com/form3tech-oss/jwt-go"
@@ -10,13 +15,30 @@ import (
 	"github.com/urfave/negroni"
 )
 
+// APIKeyHeader is the HTTP header where server-to-server publishers send their API key
+const APIKeyHeader = "X-API-Key"
+
+type contextKey string
+
+const (
+	apiKeyContextKey   contextKey = "apiKey"
+	tenantContextKey   contextKey = "tenant"
+	sourceIPContextKey contextKey = "sourceIP"
+)
+
 // JWTAuthMiddleware wrapper facility to an underlying JWTMiddleware
 type JWTAuthMiddleware struct {
-	handler *negroni.Negroni
+	handler      *negroni.Negroni
+	apiKeys      APIKeyRepository
+	revocations  RevocationRepository
+	tenants      *TenantRegistry
+	adminUserIDs []string
 }
 
-// NewJWTAuthMiddleware creates a new JWTSecureMiddleware instance for our secret key
-func NewJWTAuthMiddleware(privateKey []byte) (JWTAuthMiddleware, error) {
+// NewJWTAuthMiddleware creates a new JWTSecureMiddleware instance for our secret key. API keys are
+// optional, when a repository is not given only JWT is accepted. Same goes for revocations, when a
+// repository is not given tokens are valid until they expire
+func NewJWTAuthMiddleware(privateKey []byte, apiKeys APIKeyRepository, revocations RevocationRepository, tenants *TenantRegistry) (JWTAuthMiddleware, error) {
 	middleware := jwtmiddleware.New(jwtmiddleware.Options{
 		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
 			return privateKey, nil
@@ -25,24 +47,85 @@ func NewJWTAuthMiddleware(privateKey []byte) (JWTAuthMiddleware, error) {
 	})
 
 	handler := negroni.New(negroni.HandlerFunc(middleware.HandlerWithNext))
-	wrapper := JWTAuthMiddleware{handler: handler}
+	wrapper := JWTAuthMiddleware{
+		handler:      handler,
+		apiKeys:      apiKeys,
+		revocations:  revocations,
+		tenants:      tenants,
+		adminUserIDs: GetAdminUserIDs(),
+	}
 
 	return wrapper, nil
 }
 
-// Secure turns a otherwise public endpoint into a secure one
+// Secure turns a otherwise public endpoint into a secure one. Events routes (i.e. /api/events/*) also
+// accept an API key in place of a JWT
 func (s *JWTAuthMiddleware) Secure(endpointHandler func(http.ResponseWriter, *http.Request)) *negroni.Negroni {
-	return s.handler.With(
+	jwtSecured := s.handler.With(
+		negroni.HandlerFunc(s.checkTenantIsValid),
+		negroni.HandlerFunc(s.checkTokenIsNotRevoked),
 		negroni.HandlerFunc(checkAuthorizedUserIsValid),
 		negroni.Wrap(http.HandlerFunc(endpointHandler)))
+
+	return negroni.New(negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if s.apiKeys != nil && isAPIKeyRoute(r) && r.Header.Get(APIKeyHeader) != "" {
+			s.checkAPIKeyIsValid(w, r, endpointHandler)
+			return
+		}
+		jwtSecured.ServeHTTP(w, r)
+	}))
 }
 
-func checkAuthorizedUserIsValid(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	if !isAuthorizationRequired(r) {
+// SecurePublisher turns a otherwise public endpoint into one only reachable by publishers, i.e. by an API key or
+// by administrators, never by end users
+func (s *JWTAuthMiddleware) SecurePublisher(endpointHandler func(http.ResponseWriter, *http.Request)) *negroni.Negroni {
+	jwtSecured := s.SecureAdmin(endpointHandler)
+
+	return negroni.New(negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if s.apiKeys != nil && isAPIKeyRoute(r) && r.Header.Get(APIKeyHeader) != "" {
+			s.checkAPIKeyIsValid(w, r, endpointHandler)
+			return
+		}
+		jwtSecured.ServeHTTP(w, r)
+	}))
+}
+
+// SecureAdmin turns a otherwise public endpoint into one only reachable by administrators
+func (s *JWTAuthMiddleware) SecureAdmin(endpointHandler func(http.ResponseWriter, *http.Request)) *negroni.Negroni {
+	return s.handler.With(
+		negroni.HandlerFunc(s.checkTenantIsValid),
+		negroni.HandlerFunc(s.checkTokenIsNotRevoked),
+		negroni.HandlerFunc(s.checkAuthorizedUserIsAdmin),
+		negroni.Wrap(http.HandlerFunc(endpointHandler)))
+}
+
+func (s *JWTAuthMiddleware) checkTokenIsNotRevoked(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if s.revocations == nil {
 		next(w, r)
 		return
 	}
 
+	claims := decodeJWTClaims(r)
+	jti, _ := claims["jti"].(string)
+	userID, _ := claims["user_id"].(string)
+
+	revoked, err := s.revocations.IsRevoked(authorizedTenant(r).ID, jti, userID, time.Now())
+	if err != nil {
+		respondWithInternalServerError(w, err.Error())
+		return
+	}
+
+	if revoked {
+		log.Printf("Blocking access: token of user %s is revoked", userID)
+		respondWithUnauthorized(w, "authorization token is revoked")
+		return
+	}
+
+	next(w, r)
+}
+
+// checkAuthorizedUserIsValid makes sure client routes are only accessed by the client itself, whatever the method
+func checkAuthorizedUserIsValid(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	vars := mux.Vars(r)
 
 	// Is it a client route?
@@ -60,8 +143,115 @@ func checkAuthorizedUserIsValid(w http.ResponseWriter, r *http.Request, next htt
 	next(w, r)
 }
 
-func isAuthorizationRequired(r *http.Request) bool {
-	return r.Method == "GET" || r.Method == "POST" || r.Method == "PUT"
+func (s *JWTAuthMiddleware) checkAuthorizedUserIsAdmin(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	claims := decodeJWTClaims(r)
+	userID, _ := claims["user_id"].(string)
+
+	for _, adminUserID := range s.adminUserIDs {
+		if userID != "" && userID == adminUserID {
+			next(w, r)
+			return
+		}
+	}
+
+	log.Printf("Blocking admin access: user %s", userID)
+	respondWithForbidden(w, "authorization token does not correspond to an administrator")
+}
+
+func (s *JWTAuthMiddleware) checkAPIKeyIsValid(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	apiKey, err := s.authenticateAPIKey(r.Header.Get(APIKeyHeader))
+	if err != nil {
+		log.Printf("Blocking access: %s", err)
+		respondWithUnauthorized(w, err.Error())
+		return
+	}
+
+	scope := requiredScope(r)
+	if !apiKey.HasScope(scope) {
+		log.Printf("Blocking access: API key %d lacks scope %s", apiKey.ID, scope)
+		respondWithForbidden(w, "API key does not have scope "+scope)
+		return
+	}
+
+	// Keeps track of the last time the key was used, for the sake of auditing
+	err = s.apiKeys.Touch(apiKey.ID, time.Now())
+	if err != nil {
+		log.Printf("Failed to touch API key %d due to: %s", apiKey.ID, err)
+	}
+
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, apiKey)
+	s.checkTenantIsValid(w, r.WithContext(ctx), next)
+}
+
+// checkTenantIsValid figures out the tenant of the request, which comes from either the API key or the tenant_id
+// claim of the JWT, and makes sure it is a known one and the request origin is allowed for it
+func (s *JWTAuthMiddleware) checkTenantIsValid(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	tenantID := DefaultTenantID
+	if apiKey, ok := authorizedAPIKey(r); ok {
+		tenantID = apiKey.TenantID
+	} else if claim, ok := decodeJWTClaims(r)["tenant_id"].(string); ok && claim != "" {
+		tenantID = claim
+	}
+
+	tenant, exists := s.tenants.Get(tenantID)
+	if !exists {
+		log.Printf("Blocking access: unknown tenant %s", tenantID)
+		respondWithForbidden(w, "unknown tenant "+tenantID)
+		return
+	}
+
+	origin := r.Header.Get("Origin")
+	if !tenant.IsOriginAllowed(origin) {
+		log.Printf("Blocking access: origin %s is not allowed for tenant %s", origin, tenantID)
+		respondWithForbidden(w, "origin "+origin+" is not allowed")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), tenantContextKey, tenant)
+	next(w, r.WithContext(ctx))
+}
+
+func (s *JWTAuthMiddleware) authenticateAPIKey(plainKey string) (APIKey, error) {
+	lookupID, err := ParseAPIKeyLookupID(plainKey)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	apiKey, err := s.apiKeys.GetByLookupID(lookupID)
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			return APIKey{}, ErrAPIKeyInvalid
+		}
+		return APIKey{}, err
+	}
+
+	if !apiKey.Matches(plainKey) {
+		return APIKey{}, ErrAPIKeyInvalid
+	}
+
+	if apiKey.IsRevoked() {
+		return APIKey{}, ErrAPIKeyRevoked
+	}
+
+	return apiKey, nil
+}
+
+func isAPIKeyRoute(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/events/") || strings.HasPrefix(r.URL.Path, "/api/jobs/") ||
+		strings.HasPrefix(r.URL.Path, "/api/schedules")
+}
+
+// requiredScope for an events route is given by its path, e.g. /api/events/unicast requires events:unicast. Jobs
+// come from broadcasts, so they require events:broadcast, and schedules of any event as well as recurring ones require
+// events:schedule
+func requiredScope(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/api/jobs/") {
+		return ScopeEventsBroadcast
+	}
+	if strings.HasSuffix(r.URL.Path, "/schedule") || strings.HasPrefix(r.URL.Path, "/api/schedules") {
+		return ScopeEventsSchedule
+	}
+	return strings.Replace(strings.TrimPrefix(r.URL.Path, "/api/"), "/", ":", -1)
 }
 
 func isAuthorizedUserValid(r *http.Request, expectedUserID string) bool {
@@ -79,3 +269,28 @@ func decodeJWTClaims(r *http.Request) jwt.MapClaims {
 	}
 	return user.(*jwt.Token).Claims.(jwt.MapClaims)
 }
+
+// authorizedAPIKey gives the API key used to authenticate the request, if that is the case
+func authorizedAPIKey(r *http.Request) (APIKey, bool) {
+	apiKey, ok := r.Context().Value(apiKeyContextKey).(APIKey)
+	return apiKey, ok
+}
+
+// authorizedActor gives who is behind the request, as per its credentials, i.e. an API key or a user
+func authorizedActor(r *http.Request) (actorType string, actorID string) {
+	if apiKey, ok := authorizedAPIKey(r); ok {
+		return AuditActorAPIKey, fmt.Sprintf("%d", apiKey.ID)
+	}
+
+	userID, _ := decodeJWTClaims(r)["user_id"].(string)
+	return AuditActorUser, userID
+}
+
+// authorizedTenant gives the tenant of the request, as per its credentials
+func authorizedTenant(r *http.Request) TenantSettings {
+	tenant, ok := r.Context().Value(tenantContextKey).(TenantSettings)
+	if !ok {
+		return TenantSettings{ID: DefaultTenantID}
+	}
+	return tenant
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BroadcastAudienceAll stands for every client of a tenant, known or not
+const BroadcastAudienceAll = "all"
+
+// Broadcast is the persistent record of an event for every client of a tenant (e.g. an announcement). It is stored
+// once, no matter how many clients there are, which makes it fan-out-on-read
+type Broadcast struct {
+	ID        uint      `json:"id,omitempty" gorm:"primaryKey"`
+	TenantID  string    `json:"tenantID,omitempty" gorm:"not null;index"`
+	EventID   string    `json:"eventID,omitempty" gorm:"not null"`
+	SourceID  string    `json:"sourceID,omitempty" gorm:"not null"`
+	Type      string    `json:"type,omitempty" gorm:"column:event_type;not null;default:''"`
+	Data      JSONData  `json:"data,omitempty" gorm:"not null;size:65536"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+}
+
+// NewBroadcast creates a new broadcast for a given event
+func NewBroadcast(event *BroadcastEvent) (*Broadcast, error) {
+	// In case event doesn't already have an ID, give it a unique one
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+
+	broadcast := &Broadcast{
+		TenantID: event.TenantID,
+		EventID:  event.ID,
+		SourceID: event.SourceID,
+		Type:     event.Type,
+		Data:     event.Data,
+	}
+
+	return broadcast, nil
+}
+
+// BroadcastReceipt is the read state of a broadcast for one client. It only exists once the client reads or
+// dismisses the broadcast; until then, the broadcast is unread
+type BroadcastReceipt struct {
+	ID          uint       `json:"id,omitempty" gorm:"primaryKey"`
+	TenantID    string     `json:"tenantID,omitempty" gorm:"not null"`
+	BroadcastID uint       `json:"broadcastID,omitempty" gorm:"not null"`
+	ClientID    string     `json:"clientID,omitempty" gorm:"not null"`
+	ReadAt      *time.Time `json:"readAt,omitempty"`
+	DismissedAt *time.Time `json:"dismissedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt,omitempty"`
+}
+
+// ClientBroadcast is a broadcast as a given client sees it
+type ClientBroadcast struct {
+	Broadcast
+	ReadAt *time.Time
+}
+
+// ErrBroadcastNotFound is returned when a broadcast doesn't exist in database
+var ErrBroadcastNotFound = errors.New("broadcast not found")
+
+// BroadcastRepository is the interface to broadcast datastore. Every operation is bound to one tenant, the default
+// one unless it was scoped by ForTenant
+type BroadcastRepository interface {
+	ForTenant(tenantID string) BroadcastRepository
+	Add(ctx context.Context, broadcast *Broadcast) error
+	Get(ctx context.Context, id uint) (Broadcast, error)
+	GetForClient(ctx context.Context, clientID string, status string) ([]ClientBroadcast, error)
+	GetReceipt(ctx context.Context, broadcastID uint, clientID string) (BroadcastReceipt, error)
+	SaveReceipt(ctx context.Context, receipt *BroadcastReceipt) error
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/streadway/amqp"
 )
 
@@ -12,12 +16,39 @@ type Broker struct {
 	// The service node ID where this broken is running in
 	nid string
 
-	// Flags whether broker is isRunning or not
-	isRunning bool
+	// Closed when the broker is stopped, which ends the message exchange goroutine
+	done chan struct{}
 
 	// The underlying datastore for notifications persistence
 	repository NotificationRepository
 
+	// The underlying datastore for broadcasts to whole tenants
+	broadcasts BroadcastRepository
+
+	// The underlying datastore for client preferences, which keep notifications out of inboxes or streams (might be
+	// nil, when every notification goes everywhere)
+	preferences ClientPreferenceRepository
+
+	// The underlying datastore for client settings, which tell when clients are quiet (might be nil, when they never
+	// are)
+	settings ClientSettingsRepository
+
+	// The underlying datastore for client digests, which sum notifications of a category up every once in a while
+	// (might be nil, when every notification goes on its own)
+	digests ClientDigestRepository
+
+	// Writes notifications in batches, handing them to live delivery as soon as they are persisted
+	pipeline *PersistencePipeline
+
+	// Releases scheduled, held and accumulated notifications to live delivery once they are due
+	scheduler *Scheduler
+
+	// Caps how much of the datastore each client takes
+	quota QuotaSettings
+
+	// Tells how client sessions queue notifications up
+	stream StreamSettings
+
 	// The underlying message-orinted middleware (might be nil if it does not uses one; it depends on settings passed by on creation)
 	mq MessageQueueConnection
 
@@ -30,22 +61,40 @@ type Broker struct {
 	// Closed client connections
 	closingClients chan Client
 
-	// Client connections registry
+	// Client connections to be killed from the server side (by client key)
+	killingClients chan string
+
+	// Client connections to be killed from the server side (by token key, i.e. tenant + jti)
+	killingTokens chan string
+
+	// Client connections registry (by client key, i.e. tenant + client ID)
 	clients map[string]Client
 }
 
 // NewBroker creates a new Broker and puts it to run
-func NewBroker(nid string, repository NotificationRepository, mqSettings MessageQueueSettings) (*Broker, error) {
+func NewBroker(nid string, repository NotificationRepository, broadcasts BroadcastRepository, preferences ClientPreferenceRepository, settings ClientSettingsRepository, digests ClientDigestRepository, mqSettings MessageQueueSettings, persistenceSettings PersistenceSettings, schedulerSettings SchedulerSettings, quotaSettings QuotaSettings, streamSettings StreamSettings) (*Broker, error) {
 	broker := &Broker{
 		nid:            nid,
-		isRunning:      false,
+		done:           make(chan struct{}),
 		repository:     repository,
+		broadcasts:     broadcasts,
+		preferences:    preferences,
+		settings:       settings,
+		digests:        digests,
+		quota:          quotaSettings,
+		stream:         streamSettings,
 		notifications:  make(chan Notification, 1),
 		newClients:     make(chan Client),
 		closingClients: make(chan Client),
+		killingClients: make(chan string),
+		killingTokens:  make(chan string),
 		clients:        make(map[string]Client),
 	}
 
+	broker.pipeline = NewPersistencePipeline(repository, persistenceSettings, broker.publish)
+
+	broker.scheduler = NewScheduler(repository, schedulerSettings, broker.releaseDue, broker.releaseHeld, broker.releaseDigests)
+
 	// We're assuming RabbitMQ here but we can change it in the future and encapsulate it another way
 	// in a factory or something
 	if mqSettings.Use {
@@ -61,48 +110,69 @@ func NewBroker(nid string, repository NotificationRepository, mqSettings Message
 
 // Run starts of the Broker notification service
 func (b *Broker) Run() error {
-	b.isRunning = true
-
 	// As we know we're working with RabbitMQ in the current incarnation of Mercurio, let't make thing
 	// a bit specific here
 	var incomeMessages <-chan amqp.Delivery
 	if b.mq != nil {
 		consummer, err := b.mq.ConsumeNotifications()
 		if err != nil {
-			b.isRunning = false
 			return err
 		}
 		incomeMessages = consummer.(*RabbitMQConsumer).IncomeMessages
 	}
 
+	b.pipeline.Run()
+	b.scheduler.Run()
+
 	// The message exchange goroutine
 	go func() {
-		for b.isRunning {
+		for {
 			select {
+			case <-b.done:
+				return
+
 			case c := <-b.newClients:
 				// A new client has connected
 				// Register their message channel
-				b.clients[c.ID] = c
+				b.clients[c.Key()] = c
 				log.Printf("Client added. (%d registered clients)", len(b.clients))
 
 			case c := <-b.closingClients:
 				// A client has dettached and we want to
 				// stop sending them messages.
-				delete(b.clients, c.ID)
+				delete(b.clients, c.Key())
 				log.Printf("Removed client. (%d registered clients)", len(b.clients))
 
+			case clientKey := <-b.killingClients:
+				// A client session must be closed right away (e.g. its token was revoked)
+				b.killClient(clientKey)
+
+			case tokenKey := <-b.killingTokens:
+				// Every client session opened with a token must be closed right away (i.e. it was revoked)
+				b.killToken(tokenKey)
+
 			case notification := <-b.notifications:
+				if notification.BroadcastID != 0 {
+					// Every client of the tenant gets it, on this and every other service node
+					b.deliverToTenant(notification)
+					if b.mq != nil {
+						b.mq.PublishNotification(notification)
+					}
+					continue
+				}
+
 				// We got a new event from the outside!
 				// Should notify the destination client
 				clientID := notification.DestinationID
-				client, exists := b.clients[notification.DestinationID]
+				client, exists := b.clients[ClientKey(notification.TenantID, clientID)]
 
-				log.Printf("Got notification %d for client %s (known = %v)", notification.ID, clientID, exists)
+				log.Printf("Got notification %d for client %s of tenant %s (known = %v)", notification.ID, clientID, notification.TenantID, exists)
 
-				if exists {
-					client.Channel <- notification
+				delivered := exists && b.deliver(client, notification)
+				if delivered {
 					log.Printf("Send notification %d to client %s", notification.ID, clientID)
 				}
+				notification.reportDelivery(delivered)
 
 				if b.mq != nil {
 					// Publish message to MQ -- maybe should have an additional condition here to decide
@@ -125,21 +195,42 @@ func (b *Broker) Run() error {
 					continue
 				}
 
+				if message.Type == RabbitMQCommandType {
+					command, err := UnmarshalBrokerCommand(message.Body)
+					if err != nil {
+						log.Printf("Could not unmarshal command message body due to: %s", err)
+						continue
+					}
+
+					log.Printf("Got from MQ command %s for client %s / token %s of tenant %s", command.Name, command.ClientID, command.TokenID, command.TenantID)
+
+					switch command.Name {
+					case BrokerCommandDisconnectClient:
+						b.killClient(ClientKey(command.TenantID, command.ClientID))
+					case BrokerCommandDisconnectToken:
+						b.killToken(ClientKey(command.TenantID, command.TokenID))
+					}
+					continue
+				}
+
 				notification, err := UnmarshalNotification(message.Body)
 				if err != nil {
 					log.Printf("Could not unmarshal message body due to: %s", err)
 				}
 
+				if notification.BroadcastID != 0 {
+					b.deliverToTenant(notification)
+					continue
+				}
+
 				clientID := notification.DestinationID
-				client, exists := b.clients[notification.DestinationID]
+				client, exists := b.clients[ClientKey(notification.TenantID, clientID)]
 
-				log.Printf("Got from MQ with notification %d for client %s (known = %v)", notification.ID, clientID, exists)
+				log.Printf("Got from MQ with notification %d for client %s of tenant %s (known = %v)", notification.ID, clientID, notification.TenantID, exists)
 
-				if exists {
-					client.Channel <- notification
+				if exists && b.deliver(client, notification) {
 					log.Printf("Send notification %d got from MQ to client %s", notification.ID, clientID)
 				}
-			default:
 			}
 		}
 	}()
@@ -149,7 +240,11 @@ func (b *Broker) Run() error {
 
 // Stop shuts down the Broker notification service
 func (b *Broker) Stop() {
-	b.isRunning = false
+	// Pending notifications are written and delivered while the message exchange is still up
+	b.scheduler.Stop()
+	b.pipeline.Stop()
+
+	close(b.done)
 
 	if b.mq != nil {
 		log.Println("Closing MQ channel")
@@ -167,36 +262,629 @@ func (b *Broker) NotifyClientDisconnected(client Client) {
 	b.closingClients <- client
 }
 
-// NotifyEvent when an event has occourred for one destination
-func (b *Broker) NotifyEvent(event Event) (Notification, error) {
+// DisconnectClient kills the stream session of a client of a tenant, on this and every other service node
+func (b *Broker) DisconnectClient(tenantID string, clientID string) error {
+	b.killingClients <- ClientKey(tenantID, clientID)
+
+	if b.mq != nil {
+		command := BrokerCommand{
+			Name:     BrokerCommandDisconnectClient,
+			TenantID: tenantID,
+			ClientID: clientID,
+		}
+
+		err := b.mq.PublishCommand(command)
+		if err != nil {
+			return fmt.Errorf("failed to publish disconnect command for client %s due to: %s", clientID, err)
+		}
+	}
+
+	return nil
+}
+
+// DisconnectToken kills the stream sessions opened with a token of a tenant, by its jti, on this and every other
+// service node
+func (b *Broker) DisconnectToken(tenantID string, tokenID string) error {
+	b.killingTokens <- ClientKey(tenantID, tokenID)
+
+	if b.mq != nil {
+		command := BrokerCommand{
+			Name:     BrokerCommandDisconnectToken,
+			TenantID: tenantID,
+			TokenID:  tokenID,
+		}
+
+		err := b.mq.PublishCommand(command)
+		if err != nil {
+			return fmt.Errorf("failed to publish disconnect command for token %s due to: %s", tokenID, err)
+		}
+	}
+
+	return nil
+}
+
+// killToken must only be called from the message exchange goroutine
+func (b *Broker) killToken(tokenKey string) {
+	for clientKey, client := range b.clients {
+		if client.TokenID == "" || ClientKey(client.TenantID, client.TokenID) != tokenKey {
+			continue
+		}
+
+		client.Kill()
+		delete(b.clients, clientKey)
+		log.Printf("Killed client %s of token %s. (%d registered clients)", clientKey, tokenKey, len(b.clients))
+	}
+}
+
+// killClient must only be called from the message exchange goroutine
+func (b *Broker) killClient(clientKey string) {
+	client, exists := b.clients[clientKey]
+	if !exists {
+		return
+	}
+
+	client.Kill()
+	delete(b.clients, clientKey)
+	log.Printf("Killed client %s. (%d registered clients)", clientKey, len(b.clients))
+}
+
+// publish a notification persisted to live delivery, unless it is scheduled for later, held back while its
+// destination is quiet or accumulated for a digest: the scheduler releases it then, so it is reported as not
+// delivered live for now. A notification kept out of the stream of its destination is never delivered live
+func (b *Broker) publish(notification Notification) {
+	if notification.DeliverAt != nil || notification.HeldUntil != nil || notification.DigestAt != nil || notification.suppresses(ChannelStream) {
+		notification.reportDelivery(false)
+		return
+	}
+
+	b.notifications <- notification
+}
+
+// deliver a notification to a client, i.e. to its session queue, unless it expired or it is held back from an
+// overloaded client. It tells whether it did
+func (b *Broker) deliver(client Client, notification Notification) bool {
+	if notification.Expired(time.Now()) {
+		log.Printf("Notification %d for client %s expired, skipping it...", notification.ID, client.ID)
+		return false
+	}
+
+	if !client.Queue.Push(notification) {
+		log.Printf("Client %s is overloaded, holding notification %d back... (%d held back so far)", client.ID, notification.ID, client.Queue.Dropped())
+		return false
+	}
+
+	return true
+}
+
+// NewOutboundQueue for a client session
+func (b *Broker) NewOutboundQueue() *OutboundQueue {
+	return NewOutboundQueue(b.stream.QueueSize)
+}
+
+// deliverToTenant a broadcast notification, i.e. to every client of its tenant known to this service node, as it is
+// screened for each one of them. Screening takes a trip to the database, so it is done apart from the message exchange
+// goroutine, from which it must only be called
+func (b *Broker) deliverToTenant(notification Notification) {
+	clients := []Client{}
+	for _, client := range b.clients {
+		if client.TenantID == notification.TenantID {
+			clients = append(clients, client)
+		}
+	}
+
+	go b.deliverScreened(notification, clients)
+}
+
+// deliverScreened a broadcast notification to each one of the given clients, as long as it is not kept out of its
+// stream nor held back while it is quiet, as per screen. Either way the client still finds it when it gets its
+// notifications, since a broadcast is never held back nor accumulated for a digest of its own
+func (b *Broker) deliverScreened(notification Notification, clients []Client) {
+	screened := make([]*Notification, len(clients))
+	for i, client := range clients {
+		clientNotification := notification
+		clientNotification.DestinationID = client.ID
+		screened[i] = &clientNotification
+	}
+
+	err := b.screen(context.Background(), screened)
+	if err != nil {
+		log.Printf("Failed to screen broadcast %d due to: %s", notification.BroadcastID, err)
+	}
+
+	delivered := 0
+	for i, client := range clients {
+		if screened[i].HeldUntil != nil || screened[i].DigestAt != nil || screened[i].suppresses(ChannelStream) {
+			continue
+		}
+
+		if b.deliver(client, notification) {
+			delivered++
+		}
+	}
+
+	log.Printf("Send broadcast %d to %d clients of tenant %s", notification.BroadcastID, delivered, notification.TenantID)
+}
+
+// AnnounceEvent when an event has occourred for every client of a tenant. It is stored once, as a broadcast, and
+// delivered right away to whoever is connected and neither mutes its source on streams nor is quiet; the others find
+// it when they get their notifications
+func (b *Broker) AnnounceEvent(ctx context.Context, broadcastEvent BroadcastEvent) (Broadcast, error) {
+	broadcast, err := NewBroadcast(&broadcastEvent)
+	if err != nil {
+		return Broadcast{}, err
+	}
+
+	err = b.broadcasts.ForTenant(broadcastEvent.TenantID).Add(ctx, broadcast)
+	if err != nil {
+		return Broadcast{}, err
+	}
+
+	b.notifications <- Notification{
+		TenantID:    broadcast.TenantID,
+		EventID:     broadcast.EventID,
+		SourceID:    broadcast.SourceID,
+		Type:        broadcast.Type,
+		Data:        broadcast.Data,
+		CreatedAt:   broadcast.CreatedAt,
+		BroadcastID: broadcast.ID,
+	}
+
+	return *broadcast, nil
+}
+
+// NotifyEvent when an event has occourred for one destination. It returns as soon as the notification is persisted,
+// and it is delivered right after, or when it is due if it is scheduled. With a collapse key, the unread notification
+// of the same key is updated instead, if there is one. It fails with ErrQuotaExceeded when the destination is at its
+// quota and, as per the quota policy, no room is made for it. The preferences of the destination may keep it out of
+// its inbox, i.e. it is not persisted but it is still delivered if it is not scheduled, or out of its stream
+func (b *Broker) NotifyEvent(ctx context.Context, event Event) (Notification, error) {
 	notification, err := NewNotification(&event)
 	if err != nil {
 		return Notification{}, err
 	}
 
-	err = b.repository.Add(notification)
+	err = b.screen(ctx, []*Notification{notification})
+	if err != nil {
+		return Notification{}, err
+	}
+	if notification.suppresses(ChannelInbox) {
+		b.publish(*notification)
+		return *notification, nil
+	}
+
+	err = b.enforceQuota(ctx, notification)
 	if err != nil {
 		return Notification{}, err
 	}
 
-	b.notifications <- *notification
+	// Collapsing reads before it writes, which doesn't fit in a batch
+	if notification.CollapseKey != "" {
+		notification.Updated, err = b.repository.ForTenant(notification.TenantID).Collapse(ctx, notification)
+		if err != nil {
+			return Notification{}, err
+		}
+
+		b.publish(*notification)
+		return *notification, nil
+	}
+
+	err = b.pipeline.Persist(ctx, notification)
+	if err != nil {
+		return Notification{}, err
+	}
 
 	return *notification, nil
 }
 
-// BroadcastEvent when an event has occourred for many destinations
-func (b *Broker) BroadcastEvent(broadcastEvent BroadcastEvent) ([]Notification, error) {
-	notifications := []Notification{}
+// enforceQuota of the destination of a notification, evicting its oldest read notifications if that is the policy.
+// Publishers racing for the same destination may take it slightly over its caps, which is not worth a lock
+func (b *Broker) enforceQuota(ctx context.Context, notification *Notification) error {
+	if !b.quota.Enabled() {
+		return nil
+	}
+
+	repository := b.repository.ForTenant(notification.TenantID)
+	usage, err := repository.GetUsage(ctx, notification.DestinationID)
+	if err != nil {
+		return err
+	}
+
+	excess := b.quota.Excess(usage, len(notification.Data))
+	if (ClientUsage{}).Covers(excess) {
+		return nil
+	}
+
+	// No eviction makes room for data larger than the cap itself
+	fits := b.quota.MaxDataSize == 0 || len(notification.Data) <= b.quota.MaxDataSize
+	if b.quota.Policy == QuotaPolicyEvict && fits {
+		freed, err := repository.EvictRead(ctx, notification.DestinationID, excess)
+		if err != nil {
+			return err
+		}
+		if freed.Notifications > 0 {
+			log.Printf("Evicted %d read notifications (%d bytes) of client %s of tenant %s", freed.Notifications, freed.DataSize, notification.DestinationID, notification.TenantID)
+		}
+		if freed.Covers(excess) {
+			return nil
+		}
+	}
+
+	return quotaExceeded(notification.DestinationID, usage)
+}
+
+// ClientUsage tells how much of the datastore a client of a tenant takes
+func (b *Broker) ClientUsage(ctx context.Context, tenantID string, clientID string) (ClientUsage, error) {
+	return b.repository.ForTenant(tenantID).GetUsage(ctx, clientID)
+}
+
+// Quota each client is capped at
+func (b *Broker) Quota() QuotaSettings {
+	return b.quota
+}
+
+// BroadcastEvent when an event has occourred for many destinations, all or nothing: notifications are persisted in
+// a single transaction and only then delivered, so a failure leaves no destination notified. Notifications kept out
+// of inboxes by preferences are not persisted, see NotifyEvent
+func (b *Broker) BroadcastEvent(ctx context.Context, broadcastEvent BroadcastEvent) ([]Notification, error) {
+	notifications, err := newBroadcastNotifications(broadcastEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.screen(ctx, notifications)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := []*Notification{}
+	for _, notification := range notifications {
+		if !notification.suppresses(ChannelInbox) {
+			kept = append(kept, notification)
+		}
+	}
+
+	if len(kept) > 0 {
+		err = b.repository.ForTenant(broadcastEvent.TenantID).AddBatch(ctx, kept)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	persisted := []Notification{}
+	for _, notification := range notifications {
+		persisted = append(persisted, *notification)
+	}
+
+	for _, notification := range persisted {
+		b.publish(notification)
+	}
+
+	return persisted, nil
+}
+
+// BroadcastEventPartially when an event has occourred for many destinations, each one on its own: notifications go
+// through the persistence pipeline and are delivered as soon as their batch is written, and results tell which
+// destinations succeeded and which failed
+func (b *Broker) BroadcastEventPartially(ctx context.Context, broadcastEvent BroadcastEvent) []BroadcastResult {
+	return b.broadcastEventTracked(ctx, broadcastEvent, nil)
+}
+
+// broadcastEventTracked is BroadcastEventPartially, telling deliveries whether each notification persisted was
+// delivered live by this service node. Deliveries must have room for every destination
+func (b *Broker) broadcastEventTracked(ctx context.Context, broadcastEvent BroadcastEvent, deliveries chan<- bool) []BroadcastResult {
+	results := []BroadcastResult{}
+
+	notifications, err := newBroadcastNotifications(broadcastEvent)
+	if err != nil {
+		for _, destinationID := range broadcastEvent.Destinations {
+			results = append(results, BroadcastResult{DestinationID: destinationID, Err: err})
+		}
+		return results
+	}
+
+	err = b.screen(ctx, notifications)
+	if err != nil {
+		for _, destinationID := range broadcastEvent.Destinations {
+			results = append(results, BroadcastResult{DestinationID: destinationID, Err: err})
+		}
+		return results
+	}
+
+	dones := []<-chan error{}
+	for _, notification := range notifications {
+		notification.delivery = deliveries
+
+		// Nothing to persist, so it is as good as done
+		if notification.suppresses(ChannelInbox) {
+			suppressed := make(chan error, 1)
+			suppressed <- nil
+			dones = append(dones, suppressed)
+			b.publish(*notification)
+			continue
+		}
+
+		// Holds the broadcast back whenever the pipeline is full; once it gives up, nothing else is submitted
+		var done <-chan error
+		if err == nil {
+			done, err = b.pipeline.Submit(ctx, notification)
+		}
+
+		if err != nil {
+			failed := make(chan error, 1)
+			failed <- err
+			done = failed
+		}
+
+		dones = append(dones, done)
+	}
+
+	for i, done := range dones {
+		err := b.pipeline.Wait(ctx, done)
+		results = append(results, BroadcastResult{
+			DestinationID: notifications[i].DestinationID,
+			Suppressed:    notifications[i].Suppressed,
+			Err:           err,
+		})
+		if err == nil {
+			results[i].Notification = *notifications[i]
+		}
+	}
+
+	return results
+}
+
+// screen notifications as per the preferences of their destinations, telling the channels each one is kept out of,
+// and as per their digests and settings: the ones of a category their destinations get digests of are accumulated
+// for the next digest, and the others for destinations which are quiet are held back, but urgent ones. They must be of
+// the same tenant and subject, i.e. of the same event
+func (b *Broker) screen(ctx context.Context, notifications []*Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	clientIDs := []string{}
+	for _, notification := range notifications {
+		clientIDs = append(clientIDs, notification.DestinationID)
+	}
+	first := notifications[0]
+
+	if b.preferences != nil {
+		matched, err := b.preferences.ForTenant(first.TenantID).Match(ctx, clientIDs, preferenceSubject(*first))
+		if err != nil {
+			return fmt.Errorf("failed to check preferences due to: %w", err)
+		}
+
+		for _, notification := range notifications {
+			channels, ok := matched[notification.DestinationID]
+			if ok {
+				notification.Suppressed = channels.Disabled()
+			}
+		}
+	}
+
+	// Scheduled notifications are screened once again when they are released
+	if first.DeliverAt != nil || first.Priority >= PriorityUrgent {
+		return nil
+	}
+	now := time.Now()
+
+	// Digests are of notifications both persisted and streamed, and never of digests themselves
+	if b.digests != nil && first.Category != "" && first.Type != DigestType {
+		found, err := b.digests.ForTenant(first.TenantID).Find(ctx, clientIDs, first.Category)
+		if err != nil {
+			return fmt.Errorf("failed to check client digests due to: %w", err)
+		}
+
+		for _, notification := range notifications {
+			digest, ok := found[notification.DestinationID]
+			if ok && !notification.suppresses(ChannelStream) && !notification.suppresses(ChannelInbox) {
+				digestAt := digest.NextAt(now)
+				notification.DigestAt = &digestAt
+			}
+		}
+	}
+
+	if b.settings != nil {
+		found, err := b.settings.ForTenant(first.TenantID).Find(ctx, clientIDs)
+		if err != nil {
+			return fmt.Errorf("failed to check client settings due to: %w", err)
+		}
+
+		for _, notification := range notifications {
+			settings, ok := found[notification.DestinationID]
+			if ok && notification.DigestAt == nil && !notification.suppresses(ChannelStream) {
+				notification.HeldUntil = settings.QuietUntil(now)
+			}
+		}
+	}
+
+	return nil
+}
+
+// releaseDue a scheduled notification to live delivery, as long as the preferences, digests and settings of its
+// destination, which may have changed since it was scheduled, allow it. It is in the inbox already, though
+func (b *Broker) releaseDue(notification Notification) {
+	err := b.screen(context.Background(), []*Notification{&notification})
+	if err != nil {
+		log.Printf("Failed to screen notification %d due to: %s", notification.ID, err)
+	}
+
+	if notification.DigestAt != nil {
+		err = b.repository.ForTenant(notification.TenantID).Accumulate(context.Background(), notification.ID, *notification.DigestAt)
+		if err != nil {
+			log.Printf("Failed to accumulate notification %d for a digest due to: %s", notification.ID, err)
+		}
+		return
+	}
+	if notification.HeldUntil != nil {
+		err = b.repository.ForTenant(notification.TenantID).Hold(context.Background(), notification.ID, *notification.HeldUntil)
+		if err != nil {
+			log.Printf("Failed to hold notification %d back due to: %s", notification.ID, err)
+		}
+		return
+	}
+	if notification.suppresses(ChannelStream) {
+		return
+	}
+
+	b.notifications <- notification
+}
+
+// releaseHeld notifications to live delivery once the quiet time of their destinations is over, either one by one or
+// summed up, as per their settings. Whichever destination is quiet once again holds its notifications back again
+func (b *Broker) releaseHeld(notifications []Notification) {
+	ctx := context.Background()
+	now := time.Now()
+
+	destinations := []Notification{}
+	held := map[string][]Notification{}
+	for _, notification := range notifications {
+		key := ClientKey(notification.TenantID, notification.DestinationID)
+		if _, ok := held[key]; !ok {
+			destinations = append(destinations, notification)
+		}
+		if !notification.Expired(now) {
+			held[key] = append(held[key], notification)
+		}
+	}
+
+	for _, destination := range destinations {
+		key := ClientKey(destination.TenantID, destination.DestinationID)
+		if len(held[key]) == 0 {
+			continue
+		}
+
+		settings := *NewClientSettings(destination.DestinationID)
+		if b.settings != nil {
+			found, err := b.settings.ForTenant(destination.TenantID).Get(ctx, destination.DestinationID)
+			if err != nil && !errors.Is(err, ErrClientSettingsNotFound) {
+				log.Printf("Failed to get settings of client %s of tenant %s due to: %s", destination.DestinationID, destination.TenantID, err)
+			}
+			if err == nil {
+				settings = found
+			}
+		}
+
+		if until := settings.QuietUntil(now); until != nil {
+			for _, notification := range held[key] {
+				err := b.repository.ForTenant(notification.TenantID).Hold(ctx, notification.ID, *until)
+				if err != nil {
+					log.Printf("Failed to hold notification %d back due to: %s", notification.ID, err)
+				}
+			}
+			continue
+		}
+
+		if settings.QuietRelease == QuietReleaseSummary && len(held[key]) > 1 {
+			summary, err := newQuietSummary(held[key])
+			if err != nil {
+				log.Printf("Failed to sum up held notifications of client %s of tenant %s due to: %s", destination.DestinationID, destination.TenantID, err)
+				continue
+			}
+
+			b.notifications <- *summary
+			continue
+		}
+
+		for _, notification := range held[key] {
+			b.notifications <- notification
+		}
+	}
+}
+
+// ApplyClientSettings to the notifications held back from a client, which are released as soon as it is no longer
+// quiet or else once its quiet time, as it is now, is over
+func (b *Broker) ApplyClientSettings(ctx context.Context, tenantID string, settings ClientSettings) error {
+	until := time.Now()
+	if quietUntil := settings.QuietUntil(until); quietUntil != nil {
+		until = *quietUntil
+	}
+
+	_, err := b.repository.ForTenant(tenantID).RescheduleHeld(ctx, settings.ClientID, until)
+	return err
+}
+
+// releaseDigests of the notifications accumulated for them, one per destination and category. Each digest is screened
+// as a notification of its category would be, so it is persisted and delivered live as per the preferences and
+// settings of its destination
+func (b *Broker) releaseDigests(notifications []Notification) {
+	ctx := context.Background()
+	now := time.Now()
+
+	keys := []string{}
+	accumulated := map[string][]Notification{}
+	for _, notification := range notifications {
+		key := ClientKey(notification.TenantID, notification.DestinationID) + "/" + notification.Category
+		if _, ok := accumulated[key]; !ok {
+			keys = append(keys, key)
+			accumulated[key] = []Notification{}
+		}
+		if !notification.Expired(now) {
+			accumulated[key] = append(accumulated[key], notification)
+		}
+	}
+
+	for _, key := range keys {
+		if len(accumulated[key]) == 0 {
+			continue
+		}
+
+		digest, err := newDigest(accumulated[key])
+		if err != nil {
+			log.Printf("Failed to sum up notifications accumulated for digest %s due to: %s", key, err)
+			continue
+		}
+
+		err = b.screen(ctx, []*Notification{digest})
+		if err != nil {
+			log.Printf("Failed to screen digest %s due to: %s", key, err)
+		}
+
+		if !digest.suppresses(ChannelInbox) {
+			err = b.repository.ForTenant(digest.TenantID).Add(ctx, digest)
+			if err != nil {
+				log.Printf("Failed to add digest %s due to: %s", key, err)
+				continue
+			}
+		}
+
+		b.publish(*digest)
+	}
+}
+
+// ApplyClientDigests of a client to the notifications accumulated for it, which go out in digests right away, so
+// that none waits for a digest the client no longer gets
+func (b *Broker) ApplyClientDigests(ctx context.Context, tenantID string, clientID string) error {
+	_, err := b.repository.ForTenant(tenantID).RescheduleDigests(ctx, clientID, time.Now())
+	return err
+}
+
+// newBroadcastNotifications gives the notifications of a broadcast, one per destination. Scheduled ones share the
+// same event ID, so that they are rescheduled or canceled all at once
+func newBroadcastNotifications(broadcastEvent BroadcastEvent) ([]*Notification, error) {
+	notifications := []*Notification{}
+
+	if broadcastEvent.ID == "" && scheduledTime(broadcastEvent.DeliverAt) != nil {
+		broadcastEvent.ID = uuid.New().String()
+	}
 
 	for _, destinationID := range broadcastEvent.Destinations {
 		event := Event{
+			TenantID:      broadcastEvent.TenantID,
 			ID:            broadcastEvent.ID,
 			SourceID:      broadcastEvent.SourceID,
 			DestinationID: destinationID,
+			Type:          broadcastEvent.Type,
+			Category:      broadcastEvent.Category,
+			Topic:         broadcastEvent.Topic,
 			Data:          broadcastEvent.Data,
+			Priority:      broadcastEvent.Priority,
+			DeliverAt:     broadcastEvent.DeliverAt,
+			ExpiresAt:     broadcastEvent.ExpiresAt,
+			TTL:           broadcastEvent.TTL,
 		}
 
-		notification, err := b.NotifyEvent(event)
+		notification, err := NewNotification(&event)
 		if err != nil {
 			return nil, err
 		}
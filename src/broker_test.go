@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// failingNotificationRepository fails to add any batch holding a notification to the "fail" destination
+type failingNotificationRepository struct {
+	*MemoryNotificationRepository
+}
+
+func (repository *failingNotificationRepository) ForTenant(tenantID string) NotificationRepository {
+	return &failingNotificationRepository{repository.MemoryNotificationRepository.ForTenant(tenantID).(*MemoryNotificationRepository)}
+}
+
+func (repository *failingNotificationRepository) AddBatch(ctx context.Context, notifications []*Notification) error {
+	for _, notification := range notifications {
+		if notification.DestinationID == "fail" {
+			return errors.New("destination is failing")
+		}
+	}
+	return repository.MemoryNotificationRepository.AddBatch(ctx, notifications)
+}
+
+// memoryClientSettingsRepository keeps the settings of clients in memory, regardless of their tenant
+type memoryClientSettingsRepository struct {
+	mutex    sync.Mutex
+	settings map[string]ClientSettings
+}
+
+func (repository *memoryClientSettingsRepository) ForTenant(tenantID string) ClientSettingsRepository {
+	return repository
+}
+
+func (repository *memoryClientSettingsRepository) Save(ctx context.Context, settings *ClientSettings) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	repository.settings[settings.ClientID] = *settings
+	return nil
+}
+
+func (repository *memoryClientSettingsRepository) Get(ctx context.Context, clientID string) (ClientSettings, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	settings, ok := repository.settings[clientID]
+	if !ok {
+		return ClientSettings{}, ErrClientSettingsNotFound
+	}
+	return settings, nil
+}
+
+func (repository *memoryClientSettingsRepository) Find(ctx context.Context, clientIDs []string) (map[string]ClientSettings, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	found := map[string]ClientSettings{}
+	for _, clientID := range clientIDs {
+		if settings, ok := repository.settings[clientID]; ok {
+			found[clientID] = settings
+		}
+	}
+	return found, nil
+}
+
+// memoryClientDigestRepository keeps the digests of clients in memory, regardless of their tenant
+type memoryClientDigestRepository struct {
+	digests map[string][]ClientDigest
+}
+
+func (repository *memoryClientDigestRepository) ForTenant(tenantID string) ClientDigestRepository {
+	return repository
+}
+
+func (repository *memoryClientDigestRepository) GetAll(ctx context.Context, clientID string) ([]ClientDigest, error) {
+	return repository.digests[clientID], nil
+}
+
+func (repository *memoryClientDigestRepository) Replace(ctx context.Context, clientID string, digests []ClientDigest) error {
+	repository.digests[clientID] = digests
+	return nil
+}
+
+func (repository *memoryClientDigestRepository) Find(ctx context.Context, clientIDs []string, category string) (map[string]ClientDigest, error) {
+	found := map[string]ClientDigest{}
+	for _, clientID := range clientIDs {
+		for _, digest := range repository.digests[clientID] {
+			if digest.Category == category {
+				found[clientID] = digest
+			}
+		}
+	}
+	return found, nil
+}
+
+func runTestBroker(t *testing.T, repository NotificationRepository) *Broker {
+	return runTestBrokerWithQuota(t, repository, QuotaSettings{})
+}
+
+func runTestBrokerWithQuota(t *testing.T, repository NotificationRepository, quota QuotaSettings) *Broker {
+	return runTestBrokerWith(t, repository, quota, nil, nil)
+}
+
+func runTestBrokerWith(t *testing.T, repository NotificationRepository, quota QuotaSettings, settings ClientSettingsRepository, digests ClientDigestRepository) *Broker {
+	broker, err := NewBroker("BrokerTest", repository, nil, nil, settings, digests, MessageQueueSettings{}, PersistenceSettings{BatchSize: 1, BatchDelay: time.Millisecond, QueueSize: 10}, SchedulerSettings{Interval: 10 * time.Millisecond, BatchSize: 10}, quota, StreamSettings{QueueSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = broker.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(broker.Stop)
+
+	return broker
+}
+
+// nextNotification in a client session queue, waiting for it up to a timeout
+func nextNotification(queue *OutboundQueue, timeout time.Duration) (Notification, bool) {
+	deadline := time.After(timeout)
+	for {
+		notification, ok := queue.Pop()
+		if ok {
+			return notification, true
+		}
+
+		select {
+		case <-queue.Ready():
+		case <-deadline:
+			return Notification{}, false
+		}
+	}
+}
+
+func TestBroker_BroadcastEvent_IsAllOrNothing(t *testing.T) {
+	repository := &failingNotificationRepository{NewMemoryNotificationRepository()}
+	broker := runTestBroker(t, repository)
+	ctx := context.Background()
+
+	_, err := broker.BroadcastEvent(ctx, BroadcastEvent{SourceID: "test", Destinations: []string{"1", "fail", "2"}, Data: `"hi"`})
+	assertContent(t, err != nil, true)
+
+	for _, destinationID := range []string{"1", "2"} {
+		notifications, err := repository.GetAll(ctx, destinationID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(notifications), 0)
+	}
+
+	notifications, err := broker.BroadcastEvent(ctx, BroadcastEvent{SourceID: "test", Destinations: []string{"1", "2"}, Data: `"hi"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(notifications), 2)
+	assertContent(t, notifications[1].DestinationID, "2")
+}
+
+func TestBroker_BroadcastEventPartially_TellsEachOutcome(t *testing.T) {
+	repository := &failingNotificationRepository{NewMemoryNotificationRepository()}
+	broker := runTestBroker(t, repository)
+	ctx := context.Background()
+
+	results := broker.BroadcastEventPartially(ctx, BroadcastEvent{SourceID: "test", Destinations: []string{"1", "fail", "2"}, Data: `"hi"`})
+	assertContent(t, len(results), 3)
+
+	assertContent(t, results[0].DestinationID, "1")
+	assertContent(t, results[0].Err, nil)
+	assertContent(t, results[0].Notification.ID != 0, true)
+
+	assertContent(t, results[1].DestinationID, "fail")
+	assertContent(t, results[1].Err != nil, true)
+
+	assertContent(t, results[2].DestinationID, "2")
+	assertContent(t, results[2].Err, nil)
+
+	notifications, err := repository.GetAll(ctx, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(notifications), 1)
+}
+
+func TestBroker_NotifyEvent_WithCollapseKey(t *testing.T) {
+	broker := runTestBroker(t, NewMemoryNotificationRepository())
+	ctx := context.Background()
+
+	queue := NewOutboundQueue(10)
+	broker.NotifyClientConnected(Client{TenantID: DefaultTenantID, ID: "123", Queue: queue, Done: make(chan struct{})})
+
+	first, err := broker.NotifyEvent(ctx, Event{SourceID: "chat", DestinationID: "123", Data: `"1 new comment"`, CollapseKey: "post-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, first.Updated, false)
+
+	second, err := broker.NotifyEvent(ctx, Event{SourceID: "chat", DestinationID: "123", Data: `"2 new comments"`, CollapseKey: "post-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, second.Updated, true)
+	assertContent(t, second.ID, first.ID)
+
+	// The client gets the update of the very same notification
+	delivered, _ := nextNotification(queue, time.Second)
+	assertContent(t, delivered.Updated, false)
+
+	delivered, _ = nextNotification(queue, time.Second)
+	assertContent(t, delivered.Updated, true)
+	assertContent(t, delivered.ID, first.ID)
+	assertContent(t, delivered.Data, JSONData(`"2 new comments"`))
+}
+
+func TestBroker_NotifyEvent_Scheduled(t *testing.T) {
+	broker := runTestBroker(t, NewMemoryNotificationRepository())
+	ctx := context.Background()
+
+	queue := NewOutboundQueue(10)
+	broker.NotifyClientConnected(Client{TenantID: DefaultTenantID, ID: "123", Queue: queue, Done: make(chan struct{})})
+
+	deliverAt := time.Now().Add(100 * time.Millisecond)
+	scheduled, err := broker.NotifyEvent(ctx, Event{SourceID: "reminders", DestinationID: "123", Data: `"meeting in 5 minutes"`, DeliverAt: &deliverAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, scheduled.DeliverAt != nil, true)
+
+	// It is not delivered until due, and then by the scheduler
+	if _, ok := nextNotification(queue, 50*time.Millisecond); ok {
+		t.Fatal("scheduled notification was delivered too early")
+	}
+
+	delivered, ok := nextNotification(queue, time.Second)
+	if !ok {
+		t.Fatal("scheduled notification was never delivered")
+	}
+	assertContent(t, delivered.ID, scheduled.ID)
+	assertContent(t, delivered.DeliverAt == nil, true)
+}
+
+func TestBroker_NotifyEvent_OverQuota(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Reject", func(t *testing.T) {
+		repository := NewMemoryNotificationRepository()
+		broker := runTestBrokerWithQuota(t, repository, QuotaSettings{MaxNotifications: 2, Policy: QuotaPolicyReject})
+
+		for i := 0; i < 2; i++ {
+			_, err := broker.NotifyEvent(ctx, Event{SourceID: "test", DestinationID: "123", Data: `"hi"`})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, err := broker.NotifyEvent(ctx, Event{SourceID: "test", DestinationID: "123", Data: `"hi"`})
+		assertContent(t, errors.Is(err, ErrQuotaExceeded), true)
+
+		// Other clients have quotas of their own
+		_, err = broker.NotifyEvent(ctx, Event{SourceID: "test", DestinationID: "456", Data: `"hi"`})
+		assertContent(t, err, nil)
+	})
+
+	t.Run("Evict", func(t *testing.T) {
+		repository := NewMemoryNotificationRepository()
+		broker := runTestBrokerWithQuota(t, repository, QuotaSettings{MaxNotifications: 2, MaxDataSize: 10, Policy: QuotaPolicyEvict})
+
+		first, err := broker.NotifyEvent(ctx, Event{SourceID: "test", DestinationID: "123", Data: `"first"`})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = broker.NotifyEvent(ctx, Event{SourceID: "test", DestinationID: "123", Data: `"second"`})
+		assertContent(t, errors.Is(err, ErrQuotaExceeded), true)
+
+		// Once it is read, it makes room for what comes next
+		readAt := time.Now()
+		first.ReadAt = &readAt
+		err = repository.Update(ctx, &first)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = broker.NotifyEvent(ctx, Event{SourceID: "test", DestinationID: "123", Data: `"second"`})
+		assertContent(t, err, nil)
+
+		usage, err := broker.ClientUsage(ctx, DefaultTenantID, "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, usage, ClientUsage{Notifications: 1, DataSize: 8})
+
+		// Nothing makes room for data larger than the cap
+		_, err = broker.NotifyEvent(ctx, Event{SourceID: "test", DestinationID: "123", Data: `"way too large"`})
+		assertContent(t, errors.Is(err, ErrQuotaExceeded), true)
+	})
+}
+
+func TestBroker_NotifyEvent_WhileQuiet(t *testing.T) {
+	repository := NewMemoryNotificationRepository()
+	settings := &memoryClientSettingsRepository{settings: map[string]ClientSettings{}}
+	broker := runTestBrokerWith(t, repository, QuotaSettings{}, settings, nil)
+	ctx := context.Background()
+
+	snoozedUntil := time.Now().Add(150 * time.Millisecond)
+	snoozed := NewClientSettings("123")
+	snoozed.QuietRelease = QuietReleaseSummary
+	snoozed.SnoozedUntil = &snoozedUntil
+	settings.Save(ctx, snoozed)
+
+	queue := NewOutboundQueue(10)
+	broker.NotifyClientConnected(Client{TenantID: DefaultTenantID, ID: "123", Queue: queue, Done: make(chan struct{})})
+
+	held := []uint{}
+	for _, data := range []JSONData{`"1 new comment"`, `"2 new comments"`} {
+		notification, err := broker.NotifyEvent(ctx, Event{SourceID: "chat", DestinationID: "123", Data: data})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, notification.HeldUntil != nil, true)
+		held = append(held, notification.ID)
+	}
+
+	// Urgent notifications go through right away
+	urgent, err := broker.NotifyEvent(ctx, Event{SourceID: "alarm", DestinationID: "123", Data: `"fire"`, Priority: PriorityUrgent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, urgent.HeldUntil == nil, true)
+
+	delivered, _ := nextNotification(queue, time.Second)
+	assertContent(t, delivered.ID, urgent.ID)
+
+	// Held notifications are persisted all along, and summed up once the client is done snoozing
+	notifications, err := repository.GetAll(ctx, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(notifications), 3)
+
+	if _, ok := nextNotification(queue, 50*time.Millisecond); ok {
+		t.Fatal("held notification was delivered while snoozing")
+	}
+
+	summary, ok := nextNotification(queue, time.Second)
+	if !ok {
+		t.Fatal("held notifications were never released")
+	}
+	assertContent(t, summary.Type, QuietSummaryType)
+
+	var data struct {
+		Count           int    `json:"count"`
+		NotificationIDs []uint `json:"notificationIDs"`
+	}
+	err = json.Unmarshal([]byte(summary.Data), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, data.Count, 2)
+	assertContent(t, data.NotificationIDs[0], held[0])
+	assertContent(t, data.NotificationIDs[1], held[1])
+}
+
+func TestBroker_NotifyEvent_Digested(t *testing.T) {
+	repository := NewMemoryNotificationRepository()
+	digests := &memoryClientDigestRepository{digests: map[string][]ClientDigest{
+		"123": {{ClientID: "123", Category: "social", Interval: 1}},
+	}}
+	broker := runTestBrokerWith(t, repository, QuotaSettings{}, nil, digests)
+	ctx := context.Background()
+
+	queue := NewOutboundQueue(10)
+	broker.NotifyClientConnected(Client{TenantID: DefaultTenantID, ID: "123", Queue: queue, Done: make(chan struct{})})
+
+	accumulated := []uint{}
+	for _, data := range []JSONData{`"Ann likes your post"`, `"Bob likes your post"`} {
+		notification, err := broker.NotifyEvent(ctx, Event{SourceID: "likes", DestinationID: "123", Category: "social", Data: data})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, notification.DigestAt != nil, true)
+		accumulated = append(accumulated, notification.ID)
+	}
+
+	// Other categories and urgent notifications go through right away
+	billing, err := broker.NotifyEvent(ctx, Event{SourceID: "billing", DestinationID: "123", Category: "billing", Data: `"Invoice due"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, billing.DigestAt == nil, true)
+
+	urgent, err := broker.NotifyEvent(ctx, Event{SourceID: "likes", DestinationID: "123", Category: "social", Data: `"Account hacked?"`, Priority: PriorityUrgent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, urgent.DigestAt == nil, true)
+
+	// Which of them is popped first depends on whether the billing one is still queued when the urgent one comes
+	delivered := map[uint]bool{}
+	for i := 0; i < 2; i++ {
+		notification, _ := nextNotification(queue, time.Second)
+		delivered[notification.ID] = true
+	}
+	assertContent(t, len(delivered), 2)
+	assertContent(t, delivered[urgent.ID], true)
+	assertContent(t, delivered[billing.ID], true)
+
+	// Accumulated notifications are summed up in a digest, which is persisted along with them
+	digest, ok := nextNotification(queue, 2*time.Second)
+	if !ok {
+		t.Fatal("digest was never released")
+	}
+	assertContent(t, digest.Type, DigestType)
+	assertContent(t, digest.Category, "social")
+	assertContent(t, digest.ID != 0, true)
+
+	var data struct {
+		Category        string `json:"category"`
+		Count           int    `json:"count"`
+		NotificationIDs []uint `json:"notificationIDs"`
+	}
+	err = json.Unmarshal([]byte(digest.Data), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, data.Category, "social")
+	assertContent(t, data.Count, 2)
+	assertContent(t, data.NotificationIDs[0], accumulated[0])
+	assertContent(t, data.NotificationIDs[1], accumulated[1])
+
+	notifications, err := repository.GetAll(ctx, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(notifications), 5)
+}
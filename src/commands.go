@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// RunCommand runs an administrative command given on the command line instead of starting the service
+func RunCommand(args []string) error {
+	switch args[0] {
+	case "apikey":
+		return runAPIKeyCommand(args[1:])
+	case "migrate":
+		return runMigrateCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command '%s'", args[0])
+	}
+}
+
+// runAPIKeyCommand handles `mercurio apikey create|list|revoke`
+func runAPIKeyCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: mercurio apikey create|list|revoke")
+	}
+
+	database, err := ConnectDatabase()
+	if err != nil {
+		return err
+	}
+
+	apiKeys, err := NewSQLAPIKeyRepository(database)
+	if err != nil {
+		return fmt.Errorf("failed to create API key repository due to: %s", err)
+	}
+
+	audit, err := NewSQLAuditRepository(database)
+	if err != nil {
+		return fmt.Errorf("failed to create audit repository due to: %s", err)
+	}
+
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+		tenantID := flags.String("tenant", DefaultTenantID, "the tenant the key belongs to")
+		name := flags.String("name", "", "a name to tell what the key is for")
+		sourceID := flags.String("source", "", "the sourceID the key is allowed to publish on behalf of")
+		scopes := flags.String("scopes", ScopeEventsUnicast+","+ScopeEventsBroadcast, "comma separated scopes")
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+
+		apiKey, plainKey, err := NewAPIKey(*tenantID, *name, *sourceID, strings.Split(*scopes, ","))
+		if err != nil {
+			return err
+		}
+
+		err = apiKeys.Add(apiKey)
+		if err != nil {
+			return fmt.Errorf("failed to add API key due to: %s", err)
+		}
+
+		recordCommandAudit(audit, apiKey.TenantID, AuditActionCreateAPIKey, strconv.Itoa(int(apiKey.ID)))
+
+		fmt.Printf("API key %d created for source %s. Keep it safe, it won't be shown again:\n\n%s\n", apiKey.ID, apiKey.SourceID, plainKey)
+
+	case "list":
+		all, err := apiKeys.GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to list API keys due to: %s", err)
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tTENANT\tNAME\tSOURCE\tSCOPES\tCREATED\tLAST USED\tREVOKED")
+		for _, apiKey := range all {
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				apiKey.ID, apiKey.TenantID, apiKey.Name, apiKey.SourceID, apiKey.Scopes,
+				apiKey.CreatedAt.Format(time.RFC3339), formatOptionalTime(apiKey.LastUsedAt), formatOptionalTime(apiKey.RevokedAt))
+		}
+		writer.Flush()
+
+	case "revoke":
+		if len(args) < 2 {
+			return errors.New("usage: mercurio apikey revoke <id>")
+		}
+
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("%s is not a valid API key id", args[1])
+		}
+
+		apiKey, err := RevokeAPIKey(apiKeys, uint(id))
+		if err != nil {
+			return fmt.Errorf("failed to revoke API key %d due to: %s", id, err)
+		}
+
+		recordCommandAudit(audit, apiKey.TenantID, AuditActionRevokeAPIKey, strconv.Itoa(int(apiKey.ID)))
+
+		fmt.Printf("API key %d (%s) revoked\n", apiKey.ID, apiKey.Name)
+
+	default:
+		return fmt.Errorf("unknown apikey command '%s'", args[0])
+	}
+
+	return nil
+}
+
+// runMigrateCommand handles `mercurio migrate up|down|status`. Unlike other commands, it doesn't require the database
+// schema to be up to date, which is the whole point of it
+func runMigrateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: mercurio migrate up|down|status")
+	}
+
+	database, driver, err := OpenDatabase()
+	if err != nil {
+		return err
+	}
+
+	migrator, err := NewMigrator(database, driver)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := migrator.Up()
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%d migration(s) applied to %s database\n", len(done), driver)
+
+	case "down":
+		done, err := migrator.Down()
+		if err != nil {
+			return err
+		}
+
+		if done == nil {
+			fmt.Printf("There is no migration to revert on %s database\n", driver)
+		} else {
+			fmt.Printf("Migration %04d_%s reverted on %s database\n", done.Version, done.Name, driver)
+		}
+
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil && !errors.Is(err, ErrSchemaTooNew) {
+			return err
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			fmt.Fprintf(writer, "%04d\t%s\t%s\n", status.Version, status.Name, formatOptionalTime(status.AppliedAt))
+		}
+		writer.Flush()
+
+		// Still worth showing what is known before telling something is off
+		if err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unknown migrate command '%s'", args[0])
+	}
+
+	return nil
+}
+
+// recordCommandAudit appends an entry to the audit trail for an action taken on the command line, by the OS user
+func recordCommandAudit(audit AuditRepository, tenantID string, action string, target string) {
+	entry := &AuditEntry{
+		TenantID:  tenantID,
+		Action:    action,
+		ActorType: AuditActorCLI,
+		ActorID:   os.Getenv("USER"),
+		Target:    target,
+	}
+
+	err := audit.Add(entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "AUDIT FAILURE: could not record %s of %s due to: %s\n", action, target, err)
+	}
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
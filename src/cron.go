@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronExpression is a parsed cron expression, i.e. the five standard fields (minute, hour, day of month, month and
+// day of week) or one of the usual shorthands (e.g. @daily). Fields take values, ranges, steps and lists of those, as
+// well as the names of months and days of week (e.g. "0 9 * * MON-FRI")
+type CronExpression struct {
+	minutes     uint64
+	hours       uint64
+	daysOfMonth uint64
+	months      uint64
+	daysOfWeek  uint64
+
+	// As usual, a day matches either field when both are restricted, or the restricted one otherwise
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+// cronShorthands are expressions known by name
+var cronShorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronField tells the bounds of a field, and the names its values go by, if any
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+var (
+	cronMinuteField     = cronField{name: "minute", min: 0, max: 59}
+	cronHourField       = cronField{name: "hour", min: 0, max: 23}
+	cronDayOfMonthField = cronField{name: "day of month", min: 1, max: 31}
+	cronMonthField      = cronField{name: "month", min: 1, max: 12, names: []string{"", "JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}}
+	cronDayOfWeekField  = cronField{name: "day of week", min: 0, max: 7, names: []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}}
+)
+
+// cronSearchLimit is how far ahead to look for the next time of an expression, which may never come (e.g. "0 0 30 2 *")
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// ParseCronExpression parses a cron expression
+func ParseCronExpression(expression string) (CronExpression, error) {
+	expression = strings.TrimSpace(expression)
+	if shorthand, ok := cronShorthands[strings.ToLower(expression)]; ok {
+		expression = shorthand
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return CronExpression{}, fmt.Errorf("cron expression %q must have 5 fields, it has %d", expression, len(fields))
+	}
+
+	var cron CronExpression
+	var err error
+	for i, parsed := range []struct {
+		field cronField
+		bits  *uint64
+	}{
+		{cronMinuteField, &cron.minutes},
+		{cronHourField, &cron.hours},
+		{cronDayOfMonthField, &cron.daysOfMonth},
+		{cronMonthField, &cron.months},
+		{cronDayOfWeekField, &cron.daysOfWeek},
+	} {
+		*parsed.bits, err = parsed.field.parse(fields[i])
+		if err != nil {
+			return CronExpression{}, err
+		}
+	}
+
+	// Sunday is either 0 or 7
+	if cron.daysOfWeek&(1<<7) != 0 {
+		cron.daysOfWeek |= 1
+	}
+
+	cron.anyDayOfMonth = strings.HasPrefix(fields[2], "*")
+	cron.anyDayOfWeek = strings.HasPrefix(fields[4], "*")
+
+	return cron, nil
+}
+
+// parse a field into the set of its values, as bits
+func (field cronField) parse(text string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(text, ",") {
+		first, last, step := field.min, field.max, 1
+
+		rangeText := part
+		if i := strings.Index(part, "/"); i >= 0 {
+			rangeText = part[:i]
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("%s step in %q is not valid", field.name, part)
+			}
+		}
+
+		if rangeText != "*" {
+			bounds := strings.SplitN(rangeText, "-", 2)
+			var err error
+			first, err = field.value(bounds[0])
+			if err != nil {
+				return 0, err
+			}
+
+			last = first
+			if len(bounds) == 2 {
+				last, err = field.value(bounds[1])
+				if err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// e.g. 5/15 means from 5 onwards, every 15
+				last = field.max
+			}
+
+			if first > last {
+				return 0, fmt.Errorf("%s range in %q is backwards", field.name, part)
+			}
+		}
+
+		for value := first; value <= last; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+// value of a field, given either as a number or by its name
+func (field cronField) value(text string) (int, error) {
+	for value, name := range field.names {
+		if name != "" && strings.EqualFold(text, name) {
+			return value, nil
+		}
+	}
+
+	value, err := strconv.Atoi(text)
+	if err != nil || value < field.min || value > field.max {
+		return 0, fmt.Errorf("%s %q is not valid", field.name, text)
+	}
+
+	return value, nil
+}
+
+// Next time of the expression strictly after a given one, in a given time zone. It is zero if there is none in the
+// years to come. Times skipped by a daylight saving change are skipped as well, and repeated ones only match once
+func (cron CronExpression) Next(after time.Time, location *time.Location) time.Time {
+	t := after.In(location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronSearchLimit)
+
+	for t.Before(limit) {
+		if !hasCronValue(cron.months, int(t.Month())) {
+			t = cronForward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, location))
+			continue
+		}
+
+		if !cron.matchesDay(t) {
+			t = cronForward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location))
+			continue
+		}
+
+		// Hours are counted as they go by, since an hour skipped by a daylight saving change has no time of its own
+		if !hasCronValue(cron.hours, t.Hour()) {
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+
+		if !hasCronValue(cron.minutes, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// cronForward to a given time, unless it isn't ahead, which happens when it doesn't exist in the time zone (e.g.
+// midnight skipped by a daylight saving change) and it is taken as an earlier one. Then it is a minute ahead
+func cronForward(t time.Time, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Add(time.Minute)
+}
+
+func (cron CronExpression) matchesDay(t time.Time) bool {
+	dayOfMonth := hasCronValue(cron.daysOfMonth, t.Day())
+	dayOfWeek := hasCronValue(cron.daysOfWeek, int(t.Weekday()))
+
+	if cron.anyDayOfMonth || cron.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+func hasCronValue(bits uint64, value int) bool {
+	return bits&(1<<uint(value)) != 0
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronExpression_Next(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		expression string
+		after      string
+		location   *time.Location
+		next       string
+	}{
+		{"* * * * *", "2021-03-01T10:00:30Z", time.UTC, "2021-03-01T10:01:00Z"},
+		{"*/15 * * * *", "2021-03-01T10:01:00Z", time.UTC, "2021-03-01T10:15:00Z"},
+		{"0 9 * * MON-FRI", "2021-03-05T09:00:00Z", time.UTC, "2021-03-08T09:00:00Z"},
+		{"30 8 1,15 * *", "2021-03-02T00:00:00Z", time.UTC, "2021-03-15T08:30:00Z"},
+		{"0 0 * * 7", "2021-03-01T00:00:00Z", time.UTC, "2021-03-07T00:00:00Z"},
+		{"0 12 13 * FRI", "2021-03-01T00:00:00Z", time.UTC, "2021-03-05T12:00:00Z"},
+		{"@monthly", "2021-12-31T23:59:00Z", time.UTC, "2022-01-01T00:00:00Z"},
+		{"0 0 29 FEB *", "2021-03-01T00:00:00Z", time.UTC, "2024-02-29T00:00:00Z"},
+		{"0 9 * * *", "2021-03-01T00:00:00Z", newYork, "2021-03-01T14:00:00Z"},
+		{"0 9 * * *", "2021-03-14T00:00:00Z", newYork, "2021-03-14T13:00:00Z"},
+		{"30 2 * * *", "2021-03-14T00:00:00Z", newYork, "2021-03-15T06:30:00Z"},
+		{"0 0 30 2 *", "2021-03-01T00:00:00Z", time.UTC, "0001-01-01T00:00:00Z"},
+	} {
+		after, _ := time.Parse(time.RFC3339, test.after)
+
+		cron, err := ParseCronExpression(test.expression)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		next := cron.Next(after, test.location)
+		assertContent(t, next.UTC().Format(time.RFC3339), test.next)
+	}
+}
+
+func TestParseCronExpression_WhenNotValid(t *testing.T) {
+	for _, expression := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "@never", "* * * FOO *"} {
+		_, err := ParseCronExpression(expression)
+		assertContent(t, err != nil, true)
+	}
+}
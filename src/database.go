@@ -1,60 +1,155 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"gorm.io/gorm"
 )
 
-// SQLNotificationRepository is the concrete implementation of NotificationRepository for an SQL database
+var (
+	// DatabaseDriverSqlite stands for SQLite, which is fine for development but not much more
+	DatabaseDriverSqlite = "sqlite"
+
+	// DatabaseDriverPostgres stands for PostgreSQL, which allows for many service nodes sharing a database
+	DatabaseDriverPostgres = "postgres"
+
+	// DatabaseDriverMySQL stands for MySQL/MariaDB, which allows for many service nodes sharing a database too
+	DatabaseDriverMySQL = "mysql"
+
+	// DatabaseDriverMemory keeps everything in memory, which is handy for development and tests, and that is it
+	DatabaseDriverMemory = "memory"
+)
+
+// DatabasePoolSettings holds in the connection pool parameters of database servers (i.e. not SQLite)
+type DatabasePoolSettings struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// SQLNotificationRepository is the concrete implementation of NotificationRepository for an SQL database, results
+// ordered by ID
 type SQLNotificationRepository struct {
-	db *gorm.DB
+	db           *gorm.DB
+	tenantID     string
+	queryTimeout time.Duration
 }
 
-// NewSQLNotificationRepository creates a new SQLNotificationRepository instance with an underlying GORM's database abstraction
-func NewSQLNotificationRepository(db *gorm.DB) (*SQLNotificationRepository, error) {
+// NewSQLNotificationRepository creates a new SQLNotificationRepository instance with an underlying GORM's database abstraction,
+// bound to the default tenant. Queries taking longer than queryTimeout are interrupted; zero means no timeout other than
+// the caller's own context
+func NewSQLNotificationRepository(db *gorm.DB, queryTimeout time.Duration) (*SQLNotificationRepository, error) {
 	repository := &SQLNotificationRepository{
-		db: db,
+		db:           db,
+		tenantID:     DefaultTenantID,
+		queryTimeout: queryTimeout,
 	}
 
 	return repository, nil
 }
 
+// ForTenant gives a copy of the repository bound to the given tenant
+func (repository *SQLNotificationRepository) ForTenant(tenantID string) NotificationRepository {
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+
+	return &SQLNotificationRepository{
+		db:           repository.db,
+		tenantID:     tenantID,
+		queryTimeout: repository.queryTimeout,
+	}
+}
+
+// query runs the given function on a database session bound to the caller's context and the query timeout
+func (repository *SQLNotificationRepository) query(ctx context.Context, fn func(db *gorm.DB) error) error {
+	return queryWithTimeout(ctx, repository.db, repository.queryTimeout, "notifications", fn)
+}
+
+// queryWithTimeout runs the given function on a database session bound to a context and a query timeout. When the
+// query fails because the context is done, the error tells so (i.e. errors.Is context.DeadlineExceeded/Canceled)
+func queryWithTimeout(ctx context.Context, db *gorm.DB, timeout time.Duration, what string, fn func(db *gorm.DB) error) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err := fn(db.WithContext(ctx))
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("query on %s was interrupted: %w", what, ctx.Err())
+	}
+
+	return err
+}
+
+// scoped applies tenant isolation to every query, so there is no way to reach another tenant's notifications
+func (repository *SQLNotificationRepository) scoped(db *gorm.DB) *gorm.DB {
+	return db.Where("tenant_id = ?", repository.tenantID)
+}
+
+// visible leaves out notifications scheduled for later, as well as expired ones
+func visible(db *gorm.DB) *gorm.DB {
+	now := time.Now().UTC()
+	return db.Where("(deliver_at IS NULL OR deliver_at <= ?) AND (expires_at IS NULL OR expires_at > ?)", now, now)
+}
+
 // Add a notification to the SQL database
-func (repository *SQLNotificationRepository) Add(notification *Notification) error {
-	result := repository.db.Create(notification)
-	if result.Error != nil {
-		return result.Error
+func (repository *SQLNotificationRepository) Add(ctx context.Context, notification *Notification) error {
+	notification.TenantID = repository.tenantID
+
+	return repository.query(ctx, func(db *gorm.DB) error {
+		return db.Create(notification).Error
+	})
+}
+
+// sqlInsertBatchSize is how many rows go on a single INSERT statement, which keeps it below every database's
+// limit of bind parameters
+const sqlInsertBatchSize = 100
+
+// AddBatch of notifications to the SQL database, all or nothing, in multi-row inserts
+func (repository *SQLNotificationRepository) AddBatch(ctx context.Context, notifications []*Notification) error {
+	if len(notifications) == 0 {
+		return nil
 	}
 
-	return nil
+	for _, notification := range notifications {
+		notification.TenantID = repository.tenantID
+	}
+
+	return repository.query(ctx, func(db *gorm.DB) error {
+		return db.CreateInBatches(notifications, sqlInsertBatchSize).Error
+	})
 }
 
 // Update a notification in the SQL database
-func (repository *SQLNotificationRepository) Update(notification *Notification) error {
-	result := repository.db.Save(notification)
-	if result.Error != nil {
-		return result.Error
+func (repository *SQLNotificationRepository) Update(ctx context.Context, notification *Notification) error {
+	if notification.TenantID != repository.tenantID {
+		return ErrNotificationNotFound
 	}
 
-	return nil
+	return repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).Save(notification).Error
+	})
 }
 
 // Delete a notification in the SQL database
-func (repository *SQLNotificationRepository) Delete(id uint) error {
-	result := repository.db.Delete(&Notification{}, id)
-	if result.Error != nil {
-		return result.Error
-	}
-
-	return nil
+func (repository *SQLNotificationRepository) Delete(ctx context.Context, id uint) error {
+	return repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).Delete(&Notification{}, id).Error
+	})
 }
 
 // Get a notification in the SQL database by its ID
-func (repository *SQLNotificationRepository) Get(id uint) (Notification, error) {
+func (repository *SQLNotificationRepository) Get(ctx context.Context, id uint) (Notification, error) {
 	var notification Notification
-	result := repository.db.First(&notification, id)
-	if err := result.Error; err != nil {
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).First(&notification, id).Error
+	})
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return Notification{}, ErrNotificationNotFound
 		}
@@ -65,18 +160,20 @@ func (repository *SQLNotificationRepository) Get(id uint) (Notification, error)
 }
 
 // GetAll the notifications in the SQL database
-func (repository *SQLNotificationRepository) GetAll(destinationID string) ([]Notification, error) {
+func (repository *SQLNotificationRepository) GetAll(ctx context.Context, destinationID string) ([]Notification, error) {
 	var notifications []Notification
-	result := repository.db.Where(&Notification{DestinationID: destinationID}).Find(&notifications)
-	if result.Error != nil {
-		return []Notification{}, result.Error
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return visible(repository.scoped(db)).Where(&Notification{DestinationID: destinationID}).Order("id").Find(&notifications).Error
+	})
+	if err != nil {
+		return []Notification{}, err
 	}
 
 	return notifications, nil
 }
 
 // GetByStatus the notifications in the SQL database by its status (read/unread/all)
-func (repository *SQLNotificationRepository) GetByStatus(destinationID string, status string) ([]Notification, error) {
+func (repository *SQLNotificationRepository) GetByStatus(ctx context.Context, destinationID string, status string) ([]Notification, error) {
 	criteria := "destination_id = ?"
 	if status == StatusUnreadNotifications {
 		criteria += " AND read_at IS NULL"
@@ -86,23 +183,345 @@ func (repository *SQLNotificationRepository) GetByStatus(destinationID string, s
 	}
 
 	var notifications []Notification
-	result := repository.db.Where(criteria, destinationID).Find(&notifications)
-	if result.Error != nil {
-		return []Notification{}, result.Error
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return visible(repository.scoped(db)).Where(criteria, destinationID).Order("id").Find(&notifications).Error
+	})
+	if err != nil {
+		return []Notification{}, err
 	}
 
 	return notifications, nil
 }
 
 // FilterBy all notifications in the SQL database by given criteria
-func (repository *SQLNotificationRepository) FilterBy(destinationID string, criteria Notification) ([]Notification, error) {
+func (repository *SQLNotificationRepository) FilterBy(ctx context.Context, destinationID string, criteria Notification) ([]Notification, error) {
+	criteria.TenantID = repository.tenantID
 	criteria.DestinationID = destinationID
 
 	var notifications []Notification
-	result := repository.db.Where(&criteria).Find(&notifications)
-	if result.Error != nil {
-		return []Notification{}, result.Error
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return visible(repository.scoped(db)).Where(&criteria).Order("id").Find(&notifications).Error
+	})
+	if err != nil {
+		return []Notification{}, err
 	}
 
 	return notifications, nil
 }
+
+// Notified tells which of the given destinations have a notification of an event in the SQL database, whether it is
+// released yet or not
+func (repository *SQLNotificationRepository) Notified(ctx context.Context, eventID string, destinationIDs []string) ([]string, error) {
+	var notified []string
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).Model(&Notification{}).
+			Where("event_id = ? AND destination_id IN ?", eventID, destinationIDs).
+			Pluck("destination_id", &notified).Error
+	})
+	if err != nil {
+		return []string{}, err
+	}
+
+	return notified, nil
+}
+
+// Collapse a notification into the latest unread one (already delivered and not expired) of its destination with the
+// same collapse key, in the SQL database: that one gets its event, type, category, topic, data, timestamp, expiry,
+// priority and hold (and it tells true), otherwise the notification is added
+func (repository *SQLNotificationRepository) Collapse(ctx context.Context, notification *Notification) (bool, error) {
+	notification.TenantID = repository.tenantID
+
+	collapsed := false
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			var existing []Notification
+			err := repository.scoped(tx).
+				Where("destination_id = ? AND collapse_key = ? AND read_at IS NULL AND deliver_at IS NULL", notification.DestinationID, notification.CollapseKey).
+				Where("(expires_at IS NULL OR expires_at > ?)", time.Now().UTC()).
+				Order("id DESC").Limit(1).Find(&existing).Error
+			if err != nil {
+				return err
+			}
+
+			if len(existing) == 0 {
+				return tx.Create(notification).Error
+			}
+
+			notification.ID = existing[0].ID
+			notification.CreatedAt = time.Now()
+			collapsed = true
+
+			return repository.scoped(tx).Model(&Notification{}).Where("id = ?", notification.ID).Updates(map[string]interface{}{
+				"event_id":   notification.EventID,
+				"source_id":  notification.SourceID,
+				"event_type": notification.Type,
+				"category":   notification.Category,
+				"topic":      notification.Topic,
+				"data":       notification.Data,
+				"created_at": notification.CreatedAt,
+				"expires_at": notification.ExpiresAt,
+				"priority":   notification.Priority,
+				"held_until": notification.HeldUntil,
+				"digest_at":  notification.DigestAt,
+			}).Error
+		})
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return collapsed, nil
+}
+
+// scheduled narrows down to the notifications of an event of a source which are yet to be released
+func (repository *SQLNotificationRepository) scheduled(db *gorm.DB, sourceID string, eventID string) *gorm.DB {
+	return repository.scoped(db).Where("source_id = ? AND event_id = ? AND deliver_at IS NOT NULL", sourceID, eventID)
+}
+
+// Reschedule the notifications of an event in the SQL database, as long as they are yet to be released. It tells how
+// many of them there were
+func (repository *SQLNotificationRepository) Reschedule(ctx context.Context, sourceID string, eventID string, deliverAt time.Time) (int64, error) {
+	var rowsAffected int64
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		result := repository.scheduled(db, sourceID, eventID).Model(&Notification{}).Update("deliver_at", deliverAt.UTC())
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+
+	return rowsAffected, err
+}
+
+// CancelScheduled notifications of an event in the SQL database, i.e. delete them as long as they are yet to be
+// released. It tells how many of them there were
+func (repository *SQLNotificationRepository) CancelScheduled(ctx context.Context, sourceID string, eventID string) (int64, error) {
+	var rowsAffected int64
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		result := repository.scheduled(db, sourceID, eventID).Delete(&Notification{})
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+
+	return rowsAffected, err
+}
+
+// ReleaseDue claims up to limit scheduled notifications which are due, of every tenant, in the SQL database. Claiming
+// one clears its schedule only if nobody did it first, so that each is released by one service node only
+func (repository *SQLNotificationRepository) ReleaseDue(ctx context.Context, now time.Time, limit int) ([]Notification, error) {
+	released, err := repository.claim(ctx, "deliver_at", now, limit)
+	for i := range released {
+		released[i].DeliverAt = nil
+	}
+
+	return released, err
+}
+
+// Hold a notification back from live delivery in the SQL database until a given time
+func (repository *SQLNotificationRepository) Hold(ctx context.Context, id uint, until time.Time) error {
+	return repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).Model(&Notification{}).Where("id = ?", id).Update("held_until", until.UTC()).Error
+	})
+}
+
+// RescheduleHeld notifications of a destination in the SQL database, i.e. change when they are released, as long as
+// they are yet to be. It tells how many of them there were
+func (repository *SQLNotificationRepository) RescheduleHeld(ctx context.Context, destinationID string, until time.Time) (int64, error) {
+	var rowsAffected int64
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		result := repository.scoped(db).Model(&Notification{}).Where("destination_id = ? AND held_until IS NOT NULL", destinationID).
+			Update("held_until", until.UTC())
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+
+	return rowsAffected, err
+}
+
+// ReleaseHeld claims up to limit held notifications whose quiet time is over, of every tenant, in the SQL database,
+// just like ReleaseDue does
+func (repository *SQLNotificationRepository) ReleaseHeld(ctx context.Context, now time.Time, limit int) ([]Notification, error) {
+	released, err := repository.claim(ctx, "held_until", now, limit)
+	for i := range released {
+		released[i].HeldUntil = nil
+	}
+
+	return released, err
+}
+
+// Accumulate a notification for a digest in the SQL database, which keeps it from live delivery until a given time
+func (repository *SQLNotificationRepository) Accumulate(ctx context.Context, id uint, at time.Time) error {
+	return repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).Model(&Notification{}).Where("id = ?", id).Update("digest_at", at.UTC()).Error
+	})
+}
+
+// RescheduleDigests of a destination in the SQL database, i.e. change when the notifications accumulated for them are
+// released, as long as they are yet to be. It tells how many of them there were
+func (repository *SQLNotificationRepository) RescheduleDigests(ctx context.Context, destinationID string, at time.Time) (int64, error) {
+	var rowsAffected int64
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		result := repository.scoped(db).Model(&Notification{}).Where("destination_id = ? AND digest_at IS NOT NULL", destinationID).
+			Update("digest_at", at.UTC())
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+
+	return rowsAffected, err
+}
+
+// ReleaseDigests claims up to limit notifications accumulated for digests which are due, of every tenant, in the SQL
+// database, just like ReleaseDue does
+func (repository *SQLNotificationRepository) ReleaseDigests(ctx context.Context, now time.Time, limit int) ([]Notification, error) {
+	released, err := repository.claim(ctx, "digest_at", now, limit)
+	for i := range released {
+		released[i].DigestAt = nil
+	}
+
+	return released, err
+}
+
+// claim up to limit notifications whose time column (i.e. deliver_at, held_until or digest_at) is past, by clearing it only if
+// nobody did it first
+func (repository *SQLNotificationRepository) claim(ctx context.Context, column string, now time.Time, limit int) ([]Notification, error) {
+	past := fmt.Sprintf("%s IS NOT NULL AND %s <= ?", column, column)
+
+	var due []Notification
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return db.Where(past, now.UTC()).Order(column + ", id").Limit(limit).Find(&due).Error
+	})
+	if err != nil {
+		return []Notification{}, err
+	}
+
+	claimed := []Notification{}
+	for _, notification := range due {
+		var rowsAffected int64
+		err := repository.query(ctx, func(db *gorm.DB) error {
+			result := db.Model(&Notification{}).Where("id = ?", notification.ID).Where(past, now.UTC()).Update(column, nil)
+			rowsAffected = result.RowsAffected
+			return result.Error
+		})
+		if err != nil {
+			return claimed, err
+		}
+
+		// Another service node got it, or it was rescheduled in the meantime
+		if rowsAffected == 0 {
+			continue
+		}
+
+		claimed = append(claimed, notification)
+	}
+
+	return claimed, nil
+}
+
+// DeleteExpired notifications in the SQL database, of every tenant, up to limit of them
+func (repository *SQLNotificationRepository) DeleteExpired(ctx context.Context, now time.Time, limit int) (int64, error) {
+	return repository.deleteBatch(ctx, limit, func(db *gorm.DB) *gorm.DB {
+		return db.Where("expires_at IS NOT NULL AND expires_at <= ?", now.UTC())
+	})
+}
+
+// DeleteReadBefore notifications in the SQL database which were read before a given time, of every tenant, up to
+// limit of them
+func (repository *SQLNotificationRepository) DeleteReadBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	return repository.deleteBatch(ctx, limit, func(db *gorm.DB) *gorm.DB {
+		return db.Where("read_at IS NOT NULL AND read_at < ?", before.UTC())
+	})
+}
+
+// deleteBatch of notifications matching a condition. IDs are picked first, since not every database takes a limit on
+// deletes
+func (repository *SQLNotificationRepository) deleteBatch(ctx context.Context, limit int, condition func(db *gorm.DB) *gorm.DB) (int64, error) {
+	var rowsAffected int64
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		var ids []uint
+		err := condition(db.Model(&Notification{})).Order("id").Limit(limit).Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		result := db.Delete(&Notification{}, ids)
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+
+	return rowsAffected, err
+}
+
+// GetUsage of a destination in the SQL database, i.e. how many notifications it has and how large their data is
+func (repository *SQLNotificationRepository) GetUsage(ctx context.Context, destinationID string) (ClientUsage, error) {
+	var usage ClientUsage
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db.Model(&Notification{})).
+			Select("COUNT(*) AS notifications, COALESCE(SUM("+byteLength(db, "data")+"), 0) AS data_size").
+			Where("destination_id = ?", destinationID).
+			Scan(&usage).Error
+	})
+	if err != nil {
+		return ClientUsage{}, err
+	}
+
+	return usage, nil
+}
+
+// evictionBatchSize is how many read notifications are looked at a time while evicting
+const evictionBatchSize = 100
+
+// EvictRead deletes the oldest read notifications of a destination in the SQL database, as many as it takes to free
+// a given excess, if there are that many. It tells how much was freed
+func (repository *SQLNotificationRepository) EvictRead(ctx context.Context, destinationID string, excess ClientUsage) (ClientUsage, error) {
+	var freed ClientUsage
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		for !freed.Covers(excess) {
+			var candidates []struct {
+				ID       uint
+				DataSize int64
+			}
+			err := repository.scoped(db.Model(&Notification{})).
+				Select("id, "+byteLength(db, "data")+" AS data_size").
+				Where("destination_id = ? AND read_at IS NOT NULL", destinationID).
+				Order("id").Limit(evictionBatchSize).
+				Scan(&candidates).Error
+			if err != nil || len(candidates) == 0 {
+				return err
+			}
+
+			ids := []uint{}
+			var dataSize int64
+			for _, candidate := range candidates {
+				if (ClientUsage{Notifications: freed.Notifications + int64(len(ids)), DataSize: freed.DataSize + dataSize}).Covers(excess) {
+					break
+				}
+				ids = append(ids, candidate.ID)
+				dataSize += candidate.DataSize
+			}
+
+			result := db.Delete(&Notification{}, ids)
+			if result.Error != nil {
+				return result.Error
+			}
+			freed.Notifications += result.RowsAffected
+			freed.DataSize += dataSize
+
+			if len(candidates) < evictionBatchSize {
+				return nil
+			}
+		}
+		return nil
+	})
+
+	return freed, err
+}
+
+// byteLength of a text column, which each database tells its own way
+func byteLength(db *gorm.DB, column string) string {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return "OCTET_LENGTH(CAST(" + column + " AS text))"
+	case "sqlite":
+		return "LENGTH(CAST(" + column + " AS BLOB))"
+	default:
+		return "LENGTH(" + column + ")"
+	}
+}
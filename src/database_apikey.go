@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SQLAPIKeyRepository is the concrete implementation of APIKeyRepository for an SQL database
+type SQLAPIKeyRepository struct {
+	db *gorm.DB
+}
+
+// NewSQLAPIKeyRepository creates a new SQLAPIKeyRepository instance with an underlying GORM's database abstraction
+func NewSQLAPIKeyRepository(db *gorm.DB) (*SQLAPIKeyRepository, error) {
+	repository := &SQLAPIKeyRepository{
+		db: db,
+	}
+
+	return repository, nil
+}
+
+// Add an API key to the SQL database
+func (repository *SQLAPIKeyRepository) Add(apiKey *APIKey) error {
+	result := repository.db.Create(apiKey)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// Update an API key in the SQL database
+func (repository *SQLAPIKeyRepository) Update(apiKey *APIKey) error {
+	result := repository.db.Save(apiKey)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// Get an API key in the SQL database by its ID
+func (repository *SQLAPIKeyRepository) Get(id uint) (APIKey, error) {
+	var apiKey APIKey
+	result := repository.db.First(&apiKey, id)
+	if err := result.Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return APIKey{}, ErrAPIKeyNotFound
+		}
+		return APIKey{}, err
+	}
+
+	return apiKey, nil
+}
+
+// GetByLookupID an API key in the SQL database by the public part of the key
+func (repository *SQLAPIKeyRepository) GetByLookupID(lookupID string) (APIKey, error) {
+	var apiKey APIKey
+	result := repository.db.Where(&APIKey{LookupID: lookupID}).First(&apiKey)
+	if err := result.Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return APIKey{}, ErrAPIKeyNotFound
+		}
+		return APIKey{}, err
+	}
+
+	return apiKey, nil
+}
+
+// GetAll the API keys in the SQL database
+func (repository *SQLAPIKeyRepository) GetAll() ([]APIKey, error) {
+	var apiKeys []APIKey
+	result := repository.db.Order("id").Find(&apiKeys)
+	if result.Error != nil {
+		return []APIKey{}, result.Error
+	}
+
+	return apiKeys, nil
+}
+
+// Touch sets the last time an API key was used, without bothering with the rest of the record
+func (repository *SQLAPIKeyRepository) Touch(id uint, usedAt time.Time) error {
+	result := repository.db.Model(&APIKey{}).Where("id = ?", id).Update("last_used_at", usedAt)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
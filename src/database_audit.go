@@ -0,0 +1,56 @@
+package main
+
+import (
+	"gorm.io/gorm"
+)
+
+// SQLAuditRepository is the concrete implementation of AuditRepository for an SQL database
+type SQLAuditRepository struct {
+	db *gorm.DB
+}
+
+// NewSQLAuditRepository creates a new SQLAuditRepository instance with an underlying GORM's database abstraction
+func NewSQLAuditRepository(db *gorm.DB) (*SQLAuditRepository, error) {
+	repository := &SQLAuditRepository{
+		db: db,
+	}
+
+	return repository, nil
+}
+
+// Add an entry to the audit trail in the SQL database
+func (repository *SQLAuditRepository) Add(entry *AuditEntry) error {
+	result := repository.db.Create(entry)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// Find entries of the audit trail in the SQL database by the given filter, oldest first
+func (repository *SQLAuditRepository) Find(filter AuditFilter) ([]AuditEntry, error) {
+	query := repository.db.Where(&AuditEntry{
+		TenantID: filter.TenantID,
+		Action:   filter.Action,
+		ActorID:  filter.ActorID,
+	})
+
+	if filter.Since != nil {
+		query = query.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		query = query.Where("created_at < ?", *filter.Until)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var entries []AuditEntry
+	result := query.Order("id").Find(&entries)
+	if result.Error != nil {
+		return []AuditEntry{}, result.Error
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SQLBroadcastRepository is the concrete implementation of BroadcastRepository for an SQL database
+type SQLBroadcastRepository struct {
+	db           *gorm.DB
+	tenantID     string
+	queryTimeout time.Duration
+}
+
+// NewSQLBroadcastRepository creates a new SQLBroadcastRepository instance with an underlying GORM's database abstraction,
+// bound to the default tenant
+func NewSQLBroadcastRepository(db *gorm.DB, queryTimeout time.Duration) (*SQLBroadcastRepository, error) {
+	repository := &SQLBroadcastRepository{
+		db:           db,
+		tenantID:     DefaultTenantID,
+		queryTimeout: queryTimeout,
+	}
+
+	return repository, nil
+}
+
+// ForTenant gives a copy of the repository bound to the given tenant
+func (repository *SQLBroadcastRepository) ForTenant(tenantID string) BroadcastRepository {
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+
+	return &SQLBroadcastRepository{
+		db:           repository.db,
+		tenantID:     tenantID,
+		queryTimeout: repository.queryTimeout,
+	}
+}
+
+func (repository *SQLBroadcastRepository) query(ctx context.Context, fn func(db *gorm.DB) error) error {
+	return queryWithTimeout(ctx, repository.db, repository.queryTimeout, "broadcasts", fn)
+}
+
+// Add a broadcast to the SQL database
+func (repository *SQLBroadcastRepository) Add(ctx context.Context, broadcast *Broadcast) error {
+	broadcast.TenantID = repository.tenantID
+
+	return repository.query(ctx, func(db *gorm.DB) error {
+		return db.Create(broadcast).Error
+	})
+}
+
+// Get a broadcast in the SQL database by its ID
+func (repository *SQLBroadcastRepository) Get(ctx context.Context, id uint) (Broadcast, error) {
+	var broadcast Broadcast
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return db.Where("tenant_id = ?", repository.tenantID).First(&broadcast, id).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Broadcast{}, ErrBroadcastNotFound
+		}
+		return Broadcast{}, err
+	}
+
+	return broadcast, nil
+}
+
+// GetForClient the broadcasts of the tenant a client hasn't dismissed, by its status (read/unread/all) as per
+// its receipts, ordered by ID
+func (repository *SQLBroadcastRepository) GetForClient(ctx context.Context, clientID string, status string) ([]ClientBroadcast, error) {
+	criteria := "broadcasts.tenant_id = ? AND broadcast_receipts.dismissed_at IS NULL"
+	if status == StatusUnreadNotifications {
+		criteria += " AND broadcast_receipts.read_at IS NULL"
+	}
+	if status == StatusReadNotifications {
+		criteria += " AND broadcast_receipts.read_at IS NOT NULL"
+	}
+
+	var broadcasts []ClientBroadcast
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return db.Table("broadcasts").
+			Select("broadcasts.*, broadcast_receipts.read_at").
+			Joins("LEFT JOIN broadcast_receipts ON broadcast_receipts.broadcast_id = broadcasts.id AND broadcast_receipts.client_id = ?", clientID).
+			Where(criteria, repository.tenantID).
+			Order("broadcasts.id").
+			Scan(&broadcasts).Error
+	})
+	if err != nil {
+		return []ClientBroadcast{}, err
+	}
+
+	return broadcasts, nil
+}
+
+// GetReceipt of a broadcast for a client in the SQL database. When the client hasn't got one yet, a new (unsaved)
+// receipt is given
+func (repository *SQLBroadcastRepository) GetReceipt(ctx context.Context, broadcastID uint, clientID string) (BroadcastReceipt, error) {
+	var receipt BroadcastReceipt
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return db.Where(&BroadcastReceipt{TenantID: repository.tenantID, BroadcastID: broadcastID, ClientID: clientID}).First(&receipt).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return BroadcastReceipt{TenantID: repository.tenantID, BroadcastID: broadcastID, ClientID: clientID}, nil
+		}
+		return BroadcastReceipt{}, err
+	}
+
+	return receipt, nil
+}
+
+// SaveReceipt of a broadcast for a client in the SQL database, creating it when it is a new one
+func (repository *SQLBroadcastRepository) SaveReceipt(ctx context.Context, receipt *BroadcastReceipt) error {
+	receipt.TenantID = repository.tenantID
+
+	return repository.query(ctx, func(db *gorm.DB) error {
+		if receipt.ID == 0 {
+			return db.Create(receipt).Error
+		}
+		return db.Where("tenant_id = ?", repository.tenantID).Save(receipt).Error
+	})
+}
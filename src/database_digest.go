@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SQLClientDigestRepository is the concrete implementation of ClientDigestRepository for an SQL database
+type SQLClientDigestRepository struct {
+	db           *gorm.DB
+	tenantID     string
+	queryTimeout time.Duration
+}
+
+// NewSQLClientDigestRepository creates a new SQLClientDigestRepository instance with an underlying GORM's database
+// abstraction, bound to the default tenant
+func NewSQLClientDigestRepository(db *gorm.DB, queryTimeout time.Duration) (*SQLClientDigestRepository, error) {
+	repository := &SQLClientDigestRepository{
+		db:           db,
+		tenantID:     DefaultTenantID,
+		queryTimeout: queryTimeout,
+	}
+
+	return repository, nil
+}
+
+// ForTenant gives a copy of the repository bound to the given tenant
+func (repository *SQLClientDigestRepository) ForTenant(tenantID string) ClientDigestRepository {
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+
+	return &SQLClientDigestRepository{
+		db:           repository.db,
+		tenantID:     tenantID,
+		queryTimeout: repository.queryTimeout,
+	}
+}
+
+func (repository *SQLClientDigestRepository) query(ctx context.Context, fn func(db *gorm.DB) error) error {
+	return queryWithTimeout(ctx, repository.db, repository.queryTimeout, "client digests", fn)
+}
+
+// GetAll digests of a client in the SQL database, ordered by category
+func (repository *SQLClientDigestRepository) GetAll(ctx context.Context, clientID string) ([]ClientDigest, error) {
+	var digests []ClientDigest
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).Where("client_id = ?", clientID).Order("category").Find(&digests).Error
+	})
+	if err != nil {
+		return []ClientDigest{}, err
+	}
+
+	return digests, nil
+}
+
+// Replace every digest of a client in the SQL database with the given ones, in a single transaction
+func (repository *SQLClientDigestRepository) Replace(ctx context.Context, clientID string, digests []ClientDigest) error {
+	for i := range digests {
+		digests[i].ID = 0
+		digests[i].TenantID = repository.tenantID
+		digests[i].ClientID = clientID
+	}
+
+	return repository.query(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			err := repository.scoped(tx).Where("client_id = ?", clientID).Delete(&ClientDigest{}).Error
+			if err != nil {
+				return err
+			}
+
+			if len(digests) == 0 {
+				return nil
+			}
+
+			return tx.Create(&digests).Error
+		})
+	})
+}
+
+// Find the digests of a category of the given clients in the SQL database, by client ID
+func (repository *SQLClientDigestRepository) Find(ctx context.Context, clientIDs []string, category string) (map[string]ClientDigest, error) {
+	found := map[string]ClientDigest{}
+	if len(clientIDs) == 0 || category == "" {
+		return found, nil
+	}
+
+	var digests []ClientDigest
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).Where("client_id IN ? AND category = ?", clientIDs, category).Find(&digests).Error
+	})
+	if err != nil {
+		return found, err
+	}
+
+	for _, digest := range digests {
+		found[digest.ClientID] = digest
+	}
+
+	return found, nil
+}
+
+// scoped narrows down to the digests of the tenant the repository is bound to
+func (repository *SQLClientDigestRepository) scoped(db *gorm.DB) *gorm.DB {
+	return db.Where("tenant_id = ?", repository.tenantID)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SQLEventTypeRepository is the concrete implementation of EventTypeRepository for an SQL database
+type SQLEventTypeRepository struct {
+	db           *gorm.DB
+	tenantID     string
+	queryTimeout time.Duration
+}
+
+// NewSQLEventTypeRepository creates a new SQLEventTypeRepository instance with an underlying GORM's database
+// abstraction, bound to the default tenant
+func NewSQLEventTypeRepository(db *gorm.DB, queryTimeout time.Duration) (*SQLEventTypeRepository, error) {
+	repository := &SQLEventTypeRepository{
+		db:           db,
+		tenantID:     DefaultTenantID,
+		queryTimeout: queryTimeout,
+	}
+
+	return repository, nil
+}
+
+// ForTenant gives a copy of the repository bound to the given tenant
+func (repository *SQLEventTypeRepository) ForTenant(tenantID string) EventTypeRepository {
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+
+	return &SQLEventTypeRepository{
+		db:           repository.db,
+		tenantID:     tenantID,
+		queryTimeout: repository.queryTimeout,
+	}
+}
+
+func (repository *SQLEventTypeRepository) query(ctx context.Context, fn func(db *gorm.DB) error) error {
+	return queryWithTimeout(ctx, repository.db, repository.queryTimeout, "event types", fn)
+}
+
+// Save an event type in the SQL database, replacing the description and schema of the one of the same name, if any.
+// It is the unique index which settles it, even among service nodes
+func (repository *SQLEventTypeRepository) Save(ctx context.Context, eventType *EventType) error {
+	eventType.TenantID = repository.tenantID
+
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "tenant_id"}, {Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"description", "json_schema", "updated_at"}),
+		}).Create(eventType).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	// The event type may have been there already, which is where its ID and creation time come from
+	saved, err := repository.Get(ctx, eventType.Name)
+	if err != nil {
+		return err
+	}
+
+	*eventType = saved
+	return nil
+}
+
+// Get an event type in the SQL database by its name
+func (repository *SQLEventTypeRepository) Get(ctx context.Context, name string) (EventType, error) {
+	var eventType EventType
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return db.Where("tenant_id = ? AND name = ?", repository.tenantID, name).First(&eventType).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return EventType{}, ErrEventTypeNotFound
+		}
+		return EventType{}, err
+	}
+
+	return eventType, nil
+}
+
+// GetAll event types in the SQL database, ordered by name
+func (repository *SQLEventTypeRepository) GetAll(ctx context.Context) ([]EventType, error) {
+	var eventTypes []EventType
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return db.Where("tenant_id = ?", repository.tenantID).Order("name").Find(&eventTypes).Error
+	})
+	if err != nil {
+		return []EventType{}, err
+	}
+
+	return eventTypes, nil
+}
+
+// Delete an event type in the SQL database by its name
+func (repository *SQLEventTypeRepository) Delete(ctx context.Context, name string) error {
+	var rowsAffected int64
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		result := db.Where("tenant_id = ? AND name = ?", repository.tenantID, name).Delete(&EventType{})
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEventTypeNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SQLIdempotencyKeyRepository is the concrete implementation of IdempotencyKeyRepository for an SQL database
+type SQLIdempotencyKeyRepository struct {
+	db           *gorm.DB
+	tenantID     string
+	queryTimeout time.Duration
+}
+
+// NewSQLIdempotencyKeyRepository creates a new SQLIdempotencyKeyRepository instance with an underlying GORM's
+// database abstraction, bound to the default tenant
+func NewSQLIdempotencyKeyRepository(db *gorm.DB, queryTimeout time.Duration) (*SQLIdempotencyKeyRepository, error) {
+	repository := &SQLIdempotencyKeyRepository{
+		db:           db,
+		tenantID:     DefaultTenantID,
+		queryTimeout: queryTimeout,
+	}
+
+	return repository, nil
+}
+
+// ForTenant gives a copy of the repository bound to the given tenant
+func (repository *SQLIdempotencyKeyRepository) ForTenant(tenantID string) IdempotencyKeyRepository {
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+
+	return &SQLIdempotencyKeyRepository{
+		db:           repository.db,
+		tenantID:     tenantID,
+		queryTimeout: repository.queryTimeout,
+	}
+}
+
+func (repository *SQLIdempotencyKeyRepository) query(ctx context.Context, fn func(db *gorm.DB) error) error {
+	return queryWithTimeout(ctx, repository.db, repository.queryTimeout, "idempotency keys", fn)
+}
+
+// Reserve an idempotency key in the SQL database, unless someone else did it first (i.e. it tells false). It is the
+// unique index which settles it, even among service nodes
+func (repository *SQLIdempotencyKeyRepository) Reserve(ctx context.Context, key *IdempotencyKey) (bool, error) {
+	key.TenantID = repository.tenantID
+
+	var rowsAffected int64
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(key)
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// Get an idempotency key in the SQL database by its scope and key
+func (repository *SQLIdempotencyKeyRepository) Get(ctx context.Context, scope string, key string) (IdempotencyKey, error) {
+	var idempotencyKey IdempotencyKey
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return db.Where(&IdempotencyKey{TenantID: repository.tenantID, Scope: scope, Key: key}).First(&idempotencyKey).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return IdempotencyKey{}, ErrIdempotencyKeyNotFound
+		}
+		return IdempotencyKey{}, err
+	}
+
+	return idempotencyKey, nil
+}
+
+// Complete an idempotency key in the SQL database with the response of its request
+func (repository *SQLIdempotencyKeyRepository) Complete(ctx context.Context, key *IdempotencyKey) error {
+	return repository.query(ctx, func(db *gorm.DB) error {
+		return db.Model(&IdempotencyKey{}).
+			Where("id = ? AND tenant_id = ?", key.ID, repository.tenantID).
+			Updates(map[string]interface{}{
+				"status_code":  key.StatusCode,
+				"content_type": key.ContentType,
+				"response":     key.Response,
+			}).Error
+	})
+}
+
+// Release an idempotency key in the SQL database, so that it may be used again
+func (repository *SQLIdempotencyKeyRepository) Release(ctx context.Context, key *IdempotencyKey) error {
+	return repository.query(ctx, func(db *gorm.DB) error {
+		return db.Where("tenant_id = ?", repository.tenantID).Delete(&IdempotencyKey{}, key.ID).Error
+	})
+}
+
+// DeleteExpired idempotency keys in the SQL database, of every tenant
+func (repository *SQLIdempotencyKeyRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	var rowsAffected int64
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		result := db.Where("expires_at < ?", now).Delete(&IdempotencyKey{})
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+
+	return rowsAffected, err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SQLBroadcastJobRepository is the concrete implementation of BroadcastJobRepository for an SQL database
+type SQLBroadcastJobRepository struct {
+	db           *gorm.DB
+	tenantID     string
+	queryTimeout time.Duration
+}
+
+// NewSQLBroadcastJobRepository creates a new SQLBroadcastJobRepository instance with an underlying GORM's database
+// abstraction, bound to the default tenant
+func NewSQLBroadcastJobRepository(db *gorm.DB, queryTimeout time.Duration) (*SQLBroadcastJobRepository, error) {
+	repository := &SQLBroadcastJobRepository{
+		db:           db,
+		tenantID:     DefaultTenantID,
+		queryTimeout: queryTimeout,
+	}
+
+	return repository, nil
+}
+
+// ForTenant gives a copy of the repository bound to the given tenant
+func (repository *SQLBroadcastJobRepository) ForTenant(tenantID string) BroadcastJobRepository {
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+
+	return &SQLBroadcastJobRepository{
+		db:           repository.db,
+		tenantID:     tenantID,
+		queryTimeout: repository.queryTimeout,
+	}
+}
+
+func (repository *SQLBroadcastJobRepository) query(ctx context.Context, fn func(db *gorm.DB) error) error {
+	return queryWithTimeout(ctx, repository.db, repository.queryTimeout, "broadcast jobs", fn)
+}
+
+// Add a job to the SQL database
+func (repository *SQLBroadcastJobRepository) Add(ctx context.Context, job *BroadcastJob) error {
+	job.TenantID = repository.tenantID
+
+	return repository.query(ctx, func(db *gorm.DB) error {
+		return db.Create(job).Error
+	})
+}
+
+// Get a job in the SQL database by its ID
+func (repository *SQLBroadcastJobRepository) Get(ctx context.Context, id uint) (BroadcastJob, error) {
+	var job BroadcastJob
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return db.Where("tenant_id = ?", repository.tenantID).First(&job, id).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return BroadcastJob{}, ErrJobNotFound
+		}
+		return BroadcastJob{}, err
+	}
+
+	return job, nil
+}
+
+// UpdateProgress of a job in the SQL database, i.e. its counters and last error but never its status
+func (repository *SQLBroadcastJobRepository) UpdateProgress(ctx context.Context, job *BroadcastJob) error {
+	return repository.query(ctx, func(db *gorm.DB) error {
+		return db.Model(&BroadcastJob{}).
+			Where("id = ? AND tenant_id = ?", job.ID, repository.tenantID).
+			Updates(map[string]interface{}{
+				"persisted":  job.Persisted,
+				"delivered":  job.Delivered,
+				"failed":     job.Failed,
+				"suppressed": job.Suppressed,
+				"last_error": job.LastError,
+				"updated_at": time.Now(),
+			}).Error
+	})
+}
+
+// Transition a job in the SQL database to a new status, as long as it is in one of the given ones. It tells whether
+// it did, so that concurrent transitions (e.g. canceling a job while it completes) never overwrite each other
+func (repository *SQLBroadcastJobRepository) Transition(ctx context.Context, id uint, from []string, to string) (bool, error) {
+	now := time.Now()
+	changes := map[string]interface{}{
+		"status":     to,
+		"updated_at": now,
+	}
+	if IsFinishedJobStatus(to) {
+		changes["finished_at"] = now
+	}
+
+	var rowsAffected int64
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		result := db.Model(&BroadcastJob{}).
+			Where("id = ? AND tenant_id = ? AND status IN ?", id, repository.tenantID, from).
+			Updates(changes)
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// GetUnfinished jobs of a service node in the SQL database, of every tenant, ordered by ID
+func (repository *SQLBroadcastJobRepository) GetUnfinished(ctx context.Context, nodeID string) ([]BroadcastJob, error) {
+	var jobs []BroadcastJob
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return db.Where("node_id = ? AND status IN ?", nodeID, []string{JobStatusPending, JobStatusRunning}).Order("id").Find(&jobs).Error
+	})
+	if err != nil {
+		return []BroadcastJob{}, err
+	}
+
+	return jobs, nil
+}
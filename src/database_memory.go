@@ -0,0 +1,660 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// memoryDatabaseDSN is a process-wide SQLite database living in memory only, shared by every connection of the pool
+const memoryDatabaseDSN = "file:mercurio?mode=memory&cache=shared"
+
+// ConnectMemoryDatabase connects to an in-memory SQLite database, which holds in everything but notifications when
+// MERCURIO_DB_DRIVER is memory. It is gone as soon as the process is, so don't even think of it for production
+func ConnectMemoryDatabase() (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open(memoryDatabaseDSN), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open in-memory database due to: %s", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get in-memory database connection pool due to: %s", err)
+	}
+
+	// A single connection avoids shared cache table locks, and keeps the database alive while idle
+	sqlDB.SetMaxOpenConns(1)
+	sqlDB.SetConnMaxLifetime(0)
+
+	log.Printf("Connected to in-memory database")
+
+	return db, nil
+}
+
+// memoryNotificationStore is where MemoryNotificationRepository keeps notifications of every tenant
+type memoryNotificationStore struct {
+	mutex         sync.RWMutex
+	lastID        uint
+	notifications map[uint]Notification
+}
+
+// MemoryNotificationRepository is a thread-safe implementation of NotificationRepository which keeps notifications
+// in memory only, for development and tests. It behaves as SQLNotificationRepository does, results ordered by ID
+type MemoryNotificationRepository struct {
+	store    *memoryNotificationStore
+	tenantID string
+}
+
+// NewMemoryNotificationRepository creates a new, empty MemoryNotificationRepository bound to the default tenant
+func NewMemoryNotificationRepository() *MemoryNotificationRepository {
+	repository := &MemoryNotificationRepository{
+		store: &memoryNotificationStore{
+			notifications: make(map[uint]Notification),
+		},
+		tenantID: DefaultTenantID,
+	}
+
+	return repository
+}
+
+// ForTenant gives a copy of the repository bound to the given tenant, on top of the same store
+func (repository *MemoryNotificationRepository) ForTenant(tenantID string) NotificationRepository {
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+
+	return &MemoryNotificationRepository{
+		store:    repository.store,
+		tenantID: tenantID,
+	}
+}
+
+// checkContext fails just like an SQL query would when the context is done before it even starts
+func (repository *MemoryNotificationRepository) checkContext(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("query on notifications was interrupted: %w", ctx.Err())
+	}
+	return nil
+}
+
+// Add a notification to memory
+func (repository *MemoryNotificationRepository) Add(ctx context.Context, notification *Notification) error {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	repository.store.mutex.Lock()
+	defer repository.store.mutex.Unlock()
+
+	repository.store.lastID++
+	notification.ID = repository.store.lastID
+	notification.TenantID = repository.tenantID
+	if notification.CreatedAt.IsZero() {
+		notification.CreatedAt = time.Now()
+	}
+
+	repository.store.notifications[notification.ID] = copyNotification(*notification)
+
+	return nil
+}
+
+// AddBatch of notifications to memory, all at once
+func (repository *MemoryNotificationRepository) AddBatch(ctx context.Context, notifications []*Notification) error {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	repository.store.mutex.Lock()
+	defer repository.store.mutex.Unlock()
+
+	now := time.Now()
+	for _, notification := range notifications {
+		repository.store.lastID++
+		notification.ID = repository.store.lastID
+		notification.TenantID = repository.tenantID
+		if notification.CreatedAt.IsZero() {
+			notification.CreatedAt = now
+		}
+
+		repository.store.notifications[notification.ID] = copyNotification(*notification)
+	}
+
+	return nil
+}
+
+// Update a notification in memory
+func (repository *MemoryNotificationRepository) Update(ctx context.Context, notification *Notification) error {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if notification.TenantID != repository.tenantID {
+		return ErrNotificationNotFound
+	}
+
+	repository.store.mutex.Lock()
+	defer repository.store.mutex.Unlock()
+
+	stored, exists := repository.store.notifications[notification.ID]
+	if !exists || stored.TenantID != repository.tenantID {
+		return ErrNotificationNotFound
+	}
+
+	repository.store.notifications[notification.ID] = copyNotification(*notification)
+
+	return nil
+}
+
+// Delete a notification in memory
+func (repository *MemoryNotificationRepository) Delete(ctx context.Context, id uint) error {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	repository.store.mutex.Lock()
+	defer repository.store.mutex.Unlock()
+
+	stored, exists := repository.store.notifications[id]
+	if exists && stored.TenantID == repository.tenantID {
+		delete(repository.store.notifications, id)
+	}
+
+	return nil
+}
+
+// Get a notification in memory by its ID
+func (repository *MemoryNotificationRepository) Get(ctx context.Context, id uint) (Notification, error) {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return Notification{}, err
+	}
+
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	stored, exists := repository.store.notifications[id]
+	if !exists || stored.TenantID != repository.tenantID {
+		return Notification{}, ErrNotificationNotFound
+	}
+
+	return copyNotification(stored), nil
+}
+
+// GetAll the notifications in memory
+func (repository *MemoryNotificationRepository) GetAll(ctx context.Context, destinationID string) ([]Notification, error) {
+	return repository.find(ctx, func(notification Notification) bool {
+		return notification.DestinationID == destinationID
+	})
+}
+
+// GetByStatus the notifications in memory by its status (read/unread/all)
+func (repository *MemoryNotificationRepository) GetByStatus(ctx context.Context, destinationID string, status string) ([]Notification, error) {
+	return repository.find(ctx, func(notification Notification) bool {
+		if notification.DestinationID != destinationID {
+			return false
+		}
+		if status == StatusUnreadNotifications {
+			return notification.ReadAt == nil
+		}
+		if status == StatusReadNotifications {
+			return notification.ReadAt != nil
+		}
+		return true
+	})
+}
+
+// FilterBy all notifications in memory by given criteria. As on GORM's struct conditions, only non-zero fields count
+func (repository *MemoryNotificationRepository) FilterBy(ctx context.Context, destinationID string, criteria Notification) ([]Notification, error) {
+	return repository.find(ctx, func(notification Notification) bool {
+		return notification.DestinationID == destinationID &&
+			(criteria.ID == 0 || notification.ID == criteria.ID) &&
+			(criteria.EventID == "" || notification.EventID == criteria.EventID) &&
+			(criteria.SourceID == "" || notification.SourceID == criteria.SourceID) &&
+			(criteria.Type == "" || notification.Type == criteria.Type) &&
+			(criteria.Category == "" || notification.Category == criteria.Category) &&
+			(criteria.Topic == "" || notification.Topic == criteria.Topic) &&
+			(criteria.Data == "" || notification.Data == criteria.Data) &&
+			(criteria.CollapseKey == "" || notification.CollapseKey == criteria.CollapseKey) &&
+			(criteria.CreatedAt.IsZero() || notification.CreatedAt.Equal(criteria.CreatedAt)) &&
+			(criteria.ReadAt == nil || (notification.ReadAt != nil && notification.ReadAt.Equal(*criteria.ReadAt)))
+	})
+}
+
+// Notified tells which of the given destinations have a notification of an event in memory, whether it is released
+// yet or not
+func (repository *MemoryNotificationRepository) Notified(ctx context.Context, eventID string, destinationIDs []string) ([]string, error) {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return []string{}, err
+	}
+
+	wanted := map[string]bool{}
+	for _, destinationID := range destinationIDs {
+		wanted[destinationID] = true
+	}
+
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	notified := []string{}
+	for _, notification := range repository.store.notifications {
+		if notification.TenantID == repository.tenantID && notification.EventID == eventID && wanted[notification.DestinationID] {
+			notified = append(notified, notification.DestinationID)
+		}
+	}
+
+	return notified, nil
+}
+
+// Collapse a notification into the latest unread one (already delivered and not expired) of its destination with the
+// same collapse key, in memory: that one gets its event, type, category, topic, data, timestamp, expiry, priority and
+// hold (and it tells true), otherwise the notification is added
+func (repository *MemoryNotificationRepository) Collapse(ctx context.Context, notification *Notification) (bool, error) {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	repository.store.mutex.Lock()
+	defer repository.store.mutex.Unlock()
+
+	var latest *Notification
+	for id, stored := range repository.store.notifications {
+		if stored.TenantID == repository.tenantID && stored.DestinationID == notification.DestinationID &&
+			stored.CollapseKey == notification.CollapseKey && stored.ReadAt == nil && stored.DeliverAt == nil && !stored.Expired(time.Now()) && (latest == nil || id > latest.ID) {
+			stored := stored
+			latest = &stored
+		}
+	}
+
+	notification.TenantID = repository.tenantID
+
+	if latest == nil {
+		repository.store.lastID++
+		notification.ID = repository.store.lastID
+		if notification.CreatedAt.IsZero() {
+			notification.CreatedAt = time.Now()
+		}
+
+		repository.store.notifications[notification.ID] = copyNotification(*notification)
+		return false, nil
+	}
+
+	notification.ID = latest.ID
+	notification.CreatedAt = time.Now()
+
+	latest.EventID = notification.EventID
+	latest.SourceID = notification.SourceID
+	latest.Type = notification.Type
+	latest.Category = notification.Category
+	latest.Topic = notification.Topic
+	latest.Data = notification.Data
+	latest.CreatedAt = notification.CreatedAt
+	latest.ExpiresAt = notification.ExpiresAt
+	latest.Priority = notification.Priority
+	latest.HeldUntil = notification.HeldUntil
+	latest.DigestAt = notification.DigestAt
+	repository.store.notifications[latest.ID] = copyNotification(*latest)
+
+	return true, nil
+}
+
+// scheduled tells whether a notification is of an event of a source and yet to be released
+func (repository *MemoryNotificationRepository) scheduled(notification Notification, sourceID string, eventID string) bool {
+	return notification.TenantID == repository.tenantID && notification.SourceID == sourceID && notification.EventID == eventID &&
+		notification.DeliverAt != nil
+}
+
+// Reschedule the notifications of an event in memory, as long as they are yet to be released. It tells how many of
+// them there were
+func (repository *MemoryNotificationRepository) Reschedule(ctx context.Context, sourceID string, eventID string, deliverAt time.Time) (int64, error) {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	repository.store.mutex.Lock()
+	defer repository.store.mutex.Unlock()
+
+	var rescheduled int64
+	for id, notification := range repository.store.notifications {
+		if repository.scheduled(notification, sourceID, eventID) {
+			utc := deliverAt.UTC()
+			notification.DeliverAt = &utc
+			repository.store.notifications[id] = notification
+			rescheduled++
+		}
+	}
+
+	return rescheduled, nil
+}
+
+// CancelScheduled notifications of an event in memory, i.e. delete them as long as they are yet to be released. It
+// tells how many of them there were
+func (repository *MemoryNotificationRepository) CancelScheduled(ctx context.Context, sourceID string, eventID string) (int64, error) {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	repository.store.mutex.Lock()
+	defer repository.store.mutex.Unlock()
+
+	var canceled int64
+	for id, notification := range repository.store.notifications {
+		if repository.scheduled(notification, sourceID, eventID) {
+			delete(repository.store.notifications, id)
+			canceled++
+		}
+	}
+
+	return canceled, nil
+}
+
+// ReleaseDue claims up to limit scheduled notifications which are due, of every tenant, in memory
+func (repository *MemoryNotificationRepository) ReleaseDue(ctx context.Context, now time.Time, limit int) ([]Notification, error) {
+	return repository.claim(ctx, now, limit, func(notification *Notification) **time.Time {
+		return &notification.DeliverAt
+	})
+}
+
+// Hold a notification back from live delivery in memory until a given time
+func (repository *MemoryNotificationRepository) Hold(ctx context.Context, id uint, until time.Time) error {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	repository.store.mutex.Lock()
+	defer repository.store.mutex.Unlock()
+
+	notification, ok := repository.store.notifications[id]
+	if !ok || notification.TenantID != repository.tenantID {
+		return nil
+	}
+
+	utc := until.UTC()
+	notification.HeldUntil = &utc
+	repository.store.notifications[id] = notification
+	return nil
+}
+
+// RescheduleHeld notifications of a destination in memory, i.e. change when they are released, as long as they are
+// yet to be. It tells how many of them there were
+func (repository *MemoryNotificationRepository) RescheduleHeld(ctx context.Context, destinationID string, until time.Time) (int64, error) {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	repository.store.mutex.Lock()
+	defer repository.store.mutex.Unlock()
+
+	var rescheduled int64
+	for id, notification := range repository.store.notifications {
+		if notification.TenantID == repository.tenantID && notification.DestinationID == destinationID && notification.HeldUntil != nil {
+			utc := until.UTC()
+			notification.HeldUntil = &utc
+			repository.store.notifications[id] = notification
+			rescheduled++
+		}
+	}
+
+	return rescheduled, nil
+}
+
+// ReleaseHeld claims up to limit held notifications whose quiet time is over, of every tenant, in memory
+func (repository *MemoryNotificationRepository) ReleaseHeld(ctx context.Context, now time.Time, limit int) ([]Notification, error) {
+	return repository.claim(ctx, now, limit, func(notification *Notification) **time.Time {
+		return &notification.HeldUntil
+	})
+}
+
+// Accumulate a notification for a digest in memory, which keeps it from live delivery until a given time
+func (repository *MemoryNotificationRepository) Accumulate(ctx context.Context, id uint, at time.Time) error {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	repository.store.mutex.Lock()
+	defer repository.store.mutex.Unlock()
+
+	notification, ok := repository.store.notifications[id]
+	if !ok || notification.TenantID != repository.tenantID {
+		return nil
+	}
+
+	utc := at.UTC()
+	notification.DigestAt = &utc
+	repository.store.notifications[id] = notification
+	return nil
+}
+
+// RescheduleDigests of a destination in memory, i.e. change when the notifications accumulated for them are released,
+// as long as they are yet to be. It tells how many of them there were
+func (repository *MemoryNotificationRepository) RescheduleDigests(ctx context.Context, destinationID string, at time.Time) (int64, error) {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	repository.store.mutex.Lock()
+	defer repository.store.mutex.Unlock()
+
+	var rescheduled int64
+	for id, notification := range repository.store.notifications {
+		if notification.TenantID == repository.tenantID && notification.DestinationID == destinationID && notification.DigestAt != nil {
+			utc := at.UTC()
+			notification.DigestAt = &utc
+			repository.store.notifications[id] = notification
+			rescheduled++
+		}
+	}
+
+	return rescheduled, nil
+}
+
+// ReleaseDigests claims up to limit notifications accumulated for digests which are due, of every tenant, in memory
+func (repository *MemoryNotificationRepository) ReleaseDigests(ctx context.Context, now time.Time, limit int) ([]Notification, error) {
+	return repository.claim(ctx, now, limit, func(notification *Notification) **time.Time {
+		return &notification.DigestAt
+	})
+}
+
+// claim up to limit notifications whose time field (i.e. DeliverAt, HeldUntil or DigestAt) is past, clearing it
+func (repository *MemoryNotificationRepository) claim(ctx context.Context, now time.Time, limit int, field func(notification *Notification) **time.Time) ([]Notification, error) {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return []Notification{}, err
+	}
+
+	repository.store.mutex.Lock()
+	defer repository.store.mutex.Unlock()
+
+	due := []Notification{}
+	for _, notification := range repository.store.notifications {
+		at := *field(&notification)
+		if at != nil && !at.After(now) {
+			due = append(due, notification)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		at, other := *field(&due[i]), *field(&due[j])
+		if at.Equal(*other) {
+			return due[i].ID < due[j].ID
+		}
+		return at.Before(*other)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	for i := range due {
+		*field(&due[i]) = nil
+		repository.store.notifications[due[i].ID] = due[i]
+		due[i] = copyNotification(due[i])
+	}
+
+	return due, nil
+}
+
+// find the tenant's notifications matching a given predicate, ordered by ID. Notifications scheduled for later are
+// left out, as well as expired ones
+func (repository *MemoryNotificationRepository) find(ctx context.Context, matches func(notification Notification) bool) ([]Notification, error) {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return []Notification{}, err
+	}
+
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	now := time.Now()
+	notifications := []Notification{}
+	for _, notification := range repository.store.notifications {
+		if (notification.DeliverAt != nil && notification.DeliverAt.After(now)) || notification.Expired(now) {
+			continue
+		}
+		if notification.TenantID == repository.tenantID && matches(notification) {
+			notifications = append(notifications, copyNotification(notification))
+		}
+	}
+
+	sort.Slice(notifications, func(i, j int) bool { return notifications[i].ID < notifications[j].ID })
+
+	return notifications, nil
+}
+
+// copyNotification so that callers never share ReadAt, DeliverAt or ExpiresAt with what is stored
+func copyNotification(notification Notification) Notification {
+	if notification.ReadAt != nil {
+		readAt := *notification.ReadAt
+		notification.ReadAt = &readAt
+	}
+	if notification.DeliverAt != nil {
+		deliverAt := *notification.DeliverAt
+		notification.DeliverAt = &deliverAt
+	}
+	if notification.ExpiresAt != nil {
+		expiresAt := *notification.ExpiresAt
+		notification.ExpiresAt = &expiresAt
+	}
+	if notification.HeldUntil != nil {
+		heldUntil := *notification.HeldUntil
+		notification.HeldUntil = &heldUntil
+	}
+	if notification.DigestAt != nil {
+		digestAt := *notification.DigestAt
+		notification.DigestAt = &digestAt
+	}
+	return notification
+}
+
+// DeleteExpired notifications in memory, of every tenant, up to limit of them
+func (repository *MemoryNotificationRepository) DeleteExpired(ctx context.Context, now time.Time, limit int) (int64, error) {
+	return repository.deleteBatch(ctx, limit, func(notification Notification) bool {
+		return notification.Expired(now)
+	})
+}
+
+// DeleteReadBefore notifications in memory which were read before a given time, of every tenant, up to limit of them
+func (repository *MemoryNotificationRepository) DeleteReadBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	return repository.deleteBatch(ctx, limit, func(notification Notification) bool {
+		return notification.ReadAt != nil && notification.ReadAt.Before(before)
+	})
+}
+
+// deleteBatch of notifications matching a given predicate, lowest IDs first
+func (repository *MemoryNotificationRepository) deleteBatch(ctx context.Context, limit int, matches func(notification Notification) bool) (int64, error) {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	repository.store.mutex.Lock()
+	defer repository.store.mutex.Unlock()
+
+	ids := []uint{}
+	for id, notification := range repository.store.notifications {
+		if matches(notification) {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	for _, id := range ids {
+		delete(repository.store.notifications, id)
+	}
+
+	return int64(len(ids)), nil
+}
+
+// GetUsage of a destination in memory, i.e. how many notifications it has and how large their data is
+func (repository *MemoryNotificationRepository) GetUsage(ctx context.Context, destinationID string) (ClientUsage, error) {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return ClientUsage{}, err
+	}
+
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	var usage ClientUsage
+	for _, notification := range repository.store.notifications {
+		if notification.TenantID == repository.tenantID && notification.DestinationID == destinationID {
+			usage.Notifications++
+			usage.DataSize += int64(len(notification.Data))
+		}
+	}
+
+	return usage, nil
+}
+
+// EvictRead deletes the oldest read notifications of a destination in memory, as many as it takes to free a given
+// excess, if there are that many. It tells how much was freed
+func (repository *MemoryNotificationRepository) EvictRead(ctx context.Context, destinationID string, excess ClientUsage) (ClientUsage, error) {
+	err := repository.checkContext(ctx)
+	if err != nil {
+		return ClientUsage{}, err
+	}
+
+	repository.store.mutex.Lock()
+	defer repository.store.mutex.Unlock()
+
+	ids := []uint{}
+	for id, notification := range repository.store.notifications {
+		if notification.TenantID == repository.tenantID && notification.DestinationID == destinationID && notification.ReadAt != nil {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var freed ClientUsage
+	for _, id := range ids {
+		if freed.Covers(excess) {
+			break
+		}
+		freed.Notifications++
+		freed.DataSize += int64(len(repository.store.notifications[id].Data))
+		delete(repository.store.notifications, id)
+	}
+
+	return freed, nil
+}
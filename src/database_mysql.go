@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// ConnectMySQLDatabase connects to a given MySQL/MariaDB database (e.g. user:pass@tcp(host:3306)/mercurio) with
+// the given connection pool settings. Regardless of the connection string, it always talks utf8mb4 and parses times as UTC
+func ConnectMySQLDatabase(dsn string, pool DatabasePoolSettings) (*gorm.DB, error) {
+	config, err := mysqldriver.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MySQL connection string due to: %s", err)
+	}
+
+	config.ParseTime = true
+	config.Loc = time.UTC
+	config.Collation = "utf8mb4_unicode_ci"
+	if config.Params == nil {
+		config.Params = map[string]string{}
+	}
+	config.Params["charset"] = "utf8mb4"
+
+	db, err := gorm.Open(mysql.Open(config.FormatDSN()), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open MySQL database due to: %s", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MySQL connection pool due to: %s", err)
+	}
+
+	sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(pool.ConnMaxLifetime)
+
+	// Connection string is not logged as it most likely holds in a password
+	log.Printf("Connected to MySQL database at '%s' (max open conns = %d, max idle conns = %d)", config.Addr, pool.MaxOpenConns, pool.MaxIdleConns)
+
+	return db, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// These tests run against a real MySQL server, such as the one from `make testing-mysql`, only when
+// MERCURIO_TEST_MYSQL_CONN is provided; otherwise they are skipped
+func connectTestMySQLDatabase(t *testing.T) *SQLNotificationRepository {
+	dsn := os.Getenv("MERCURIO_TEST_MYSQL_CONN")
+	if dsn == "" {
+		t.Skip("MERCURIO_TEST_MYSQL_CONN is not provided")
+	}
+
+	pool := DatabasePoolSettings{MaxOpenConns: 5, MaxIdleConns: 1, ConnMaxLifetime: time.Minute}
+	db, err := ConnectMySQLDatabase(dsn, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrator, err := NewMigrator(db, DatabaseDriverMySQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = migrator.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every test starts from scratch
+	db.Exec("TRUNCATE TABLE notifications")
+
+	repository, err := NewSQLNotificationRepository(db, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repository
+}
+
+func TestMySQLNotificationRepository_KeepsPrecisionAndUnicode(t *testing.T) {
+	repository := connectTestMySQLDatabase(t)
+	ctx := context.Background()
+
+	data := JSONData(`"Olá, 世界! 🚀 ` + strings.Repeat("x", 70000) + `"`)
+	notification := &Notification{EventID: "e1", SourceID: "test", DestinationID: "123", Data: data}
+	err := repository.Add(ctx, notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readAt := time.Date(2021, 2, 3, 4, 5, 6, 789123000, time.UTC)
+	notification.ReadAt = &readAt
+	err = repository.Update(ctx, notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repository.Get(ctx, notification.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertContent(t, got.Data, data)
+	assertContent(t, got.ReadAt.Equal(readAt), true)
+	assertContent(t, got.CreatedAt.Nanosecond()%1000, 0)
+}
+
+func TestMySQLNotificationRepository(t *testing.T) {
+	testNotificationRepository(t, func(t *testing.T) NotificationRepository {
+		return connectTestMySQLDatabase(t)
+	})
+}
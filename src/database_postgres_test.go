@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// These tests run against a real PostgreSQL server, such as the one from `make testing-postgres`, only when
+// MERCURIO_TEST_POSTGRES_CONN is provided; otherwise they are skipped
+func connectTestPostgresDatabase(t *testing.T) *SQLNotificationRepository {
+	dsn := os.Getenv("MERCURIO_TEST_POSTGRES_CONN")
+	if dsn == "" {
+		t.Skip("MERCURIO_TEST_POSTGRES_CONN is not provided")
+	}
+
+	pool := DatabasePoolSettings{MaxOpenConns: 5, MaxIdleConns: 1, ConnMaxLifetime: time.Minute}
+	db, err := ConnectPostgresDatabase(dsn, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrator, err := NewMigrator(db, DatabaseDriverPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = migrator.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every test starts from scratch
+	db.Exec("TRUNCATE TABLE notifications RESTART IDENTITY")
+
+	repository, err := NewSQLNotificationRepository(db, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repository
+}
+
+func TestPostgresNotificationRepository(t *testing.T) {
+	testNotificationRepository(t, func(t *testing.T) NotificationRepository {
+		return connectTestPostgresDatabase(t)
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SQLClientPreferenceRepository is the concrete implementation of ClientPreferenceRepository for an SQL database
+type SQLClientPreferenceRepository struct {
+	db           *gorm.DB
+	tenantID     string
+	queryTimeout time.Duration
+}
+
+// NewSQLClientPreferenceRepository creates a new SQLClientPreferenceRepository instance with an underlying GORM's
+// database abstraction, bound to the default tenant
+func NewSQLClientPreferenceRepository(db *gorm.DB, queryTimeout time.Duration) (*SQLClientPreferenceRepository, error) {
+	repository := &SQLClientPreferenceRepository{
+		db:           db,
+		tenantID:     DefaultTenantID,
+		queryTimeout: queryTimeout,
+	}
+
+	return repository, nil
+}
+
+// ForTenant gives a copy of the repository bound to the given tenant
+func (repository *SQLClientPreferenceRepository) ForTenant(tenantID string) ClientPreferenceRepository {
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+
+	return &SQLClientPreferenceRepository{
+		db:           repository.db,
+		tenantID:     tenantID,
+		queryTimeout: repository.queryTimeout,
+	}
+}
+
+func (repository *SQLClientPreferenceRepository) query(ctx context.Context, fn func(db *gorm.DB) error) error {
+	return queryWithTimeout(ctx, repository.db, repository.queryTimeout, "client preferences", fn)
+}
+
+// GetAll preferences of a client in the SQL database, ordered by scope and key
+func (repository *SQLClientPreferenceRepository) GetAll(ctx context.Context, clientID string) ([]ClientPreference, error) {
+	var preferences []ClientPreference
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).Where("client_id = ?", clientID).Order("scope, match_key").Find(&preferences).Error
+	})
+	if err != nil {
+		return []ClientPreference{}, err
+	}
+
+	return preferences, nil
+}
+
+// Replace every preference of a client in the SQL database with the given ones, in a single transaction
+func (repository *SQLClientPreferenceRepository) Replace(ctx context.Context, clientID string, preferences []ClientPreference) error {
+	for i := range preferences {
+		preferences[i].ID = 0
+		preferences[i].TenantID = repository.tenantID
+		preferences[i].ClientID = clientID
+	}
+
+	return repository.query(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			err := repository.scoped(tx).Where("client_id = ?", clientID).Delete(&ClientPreference{}).Error
+			if err != nil {
+				return err
+			}
+
+			if len(preferences) == 0 {
+				return nil
+			}
+
+			return tx.Create(&preferences).Error
+		})
+	})
+}
+
+// Match preferences of the given clients on a subject in the SQL database, giving the channels of each client which
+// has any
+func (repository *SQLClientPreferenceRepository) Match(ctx context.Context, clientIDs []string, subject PreferenceSubject) (map[string]Channels, error) {
+	matched := map[string]Channels{}
+
+	keys := []string{}
+	for _, key := range []string{subject.Category, subject.SourceID, subject.Topic} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(clientIDs) == 0 || len(keys) == 0 {
+		return matched, nil
+	}
+
+	// Keys narrow rows down well enough, which are told apart by scope right after
+	var preferences []ClientPreference
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).Where("client_id IN ? AND match_key IN ?", clientIDs, keys).Find(&preferences).Error
+	})
+	if err != nil {
+		return matched, err
+	}
+
+	for _, preference := range preferences {
+		if !subject.Matches(preference) {
+			continue
+		}
+
+		channels, ok := matched[preference.ClientID]
+		if !ok {
+			channels = AllChannels
+		}
+		matched[preference.ClientID] = channels.Restrict(preference.Channels)
+	}
+
+	return matched, nil
+}
+
+// scoped narrows down to the preferences of the tenant the repository is bound to
+func (repository *SQLClientPreferenceRepository) scoped(db *gorm.DB) *gorm.DB {
+	return db.Where("tenant_id = ?", repository.tenantID)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SQLClientSettingsRepository is the concrete implementation of ClientSettingsRepository for an SQL database
+type SQLClientSettingsRepository struct {
+	db           *gorm.DB
+	tenantID     string
+	queryTimeout time.Duration
+}
+
+// NewSQLClientSettingsRepository creates a new SQLClientSettingsRepository instance with an underlying GORM's database
+// abstraction, bound to the default tenant
+func NewSQLClientSettingsRepository(db *gorm.DB, queryTimeout time.Duration) (*SQLClientSettingsRepository, error) {
+	repository := &SQLClientSettingsRepository{
+		db:           db,
+		tenantID:     DefaultTenantID,
+		queryTimeout: queryTimeout,
+	}
+
+	return repository, nil
+}
+
+// ForTenant gives a copy of the repository bound to the given tenant
+func (repository *SQLClientSettingsRepository) ForTenant(tenantID string) ClientSettingsRepository {
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+
+	return &SQLClientSettingsRepository{
+		db:           repository.db,
+		tenantID:     tenantID,
+		queryTimeout: repository.queryTimeout,
+	}
+}
+
+func (repository *SQLClientSettingsRepository) query(ctx context.Context, fn func(db *gorm.DB) error) error {
+	return queryWithTimeout(ctx, repository.db, repository.queryTimeout, "client settings", fn)
+}
+
+// Save the settings of a client in the SQL database, replacing the ones it had, if any
+func (repository *SQLClientSettingsRepository) Save(ctx context.Context, settings *ClientSettings) error {
+	settings.ID = 0
+	settings.TenantID = repository.tenantID
+	if settings.SnoozedUntil != nil {
+		snoozedUntil := settings.SnoozedUntil.UTC()
+		settings.SnoozedUntil = &snoozedUntil
+	}
+
+	return repository.query(ctx, func(db *gorm.DB) error {
+		err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "tenant_id"}, {Name: "client_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"time_zone", "quiet_start", "quiet_end", "quiet_release", "snoozed_until", "updated_at"}),
+		}).Create(settings).Error
+		if err != nil {
+			return err
+		}
+
+		// The settings may have been there already, which is where their ID and creation time come from
+		var saved ClientSettings
+		err = repository.scoped(db).Where("client_id = ?", settings.ClientID).First(&saved).Error
+		if err != nil {
+			return err
+		}
+
+		*settings = saved
+		return nil
+	})
+}
+
+// Get the settings of a client in the SQL database
+func (repository *SQLClientSettingsRepository) Get(ctx context.Context, clientID string) (ClientSettings, error) {
+	var settings ClientSettings
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).Where("client_id = ?", clientID).First(&settings).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ClientSettings{}, ErrClientSettingsNotFound
+		}
+		return ClientSettings{}, err
+	}
+
+	return settings, nil
+}
+
+// Find the settings of the given clients in the SQL database, by client ID
+func (repository *SQLClientSettingsRepository) Find(ctx context.Context, clientIDs []string) (map[string]ClientSettings, error) {
+	found := map[string]ClientSettings{}
+	if len(clientIDs) == 0 {
+		return found, nil
+	}
+
+	var settings []ClientSettings
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).Where("client_id IN ?", clientIDs).Find(&settings).Error
+	})
+	if err != nil {
+		return found, err
+	}
+
+	for _, clientSettings := range settings {
+		found[clientSettings.ClientID] = clientSettings
+	}
+
+	return found, nil
+}
+
+// scoped narrows down to the settings of the tenant the repository is bound to
+func (repository *SQLClientSettingsRepository) scoped(db *gorm.DB) *gorm.DB {
+	return db.Where("tenant_id = ?", repository.tenantID)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SQLRecurringScheduleRepository is the concrete implementation of RecurringScheduleRepository for an SQL database
+type SQLRecurringScheduleRepository struct {
+	db           *gorm.DB
+	tenantID     string
+	queryTimeout time.Duration
+}
+
+// NewSQLRecurringScheduleRepository creates a new SQLRecurringScheduleRepository instance with an underlying GORM's
+// database abstraction, bound to the default tenant
+func NewSQLRecurringScheduleRepository(db *gorm.DB, queryTimeout time.Duration) (*SQLRecurringScheduleRepository, error) {
+	repository := &SQLRecurringScheduleRepository{
+		db:           db,
+		tenantID:     DefaultTenantID,
+		queryTimeout: queryTimeout,
+	}
+
+	return repository, nil
+}
+
+// ForTenant gives a copy of the repository bound to the given tenant
+func (repository *SQLRecurringScheduleRepository) ForTenant(tenantID string) RecurringScheduleRepository {
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+
+	return &SQLRecurringScheduleRepository{
+		db:           repository.db,
+		tenantID:     tenantID,
+		queryTimeout: repository.queryTimeout,
+	}
+}
+
+func (repository *SQLRecurringScheduleRepository) query(ctx context.Context, fn func(db *gorm.DB) error) error {
+	return queryWithTimeout(ctx, repository.db, repository.queryTimeout, "recurring schedules", fn)
+}
+
+// Add a schedule to the SQL database
+func (repository *SQLRecurringScheduleRepository) Add(ctx context.Context, schedule *RecurringSchedule) error {
+	schedule.TenantID = repository.tenantID
+
+	return repository.query(ctx, func(db *gorm.DB) error {
+		return db.Create(schedule).Error
+	})
+}
+
+// Get a schedule in the SQL database by its ID
+func (repository *SQLRecurringScheduleRepository) Get(ctx context.Context, id uint) (RecurringSchedule, error) {
+	var schedule RecurringSchedule
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return db.Where("tenant_id = ?", repository.tenantID).First(&schedule, id).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return RecurringSchedule{}, ErrRecurringScheduleNotFound
+		}
+		return RecurringSchedule{}, err
+	}
+
+	return schedule, nil
+}
+
+// GetAll schedules of a source in the SQL database, or of every source if none is given, ordered by ID
+func (repository *SQLRecurringScheduleRepository) GetAll(ctx context.Context, sourceID string) ([]RecurringSchedule, error) {
+	var schedules []RecurringSchedule
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		db = db.Where("tenant_id = ?", repository.tenantID)
+		if sourceID != "" {
+			db = db.Where("source_id = ?", sourceID)
+		}
+		return db.Order("id").Find(&schedules).Error
+	})
+	if err != nil {
+		return []RecurringSchedule{}, err
+	}
+
+	return schedules, nil
+}
+
+// Update what a schedule publishes and when in the SQL database, as long as it is at the same revision
+func (repository *SQLRecurringScheduleRepository) Update(ctx context.Context, schedule *RecurringSchedule) (bool, error) {
+	return repository.change(ctx, schedule, map[string]interface{}{
+		"cron":           schedule.Cron,
+		"time_zone":      schedule.TimeZone,
+		"destination_id": schedule.DestinationID,
+		"audience":       schedule.Audience,
+		"data":           schedule.Data,
+		"misfire_policy": schedule.MisfirePolicy,
+		"next_fire_at":   schedule.NextFireAt,
+	})
+}
+
+// Advance a schedule to its next fire time in the SQL database, as long as it is at the same revision, so that each
+// fire is claimed by one service node only. It tells when it fired, unless it skipped
+func (repository *SQLRecurringScheduleRepository) Advance(ctx context.Context, schedule *RecurringSchedule, firedAt *time.Time) (bool, error) {
+	changes := map[string]interface{}{
+		"next_fire_at": schedule.NextFireAt,
+	}
+	if firedAt != nil {
+		changes["last_fired_at"] = *firedAt
+	}
+
+	return repository.change(ctx, schedule, changes)
+}
+
+// change a schedule at the same revision, moving its revision on
+func (repository *SQLRecurringScheduleRepository) change(ctx context.Context, schedule *RecurringSchedule, changes map[string]interface{}) (bool, error) {
+	changes["revision"] = schedule.Revision + 1
+	changes["updated_at"] = time.Now()
+
+	var rowsAffected int64
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		result := db.Model(&RecurringSchedule{}).
+			Where("id = ? AND tenant_id = ? AND revision = ?", schedule.ID, repository.tenantID, schedule.Revision).
+			Updates(changes)
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return false, err
+	}
+	if rowsAffected == 0 {
+		return false, nil
+	}
+
+	schedule.Revision++
+	return true, nil
+}
+
+// Delete a schedule in the SQL database by its ID
+func (repository *SQLRecurringScheduleRepository) Delete(ctx context.Context, id uint) error {
+	var rowsAffected int64
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		result := db.Where("tenant_id = ?", repository.tenantID).Delete(&RecurringSchedule{}, id)
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecurringScheduleNotFound
+	}
+
+	return nil
+}
+
+// GetDue schedules in the SQL database, of every tenant, up to limit of them ordered by when they are due
+func (repository *SQLRecurringScheduleRepository) GetDue(ctx context.Context, now time.Time, limit int) ([]RecurringSchedule, error) {
+	var schedules []RecurringSchedule
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return db.Where("next_fire_at IS NOT NULL AND next_fire_at <= ?", now.UTC()).Order("next_fire_at, id").Limit(limit).Find(&schedules).Error
+	})
+	if err != nil {
+		return []RecurringSchedule{}, err
+	}
+
+	return schedules, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SQLRevocationRepository is the concrete implementation of RevocationRepository for an SQL database
+type SQLRevocationRepository struct {
+	db *gorm.DB
+}
+
+// NewSQLRevocationRepository creates a new SQLRevocationRepository instance with an underlying GORM's database abstraction
+func NewSQLRevocationRepository(db *gorm.DB) (*SQLRevocationRepository, error) {
+	repository := &SQLRevocationRepository{
+		db: db,
+	}
+
+	return repository, nil
+}
+
+// Add a revocation to the SQL database
+func (repository *SQLRevocationRepository) Add(revocation *Revocation) error {
+	result := repository.db.Create(revocation)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// GetActive revocations in the SQL database, i.e. those not expired at the given time
+func (repository *SQLRevocationRepository) GetActive(at time.Time) ([]Revocation, error) {
+	var revocations []Revocation
+	result := repository.db.Where("expires_at > ?", at).Order("id").Find(&revocations)
+	if result.Error != nil {
+		return []Revocation{}, result.Error
+	}
+
+	return revocations, nil
+}
+
+// IsRevoked tells whether there is an active revocation for either the token ID or the subject of a tenant
+func (repository *SQLRevocationRepository) IsRevoked(tenantID string, jti string, subject string, at time.Time) (bool, error) {
+	var count int64
+	result := repository.db.Model(&Revocation{}).
+		Where("tenant_id = ? AND ((jti = ? AND jti <> '') OR (subject = ? AND subject <> '')) AND expires_at > ?", tenantID, jti, subject, at).
+		Count(&count)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return count > 0, nil
+}
+
+// DeleteExpired revocations from the SQL database, as they are no longer of any use
+func (repository *SQLRevocationRepository) DeleteExpired(at time.Time) error {
+	result := repository.db.Where("expires_at <= ?", at).Delete(&Revocation{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
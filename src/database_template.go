@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SQLNotificationTemplateRepository is the concrete implementation of NotificationTemplateRepository for an SQL
+// database
+type SQLNotificationTemplateRepository struct {
+	db           *gorm.DB
+	tenantID     string
+	queryTimeout time.Duration
+}
+
+// NewSQLNotificationTemplateRepository creates a new SQLNotificationTemplateRepository instance with an underlying
+// GORM's database abstraction, bound to the default tenant
+func NewSQLNotificationTemplateRepository(db *gorm.DB, queryTimeout time.Duration) (*SQLNotificationTemplateRepository, error) {
+	repository := &SQLNotificationTemplateRepository{
+		db:           db,
+		tenantID:     DefaultTenantID,
+		queryTimeout: queryTimeout,
+	}
+
+	return repository, nil
+}
+
+// ForTenant gives a copy of the repository bound to the given tenant
+func (repository *SQLNotificationTemplateRepository) ForTenant(tenantID string) NotificationTemplateRepository {
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+
+	return &SQLNotificationTemplateRepository{
+		db:           repository.db,
+		tenantID:     tenantID,
+		queryTimeout: repository.queryTimeout,
+	}
+}
+
+func (repository *SQLNotificationTemplateRepository) query(ctx context.Context, fn func(db *gorm.DB) error) error {
+	return queryWithTimeout(ctx, repository.db, repository.queryTimeout, "notification templates", fn)
+}
+
+// Save a template in the SQL database, replacing the one of the same event type and locale, if any
+func (repository *SQLNotificationTemplateRepository) Save(ctx context.Context, notificationTemplate *NotificationTemplate) error {
+	notificationTemplate.TenantID = repository.tenantID
+
+	return repository.query(ctx, func(db *gorm.DB) error {
+		err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "tenant_id"}, {Name: "event_type"}, {Name: "locale"}},
+			DoUpdates: clause.AssignmentColumns([]string{"title", "body", "action_url", "updated_at"}),
+		}).Create(notificationTemplate).Error
+		if err != nil {
+			return err
+		}
+
+		// The template may have been there already, which is where its ID and creation time come from
+		var saved NotificationTemplate
+		err = repository.scoped(db).Where("event_type = ? AND locale = ?", notificationTemplate.EventType, notificationTemplate.Locale).
+			First(&saved).Error
+		if err != nil {
+			return err
+		}
+
+		*notificationTemplate = saved
+		return nil
+	})
+}
+
+// Find templates of an event type in the SQL database, in any of the given locales
+func (repository *SQLNotificationTemplateRepository) Find(ctx context.Context, eventType string, locales []string) ([]NotificationTemplate, error) {
+	var templates []NotificationTemplate
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).Where("event_type = ? AND locale IN ?", eventType, locales).Find(&templates).Error
+	})
+	if err != nil {
+		return []NotificationTemplate{}, err
+	}
+
+	return templates, nil
+}
+
+// GetAll templates of an event type in the SQL database, ordered by locale
+func (repository *SQLNotificationTemplateRepository) GetAll(ctx context.Context, eventType string) ([]NotificationTemplate, error) {
+	var templates []NotificationTemplate
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).Where("event_type = ?", eventType).Order("locale").Find(&templates).Error
+	})
+	if err != nil {
+		return []NotificationTemplate{}, err
+	}
+
+	return templates, nil
+}
+
+// Delete the template of an event type in a given locale in the SQL database
+func (repository *SQLNotificationTemplateRepository) Delete(ctx context.Context, eventType string, locale string) error {
+	var rowsAffected int64
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		result := repository.scoped(db).Where("event_type = ? AND locale = ?", eventType, locale).Delete(&NotificationTemplate{})
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotificationTemplateNotFound
+	}
+
+	return nil
+}
+
+// DeleteAll templates of an event type in the SQL database, if there is any
+func (repository *SQLNotificationTemplateRepository) DeleteAll(ctx context.Context, eventType string) error {
+	return repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).Where("event_type = ?", eventType).Delete(&NotificationTemplate{}).Error
+	})
+}
+
+// scoped narrows down to the templates of the tenant the repository is bound to
+func (repository *SQLNotificationTemplateRepository) scoped(db *gorm.DB) *gorm.DB {
+	return db.Where("tenant_id = ?", repository.tenantID)
+}
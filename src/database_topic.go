@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SQLTopicSubscriptionRepository is the concrete implementation of TopicSubscriptionRepository for an SQL database
+type SQLTopicSubscriptionRepository struct {
+	db           *gorm.DB
+	tenantID     string
+	queryTimeout time.Duration
+}
+
+// NewSQLTopicSubscriptionRepository creates a new SQLTopicSubscriptionRepository instance with an underlying GORM's
+// database abstraction, bound to the default tenant
+func NewSQLTopicSubscriptionRepository(db *gorm.DB, queryTimeout time.Duration) (*SQLTopicSubscriptionRepository, error) {
+	repository := &SQLTopicSubscriptionRepository{
+		db:           db,
+		tenantID:     DefaultTenantID,
+		queryTimeout: queryTimeout,
+	}
+
+	return repository, nil
+}
+
+// ForTenant gives a copy of the repository bound to the given tenant
+func (repository *SQLTopicSubscriptionRepository) ForTenant(tenantID string) TopicSubscriptionRepository {
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+
+	return &SQLTopicSubscriptionRepository{
+		db:           repository.db,
+		tenantID:     tenantID,
+		queryTimeout: repository.queryTimeout,
+	}
+}
+
+func (repository *SQLTopicSubscriptionRepository) query(ctx context.Context, fn func(db *gorm.DB) error) error {
+	return queryWithTimeout(ctx, repository.db, repository.queryTimeout, "topic subscriptions", fn)
+}
+
+// Subscribe a client to a topic in the SQL database, unless it already is
+func (repository *SQLTopicSubscriptionRepository) Subscribe(ctx context.Context, subscription *TopicSubscription) error {
+	subscription.ID = 0
+	subscription.TenantID = repository.tenantID
+
+	return repository.query(ctx, func(db *gorm.DB) error {
+		err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(subscription).Error
+		if err != nil {
+			return err
+		}
+
+		// The subscription may have been there already, which is where its ID and creation time come from
+		var saved TopicSubscription
+		err = repository.scoped(db).Where("client_id = ? AND topic = ?", subscription.ClientID, subscription.Topic).First(&saved).Error
+		if err != nil {
+			return err
+		}
+
+		*subscription = saved
+		return nil
+	})
+}
+
+// Unsubscribe a client from a topic in the SQL database, giving the subscription it had
+func (repository *SQLTopicSubscriptionRepository) Unsubscribe(ctx context.Context, clientID string, topic string) (TopicSubscription, error) {
+	var subscription TopicSubscription
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		err := repository.scoped(db).Where("client_id = ? AND topic = ?", clientID, topic).First(&subscription).Error
+		if err != nil {
+			return err
+		}
+
+		return db.Delete(&TopicSubscription{}, subscription.ID).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return TopicSubscription{}, ErrTopicSubscriptionNotFound
+		}
+		return TopicSubscription{}, err
+	}
+
+	return subscription, nil
+}
+
+// GetAll subscriptions of a client in the SQL database, ordered by topic
+func (repository *SQLTopicSubscriptionRepository) GetAll(ctx context.Context, clientID string) ([]TopicSubscription, error) {
+	var subscriptions []TopicSubscription
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).Where("client_id = ?", clientID).Order("topic").Find(&subscriptions).Error
+	})
+	if err != nil {
+		return []TopicSubscription{}, err
+	}
+
+	return subscriptions, nil
+}
+
+// Subscribers of a topic in the SQL database, by client ID
+func (repository *SQLTopicSubscriptionRepository) Subscribers(ctx context.Context, topic string) ([]string, error) {
+	clientIDs := []string{}
+	err := repository.query(ctx, func(db *gorm.DB) error {
+		return repository.scoped(db).Model(&TopicSubscription{}).Where("topic = ?", topic).Order("client_id").Pluck("client_id", &clientIDs).Error
+	})
+	if err != nil {
+		return []string{}, err
+	}
+
+	return clientIDs, nil
+}
+
+// scoped narrows down to the subscriptions of the tenant the repository is bound to
+func (repository *SQLTopicSubscriptionRepository) scoped(db *gorm.DB) *gorm.DB {
+	return db.Where("tenant_id = ?", repository.tenantID)
+}
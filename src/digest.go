@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DigestMinInterval is as often as a client may get digests of a category, in seconds
+	DigestMinInterval = 60
+
+	// DigestMaxInterval is as seldom as a client may get digests of a category, in seconds
+	DigestMaxInterval = 7 * 24 * 60 * 60
+)
+
+// DigestType is the event type of the digest of notifications of a category, whose data tells the category, how many
+// of them there are and their IDs. It may have templates, as long as it is registered
+const DigestType = "mercurio.digest"
+
+// ClientDigest is the persistent record of how often a client wants notifications of a category summed up, in
+// seconds, rather than each one delivered live. Digests go out at multiples of their interval, e.g. on the hour
+type ClientDigest struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	TenantID  string    `json:"-" gorm:"not null"`
+	ClientID  string    `json:"-" gorm:"not null"`
+	Category  string    `json:"category" gorm:"not null"`
+	Interval  int       `json:"interval" gorm:"column:interval_seconds;not null"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// NewClientDigest creates a new ClientDigest, making sure its category is given and its interval is within bounds
+func NewClientDigest(category string, interval int) (*ClientDigest, error) {
+	if category == "" {
+		return nil, errors.New("category must be given")
+	}
+	if len(category) > preferenceKeyMaxLength {
+		return nil, fmt.Errorf("category must not be longer than %d characters", preferenceKeyMaxLength)
+	}
+	if interval < DigestMinInterval || interval > DigestMaxInterval {
+		return nil, fmt.Errorf("interval must be between %d and %d seconds", DigestMinInterval, DigestMaxInterval)
+	}
+
+	digest := &ClientDigest{
+		Category: category,
+		Interval: interval,
+	}
+
+	return digest, nil
+}
+
+// NextAt tells when the next digest goes out after a given time. Digests go out at multiples of their interval in
+// UTC, whatever the time zone of the client's quiet hours, e.g. a daily one at midnight UTC
+func (digest ClientDigest) NextAt(now time.Time) time.Time {
+	interval := time.Duration(digest.Interval) * time.Second
+	return now.UTC().Truncate(interval).Add(interval)
+}
+
+// ClientDigestRepository is the interface to client digest datastore. Every operation is bound to one tenant, the
+// default one unless it was scoped by ForTenant. Replace swaps every digest of a client for the given ones, and Find
+// gives the digest of a category of whichever of the given clients has one
+type ClientDigestRepository interface {
+	ForTenant(tenantID string) ClientDigestRepository
+	GetAll(ctx context.Context, clientID string) ([]ClientDigest, error)
+	Replace(ctx context.Context, clientID string, digests []ClientDigest) error
+	Find(ctx context.Context, clientIDs []string, category string) (map[string]ClientDigest, error)
+}
+
+// newDigest of the notifications accumulated for a client, all of the same category, which is delivered in their
+// stead. It goes ahead as the most important of them does
+func newDigest(notifications []Notification) (*Notification, error) {
+	notificationIDs := []uint{}
+	priority := PriorityLow
+	for _, notification := range notifications {
+		notificationIDs = append(notificationIDs, notification.ID)
+		if notification.Priority > priority {
+			priority = notification.Priority
+		}
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"category":        notifications[0].Category,
+		"count":           len(notifications),
+		"notificationIDs": notificationIDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	digest := &Notification{
+		TenantID:      notifications[0].TenantID,
+		EventID:       uuid.New().String(),
+		DestinationID: notifications[0].DestinationID,
+		Type:          DigestType,
+		Category:      notifications[0].Category,
+		Data:          JSONData(data),
+		Priority:      priority,
+		CreatedAt:     time.Now(),
+	}
+
+	return digest, nil
+}
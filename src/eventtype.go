@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrEventTypeNotFound is returned when an event type doesn't exist in database
+var ErrEventTypeNotFound = errors.New("event type not found")
+
+// eventTypeNamePattern tells what an event type name looks like, e.g. comment.created or billing:invoice-due
+var eventTypeNamePattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,100}$`)
+
+// EventType is the persistent record of a type events of a tenant may be of, which may carry a JSON Schema the data
+// of such events must match. Schema is the JSON Schema document, null when any data goes
+type EventType struct {
+	ID          uint      `json:"-" gorm:"primaryKey"`
+	TenantID    string    `json:"tenantID,omitempty" gorm:"not null"`
+	Name        string    `json:"name" gorm:"not null"`
+	Description string    `json:"description,omitempty" gorm:"not null;default:''"`
+	Schema      JSONData  `json:"schema" gorm:"column:json_schema;not null"`
+	CreatedAt   time.Time `json:"createdAt,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt,omitempty"`
+}
+
+// NewEventType creates a new EventType, making sure its name is a valid one and its schema compiles
+func NewEventType(name string, description string, schema JSONData) (*EventType, error) {
+	if !eventTypeNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("%s is not a valid event type name", name)
+	}
+
+	eventType := &EventType{
+		Name:        name,
+		Description: description,
+		Schema:      schema,
+	}
+
+	_, err := eventType.CompileSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	return eventType, nil
+}
+
+// CompileSchema of the event type, which is nil when any data goes
+func (eventType EventType) CompileSchema() (*JSONSchema, error) {
+	if eventType.Schema == "" {
+		return nil, nil
+	}
+
+	return CompileJSONSchema([]byte(eventType.Schema))
+}
+
+// EventTypeRepository is the interface to event type datastore. Every operation is bound to one tenant, the default
+// one unless it was scoped by ForTenant. Save adds an event type, or replaces the one of the same name
+type EventTypeRepository interface {
+	ForTenant(tenantID string) EventTypeRepository
+	Save(ctx context.Context, eventType *EventType) error
+	Get(ctx context.Context, name string) (EventType, error)
+	GetAll(ctx context.Context) ([]EventType, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// SchemaViolationError tells that the data of an event doesn't match the schema of its type
+type SchemaViolationError struct {
+	Type       string
+	Violations []SchemaViolation
+}
+
+func (e *SchemaViolationError) Error() string {
+	return fmt.Sprintf("data doesn't match the schema of event type %s", e.Type)
+}
+
+// EventValidator makes sure events are of a registered type and their data match its schema. Compiled schemas are
+// kept as long as their event type doesn't change
+type EventValidator struct {
+	repository EventTypeRepository
+
+	mutex   sync.Mutex
+	schemas map[string]compiledEventType
+}
+
+type compiledEventType struct {
+	updatedAt time.Time
+	schema    *JSONSchema
+}
+
+// NewEventValidator creates a new EventValidator on top of an event type repository
+func NewEventValidator(repository EventTypeRepository) *EventValidator {
+	return &EventValidator{
+		repository: repository,
+		schemas:    map[string]compiledEventType{},
+	}
+}
+
+// Validate the data of an event of a tenant against the schema of its type. An event of no type goes as is, but an
+// event of an unknown type gives ErrEventTypeNotFound and one whose data doesn't match a SchemaViolationError
+func (v *EventValidator) Validate(ctx context.Context, tenantID string, eventType string, data JSONData) error {
+	if eventType == "" {
+		return nil
+	}
+
+	registered, err := v.repository.ForTenant(tenantID).Get(ctx, eventType)
+	if err != nil {
+		if errors.Is(err, ErrEventTypeNotFound) {
+			return fmt.Errorf("%s is not a registered event type: %w", eventType, err)
+		}
+		return err
+	}
+
+	schema, err := v.schema(tenantID, registered)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		return nil
+	}
+
+	document := data
+	if document == "" {
+		document = "null"
+	}
+
+	violations, err := schema.Validate([]byte(document))
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		return &SchemaViolationError{Type: eventType, Violations: violations}
+	}
+
+	return nil
+}
+
+func (v *EventValidator) schema(tenantID string, eventType EventType) (*JSONSchema, error) {
+	key := tenantID + "/" + eventType.Name
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	compiled, ok := v.schemas[key]
+	if ok && compiled.updatedAt.Equal(eventType.UpdatedAt) {
+		return compiled.schema, nil
+	}
+
+	schema, err := eventType.CompileSchema()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile schema of event type %s due to: %s", eventType.Name, err)
+	}
+
+	v.schemas[key] = compiledEventType{updatedAt: eventType.UpdatedAt, schema: schema}
+	return schema, nil
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 )
 
@@ -24,6 +26,10 @@ func respondWithSuccess(w http.ResponseWriter, content interface{}) {
 	respondWithJSON(w, content, http.StatusOK)
 }
 
+func respondWithCreated(w http.ResponseWriter, content interface{}) {
+	respondWithJSON(w, content, http.StatusCreated)
+}
+
 func respondWithError(w http.ResponseWriter, message string, httpStatus int) {
 	content := map[string]string{"error": message}
 	respondWithJSON(w, content, httpStatus)
@@ -41,6 +47,48 @@ func respondWithUnauthorized(w http.ResponseWriter, message string) {
 	respondWithError(w, message, http.StatusUnauthorized)
 }
 
+func respondWithForbidden(w http.ResponseWriter, message string) {
+	respondWithError(w, message, http.StatusForbidden)
+}
+
 func respondWithInternalServerError(w http.ResponseWriter, message string) {
 	respondWithError(w, message, http.StatusInternalServerError)
 }
+
+// respondWithRepositoryError tells apart a database that took too long (504), a request given up on before the
+// database could answer (503), or a destination at its quota (429), from any other failure (500)
+func respondWithRepositoryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrQuotaExceeded):
+		respondWithError(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, context.DeadlineExceeded):
+		respondWithError(w, err.Error(), http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled):
+		respondWithError(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		respondWithInternalServerError(w, err.Error())
+	}
+}
+
+type schemaViolationResponse struct {
+	Error      string            `json:"error"`
+	Violations []SchemaViolation `json:"violations"`
+}
+
+// respondWithEventError tells an event of an unknown type or whose data doesn't match the schema of its type (422),
+// along with every violation, from any other failure
+func respondWithEventError(w http.ResponseWriter, err error) {
+	var violation *SchemaViolationError
+	switch {
+	case errors.As(err, &violation):
+		response := schemaViolationResponse{
+			Error:      violation.Error(),
+			Violations: violation.Violations,
+		}
+		respondWithJSON(w, response, http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrEventTypeNotFound):
+		respondWithError(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		respondWithRepositoryError(w, err)
+	}
+}
@@ -9,13 +9,23 @@ import (
 )
 
 // NewHTTPServer creates a new HTTP server to serves Broker's Notification API
-func NewHTTPServer(jwtAuth JWTAuthMiddleware, api NotificationAPI) (*http.Server, error) {
+func NewHTTPServer(jwtAuth JWTAuthMiddleware, api NotificationAPI, adminAPI AdminAPI, tenants *TenantRegistry) (*http.Server, error) {
 	n := negroni.Classic()
 
-	c := cors.New(GetCORSOptions())
+	// Origins are checked per tenant once the request is authorized
+	corsOptions := GetCORSOptions()
+	corsOptions.AllowedOrigins = append(corsOptions.AllowedOrigins, tenants.AllowedOrigins()...)
+
+	c := cors.New(corsOptions)
 	n.Use(c)
 
-	r := mountRoutes(jwtAuth, api)
+	trustedProxies, err := GetTrustedProxies()
+	if err != nil {
+		return nil, err
+	}
+	n.Use(SourceIPMiddleware(trustedProxies))
+
+	r := mountRoutes(jwtAuth, api, adminAPI)
 	n.UseHandler(r)
 
 	s := &http.Server{
@@ -26,7 +36,7 @@ func NewHTTPServer(jwtAuth JWTAuthMiddleware, api NotificationAPI) (*http.Server
 	return s, nil
 }
 
-func mountRoutes(jwtAuth JWTAuthMiddleware, api NotificationAPI) *mux.Router {
+func mountRoutes(jwtAuth JWTAuthMiddleware, api NotificationAPI, adminAPI AdminAPI) *mux.Router {
 	r := mux.NewRouter()
 
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -35,8 +45,10 @@ func mountRoutes(jwtAuth JWTAuthMiddleware, api NotificationAPI) *mux.Router {
 	})
 
 	eventsRouter := r.PathPrefix("/api/events").Subrouter()
-	eventsRouter.Handle("/unicast", jwtAuth.Secure(api.UnicastEventHandler)).Methods("POST")
-	eventsRouter.Handle("/broadcast", jwtAuth.Secure(api.BroadcastEventHandler)).Methods("POST")
+	eventsRouter.Handle("/unicast", jwtAuth.Secure(api.Idempotency.Guard(api.UnicastEventHandler))).Methods("POST")
+	eventsRouter.Handle("/broadcast", jwtAuth.Secure(api.Idempotency.Guard(api.BroadcastEventHandler))).Methods("POST")
+	eventsRouter.Handle("/{eventID}/schedule", jwtAuth.SecurePublisher(api.RescheduleEventHandler)).Methods("PUT")
+	eventsRouter.Handle("/{eventID}/schedule", jwtAuth.SecurePublisher(api.CancelScheduledEventHandler)).Methods("DELETE")
 
 	clientsRouter := r.PathPrefix("/api/clients/{clientID}").Subrouter()
 	clientsRouter.Handle("/notifications/stream", jwtAuth.Secure(api.StreamNotificationsHandler))
@@ -44,6 +56,47 @@ func mountRoutes(jwtAuth JWTAuthMiddleware, api NotificationAPI) *mux.Router {
 	clientsRouter.Handle("/notifications/{notificationID:[0-9]+}", jwtAuth.Secure(api.GetNotificationHandler))
 	clientsRouter.Handle("/notifications/{notificationID:[0-9]+}/read", jwtAuth.Secure(api.MarkNotificationReadHandler)).Methods("PUT")
 	clientsRouter.Handle("/notifications/{notificationID:[0-9]+}/unread", jwtAuth.Secure(api.MarkNotificationUnreadHandler)).Methods("PUT")
+	clientsRouter.Handle("/broadcasts/{broadcastID:[0-9]+}/read", jwtAuth.Secure(api.MarkBroadcastReadHandler)).Methods("PUT")
+	clientsRouter.Handle("/broadcasts/{broadcastID:[0-9]+}/unread", jwtAuth.Secure(api.MarkBroadcastUnreadHandler)).Methods("PUT")
+	clientsRouter.Handle("/broadcasts/{broadcastID:[0-9]+}/dismiss", jwtAuth.Secure(api.DismissBroadcastHandler)).Methods("PUT")
+	clientsRouter.Handle("/topics", jwtAuth.Secure(api.GetSubscriptionsHandler)).Methods("GET")
+	clientsRouter.Handle("/topics/{topic}", jwtAuth.Secure(api.SubscribeHandler)).Methods("PUT")
+	clientsRouter.Handle("/topics/{topic}", jwtAuth.Secure(api.UnsubscribeHandler)).Methods("DELETE")
+	clientsRouter.Handle("/preferences", jwtAuth.Secure(api.GetPreferencesHandler)).Methods("GET")
+	clientsRouter.Handle("/preferences", jwtAuth.Secure(api.UpdatePreferencesHandler)).Methods("PUT")
+	clientsRouter.Handle("/quiet-hours", jwtAuth.Secure(api.GetQuietHoursHandler)).Methods("GET")
+	clientsRouter.Handle("/quiet-hours", jwtAuth.Secure(api.UpdateQuietHoursHandler)).Methods("PUT")
+	clientsRouter.Handle("/snooze", jwtAuth.Secure(api.SnoozeHandler)).Methods("PUT")
+	clientsRouter.Handle("/snooze", jwtAuth.Secure(api.UnsnoozeHandler)).Methods("DELETE")
+	clientsRouter.Handle("/digests", jwtAuth.Secure(api.GetDigestsHandler)).Methods("GET")
+	clientsRouter.Handle("/digests", jwtAuth.Secure(api.UpdateDigestsHandler)).Methods("PUT")
+
+	jobsRouter := r.PathPrefix("/api/jobs").Subrouter()
+	jobsRouter.Handle("/{jobID:[0-9]+}", jwtAuth.SecurePublisher(api.GetJobHandler)).Methods("GET")
+	jobsRouter.Handle("/{jobID:[0-9]+}/cancel", jwtAuth.SecurePublisher(api.CancelJobHandler)).Methods("PUT")
+
+	schedulesRouter := r.PathPrefix("/api/schedules").Subrouter()
+	schedulesRouter.Handle("", jwtAuth.SecurePublisher(api.CreateScheduleHandler)).Methods("POST")
+	schedulesRouter.Handle("", jwtAuth.SecurePublisher(api.GetSchedulesHandler)).Methods("GET")
+	schedulesRouter.Handle("/{scheduleID:[0-9]+}", jwtAuth.SecurePublisher(api.GetScheduleHandler)).Methods("GET")
+	schedulesRouter.Handle("/{scheduleID:[0-9]+}", jwtAuth.SecurePublisher(api.UpdateScheduleHandler)).Methods("PUT")
+	schedulesRouter.Handle("/{scheduleID:[0-9]+}", jwtAuth.SecurePublisher(api.DeleteScheduleHandler)).Methods("DELETE")
+
+	adminRouter := r.PathPrefix("/api/admin").Subrouter()
+	adminRouter.Handle("/apikeys", jwtAuth.SecureAdmin(adminAPI.CreateAPIKeyHandler)).Methods("POST")
+	adminRouter.Handle("/apikeys", jwtAuth.SecureAdmin(adminAPI.GetAPIKeysHandler)).Methods("GET")
+	adminRouter.Handle("/apikeys/{apiKeyID:[0-9]+}", jwtAuth.SecureAdmin(adminAPI.RevokeAPIKeyHandler)).Methods("DELETE")
+	adminRouter.Handle("/revocations", jwtAuth.SecureAdmin(adminAPI.CreateRevocationHandler)).Methods("POST")
+	adminRouter.Handle("/revocations", jwtAuth.SecureAdmin(adminAPI.GetRevocationsHandler)).Methods("GET")
+	adminRouter.Handle("/audit", jwtAuth.SecureAdmin(adminAPI.GetAuditHandler)).Methods("GET")
+	adminRouter.Handle("/clients/{clientID}/usage", jwtAuth.SecureAdmin(adminAPI.GetClientUsageHandler)).Methods("GET")
+	adminRouter.Handle("/event-types", jwtAuth.SecureAdmin(adminAPI.GetEventTypesHandler)).Methods("GET")
+	adminRouter.Handle("/event-types/{name}", jwtAuth.SecureAdmin(adminAPI.SaveEventTypeHandler)).Methods("PUT")
+	adminRouter.Handle("/event-types/{name}", jwtAuth.SecureAdmin(adminAPI.GetEventTypeHandler)).Methods("GET")
+	adminRouter.Handle("/event-types/{name}", jwtAuth.SecureAdmin(adminAPI.DeleteEventTypeHandler)).Methods("DELETE")
+	adminRouter.Handle("/event-types/{name}/templates", jwtAuth.SecureAdmin(adminAPI.GetTemplatesHandler)).Methods("GET")
+	adminRouter.Handle("/event-types/{name}/templates/{locale}", jwtAuth.SecureAdmin(adminAPI.SaveTemplateHandler)).Methods("PUT")
+	adminRouter.Handle("/event-types/{name}/templates/{locale}", jwtAuth.SecureAdmin(adminAPI.DeleteTemplateHandler)).Methods("DELETE")
 
 	return r
 }
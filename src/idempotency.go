@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader is the HTTP header publishers give a key with, so that retrying a request doesn't take its
+// action twice (e.g. notify a client twice)
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotencyReplayedHeader tells a response is the one stored for an idempotency key, not a fresh one
+const IdempotencyReplayedHeader = "Idempotent-Replayed"
+
+// maxIdempotencyKeyLength fits the key on an indexed column of every database
+const maxIdempotencyKeyLength = 191
+
+// ErrIdempotencyKeyNotFound is returned when an idempotency key doesn't exist in database
+var ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+
+// IdempotencyKey is the persistent record of a request made with an idempotency key, and of its response once
+// there is one (i.e. StatusCode is 0 while the request is in progress). Keys are scoped to who used them on which
+// route, so that publishers can't step on each other's
+type IdempotencyKey struct {
+	ID          uint      `json:"id,omitempty" gorm:"primaryKey"`
+	TenantID    string    `json:"tenantID,omitempty" gorm:"not null"`
+	Scope       string    `json:"scope,omitempty" gorm:"not null"`
+	Key         string    `json:"key,omitempty" gorm:"not null"`
+	RequestHash string    `json:"requestHash,omitempty" gorm:"not null"`
+	StatusCode  int       `json:"statusCode,omitempty"`
+	ContentType string    `json:"contentType,omitempty"`
+	Response    string    `json:"response,omitempty" gorm:"size:65536"`
+	CreatedAt   time.Time `json:"createdAt,omitempty"`
+	ExpiresAt   time.Time `json:"expiresAt,omitempty" gorm:"not null"`
+}
+
+// IdempotencyKeyRepository is the interface to idempotency key datastore. Every operation is bound to one tenant,
+// the default one unless it was scoped by ForTenant, but DeleteExpired
+type IdempotencyKeyRepository interface {
+	ForTenant(tenantID string) IdempotencyKeyRepository
+	Reserve(ctx context.Context, key *IdempotencyKey) (bool, error)
+	Get(ctx context.Context, scope string, key string) (IdempotencyKey, error)
+	Complete(ctx context.Context, key *IdempotencyKey) error
+	Release(ctx context.Context, key *IdempotencyKey) error
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+// IdempotencyGuard makes endpoints idempotent for requests with an idempotency key: the first one takes the action,
+// and retries within the retention window get its response back
+type IdempotencyGuard struct {
+	repository IdempotencyKeyRepository
+	retention  time.Duration
+
+	// Expired keys are deleted every once in a while, along the way
+	purgeMutex sync.Mutex
+	lastPurge  time.Time
+}
+
+// NewIdempotencyGuard creates a new IdempotencyGuard keeping responses for as long as retention
+func NewIdempotencyGuard(repository IdempotencyKeyRepository, retention time.Duration) *IdempotencyGuard {
+	guard := &IdempotencyGuard{
+		repository: repository,
+		retention:  retention,
+	}
+
+	return guard
+}
+
+// idempotencyPurgeInterval is how often a guard deletes expired keys
+const idempotencyPurgeInterval = time.Minute
+
+// Guard wraps an endpoint so that it is idempotent for requests with an idempotency key. Requests without one go
+// straight to the endpoint
+func (guard *IdempotencyGuard) Guard(endpointHandler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			endpointHandler(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			respondWithBadRequest(w, fmt.Sprintf("%s is longer than %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			respondWithBadRequest(w, err.Error())
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		guard.purgeExpired()
+
+		repository := guard.repository.ForTenant(authorizedTenant(r).ID)
+		now := time.Now().UTC()
+		record := &IdempotencyKey{
+			Scope:       idempotencyScope(r),
+			Key:         key,
+			RequestHash: idempotencyRequestHash(r, body),
+			ExpiresAt:   now.Add(guard.retention),
+		}
+
+		reserved, err := repository.Reserve(r.Context(), record)
+		if err != nil {
+			respondWithRepositoryError(w, err)
+			return
+		}
+
+		if !reserved {
+			existing, err := repository.Get(r.Context(), record.Scope, record.Key)
+			if err != nil && !errors.Is(err, ErrIdempotencyKeyNotFound) {
+				respondWithRepositoryError(w, err)
+				return
+			}
+
+			// Whatever is past its retention window is as good as gone, and it may be purged in the meantime too
+			if err == nil && existing.ExpiresAt.Before(now) {
+				err = repository.Release(r.Context(), &existing)
+				if err == nil {
+					reserved, err = repository.Reserve(r.Context(), record)
+				}
+			} else if err != nil {
+				reserved, err = repository.Reserve(r.Context(), record)
+			}
+			if err != nil {
+				respondWithRepositoryError(w, err)
+				return
+			}
+
+			if !reserved {
+				guard.replay(w, existing, record)
+				return
+			}
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		endpointHandler(recorder, r)
+
+		// The request is done, even if its client is gone; a response which may turn out otherwise on retry (e.g. a
+		// server failure or too many requests) is not worth keeping though, so that it may be retried
+		if isIdempotentResponse(recorder.statusCode) {
+			record.StatusCode = recorder.statusCode
+			record.ContentType = recorder.Header().Get("Content-Type")
+			record.Response = recorder.body.String()
+			err = repository.Complete(context.Background(), record)
+		} else {
+			err = repository.Release(context.Background(), record)
+		}
+		if err != nil {
+			log.Printf("Failed to keep response for idempotency key %s due to: %s", key, err)
+		}
+	}
+}
+
+// isIdempotentResponse tells whether a response is the same whenever the request is retried, i.e. a success or the
+// request itself is wrong (bad request, conflict or unprocessable entity)
+func isIdempotentResponse(statusCode int) bool {
+	if statusCode >= http.StatusOK && statusCode < http.StatusMultipleChoices {
+		return true
+	}
+
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
+
+// replay the response of a request made with the same idempotency key before, as long as it is the same request and
+// it is done
+func (guard *IdempotencyGuard) replay(w http.ResponseWriter, existing IdempotencyKey, record *IdempotencyKey) {
+	if existing.ID == 0 || existing.StatusCode == 0 {
+		respondWithError(w, fmt.Sprintf("a request with %s %s is still in progress", IdempotencyKeyHeader, record.Key), http.StatusConflict)
+		return
+	}
+
+	if existing.RequestHash != record.RequestHash {
+		respondWithError(w, fmt.Sprintf("%s %s was used on another request", IdempotencyKeyHeader, record.Key), http.StatusUnprocessableEntity)
+		return
+	}
+
+	log.Printf("Replaying response for idempotency key %s", record.Key)
+
+	if existing.ContentType != "" {
+		w.Header().Set("Content-Type", existing.ContentType)
+	}
+	w.Header().Set(IdempotencyReplayedHeader, "true")
+	w.WriteHeader(existing.StatusCode)
+	w.Write([]byte(existing.Response))
+}
+
+// purgeExpired keys, unless it was done just now
+func (guard *IdempotencyGuard) purgeExpired() {
+	guard.purgeMutex.Lock()
+	defer guard.purgeMutex.Unlock()
+
+	now := time.Now().UTC()
+	if now.Sub(guard.lastPurge) < idempotencyPurgeInterval {
+		return
+	}
+	guard.lastPurge = now
+
+	go func() {
+		deleted, err := guard.repository.DeleteExpired(context.Background(), now)
+		if err != nil {
+			log.Printf("Failed to delete expired idempotency keys due to: %s", err)
+			return
+		}
+		if deleted > 0 {
+			log.Printf("Deleted %d expired idempotency keys", deleted)
+		}
+	}()
+}
+
+// idempotencyScope tells who made a request on which route, e.g. "apikey:7 POST /api/events/unicast"
+func idempotencyScope(r *http.Request) string {
+	actorType, actorID := authorizedActor(r)
+	return fmt.Sprintf("%s:%s %s %s", actorType, actorID, r.Method, r.URL.Path)
+}
+
+// idempotencyRequestHash tells apart requests made with the same idempotency key
+func idempotencyRequestHash(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder keeps a copy of a response while it is written
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (recorder *responseRecorder) WriteHeader(statusCode int) {
+	recorder.statusCode = statusCode
+	recorder.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (recorder *responseRecorder) Write(content []byte) (int, error) {
+	recorder.body.Write(content)
+	return recorder.ResponseWriter.Write(content)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestIdempotencyKeyRepository(t *testing.T) *SQLIdempotencyKeyRepository {
+	repository, err := NewSQLIdempotencyKeyRepository(newTestSqliteDatabase(t), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repository
+}
+
+func serveIdempotentRequest(handler func(http.ResponseWriter, *http.Request), key string, payload string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/api/events/unicast", strings.NewReader(payload))
+	r.Header.Set(IdempotencyKeyHeader, key)
+
+	rr := httptest.NewRecorder()
+	handler(rr, r)
+
+	return rr
+}
+
+func TestIdempotencyGuard_TakesActionOnceAcrossNodes(t *testing.T) {
+	repository := newTestIdempotencyKeyRepository(t)
+
+	var actions int32
+	endpoint := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&actions, 1)
+		time.Sleep(20 * time.Millisecond)
+		respondWithSuccess(w, map[string]string{"done": "once"})
+	}
+
+	// Two service nodes sharing the database
+	nodes := []func(http.ResponseWriter, *http.Request){
+		NewIdempotencyGuard(repository, time.Hour).Guard(endpoint),
+		NewIdempotencyGuard(repository, time.Hour).Guard(endpoint),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(node func(http.ResponseWriter, *http.Request)) {
+			defer wg.Done()
+			rr := serveIdempotentRequest(node, "k1", `{"data":"hi"}`)
+			if rr.Code != http.StatusOK && rr.Code != http.StatusConflict {
+				t.Errorf("got status %d", rr.Code)
+			}
+		}(nodes[i%2])
+	}
+	wg.Wait()
+
+	assertContent(t, atomic.LoadInt32(&actions), int32(1))
+
+	// Once done, retries get the response back
+	rr := serveIdempotentRequest(nodes[1], "k1", `{"data":"hi"}`)
+	assertContent(t, rr.Code, http.StatusOK)
+	assertContent(t, rr.Header().Get(IdempotencyReplayedHeader), "true")
+	assertContent(t, strings.TrimSpace(rr.Body.String()), `{"done":"once"}`)
+	assertContent(t, atomic.LoadInt32(&actions), int32(1))
+
+	// But not for another request
+	rr = serveIdempotentRequest(nodes[0], "k1", `{"data":"bye"}`)
+	assertContent(t, rr.Code, http.StatusUnprocessableEntity)
+}
+
+func TestIdempotencyGuard_ForgetsFailuresAndExpiredKeys(t *testing.T) {
+	repository := newTestIdempotencyKeyRepository(t)
+
+	status := http.StatusInternalServerError
+	var actions int32
+	endpoint := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&actions, 1)
+		respondWithJSON(w, map[string]string{}, status)
+	}
+
+	// Neither a server failure nor too many requests is kept, so it may be retried
+	handler := NewIdempotencyGuard(repository, time.Hour).Guard(endpoint)
+	serveIdempotentRequest(handler, "k1", "{}")
+	status = http.StatusTooManyRequests
+	serveIdempotentRequest(handler, "k1", "{}")
+	status = http.StatusOK
+	rr := serveIdempotentRequest(handler, "k1", "{}")
+
+	assertContent(t, rr.Code, http.StatusOK)
+	assertContent(t, atomic.LoadInt32(&actions), int32(3))
+
+	// Whereas a wrong request is, as it goes wrong whenever it is retried
+	status = http.StatusUnprocessableEntity
+	serveIdempotentRequest(handler, "k3", "{}")
+	status = http.StatusOK
+	rr = serveIdempotentRequest(handler, "k3", "{}")
+
+	assertContent(t, rr.Code, http.StatusUnprocessableEntity)
+	assertContent(t, rr.Header().Get(IdempotencyReplayedHeader), "true")
+	assertContent(t, atomic.LoadInt32(&actions), int32(4))
+
+	// Past its retention window, a key is good as new
+	handler = NewIdempotencyGuard(repository, -time.Second).Guard(endpoint)
+	serveIdempotentRequest(handler, "k2", "{}")
+	rr = serveIdempotentRequest(handler, "k2", `{"another":"request"}`)
+
+	assertContent(t, rr.Code, http.StatusOK)
+	assertContent(t, rr.Header().Get(IdempotencyReplayedHeader), "")
+	assertContent(t, atomic.LoadInt32(&actions), int32(6))
+}
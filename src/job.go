@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// JobStatusPending stands for a job waiting for a worker
+	JobStatusPending = "pending"
+
+	// JobStatusRunning stands for a job being worked on, or interrupted by a restart and about to be resumed
+	JobStatusRunning = "running"
+
+	// JobStatusCompleted stands for a job which went through every destination, whether they all succeeded or not
+	JobStatusCompleted = "completed"
+
+	// JobStatusCanceled stands for a job canceled before it went through every destination
+	JobStatusCanceled = "canceled"
+
+	// JobStatusFailed stands for a job which couldn't go on at all
+	JobStatusFailed = "failed"
+)
+
+// IsFinishedJobStatus tells whether a job in a given status is done for good
+func IsFinishedJobStatus(status string) bool {
+	return status == JobStatusCompleted || status == JobStatusCanceled || status == JobStatusFailed
+}
+
+// ErrJobNotFound is returned when a job doesn't exist in database
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrJobFinished is returned when a job can no longer be changed, e.g. canceling a completed one
+var ErrJobFinished = errors.New("job is already finished")
+
+// BroadcastJob is the persistent record of a broadcast running in the background, as well as its progress. It is
+// owned by the service node which took it, which is the one to resume it after a restart
+type BroadcastJob struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	TenantID     string     `json:"tenantID,omitempty" gorm:"not null"`
+	NodeID       string     `json:"-" gorm:"not null"`
+	EventID      string     `json:"eventID,omitempty" gorm:"not null"`
+	SourceID     string     `json:"sourceID,omitempty" gorm:"not null"`
+	Destinations string     `json:"-" gorm:"not null"`
+	Type         string     `json:"type,omitempty" gorm:"column:event_type;not null;default:''"`
+	Category     string     `json:"category,omitempty" gorm:"not null;default:''"`
+	Topic        string     `json:"topic,omitempty" gorm:"not null;default:''"`
+	Data         JSONData   `json:"-" gorm:"not null;size:65536"`
+	Status       string     `json:"status" gorm:"not null"`
+	Total        int        `json:"total"`
+	Persisted    int        `json:"persisted"`
+	Delivered    int        `json:"delivered"`
+	Failed       int        `json:"failed"`
+	Suppressed   int        `json:"suppressed"`
+	LastError    string     `json:"lastError,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt,omitempty"`
+	UpdatedAt    time.Time  `json:"updatedAt,omitempty"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"`
+	DeliverAt    *time.Time `json:"deliverAt,omitempty"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	Priority     Priority   `json:"priority,omitempty" gorm:"not null;default:0"`
+}
+
+// NewBroadcastJob creates a new, pending job for a given broadcast event
+func NewBroadcastJob(nid string, broadcastEvent BroadcastEvent) (*BroadcastJob, error) {
+	destinations, err := json.Marshal(broadcastEvent.Destinations)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every chunk shares the same event ID, so that the job can be rescheduled or canceled as a whole when scheduled,
+	// and so that a resumed job can tell which destinations it got to already
+	deliverAt := scheduledTime(broadcastEvent.DeliverAt)
+	if broadcastEvent.ID == "" {
+		broadcastEvent.ID = uuid.New().String()
+	}
+
+	job := &BroadcastJob{
+		TenantID:     broadcastEvent.TenantID,
+		NodeID:       nid,
+		EventID:      broadcastEvent.ID,
+		SourceID:     broadcastEvent.SourceID,
+		Destinations: string(destinations),
+		Type:         broadcastEvent.Type,
+		Category:     broadcastEvent.Category,
+		Topic:        broadcastEvent.Topic,
+		Data:         broadcastEvent.Data,
+		Status:       JobStatusPending,
+		Total:        len(broadcastEvent.Destinations),
+		DeliverAt:    deliverAt,
+		Priority:     broadcastEvent.Priority,
+
+		// A time to live runs from when the job is started, not from when it gets to each destination
+		ExpiresAt: expiryTime(broadcastEvent.ExpiresAt, broadcastEvent.TTL, deliverAt),
+	}
+
+	return job, nil
+}
+
+// BroadcastEvent the job stands for
+func (job BroadcastJob) BroadcastEvent() (BroadcastEvent, error) {
+	var destinations []string
+	err := json.Unmarshal([]byte(job.Destinations), &destinations)
+	if err != nil {
+		return BroadcastEvent{}, err
+	}
+
+	broadcastEvent := BroadcastEvent{
+		TenantID:     job.TenantID,
+		ID:           job.EventID,
+		SourceID:     job.SourceID,
+		Destinations: destinations,
+		Mode:         BroadcastModePartial,
+		Type:         job.Type,
+		Category:     job.Category,
+		Topic:        job.Topic,
+		Data:         job.Data,
+		DeliverAt:    job.DeliverAt,
+		ExpiresAt:    job.ExpiresAt,
+		Priority:     job.Priority,
+	}
+
+	return broadcastEvent, nil
+}
+
+// BroadcastJobRepository is the interface to broadcast job datastore. Every operation is bound to one tenant, the
+// default one unless it was scoped by ForTenant, but GetUnfinished
+type BroadcastJobRepository interface {
+	ForTenant(tenantID string) BroadcastJobRepository
+	Add(ctx context.Context, job *BroadcastJob) error
+	Get(ctx context.Context, id uint) (BroadcastJob, error)
+	UpdateProgress(ctx context.Context, job *BroadcastJob) error
+	Transition(ctx context.Context, id uint, from []string, to string) (bool, error)
+	GetUnfinished(ctx context.Context, nodeID string) ([]BroadcastJob, error)
+}
+
+// JobSettings tells how many workers run broadcast jobs, how many destinations they handle at a time (i.e. between
+// progress updates and cancellation checks), and how many jobs may wait for a worker
+type JobSettings struct {
+	Workers   int
+	ChunkSize int
+	QueueSize int
+}
+
+// JobRunner runs broadcast jobs in a pool of workers, on top of the Broker: notifications go through its
+// persistence pipeline and are delivered live as usual
+type JobRunner struct {
+	nid        string
+	broker     *Broker
+	repository BroadcastJobRepository
+	settings   JobSettings
+	queue      chan BroadcastJob
+	stopping   chan struct{}
+	workers    sync.WaitGroup
+}
+
+// NewJobRunner creates a new JobRunner for the service node
+func NewJobRunner(nid string, broker *Broker, repository BroadcastJobRepository, settings JobSettings) *JobRunner {
+	if settings.Workers < 1 {
+		settings.Workers = 1
+	}
+	if settings.ChunkSize < 1 {
+		settings.ChunkSize = 1
+	}
+
+	runner := &JobRunner{
+		nid:        nid,
+		broker:     broker,
+		repository: repository,
+		settings:   settings,
+		queue:      make(chan BroadcastJob, settings.QueueSize),
+		stopping:   make(chan struct{}),
+	}
+
+	return runner
+}
+
+// Run starts the workers, and resumes whatever jobs of the service node were left unfinished
+func (runner *JobRunner) Run() error {
+	unfinished, err := runner.repository.GetUnfinished(context.Background(), runner.nid)
+	if err != nil {
+		return fmt.Errorf("failed to get unfinished jobs due to: %s", err)
+	}
+
+	for i := 0; i < runner.settings.Workers; i++ {
+		runner.workers.Add(1)
+		go func() {
+			defer runner.workers.Done()
+			for {
+				select {
+				case job := <-runner.queue:
+					runner.process(job)
+				case <-runner.stopping:
+					return
+				}
+			}
+		}()
+	}
+
+	if len(unfinished) > 0 {
+		log.Printf("Resuming %d unfinished jobs", len(unfinished))
+
+		// There may be more of them than the queue holds
+		go func() {
+			for _, job := range unfinished {
+				select {
+				case runner.queue <- job:
+				case <-runner.stopping:
+					return
+				}
+			}
+		}()
+	}
+
+	return nil
+}
+
+// Stop waits for every worker to finish the chunk at hand. Jobs left unfinished are resumed on the next run
+func (runner *JobRunner) Stop() {
+	close(runner.stopping)
+	runner.workers.Wait()
+}
+
+// StartBroadcast persists a job for a broadcast event and queues it, returning right away
+func (runner *JobRunner) StartBroadcast(ctx context.Context, broadcastEvent BroadcastEvent) (BroadcastJob, error) {
+	job, err := NewBroadcastJob(runner.nid, broadcastEvent)
+	if err != nil {
+		return BroadcastJob{}, err
+	}
+
+	repository := runner.repository.ForTenant(job.TenantID)
+	err = repository.Add(ctx, job)
+	if err != nil {
+		return BroadcastJob{}, err
+	}
+
+	select {
+	case runner.queue <- *job:
+		return *job, nil
+	case <-runner.stopping:
+		err = errors.New("job runner is stopped")
+	case <-ctx.Done():
+		err = fmt.Errorf("job queue is full: %w", ctx.Err())
+	}
+
+	// Nobody is going to work on it
+	job.LastError = err.Error()
+	repository.UpdateProgress(context.Background(), job)
+	repository.Transition(context.Background(), job.ID, []string{JobStatusPending}, JobStatusFailed)
+
+	return BroadcastJob{}, err
+}
+
+// Get a job of a tenant by its ID
+func (runner *JobRunner) Get(ctx context.Context, tenantID string, id uint) (BroadcastJob, error) {
+	return runner.repository.ForTenant(tenantID).Get(ctx, id)
+}
+
+// Cancel a job of a tenant which is not finished yet. Its worker stops at the end of the chunk at hand, so what was
+// already persisted stays so
+func (runner *JobRunner) Cancel(ctx context.Context, tenantID string, id uint) (BroadcastJob, error) {
+	repository := runner.repository.ForTenant(tenantID)
+
+	canceled, err := repository.Transition(ctx, id, []string{JobStatusPending, JobStatusRunning}, JobStatusCanceled)
+	if err != nil {
+		return BroadcastJob{}, err
+	}
+
+	job, err := repository.Get(ctx, id)
+	if err != nil {
+		return BroadcastJob{}, err
+	}
+
+	if !canceled && job.Status != JobStatusCanceled {
+		return job, ErrJobFinished
+	}
+
+	return job, nil
+}
+
+// process a job chunk by chunk from where it was left, updating its progress after each one
+func (runner *JobRunner) process(job BroadcastJob) {
+	ctx := context.Background()
+	repository := runner.repository.ForTenant(job.TenantID)
+
+	started, err := repository.Transition(ctx, job.ID, []string{JobStatusPending, JobStatusRunning}, JobStatusRunning)
+	if err != nil {
+		log.Printf("Failed to start job %d due to: %s", job.ID, err)
+		return
+	}
+	if !started {
+		log.Printf("Job %d was canceled before it started", job.ID)
+		return
+	}
+
+	broadcastEvent, err := job.BroadcastEvent()
+	if err != nil {
+		log.Printf("Failed to read destinations of job %d due to: %s", job.ID, err)
+		job.LastError = err.Error()
+		repository.UpdateProgress(ctx, &job)
+		repository.Transition(ctx, job.ID, []string{JobStatusRunning}, JobStatusFailed)
+		return
+	}
+	destinations := broadcastEvent.Destinations
+
+	// A job which was running already was interrupted, possibly after its last chunk was persisted but before its
+	// progress was
+	resuming := job.Status == JobStatusRunning
+
+	// Destinations are handled in order, so whatever was persisted, failed or suppressed is behind
+	for next := job.Persisted + job.Failed + job.Suppressed; next < len(destinations); next += runner.settings.ChunkSize {
+		select {
+		case <-runner.stopping:
+			log.Printf("Job %d is interrupted at %d of %d destinations", job.ID, next, len(destinations))
+			return
+		default:
+		}
+
+		// Canceling may happen on any service node, so it is the database which tells
+		current, err := repository.Get(ctx, job.ID)
+		if err != nil {
+			log.Printf("Failed to check on job %d due to: %s", job.ID, err)
+			return
+		}
+		if current.Status != JobStatusRunning {
+			log.Printf("Job %d is %s at %d of %d destinations", job.ID, current.Status, next, len(destinations))
+			return
+		}
+
+		end := next + runner.settings.ChunkSize
+		if end > len(destinations) {
+			end = len(destinations)
+		}
+
+		chunk := broadcastEvent
+		chunk.Destinations = destinations[next:end]
+
+		if resuming {
+			resuming = false
+			chunk.Destinations, err = runner.leaveOutNotified(ctx, &job, chunk)
+			if err != nil {
+				log.Printf("Failed to resume job %d due to: %s", job.ID, err)
+				return
+			}
+		}
+
+		deliveries := make(chan bool, len(chunk.Destinations))
+		results := runner.broker.broadcastEventTracked(ctx, chunk, deliveries)
+
+		persisted, suppressed := 0, 0
+		for _, result := range results {
+			if result.Err != nil {
+				job.Failed++
+				job.LastError = result.Err.Error()
+				continue
+			}
+			if result.Notification.suppresses(ChannelInbox) {
+				suppressed++
+				continue
+			}
+			persisted++
+		}
+		job.Persisted += persisted
+		job.Suppressed += suppressed
+
+		// Every notification persisted, or kept out of the inbox of its destination, is reported once the message
+		// exchange gets to it
+	waiting:
+		for i := 0; i < persisted+suppressed; i++ {
+			select {
+			case live := <-deliveries:
+				if live {
+					job.Delivered++
+				}
+			case <-runner.stopping:
+				break waiting
+			}
+		}
+
+		err = repository.UpdateProgress(ctx, &job)
+		if err != nil {
+			log.Printf("Failed to update progress of job %d due to: %s", job.ID, err)
+		}
+	}
+
+	_, err = repository.Transition(ctx, job.ID, []string{JobStatusRunning}, JobStatusCompleted)
+	if err != nil {
+		log.Printf("Failed to complete job %d due to: %s", job.ID, err)
+		return
+	}
+
+	log.Printf("Job %d is completed: %d persisted, %d delivered live, %d failed, %d suppressed", job.ID, job.Persisted, job.Delivered, job.Failed, job.Suppressed)
+}
+
+// leaveOutNotified the destinations of a chunk which already have a notification of the job's event, counting them as
+// persisted, so that resuming a job never notifies anyone twice
+func (runner *JobRunner) leaveOutNotified(ctx context.Context, job *BroadcastJob, chunk BroadcastEvent) ([]string, error) {
+	// Jobs created before every job had an event ID can't tell
+	if chunk.ID == "" {
+		return chunk.Destinations, nil
+	}
+
+	notified, err := runner.broker.repository.ForTenant(job.TenantID).Notified(ctx, chunk.ID, chunk.Destinations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notified destinations due to: %s", err)
+	}
+
+	skipped := map[string]bool{}
+	for _, destinationID := range notified {
+		skipped[destinationID] = true
+	}
+
+	destinations := []string{}
+	for _, destinationID := range chunk.Destinations {
+		if skipped[destinationID] {
+			job.Persisted++
+			continue
+		}
+		destinations = append(destinations, destinationID)
+	}
+
+	return destinations, nil
+}
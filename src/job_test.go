@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestJobRepository(t *testing.T) *SQLBroadcastJobRepository {
+	repository, err := NewSQLBroadcastJobRepository(newTestSqliteDatabase(t), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repository
+}
+
+func waitForJob(t *testing.T, repository BroadcastJobRepository, id uint) BroadcastJob {
+	for i := 0; i < 100; i++ {
+		job, err := repository.Get(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if IsFinishedJobStatus(job.Status) {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("job %d did not finish", id)
+	return BroadcastJob{}
+}
+
+func TestJobRunner_ResumesUnfinishedJobsOfItsNode(t *testing.T) {
+	ctx := context.Background()
+	notifications := NewMemoryNotificationRepository()
+	broker := runTestBroker(t, notifications)
+	repository := newTestJobRepository(t)
+
+	// An interrupted job, which was through its first destination already
+	interrupted, err := NewBroadcastJob("JobTest", BroadcastEvent{TenantID: "acme", SourceID: "test", Destinations: []string{"1", "2", "3"}, Data: `"resumed"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	interrupted.Status = JobStatusRunning
+	interrupted.Persisted = 1
+	err = repository.ForTenant("acme").Add(ctx, interrupted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A job of another service node, which is none of this one's business
+	someoneElses, err := NewBroadcastJob("AnotherNode", BroadcastEvent{SourceID: "test", Destinations: []string{"4"}, Data: `"not mine"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repository.Add(ctx, someoneElses)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runner := NewJobRunner("JobTest", broker, repository, JobSettings{Workers: 2, ChunkSize: 1, QueueSize: 10})
+	err = runner.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Stop()
+
+	job := waitForJob(t, repository.ForTenant("acme"), interrupted.ID)
+	assertContent(t, job.Status, JobStatusCompleted)
+	assertContent(t, job.Persisted, 3)
+	assertContent(t, job.Delivered, 0)
+	assertContent(t, job.Failed, 0)
+
+	for destinationID, expected := range map[string]int{"1": 0, "2": 1, "3": 1} {
+		got, err := notifications.ForTenant("acme").GetAll(ctx, destinationID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(got), expected)
+	}
+
+	job, err = repository.Get(ctx, someoneElses.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, job.Status, JobStatusPending)
+}
+
+func TestJobRunner_Cancel(t *testing.T) {
+	ctx := context.Background()
+	repository := newTestJobRepository(t)
+
+	// Nothing runs it, so it stays pending until it is canceled
+	runner := NewJobRunner("JobTest", nil, repository, JobSettings{QueueSize: 10})
+
+	job, err := runner.StartBroadcast(ctx, BroadcastEvent{SourceID: "test", Destinations: []string{"1", "2"}, Data: `"never"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, job.Status, JobStatusPending)
+
+	job, err = runner.Cancel(ctx, DefaultTenantID, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, job.Status, JobStatusCanceled)
+	assertContent(t, job.FinishedAt != nil, true)
+
+	_, err = runner.Cancel(ctx, DefaultTenantID, job.ID)
+	assertContent(t, err, nil)
+
+	_, err = runner.Cancel(ctx, "acme", job.ID)
+	assertContent(t, err, ErrJobNotFound)
+
+	// A worker picking it up by now leaves it alone
+	runner.process(job)
+
+	job, err = runner.Get(ctx, DefaultTenantID, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, job.Status, JobStatusCanceled)
+	assertContent(t, job.Persisted, 0)
+}
+
+func TestJobRunner_ResumesWithoutNotifyingTwice(t *testing.T) {
+	ctx := context.Background()
+	notifications := NewMemoryNotificationRepository()
+	broker := runTestBroker(t, notifications)
+	repository := newTestJobRepository(t)
+
+	crashed, err := NewBroadcastJob("JobTest", BroadcastEvent{TenantID: "acme", SourceID: "test", Destinations: []string{"1", "2", "3"}, Data: `"once"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashed.Status = JobStatusRunning
+	err = repository.ForTenant("acme").Add(ctx, crashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The service node went down right after its first chunk was persisted, before its progress was
+	broadcastEvent, err := crashed.BroadcastEvent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	broadcastEvent.Destinations = broadcastEvent.Destinations[:2]
+	for _, result := range broker.BroadcastEventPartially(ctx, broadcastEvent) {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+	}
+
+	runner := NewJobRunner("JobTest", broker, repository, JobSettings{Workers: 1, ChunkSize: 2, QueueSize: 10})
+	err = runner.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Stop()
+
+	job := waitForJob(t, repository.ForTenant("acme"), crashed.ID)
+	assertContent(t, job.Status, JobStatusCompleted)
+	assertContent(t, job.Persisted, 3)
+	assertContent(t, job.Failed, 0)
+
+	for _, destinationID := range []string{"1", "2", "3"} {
+		got, err := notifications.ForTenant("acme").GetAll(ctx, destinationID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(got), 1)
+	}
+}
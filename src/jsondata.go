@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// JSONData is any JSON value (e.g. an object), kept as its compact text. It goes as is in and out of JSON documents,
+// so that nobody has to decode it twice, and it is stored as JSON where the database has such a type (i.e. jsonb on
+// PostgreSQL). Missing is null
+type JSONData string
+
+// MarshalJSON as is. Whatever is not valid JSON (e.g. text stored before data was JSON) goes as a string
+func (data JSONData) MarshalJSON() ([]byte, error) {
+	if data == "" {
+		return []byte("null"), nil
+	}
+	if !json.Valid([]byte(data)) {
+		return json.Marshal(string(data))
+	}
+	return []byte(data), nil
+}
+
+// UnmarshalJSON any JSON value, compacted
+func (data *JSONData) UnmarshalJSON(text []byte) error {
+	var buffer bytes.Buffer
+	err := json.Compact(&buffer, text)
+	if err != nil {
+		return err
+	}
+
+	if buffer.String() == "null" {
+		*data = ""
+		return nil
+	}
+
+	*data = JSONData(buffer.String())
+	return nil
+}
+
+// Value to store, which is null when missing, since a JSON column doesn't take an empty text
+func (data JSONData) Value() (driver.Value, error) {
+	if data == "" {
+		return "null", nil
+	}
+	return string(data), nil
+}
+
+// Scan what was stored, be it text or bytes (e.g. jsonb)
+func (data *JSONData) Scan(value interface{}) error {
+	var text string
+	switch value := value.(type) {
+	case string:
+		text = value
+	case []byte:
+		text = string(value)
+	case nil:
+	default:
+		return fmt.Errorf("can't scan %T as JSON data", value)
+	}
+
+	if text == "null" {
+		text = ""
+	}
+
+	*data = JSONData(text)
+	return nil
+}
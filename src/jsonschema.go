@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// jsonSchemaURL is where a schema is compiled at, as far as resolving its references goes
+const jsonSchemaURL = "mercurio:///schema.json"
+
+// JSONSchema is a compiled JSON Schema, draft 2020-12 unless its $schema tells otherwise. Schemas must be self
+// contained, i.e. they may only refer to themselves, and annotations (e.g. format) are not asserted
+type JSONSchema struct {
+	schema *jsonschema.Schema
+}
+
+// SchemaViolation tells where a JSON document doesn't match its schema (as a JSON Pointer, e.g. /items/0/name) and
+// why not
+type SchemaViolation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// CompileJSONSchema out of its JSON document
+func CompileJSONSchema(document []byte) (*JSONSchema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("%s is not within the schema", url)
+	}
+
+	err := compiler.AddResource(jsonSchemaURL, bytes.NewReader(document))
+	if err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %s", err)
+	}
+
+	schema, err := compiler.Compile(jsonSchemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("schema is not valid: %s", schemaErrorMessage(err))
+	}
+
+	return &JSONSchema{schema: schema}, nil
+}
+
+// schemaErrorMessage tells what is wrong with a schema, leaving out where the compiler put it
+func schemaErrorMessage(err error) string {
+	var schemaErr *jsonschema.SchemaError
+	if errors.As(err, &schemaErr) && schemaErr.Err != nil {
+		err = schemaErr.Err
+	}
+
+	var validationErr *jsonschema.ValidationError
+	if errors.As(err, &validationErr) {
+		violations := schemaViolations(validationErr)
+		if len(violations) > 0 {
+			return fmt.Sprintf("%s %s", schemaPath(violations[0].Path), violations[0].Message)
+		}
+	}
+
+	return err.Error()
+}
+
+// Validate a JSON document, telling every violation of the schema, if any
+func (schema *JSONSchema) Validate(document []byte) ([]SchemaViolation, error) {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, fmt.Errorf("document is not valid JSON: %s", err)
+	}
+
+	err = schema.schema.Validate(value)
+	if err == nil {
+		return []SchemaViolation{}, nil
+	}
+
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return nil, err
+	}
+
+	return schemaViolations(validationErr), nil
+}
+
+// schemaViolations out of the causes a validation error comes down to, sorted by path
+func schemaViolations(validationErr *jsonschema.ValidationError) []SchemaViolation {
+	violations := []SchemaViolation{}
+
+	var collect func(*jsonschema.ValidationError)
+	collect = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			violations = append(violations, SchemaViolation{Path: e.InstanceLocation, Message: e.Message})
+			return
+		}
+		for _, cause := range e.Causes {
+			collect(cause)
+		}
+	}
+	collect(validationErr)
+
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Path < violations[j].Path
+	})
+
+	return violations
+}
+
+func schemaPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
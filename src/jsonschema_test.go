@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCompileJSONSchema_ShouldRefuseInvalidSchemas(t *testing.T) {
+	for _, schema := range []string{
+		`"object"`,
+		`{"type":"text"}`,
+		`{"$ref":"#/definitions/id"}`,
+		`{"$ref":"file:///etc/hostname"}`,
+		`{"pattern":"("}`,
+		`{"minLength":-1}`,
+		`{"anyOf":[]}`,
+	} {
+		_, err := CompileJSONSchema([]byte(schema))
+		if err == nil {
+			t.Errorf("schema %s should not compile", schema)
+		}
+	}
+
+	// Annotations don't get in the way, and references within the schema go
+	_, err := CompileJSONSchema([]byte(`{"$schema":"http://json-schema.org/draft-07/schema#","title":"Anything","format":"email"}`))
+	assertContent(t, err, nil)
+
+	schema, err := CompileJSONSchema([]byte(`{"$defs":{"id":{"type":"integer"}},"properties":{"orderID":{"$ref":"#/$defs/id"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	violations, err := schema.Validate([]byte(`{"orderID":"42"}`))
+	assertContent(t, err, nil)
+	assertContent(t, fmt.Sprint(violations), "[{/orderID expected integer, but got string}]")
+}
+
+func TestJSONSchema_Validate(t *testing.T) {
+	schema, err := CompileJSONSchema([]byte(`{
+		"type": "object",
+		"required": ["id", "tags"],
+		"additionalProperties": false,
+		"properties": {
+			"id": {"type": "integer", "exclusiveMinimum": 0},
+			"status": {"enum": ["open", "closed"]},
+			"tags": {"type": "array", "maxItems": 2, "uniqueItems": true, "items": {"type": "string", "pattern": "^[a-z]+$"}},
+			"price": {"type": "number", "multipleOf": 0.01},
+			"owner": {"oneOf": [{"type": "null"}, {"type": "string", "minLength": 1}]}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		document   string
+		violations string
+	}{
+		{`{"id":1,"tags":["a","b"],"status":"open","price":9.99,"owner":null}`, "[]"},
+		{`[]`, "[{ expected object, but got array}]"},
+		{`{"id":1.5,"tags":[]}`, "[{/id expected integer, but got number}]"},
+		{`{"tags":["a","a","B"]}`, "[{ missing properties: 'id'} {/tags maximum 2 items required, but found 3 items} {/tags items at index 0 and 1 are equal} {/tags/2 does not match pattern '^[a-z]+$'}]"},
+		{`{"id":0,"tags":[],"status":"pending","color":"red"}`, "[{ additionalProperties 'color' not allowed} {/id must be > 0 but found 0} {/status value must be one of \"open\", \"closed\"}]"},
+		{`{"id":1,"tags":[],"price":1.234,"owner":""}`, "[{/owner expected null, but got string} {/owner length must be >= 1, but got 0} {/price 1.234 not multipleOf 0.01}]"},
+	}
+
+	for _, test := range tests {
+		violations, err := schema.Validate([]byte(test.document))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, fmt.Sprint(violations), test.violations)
+	}
+}
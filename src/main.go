@@ -11,6 +11,15 @@ import (
 func main() {
 	LoadEnvironmentVars()
 
+	// Administrative commands (e.g. `mercurio apikey list`) run and exit right away
+	if len(os.Args) > 1 {
+		err := RunCommand(os.Args[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
 	// Basic underlying setup
 	//
 
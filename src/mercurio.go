@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+
+	"gorm.io/gorm"
 )
 
 // Mercurio is what you thing it is, or not
@@ -14,7 +16,11 @@ type Mercurio struct {
 
 	JWTAuth    JWTAuthMiddleware
 	Broker     *Broker
+	Jobs       *JobRunner
+	Recurring  *RecurringScheduler
+	Purger     *Purger
 	API        NotificationAPI
+	AdminAPI   AdminAPI
 	HTTPServer *http.Server
 }
 
@@ -22,44 +28,168 @@ type Mercurio struct {
 func NewMercurio() (*Mercurio, error) {
 	nid := GetNID()
 
+	database, err := ConnectDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	queryTimeout, err := GetDatabaseQueryTimeout()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database query timeout due to: %s", err)
+	}
+
+	var repository NotificationRepository
+	if GetDatabaseDriver() == DatabaseDriverMemory {
+		repository = NewMemoryNotificationRepository()
+	} else {
+		repository, err = NewSQLNotificationRepository(database, queryTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create notification repository on top of an SQL database due to: %s", err)
+		}
+	}
+
+	broadcasts, err := NewSQLBroadcastRepository(database, queryTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create broadcast repository on top of an SQL database due to: %s", err)
+	}
+
+	jobs, err := NewSQLBroadcastJobRepository(database, queryTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create broadcast job repository on top of an SQL database due to: %s", err)
+	}
+
+	schedules, err := NewSQLRecurringScheduleRepository(database, queryTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recurring schedule repository on top of an SQL database due to: %s", err)
+	}
+
+	topics, err := NewSQLTopicSubscriptionRepository(database, queryTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create topic subscription repository on top of an SQL database due to: %s", err)
+	}
+
+	eventTypes, err := NewSQLEventTypeRepository(database, queryTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create event type repository on top of an SQL database due to: %s", err)
+	}
+
+	templates, err := NewSQLNotificationTemplateRepository(database, queryTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create notification template repository on top of an SQL database due to: %s", err)
+	}
+
+	preferences, err := NewSQLClientPreferenceRepository(database, queryTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client preference repository on top of an SQL database due to: %s", err)
+	}
+
+	clientSettings, err := NewSQLClientSettingsRepository(database, queryTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client settings repository on top of an SQL database due to: %s", err)
+	}
+
+	digests, err := NewSQLClientDigestRepository(database, queryTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client digest repository on top of an SQL database due to: %s", err)
+	}
+
+	idempotencyKeys, err := NewSQLIdempotencyKeyRepository(database, queryTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create idempotency key repository on top of an SQL database due to: %s", err)
+	}
+
+	apiKeys, err := NewSQLAPIKeyRepository(database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API key repository on top of an SQL database due to: %s", err)
+	}
+
+	revocations, err := NewSQLRevocationRepository(database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create revocation repository on top of an SQL database due to: %s", err)
+	}
+
+	audit, err := NewSQLAuditRepository(database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audit repository on top of an SQL database due to: %s", err)
+	}
+
+	tenantSettings, err := GetTenantSettings()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant settings due to: %s", err)
+	}
+
+	tenants := NewTenantRegistry(tenantSettings)
+
 	authPrivateKey, err := GetAuthPrivateKey()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get a private key for JWT Auth Middleware due to: %s", err)
 	}
 
-	jwtAuth, err := NewJWTAuthMiddleware(authPrivateKey)
+	jwtAuth, err := NewJWTAuthMiddleware(authPrivateKey, apiKeys, revocations, tenants)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create JWT Auth Middleware due to: %s", err)
 	}
 
-	databaseFilePath, err := GetDatabaseConnectionString()
+	mqSettings, err := GetMQSettings()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get file path for SQLite database due to: %s", err)
+		return nil, fmt.Errorf("failed to get settings to connect to RabbitMQ server due to: %s", err)
 	}
 
-	database, err := ConnectSqliteDatabase(databaseFilePath, true)
+	persistenceSettings, err := GetPersistenceSettings()
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to SQLite database due to: %s", err)
+		return nil, fmt.Errorf("failed to get persistence settings due to: %s", err)
 	}
 
-	repository, err := NewSQLNotificationRepository(database)
+	schedulerSettings, err := GetSchedulerSettings()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create notification repository on top of an SQLite database due to: %s", err)
+		return nil, fmt.Errorf("failed to get scheduler settings due to: %s", err)
 	}
 
-	mqSettings, err := GetMQSettings()
+	quotaSettings, err := GetQuotaSettings()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get settings to connect to RabbitMQ server due to: %s", err)
+		return nil, fmt.Errorf("failed to get quota settings due to: %s", err)
+	}
+
+	streamSettings, err := GetStreamSettings()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stream settings due to: %s", err)
 	}
 
-	broker, err := NewBroker(nid, repository, mqSettings)
+	broker, err := NewBroker(nid, repository, broadcasts, preferences, clientSettings, digests, mqSettings, persistenceSettings, schedulerSettings, quotaSettings, streamSettings)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Broker due to: %s", err)
 	}
 
-	api := NewNotificationAPI(broker, repository)
+	jobSettings, err := GetJobSettings()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job settings due to: %s", err)
+	}
+
+	jobRunner := NewJobRunner(nid, broker, jobs, jobSettings)
 
-	httpServer, err := NewHTTPServer(jwtAuth, api)
+	recurringScheduler := NewRecurringScheduler(broker, schedules, topics, schedulerSettings)
+
+	purgeSettings, err := GetPurgeSettings()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get purge settings due to: %s", err)
+	}
+
+	purger := NewPurger(repository, purgeSettings)
+
+	idempotencyRetention, err := GetIdempotencyRetention()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotency retention due to: %s", err)
+	}
+
+	idempotency := NewIdempotencyGuard(idempotencyKeys, idempotencyRetention)
+
+	validator := NewEventValidator(eventTypes)
+	renderer := NewTemplateRenderer(templates, GetDefaultLocale())
+
+	api := NewNotificationAPI(broker, repository, broadcasts, jobRunner, schedules, topics, idempotency, audit, validator, renderer, preferences, clientSettings, digests)
+	adminAPI := NewAdminAPI(broker, apiKeys, revocations, audit, eventTypes, templates)
+
+	httpServer, err := NewHTTPServer(jwtAuth, api, adminAPI, tenants)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create the HTTP server due to: %s", err)
 	}
@@ -68,13 +198,84 @@ func NewMercurio() (*Mercurio, error) {
 		NID:        nid,
 		JWTAuth:    jwtAuth,
 		Broker:     broker,
+		Jobs:       jobRunner,
+		Recurring:  recurringScheduler,
+		Purger:     purger,
 		API:        api,
+		AdminAPI:   adminAPI,
 		HTTPServer: httpServer,
 	}
 
 	return mercurio, nil
 }
 
+// ConnectDatabase connects to the database as per settings, i.e. MERCURIO_DB_DRIVER and MERCURIO_DB_CONN, and makes
+// sure its schema is up to date, applying pending migrations when MERCURIO_DB_AUTO_MIGRATE is on
+func ConnectDatabase() (*gorm.DB, error) {
+	database, driver, err := OpenDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := NewMigrator(database, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	// An in-memory database is brand new every time, there is nothing to be careful about
+	if AutoMigrateDatabase() || GetDatabaseDriver() == DatabaseDriverMemory {
+		_, err = migrator.Up()
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate %s database due to: %s", driver, err)
+		}
+	}
+
+	err = migrator.Check()
+	if err != nil {
+		return nil, fmt.Errorf("refused to run against %s database due to: %s", driver, err)
+	}
+
+	return database, nil
+}
+
+// OpenDatabase connects to the database as per settings, i.e. MERCURIO_DB_DRIVER and MERCURIO_DB_CONN, as it is
+func OpenDatabase() (*gorm.DB, string, error) {
+	driver := GetDatabaseDriver()
+
+	// In-memory is nothing but SQLite under the hood, as far as migrations are concerned
+	if driver == DatabaseDriverMemory {
+		database, err := ConnectMemoryDatabase()
+		return database, DatabaseDriverSqlite, err
+	}
+
+	databaseConn, err := GetDatabaseConnectionString()
+	if err != nil {
+		return nil, driver, fmt.Errorf("failed to get connection string for %s database due to: %s", driver, err)
+	}
+
+	poolSettings, err := GetDatabasePoolSettings()
+	if err != nil {
+		return nil, driver, fmt.Errorf("failed to get database pool settings due to: %s", err)
+	}
+
+	var database *gorm.DB
+	switch driver {
+	case DatabaseDriverSqlite:
+		database, err = ConnectSqliteDatabase(databaseConn)
+	case DatabaseDriverPostgres:
+		database, err = ConnectPostgresDatabase(databaseConn, poolSettings)
+	case DatabaseDriverMySQL:
+		database, err = ConnectMySQLDatabase(databaseConn, poolSettings)
+	default:
+		return nil, driver, fmt.Errorf("%s is not a supported database driver", driver)
+	}
+	if err != nil {
+		return nil, driver, fmt.Errorf("failed to connect to %s database due to: %s", driver, err)
+	}
+
+	return database, driver, nil
+}
+
 // Start runs Broker, HTTP server, and everything else
 func (m *Mercurio) Start() error {
 	log.Println("Starting notification service broker")
@@ -83,6 +284,18 @@ func (m *Mercurio) Start() error {
 		return fmt.Errorf("failed running Broker due to: %s", err)
 	}
 
+	log.Println("Starting broadcast job runner")
+	err = m.Jobs.Run()
+	if err != nil {
+		return fmt.Errorf("failed running job runner due to: %s", err)
+	}
+
+	log.Println("Starting recurring scheduler")
+	m.Recurring.Run()
+
+	log.Println("Starting notification purger")
+	m.Purger.Run()
+
 	log.Println("HTTP server listening on", m.HTTPServer.Addr)
 	err = m.HTTPServer.ListenAndServe()
 	if err != nil {
@@ -94,6 +307,16 @@ func (m *Mercurio) Start() error {
 
 // Stop the HTTP server, close MQ channel, and cleans everything before go
 func (m *Mercurio) Stop(ctx context.Context) {
+	log.Println("Stopping notification purger")
+	m.Purger.Stop()
+
+	// Recurring schedules and jobs need the Broker to finish what they are at
+	log.Println("Stopping recurring scheduler")
+	m.Recurring.Stop()
+
+	log.Println("Stopping broadcast job runner")
+	m.Jobs.Stop()
+
 	log.Println("Stopping Broker")
 	m.Broker.Stop()
 
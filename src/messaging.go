@@ -14,6 +14,7 @@ type MessageQueueSettings struct {
 type MessageQueueConnection interface {
 	Close()
 	PublishNotification(notification Notification) error
+	PublishCommand(command BrokerCommand) error
 	ConsumeNotifications() (MessageConsumer, error)
 }
 
@@ -21,3 +22,17 @@ type MessageQueueConnection interface {
 type MessageConsumer interface {
 	IsReady() bool
 }
+
+// BrokerCommandDisconnectClient tells every service node to close the stream sessions of a client
+const BrokerCommandDisconnectClient = "client.disconnect"
+
+// BrokerCommandDisconnectToken tells every service node to close the stream sessions opened with a token
+const BrokerCommandDisconnectToken = "token.disconnect"
+
+// BrokerCommand is a control message exchanged by service nodes through the message-oriented middleware
+type BrokerCommand struct {
+	Name     string `json:"name"`
+	TenantID string `json:"tenantID,omitempty"`
+	ClientID string `json:"clientID,omitempty"`
+	TokenID  string `json:"tokenID,omitempty"`
+}
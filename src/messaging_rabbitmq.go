@@ -8,6 +8,14 @@ import (
 	"github.com/streadway/amqp"
 )
 
+const (
+	// RabbitMQNotificationType is the AMQP message type of notifications
+	RabbitMQNotificationType = "notification"
+
+	// RabbitMQCommandType is the AMQP message type of broker commands
+	RabbitMQCommandType = "command"
+)
+
 // RabbitMQConsumer is a wrapper over a AMQP consumer channel
 type RabbitMQConsumer struct {
 	IncomeMessages <-chan amqp.Delivery
@@ -73,10 +81,11 @@ func NewRabbitMQConnection(nid string, settings MessageQueueSettings) (*RabbitMQ
 		nil,   // arguments
 	)
 
+	// Messages are routed by tenant (i.e. <routing key>.<tenant>), so we bind to all of them
 	err = sch.QueueBind(
-		q.Name,              // queue name
-		settings.RoutingKey, // routing key
-		settings.Topic,      // exchange
+		q.Name,                   // queue name
+		settings.RoutingKey+".#", // routing key
+		settings.Topic,           // exchange
 		false,
 		nil)
 	if err != nil {
@@ -111,14 +120,45 @@ func (mq *RabbitMQConnection) PublishNotification(notification Notification) err
 		return err
 	}
 
+	routingKey := mq.tenantRoutingKey(notification.TenantID)
+
 	err = mq.pubChannel.Publish(
-		mq.topic,      // exchange
-		mq.routingKey, // routing key
-		false,         // mandatory
-		false,         // immediate
+		mq.topic,   // exchange
+		routingKey, // routing key
+		false,      // mandatory
+		false,      // immediate
 		amqp.Publishing{
 			AppId:       mq.nid,
 			MessageId:   fmt.Sprintf("%d", notification.ID),
+			Type:        RabbitMQNotificationType,
+			ContentType: "application/json",
+			Body:        body,
+		})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PublishCommand send a broker command to a RabbitMQ topic with the given routing key, so every service node gets it
+func (mq *RabbitMQConnection) PublishCommand(command BrokerCommand) error {
+	body, err := json.Marshal(command)
+	if err != nil {
+		return err
+	}
+
+	routingKey := mq.tenantRoutingKey(command.TenantID)
+
+	err = mq.pubChannel.Publish(
+		mq.topic,   // exchange
+		routingKey, // routing key
+		false,      // mandatory
+		false,      // immediate
+		amqp.Publishing{
+			AppId:       mq.nid,
+			MessageId:   command.Name,
+			Type:        RabbitMQCommandType,
 			ContentType: "application/json",
 			Body:        body,
 		})
@@ -129,6 +169,13 @@ func (mq *RabbitMQConnection) PublishNotification(notification Notification) err
 	return nil
 }
 
+func (mq *RabbitMQConnection) tenantRoutingKey(tenantID string) string {
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+	return mq.routingKey + "." + tenantID
+}
+
 // ConsumeNotifications gets a MessageConsumer for consuming messages from a RabbitMQ topic
 func (mq *RabbitMQConnection) ConsumeNotifications() (MessageConsumer, error) {
 	msgs, err := mq.subChannel.Consume(
@@ -161,3 +208,14 @@ func UnmarshalNotification(jsonNotification []byte) (Notification, error) {
 
 	return notification, nil
 }
+
+// UnmarshalBrokerCommand decodes a JSON broker command
+func UnmarshalBrokerCommand(jsonCommand []byte) (BrokerCommand, error) {
+	var command BrokerCommand
+	err := json.Unmarshal(jsonCommand, &command)
+	if err != nil {
+		return BrokerCommand{}, err
+	}
+
+	return command, nil
+}
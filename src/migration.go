@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migrations are SQL files named <version>_<name>.<up|down>.sql, one folder per database driver, embedded in the binary
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer Mercurio than this one
+var ErrSchemaTooNew = errors.New("database schema is newer than this Mercurio knows about")
+
+// ErrSchemaOutdated is returned when the database has pending migrations
+var ErrSchemaOutdated = errors.New("database schema has pending migrations, run `mercurio migrate up`")
+
+// Migration is one numbered step of the database schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether a migration was applied to the database and when
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// schemaMigrationsTable is created apart from migrations, since it is what keeps track of them
+const schemaMigrationsTable = "CREATE TABLE IF NOT EXISTS schema_migrations (version integer PRIMARY KEY, name varchar(191) NOT NULL, applied_at %s NOT NULL)"
+
+var schemaMigrationsTimeType = map[string]string{
+	DatabaseDriverSqlite:   "datetime",
+	DatabaseDriverPostgres: "timestamptz",
+	DatabaseDriverMySQL:    "datetime(6)",
+}
+
+// SchemaMigration is the record of an applied migration, on the schema_migrations table
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"not null;size:191"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// LoadMigrations reads the embedded migrations of a database driver, sorted by version
+func LoadMigrations(driver string) ([]Migration, error) {
+	dir := path.Join("migrations", driver)
+	files, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("there are no migrations for %s database", driver)
+	}
+
+	migrations := map[int]*Migration{}
+	for _, file := range files {
+		// e.g. 0001_baseline.up.sql
+		parts := strings.SplitN(strings.TrimSuffix(file.Name(), ".sql"), "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("migration file %s is not named <version>_<name>.<up|down>.sql", file.Name())
+		}
+
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s has no valid version", file.Name())
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, exists := migrations[version]
+		if !exists {
+			migration = &Migration{Version: version}
+			migrations[version] = migration
+		}
+
+		switch {
+		case strings.HasSuffix(parts[1], ".up"):
+			migration.Name = strings.TrimSuffix(parts[1], ".up")
+			migration.Up = string(content)
+		case strings.HasSuffix(parts[1], ".down"):
+			migration.Down = string(content)
+		default:
+			return nil, fmt.Errorf("migration file %s is neither up nor down", file.Name())
+		}
+	}
+
+	sorted := []Migration{}
+	for _, migration := range migrations {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d of %s database must have both up and down files", migration.Version, driver)
+		}
+		sorted = append(sorted, *migration)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	return sorted, nil
+}
+
+// Migrator applies and reverts migrations to a database, keeping track of its version on the schema_migrations table
+type Migrator struct {
+	db         *gorm.DB
+	driver     string
+	migrations []Migration
+}
+
+// NewMigrator creates a new Migrator for the given database and driver
+func NewMigrator(db *gorm.DB, driver string) (*Migrator, error) {
+	migrations, err := LoadMigrations(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	result := db.Exec(fmt.Sprintf(schemaMigrationsTable, schemaMigrationsTimeType[driver]))
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table due to: %s", result.Error)
+	}
+
+	migrator := &Migrator{
+		db:         db,
+		driver:     driver,
+		migrations: migrations,
+	}
+
+	return migrator, nil
+}
+
+// Status of every known migration, and an error if the database has a migration this Mercurio doesn't know about
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []MigrationStatus{}
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Migration: migration}
+		if schemaMigration, exists := applied[migration.Version]; exists {
+			status.AppliedAt = &schemaMigration.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	if len(applied) > 0 {
+		return statuses, ErrSchemaTooNew
+	}
+
+	return statuses, nil
+}
+
+// Check makes sure the database schema is exactly what this Mercurio expects
+func (m *Migrator) Check() error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			return ErrSchemaOutdated
+		}
+	}
+
+	return nil
+}
+
+// Up applies every pending migration, oldest first
+func (m *Migrator) Up() ([]Migration, error) {
+	done := []Migration{}
+
+	err := m.withLock(func() error {
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			if status.AppliedAt != nil {
+				continue
+			}
+
+			err := m.apply(status.Migration, status.Up, func(tx *gorm.DB) error {
+				return tx.Create(&SchemaMigration{Version: status.Version, Name: status.Name, AppliedAt: time.Now()}).Error
+			})
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Migration %04d_%s applied", status.Version, status.Name)
+			done = append(done, status.Migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Down reverts the last applied migration, if any
+func (m *Migrator) Down() (*Migration, error) {
+	var done *Migration
+
+	err := m.withLock(func() error {
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+
+		for i := len(statuses) - 1; i >= 0; i-- {
+			status := statuses[i]
+			if status.AppliedAt == nil {
+				continue
+			}
+
+			err := m.apply(status.Migration, status.Down, func(tx *gorm.DB) error {
+				return tx.Delete(&SchemaMigration{}, status.Version).Error
+			})
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Migration %04d_%s reverted", status.Version, status.Name)
+			done = &status.Migration
+			return nil
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+func (m *Migrator) applied() (map[int]SchemaMigration, error) {
+	var schemaMigrations []SchemaMigration
+	result := m.db.Find(&schemaMigrations)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations table due to: %s", result.Error)
+	}
+
+	applied := map[int]SchemaMigration{}
+	for _, schemaMigration := range schemaMigrations {
+		applied[schemaMigration.Version] = schemaMigration
+	}
+
+	return applied, nil
+}
+
+// apply runs the statements of a migration and its bookkeeping in a single transaction. Keep in mind that MySQL
+// commits DDL statements right away, so a failed migration there may need some manual cleanup
+func (m *Migrator) apply(migration Migration, script string, bookkeeping func(tx *gorm.DB) error) error {
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range splitSQLStatements(script) {
+			result := tx.Exec(statement)
+			if result.Error != nil {
+				return fmt.Errorf("migration %04d_%s failed due to: %s", migration.Version, migration.Name, result.Error)
+			}
+		}
+
+		return bookkeeping(tx)
+	})
+}
+
+// withLock makes sure only one service node at a time migrates a shared database, the others wait for it and
+// then find nothing pending. SQLite doesn't need it since it is not shared anyway
+func (m *Migrator) withLock(fn func() error) error {
+	lock, unlock := "", ""
+	switch m.driver {
+	case DatabaseDriverPostgres:
+		lock = fmt.Sprintf("SELECT pg_advisory_lock(%d)", migrationLockID)
+		unlock = fmt.Sprintf("SELECT pg_advisory_unlock(%d)", migrationLockID)
+	case DatabaseDriverMySQL:
+		lock = fmt.Sprintf("SELECT GET_LOCK('%s', -1)", migrationLockName)
+		unlock = fmt.Sprintf("SELECT RELEASE_LOCK('%s')", migrationLockName)
+	default:
+		return fn()
+	}
+
+	sqlDB, err := m.db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get migration lock due to: %s", err)
+	}
+
+	// Locks are bound to sessions, so the same connection must be held from lock to unlock
+	ctx := context.Background()
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get migration lock due to: %s", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, lock)
+	if err != nil {
+		return fmt.Errorf("failed to get migration lock due to: %s", err)
+	}
+	defer conn.ExecContext(ctx, unlock)
+
+	return fn()
+}
+
+const (
+	migrationLockID   = 7366782
+	migrationLockName = "mercurio_migrations"
+)
+
+// splitSQLStatements breaks a migration script into statements, dropping comments. Statements end with
+// a semicolon at the end of a line
+func splitSQLStatements(script string) []string {
+	statements := []string{}
+	current := strings.Builder{}
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		current.WriteString(line)
+		current.WriteString("\n")
+
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+
+	if strings.TrimSpace(current.String()) != "" {
+		statements = append(statements, strings.TrimSpace(current.String()))
+	}
+
+	return statements
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrator_UpDownAndStatus(t *testing.T) {
+	db, err := ConnectSqliteDatabase(filepath.Join(t.TempDir(), "mercurio-migration.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrator, err := NewMigrator(db, DatabaseDriverSqlite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, migrator.Check(), ErrSchemaOutdated)
+
+	done, err := migrator.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(done), len(migrator.migrations))
+	assertContent(t, migrator.Check(), nil)
+	assertContent(t, db.Migrator().HasTable(&Notification{}), true)
+
+	// Nothing left to apply the second time around
+	done, err = migrator.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(done), 0)
+
+	last, err := migrator.Down()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, last.Version, migrator.migrations[len(migrator.migrations)-1].Version)
+	assertContent(t, migrator.Check(), ErrSchemaOutdated)
+}
+
+func TestMigrator_ShouldRefuseNewerSchema(t *testing.T) {
+	db, err := ConnectSqliteDatabase(filepath.Join(t.TempDir(), "mercurio-migration.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrator, err := NewMigrator(db, DatabaseDriverSqlite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = migrator.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// As if a newer Mercurio had migrated the database
+	db.Create(&SchemaMigration{Version: 9999, Name: "from_the_future"})
+
+	assertContent(t, migrator.Check(), ErrSchemaTooNew)
+}
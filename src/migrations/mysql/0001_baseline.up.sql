@@ -0,0 +1,64 @@
+-- Baseline schema. Tables are created only when missing, so databases set up by the former GORM AutoMigrate
+-- are adopted as they are. Indexes are inline since MySQL has no CREATE INDEX IF NOT EXISTS; string columns
+-- are varchar(191) so InnoDB can index them on utf8mb4, and datetimes keep microseconds
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id bigint unsigned AUTO_INCREMENT PRIMARY KEY,
+    tenant_id varchar(191) NOT NULL,
+    event_id varchar(191) NOT NULL,
+    source_id varchar(191) NOT NULL,
+    destination_id varchar(191) NOT NULL,
+    data mediumtext NOT NULL,
+    created_at datetime(6) NULL,
+    read_at datetime(6) NULL,
+    INDEX idx_notifications_tenant_destination (tenant_id, destination_id),
+    INDEX idx_notifications_status (tenant_id, destination_id, read_at),
+    INDEX idx_notifications_event_id (event_id),
+    INDEX idx_notifications_source_id (source_id),
+    INDEX idx_notifications_destination_id (destination_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigint unsigned AUTO_INCREMENT PRIMARY KEY,
+    tenant_id varchar(191) NOT NULL,
+    name varchar(191) NOT NULL,
+    lookup_id varchar(191) NOT NULL,
+    hash varchar(191) NOT NULL,
+    scopes varchar(191) NOT NULL,
+    source_id varchar(191) NOT NULL,
+    created_at datetime(6) NULL,
+    last_used_at datetime(6) NULL,
+    revoked_at datetime(6) NULL,
+    UNIQUE INDEX idx_api_keys_lookup_id (lookup_id),
+    INDEX idx_api_keys_tenant_id (tenant_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS revocations (
+    id bigint unsigned AUTO_INCREMENT PRIMARY KEY,
+    tenant_id varchar(191) NOT NULL,
+    jti varchar(191) NULL,
+    subject varchar(191) NULL,
+    reason varchar(191) NULL,
+    created_at datetime(6) NULL,
+    expires_at datetime(6) NOT NULL,
+    INDEX idx_revocations_tenant_id (tenant_id),
+    INDEX idx_revocations_jti (jti),
+    INDEX idx_revocations_subject (subject),
+    INDEX idx_revocations_expires_at (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS audit_entries (
+    id bigint unsigned AUTO_INCREMENT PRIMARY KEY,
+    tenant_id varchar(191) NOT NULL,
+    action varchar(191) NOT NULL,
+    actor_type varchar(191) NOT NULL,
+    actor_id varchar(191) NOT NULL,
+    source_ip varchar(191) NULL,
+    target varchar(191) NULL,
+    details mediumtext NULL,
+    created_at datetime(6) NULL,
+    INDEX idx_audit_entries_tenant_id (tenant_id),
+    INDEX idx_audit_entries_action (action),
+    INDEX idx_audit_entries_actor_id (actor_id),
+    INDEX idx_audit_entries_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
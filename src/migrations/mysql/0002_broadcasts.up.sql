@@ -0,0 +1,24 @@
+-- Broadcasts to a whole tenant are stored once; receipts keep per-client read state, created only when a client
+-- reads or dismisses a broadcast
+
+CREATE TABLE broadcasts (
+    id bigint unsigned AUTO_INCREMENT PRIMARY KEY,
+    tenant_id varchar(191) NOT NULL,
+    event_id varchar(191) NOT NULL,
+    source_id varchar(191) NOT NULL,
+    data mediumtext NOT NULL,
+    created_at datetime(6) NULL,
+    INDEX idx_broadcasts_tenant_id (tenant_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE broadcast_receipts (
+    id bigint unsigned AUTO_INCREMENT PRIMARY KEY,
+    tenant_id varchar(191) NOT NULL,
+    broadcast_id bigint unsigned NOT NULL,
+    client_id varchar(191) NOT NULL,
+    read_at datetime(6) NULL,
+    dismissed_at datetime(6) NULL,
+    created_at datetime(6) NULL,
+    UNIQUE INDEX idx_broadcast_receipts_broadcast_client (broadcast_id, client_id),
+    CONSTRAINT fk_broadcast_receipts_broadcast FOREIGN KEY (broadcast_id) REFERENCES broadcasts (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
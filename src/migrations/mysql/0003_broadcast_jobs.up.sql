@@ -0,0 +1,22 @@
+-- Broadcasts to many destinations may run in the background as jobs, which keep their own progress so they can be
+-- resumed by the service node that owns them after a restart
+
+CREATE TABLE broadcast_jobs (
+    id bigint unsigned AUTO_INCREMENT PRIMARY KEY,
+    tenant_id varchar(191) NOT NULL,
+    node_id varchar(191) NOT NULL,
+    event_id varchar(191) NOT NULL,
+    source_id varchar(191) NOT NULL,
+    destinations longtext NOT NULL,
+    data mediumtext NOT NULL,
+    status varchar(32) NOT NULL,
+    total bigint NOT NULL DEFAULT 0,
+    persisted bigint NOT NULL DEFAULT 0,
+    delivered bigint NOT NULL DEFAULT 0,
+    failed bigint NOT NULL DEFAULT 0,
+    last_error text NOT NULL,
+    created_at datetime(6) NULL,
+    updated_at datetime(6) NULL,
+    finished_at datetime(6) NULL,
+    INDEX idx_broadcast_jobs_node_status (node_id, status)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,17 @@
+-- Idempotency keys of publishers, along with the response to give back when a request is retried. The unique index
+-- is what makes a key hold across service nodes sharing the database
+
+CREATE TABLE idempotency_keys (
+    id bigint unsigned AUTO_INCREMENT PRIMARY KEY,
+    tenant_id varchar(191) NOT NULL,
+    scope varchar(191) NOT NULL,
+    `key` varchar(191) NOT NULL,
+    request_hash varchar(64) NOT NULL,
+    status_code int NOT NULL DEFAULT 0,
+    content_type varchar(191) NOT NULL DEFAULT '',
+    response mediumtext NOT NULL,
+    created_at datetime(6) NULL,
+    expires_at datetime(6) NOT NULL,
+    UNIQUE INDEX idx_idempotency_keys_tenant_scope_key (tenant_id, scope, `key`),
+    INDEX idx_idempotency_keys_expires_at (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,3 @@
+DROP INDEX idx_notifications_collapse_key ON notifications;
+
+ALTER TABLE notifications DROP COLUMN collapse_key;
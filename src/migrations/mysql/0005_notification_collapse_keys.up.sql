@@ -0,0 +1,5 @@
+-- Notifications with a collapse key are updated in place by newer events of the same key, as long as they are unread
+
+ALTER TABLE notifications ADD COLUMN collapse_key varchar(191) NOT NULL DEFAULT '';
+
+CREATE INDEX idx_notifications_collapse_key ON notifications (tenant_id, destination_id, collapse_key);
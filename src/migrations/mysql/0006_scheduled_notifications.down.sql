@@ -0,0 +1,5 @@
+ALTER TABLE broadcast_jobs DROP COLUMN deliver_at;
+
+DROP INDEX idx_notifications_deliver_at ON notifications;
+
+ALTER TABLE notifications DROP COLUMN deliver_at;
@@ -0,0 +1,33 @@
+-- Recurring schedules of publishers, each one materializing a notification (or a broadcast to an audience or to the
+-- subscribers of a topic) every time its cron expression fires. The revision is what makes exactly one service node
+-- fire each time. Clients subscribe to topics on their own
+
+CREATE TABLE recurring_schedules (
+    id bigint unsigned AUTO_INCREMENT PRIMARY KEY,
+    tenant_id varchar(191) NOT NULL,
+    source_id varchar(191) NOT NULL,
+    cron varchar(191) NOT NULL,
+    time_zone varchar(64) NOT NULL,
+    destination_id varchar(191) NOT NULL DEFAULT '',
+    audience varchar(32) NOT NULL DEFAULT '',
+    topic varchar(191) NOT NULL DEFAULT '',
+    data mediumtext NOT NULL,
+    misfire_policy varchar(32) NOT NULL,
+    next_fire_at datetime(6) NULL,
+    last_fired_at datetime(6) NULL,
+    revision bigint NOT NULL DEFAULT 0,
+    created_at datetime(6) NULL,
+    updated_at datetime(6) NULL,
+    INDEX idx_recurring_schedules_tenant_source (tenant_id, source_id),
+    INDEX idx_recurring_schedules_next_fire_at (next_fire_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE topic_subscriptions (
+    id bigint unsigned AUTO_INCREMENT PRIMARY KEY,
+    tenant_id varchar(191) NOT NULL,
+    client_id varchar(191) NOT NULL,
+    topic varchar(191) NOT NULL,
+    created_at datetime(6) NULL,
+    UNIQUE INDEX idx_topic_subscriptions_tenant_topic_client (tenant_id, topic, client_id),
+    INDEX idx_topic_subscriptions_tenant_client (tenant_id, client_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,6 @@
+ALTER TABLE broadcast_jobs DROP COLUMN expires_at;
+
+DROP INDEX idx_notifications_read_at ON notifications;
+DROP INDEX idx_notifications_expires_at ON notifications;
+
+ALTER TABLE notifications DROP COLUMN expires_at;
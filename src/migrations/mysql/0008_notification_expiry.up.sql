@@ -0,0 +1,9 @@
+-- Notifications may expire: past expires_at they are out of sight, never delivered, and deleted by the purger along
+-- with whatever is past the retention policy (e.g. read long ago)
+
+ALTER TABLE notifications ADD COLUMN expires_at datetime(6) NULL;
+
+CREATE INDEX idx_notifications_expires_at ON notifications (expires_at);
+CREATE INDEX idx_notifications_read_at ON notifications (read_at);
+
+ALTER TABLE broadcast_jobs ADD COLUMN expires_at datetime(6) NULL;
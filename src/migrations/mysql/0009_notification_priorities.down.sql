@@ -0,0 +1,3 @@
+ALTER TABLE broadcast_jobs DROP COLUMN priority;
+
+ALTER TABLE notifications DROP COLUMN priority;
@@ -0,0 +1,6 @@
+-- Notifications have a priority, from low (-1) to urgent (2), normal (0) being the default: higher ones go first to
+-- a client stream, and low ones are held back from an overloaded one
+
+ALTER TABLE notifications ADD COLUMN priority smallint NOT NULL DEFAULT 0;
+
+ALTER TABLE broadcast_jobs ADD COLUMN priority smallint NOT NULL DEFAULT 0;
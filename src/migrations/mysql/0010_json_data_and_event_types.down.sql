@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS event_types;
+
+ALTER TABLE broadcast_jobs DROP COLUMN event_type;
+ALTER TABLE broadcasts DROP COLUMN event_type;
+ALTER TABLE notifications DROP COLUMN event_type;
+
+ALTER TABLE recurring_schedules MODIFY data mediumtext NOT NULL;
+UPDATE recurring_schedules SET data = JSON_UNQUOTE(data) WHERE JSON_TYPE(data) = 'STRING';
+
+ALTER TABLE broadcast_jobs MODIFY data mediumtext NOT NULL;
+UPDATE broadcast_jobs SET data = JSON_UNQUOTE(data) WHERE JSON_TYPE(data) = 'STRING';
+
+ALTER TABLE broadcasts MODIFY data mediumtext NOT NULL;
+UPDATE broadcasts SET data = JSON_UNQUOTE(data) WHERE JSON_TYPE(data) = 'STRING';
+
+ALTER TABLE notifications MODIFY data mediumtext NOT NULL;
+UPDATE notifications SET data = JSON_UNQUOTE(data) WHERE JSON_TYPE(data) = 'STRING';
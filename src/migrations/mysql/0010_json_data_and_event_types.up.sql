@@ -0,0 +1,29 @@
+-- Event data is any JSON value, rather than a string, so whatever was stored so far becomes a JSON string. Events may
+-- be of a type, which may carry a JSON Schema their data must match
+
+UPDATE notifications SET data = JSON_QUOTE(data);
+ALTER TABLE notifications MODIFY data json NOT NULL;
+
+UPDATE broadcasts SET data = JSON_QUOTE(data);
+ALTER TABLE broadcasts MODIFY data json NOT NULL;
+
+UPDATE broadcast_jobs SET data = JSON_QUOTE(data);
+ALTER TABLE broadcast_jobs MODIFY data json NOT NULL;
+
+UPDATE recurring_schedules SET data = JSON_QUOTE(data);
+ALTER TABLE recurring_schedules MODIFY data json NOT NULL;
+
+ALTER TABLE notifications ADD COLUMN event_type varchar(191) NOT NULL DEFAULT '';
+ALTER TABLE broadcasts ADD COLUMN event_type varchar(191) NOT NULL DEFAULT '';
+ALTER TABLE broadcast_jobs ADD COLUMN event_type varchar(191) NOT NULL DEFAULT '';
+
+CREATE TABLE event_types (
+    id bigint unsigned AUTO_INCREMENT PRIMARY KEY,
+    tenant_id varchar(191) NOT NULL,
+    name varchar(191) NOT NULL,
+    description varchar(1024) NOT NULL DEFAULT '',
+    json_schema json NOT NULL,
+    created_at datetime(6) NULL,
+    updated_at datetime(6) NULL,
+    UNIQUE INDEX idx_event_types_tenant_name (tenant_id, name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,14 @@
+-- Templates which render notifications of an event type for people to read, one per locale
+
+CREATE TABLE notification_templates (
+    id bigint unsigned AUTO_INCREMENT PRIMARY KEY,
+    tenant_id varchar(191) NOT NULL,
+    event_type varchar(191) NOT NULL,
+    locale varchar(35) NOT NULL,
+    title text NOT NULL,
+    body text NOT NULL,
+    action_url text NOT NULL,
+    created_at datetime(6) NULL,
+    updated_at datetime(6) NULL,
+    UNIQUE INDEX idx_notification_templates_tenant_type_locale (tenant_id, event_type, locale)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
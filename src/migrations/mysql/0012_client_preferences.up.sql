@@ -0,0 +1,23 @@
+-- Events may be of a category and a topic, which (along with their source) clients set preferences by, e.g. to mute
+-- them. Broadcast jobs count destinations whose preferences kept the notification out of their inbox
+
+ALTER TABLE notifications ADD COLUMN category varchar(191) NOT NULL DEFAULT '';
+ALTER TABLE notifications ADD COLUMN topic varchar(191) NOT NULL DEFAULT '';
+ALTER TABLE broadcast_jobs ADD COLUMN category varchar(191) NOT NULL DEFAULT '';
+ALTER TABLE broadcast_jobs ADD COLUMN topic varchar(191) NOT NULL DEFAULT '';
+ALTER TABLE broadcast_jobs ADD COLUMN suppressed int NOT NULL DEFAULT 0;
+
+CREATE TABLE client_preferences (
+    id bigint unsigned AUTO_INCREMENT PRIMARY KEY,
+    tenant_id varchar(191) NOT NULL,
+    client_id varchar(191) NOT NULL,
+    scope varchar(32) NOT NULL,
+    match_key varchar(191) NOT NULL,
+    stream boolean NOT NULL DEFAULT true,
+    inbox boolean NOT NULL DEFAULT true,
+    email boolean NOT NULL DEFAULT true,
+    webhook boolean NOT NULL DEFAULT true,
+    created_at datetime(6) NULL,
+    updated_at datetime(6) NULL,
+    UNIQUE INDEX idx_client_preferences_tenant_client_scope_key (tenant_id, client_id, scope, match_key)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS client_settings;
+
+DROP INDEX idx_notifications_held_until ON notifications;
+
+ALTER TABLE notifications DROP COLUMN held_until;
@@ -0,0 +1,20 @@
+-- Clients may have quiet hours, or snooze everything for a while: notifications are persisted but held back from live
+-- streams until held_until, which is cleared by the one service node releasing them
+
+ALTER TABLE notifications ADD COLUMN held_until datetime(6) NULL;
+
+CREATE INDEX idx_notifications_held_until ON notifications (held_until);
+
+CREATE TABLE client_settings (
+    id bigint unsigned AUTO_INCREMENT PRIMARY KEY,
+    tenant_id varchar(191) NOT NULL,
+    client_id varchar(191) NOT NULL,
+    time_zone varchar(64) NOT NULL,
+    quiet_start varchar(5) NOT NULL,
+    quiet_end varchar(5) NOT NULL,
+    quiet_release varchar(32) NOT NULL,
+    snoozed_until datetime(6) NULL,
+    created_at datetime(6) NULL,
+    updated_at datetime(6) NULL,
+    UNIQUE INDEX idx_client_settings_tenant_client (tenant_id, client_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
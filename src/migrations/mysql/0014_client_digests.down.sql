@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS client_digests;
+
+DROP INDEX idx_notifications_digest_at ON notifications;
+
+ALTER TABLE notifications DROP COLUMN digest_at;
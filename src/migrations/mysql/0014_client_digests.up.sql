@@ -0,0 +1,17 @@
+-- Clients may get notifications of a category in digests: they are persisted but kept from live streams until
+-- digest_at, when the one service node which clears it sums them up in a single notification
+
+ALTER TABLE notifications ADD COLUMN digest_at datetime(6) NULL;
+
+CREATE INDEX idx_notifications_digest_at ON notifications (digest_at);
+
+CREATE TABLE client_digests (
+    id bigint unsigned AUTO_INCREMENT PRIMARY KEY,
+    tenant_id varchar(191) NOT NULL,
+    client_id varchar(191) NOT NULL,
+    category varchar(191) NOT NULL,
+    interval_seconds int NOT NULL,
+    created_at datetime(6) NULL,
+    updated_at datetime(6) NULL,
+    UNIQUE INDEX idx_client_digests_tenant_client_category (tenant_id, client_id, category)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
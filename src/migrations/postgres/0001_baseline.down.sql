@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS audit_entries;
+DROP TABLE IF EXISTS revocations;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS notifications;
@@ -0,0 +1,70 @@
+-- Baseline schema. Tables and indexes are created only when missing, so databases set up by the former
+-- GORM AutoMigrate are adopted as they are
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id bigserial PRIMARY KEY,
+    tenant_id text NOT NULL,
+    event_id text NOT NULL,
+    source_id text NOT NULL,
+    destination_id text NOT NULL,
+    data text NOT NULL,
+    created_at timestamptz,
+    read_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_tenant_destination ON notifications (tenant_id, destination_id);
+CREATE INDEX IF NOT EXISTS idx_notifications_event_id ON notifications (event_id);
+CREATE INDEX IF NOT EXISTS idx_notifications_source_id ON notifications (source_id);
+CREATE INDEX IF NOT EXISTS idx_notifications_destination_id ON notifications (destination_id);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY,
+    tenant_id text NOT NULL,
+    name text NOT NULL,
+    lookup_id text NOT NULL,
+    hash text NOT NULL,
+    scopes text NOT NULL,
+    source_id text NOT NULL,
+    created_at timestamptz,
+    last_used_at timestamptz,
+    revoked_at timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_lookup_id ON api_keys (lookup_id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_tenant_id ON api_keys (tenant_id);
+
+CREATE TABLE IF NOT EXISTS revocations (
+    id bigserial PRIMARY KEY,
+    tenant_id text NOT NULL,
+    jti text,
+    subject text,
+    reason text,
+    created_at timestamptz,
+    expires_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_revocations_tenant_id ON revocations (tenant_id);
+CREATE INDEX IF NOT EXISTS idx_revocations_jti ON revocations (jti);
+CREATE INDEX IF NOT EXISTS idx_revocations_subject ON revocations (subject);
+CREATE INDEX IF NOT EXISTS idx_revocations_expires_at ON revocations (expires_at);
+
+CREATE TABLE IF NOT EXISTS audit_entries (
+    id bigserial PRIMARY KEY,
+    tenant_id text NOT NULL,
+    action text NOT NULL,
+    actor_type text NOT NULL,
+    actor_id text NOT NULL,
+    source_ip text,
+    target text,
+    details text,
+    created_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_entries_tenant_id ON audit_entries (tenant_id);
+CREATE INDEX IF NOT EXISTS idx_audit_entries_action ON audit_entries (action);
+CREATE INDEX IF NOT EXISTS idx_audit_entries_actor_id ON audit_entries (actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_entries_created_at ON audit_entries (created_at);
+
+-- Partial indexes for the status queries of a destination, unread ones are by far the most frequent
+CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications (tenant_id, destination_id) WHERE read_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_notifications_read ON notifications (tenant_id, destination_id, read_at) WHERE read_at IS NOT NULL;
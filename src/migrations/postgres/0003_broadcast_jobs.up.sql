@@ -0,0 +1,23 @@
+-- Broadcasts to many destinations may run in the background as jobs, which keep their own progress so they can be
+-- resumed by the service node that owns them after a restart
+
+CREATE TABLE broadcast_jobs (
+    id bigserial PRIMARY KEY,
+    tenant_id text NOT NULL,
+    node_id text NOT NULL,
+    event_id text NOT NULL,
+    source_id text NOT NULL,
+    destinations text NOT NULL,
+    data text NOT NULL,
+    status text NOT NULL,
+    total bigint NOT NULL DEFAULT 0,
+    persisted bigint NOT NULL DEFAULT 0,
+    delivered bigint NOT NULL DEFAULT 0,
+    failed bigint NOT NULL DEFAULT 0,
+    last_error text NOT NULL DEFAULT '',
+    created_at timestamptz,
+    updated_at timestamptz,
+    finished_at timestamptz
+);
+
+CREATE INDEX idx_broadcast_jobs_node_status ON broadcast_jobs (node_id, status);
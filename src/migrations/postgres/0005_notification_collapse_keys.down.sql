@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_notifications_collapse_key;
+
+ALTER TABLE notifications DROP COLUMN IF EXISTS collapse_key;
@@ -0,0 +1,5 @@
+ALTER TABLE broadcast_jobs DROP COLUMN IF EXISTS deliver_at;
+
+DROP INDEX IF EXISTS idx_notifications_deliver_at;
+
+ALTER TABLE notifications DROP COLUMN IF EXISTS deliver_at;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS topic_subscriptions;
+DROP TABLE IF EXISTS recurring_schedules;
@@ -0,0 +1,35 @@
+-- Recurring schedules of publishers, each one materializing a notification (or a broadcast to an audience or to the
+-- subscribers of a topic) every time its cron expression fires. The revision is what makes exactly one service node
+-- fire each time. Clients subscribe to topics on their own
+
+CREATE TABLE recurring_schedules (
+    id bigserial PRIMARY KEY,
+    tenant_id text NOT NULL,
+    source_id text NOT NULL,
+    cron text NOT NULL,
+    time_zone text NOT NULL,
+    destination_id text NOT NULL DEFAULT '',
+    audience text NOT NULL DEFAULT '',
+    topic text NOT NULL DEFAULT '',
+    data text NOT NULL,
+    misfire_policy text NOT NULL,
+    next_fire_at timestamptz,
+    last_fired_at timestamptz,
+    revision bigint NOT NULL DEFAULT 0,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+
+CREATE INDEX idx_recurring_schedules_tenant_source ON recurring_schedules (tenant_id, source_id);
+CREATE INDEX idx_recurring_schedules_next_fire_at ON recurring_schedules (next_fire_at) WHERE next_fire_at IS NOT NULL;
+
+CREATE TABLE topic_subscriptions (
+    id bigserial PRIMARY KEY,
+    tenant_id text NOT NULL,
+    client_id text NOT NULL,
+    topic text NOT NULL,
+    created_at timestamptz
+);
+
+CREATE UNIQUE INDEX idx_topic_subscriptions_tenant_topic_client ON topic_subscriptions (tenant_id, topic, client_id);
+CREATE INDEX idx_topic_subscriptions_tenant_client ON topic_subscriptions (tenant_id, client_id);
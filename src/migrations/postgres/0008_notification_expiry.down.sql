@@ -0,0 +1,6 @@
+ALTER TABLE broadcast_jobs DROP COLUMN IF EXISTS expires_at;
+
+DROP INDEX IF EXISTS idx_notifications_read_at;
+DROP INDEX IF EXISTS idx_notifications_expires_at;
+
+ALTER TABLE notifications DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,3 @@
+ALTER TABLE broadcast_jobs DROP COLUMN IF EXISTS priority;
+
+ALTER TABLE notifications DROP COLUMN IF EXISTS priority;
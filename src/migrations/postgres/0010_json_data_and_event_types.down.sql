@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS event_types;
+
+ALTER TABLE broadcast_jobs DROP COLUMN IF EXISTS event_type;
+ALTER TABLE broadcasts DROP COLUMN IF EXISTS event_type;
+ALTER TABLE notifications DROP COLUMN IF EXISTS event_type;
+
+ALTER TABLE recurring_schedules ALTER COLUMN data TYPE text USING (CASE WHEN jsonb_typeof(data) = 'string' THEN data #>> '{}' ELSE data::text END);
+ALTER TABLE broadcast_jobs ALTER COLUMN data TYPE text USING (CASE WHEN jsonb_typeof(data) = 'string' THEN data #>> '{}' ELSE data::text END);
+ALTER TABLE broadcasts ALTER COLUMN data TYPE text USING (CASE WHEN jsonb_typeof(data) = 'string' THEN data #>> '{}' ELSE data::text END);
+ALTER TABLE notifications ALTER COLUMN data TYPE text USING (CASE WHEN jsonb_typeof(data) = 'string' THEN data #>> '{}' ELSE data::text END);
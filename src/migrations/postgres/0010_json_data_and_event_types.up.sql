@@ -0,0 +1,23 @@
+-- Event data is any JSON value, rather than a string, so whatever was stored so far becomes a JSON string. Events may
+-- be of a type, which may carry a JSON Schema their data must match
+
+ALTER TABLE notifications ALTER COLUMN data TYPE jsonb USING to_jsonb(data);
+ALTER TABLE broadcasts ALTER COLUMN data TYPE jsonb USING to_jsonb(data);
+ALTER TABLE broadcast_jobs ALTER COLUMN data TYPE jsonb USING to_jsonb(data);
+ALTER TABLE recurring_schedules ALTER COLUMN data TYPE jsonb USING to_jsonb(data);
+
+ALTER TABLE notifications ADD COLUMN event_type text NOT NULL DEFAULT '';
+ALTER TABLE broadcasts ADD COLUMN event_type text NOT NULL DEFAULT '';
+ALTER TABLE broadcast_jobs ADD COLUMN event_type text NOT NULL DEFAULT '';
+
+CREATE TABLE event_types (
+    id bigserial PRIMARY KEY,
+    tenant_id text NOT NULL,
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    json_schema jsonb NOT NULL,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+
+CREATE UNIQUE INDEX idx_event_types_tenant_name ON event_types (tenant_id, name);
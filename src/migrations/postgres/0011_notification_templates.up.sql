@@ -0,0 +1,15 @@
+-- Templates which render notifications of an event type for people to read, one per locale
+
+CREATE TABLE notification_templates (
+    id bigserial PRIMARY KEY,
+    tenant_id text NOT NULL,
+    event_type text NOT NULL,
+    locale text NOT NULL,
+    title text NOT NULL,
+    body text NOT NULL,
+    action_url text NOT NULL,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+
+CREATE UNIQUE INDEX idx_notification_templates_tenant_type_locale ON notification_templates (tenant_id, event_type, locale);
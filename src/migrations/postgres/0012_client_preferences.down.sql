@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS client_preferences;
+
+ALTER TABLE broadcast_jobs DROP COLUMN suppressed;
+ALTER TABLE broadcast_jobs DROP COLUMN topic;
+ALTER TABLE broadcast_jobs DROP COLUMN category;
+ALTER TABLE notifications DROP COLUMN topic;
+ALTER TABLE notifications DROP COLUMN category;
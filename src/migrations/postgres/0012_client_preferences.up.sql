@@ -0,0 +1,24 @@
+-- Events may be of a category and a topic, which (along with their source) clients set preferences by, e.g. to mute
+-- them. Broadcast jobs count destinations whose preferences kept the notification out of their inbox
+
+ALTER TABLE notifications ADD COLUMN category text NOT NULL DEFAULT '';
+ALTER TABLE notifications ADD COLUMN topic text NOT NULL DEFAULT '';
+ALTER TABLE broadcast_jobs ADD COLUMN category text NOT NULL DEFAULT '';
+ALTER TABLE broadcast_jobs ADD COLUMN topic text NOT NULL DEFAULT '';
+ALTER TABLE broadcast_jobs ADD COLUMN suppressed integer NOT NULL DEFAULT 0;
+
+CREATE TABLE client_preferences (
+    id bigserial PRIMARY KEY,
+    tenant_id text NOT NULL,
+    client_id text NOT NULL,
+    scope text NOT NULL,
+    match_key text NOT NULL,
+    stream boolean NOT NULL DEFAULT true,
+    inbox boolean NOT NULL DEFAULT true,
+    email boolean NOT NULL DEFAULT true,
+    webhook boolean NOT NULL DEFAULT true,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+
+CREATE UNIQUE INDEX idx_client_preferences_tenant_client_scope_key ON client_preferences (tenant_id, client_id, scope, match_key);
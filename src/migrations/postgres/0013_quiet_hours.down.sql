@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS client_settings;
+
+DROP INDEX IF EXISTS idx_notifications_held_until;
+
+ALTER TABLE notifications DROP COLUMN IF EXISTS held_until;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS client_digests;
+
+DROP INDEX IF EXISTS idx_notifications_digest_at;
+
+ALTER TABLE notifications DROP COLUMN IF EXISTS digest_at;
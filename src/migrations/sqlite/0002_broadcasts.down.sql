@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS broadcast_receipts;
+DROP TABLE IF EXISTS broadcasts;
@@ -0,0 +1,25 @@
+-- Broadcasts to a whole tenant are stored once; receipts keep per-client read state, created only when a client
+-- reads or dismisses a broadcast
+
+CREATE TABLE broadcasts (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    tenant_id text NOT NULL,
+    event_id text NOT NULL,
+    source_id text NOT NULL,
+    data text NOT NULL,
+    created_at datetime
+);
+
+CREATE INDEX idx_broadcasts_tenant_id ON broadcasts (tenant_id);
+
+CREATE TABLE broadcast_receipts (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    tenant_id text NOT NULL,
+    broadcast_id integer NOT NULL REFERENCES broadcasts (id) ON DELETE CASCADE,
+    client_id text NOT NULL,
+    read_at datetime,
+    dismissed_at datetime,
+    created_at datetime
+);
+
+CREATE UNIQUE INDEX idx_broadcast_receipts_broadcast_client ON broadcast_receipts (broadcast_id, client_id);
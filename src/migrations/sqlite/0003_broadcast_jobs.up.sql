@@ -0,0 +1,23 @@
+-- Broadcasts to many destinations may run in the background as jobs, which keep their own progress so they can be
+-- resumed by the service node that owns them after a restart
+
+CREATE TABLE broadcast_jobs (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    tenant_id text NOT NULL,
+    node_id text NOT NULL,
+    event_id text NOT NULL,
+    source_id text NOT NULL,
+    destinations text NOT NULL,
+    data text NOT NULL,
+    status text NOT NULL,
+    total integer NOT NULL DEFAULT 0,
+    persisted integer NOT NULL DEFAULT 0,
+    delivered integer NOT NULL DEFAULT 0,
+    failed integer NOT NULL DEFAULT 0,
+    last_error text NOT NULL DEFAULT '',
+    created_at datetime,
+    updated_at datetime,
+    finished_at datetime
+);
+
+CREATE INDEX idx_broadcast_jobs_node_status ON broadcast_jobs (node_id, status);
@@ -0,0 +1,18 @@
+-- Idempotency keys of publishers, along with the response to give back when a request is retried. The unique index
+-- is what makes a key hold across service nodes sharing the database
+
+CREATE TABLE idempotency_keys (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    tenant_id text NOT NULL,
+    scope text NOT NULL,
+    key text NOT NULL,
+    request_hash text NOT NULL,
+    status_code integer NOT NULL DEFAULT 0,
+    content_type text NOT NULL DEFAULT '',
+    response text NOT NULL DEFAULT '',
+    created_at datetime,
+    expires_at datetime NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_idempotency_keys_tenant_scope_key ON idempotency_keys (tenant_id, scope, key);
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
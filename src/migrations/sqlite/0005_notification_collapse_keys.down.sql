@@ -0,0 +1,26 @@
+-- SQLite can't drop a column, so the table is rebuilt without it
+
+DROP INDEX IF EXISTS idx_notifications_collapse_key;
+
+CREATE TABLE notifications_without_collapse_key (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    tenant_id text NOT NULL,
+    event_id text NOT NULL,
+    source_id text NOT NULL,
+    destination_id text NOT NULL,
+    data text NOT NULL,
+    created_at datetime,
+    read_at datetime
+);
+
+INSERT INTO notifications_without_collapse_key (id, tenant_id, event_id, source_id, destination_id, data, created_at, read_at)
+SELECT id, tenant_id, event_id, source_id, destination_id, data, created_at, read_at FROM notifications;
+
+DROP TABLE notifications;
+
+ALTER TABLE notifications_without_collapse_key RENAME TO notifications;
+
+CREATE INDEX idx_notifications_tenant_destination ON notifications (tenant_id, destination_id);
+CREATE INDEX idx_notifications_event_id ON notifications (event_id);
+CREATE INDEX idx_notifications_source_id ON notifications (source_id);
+CREATE INDEX idx_notifications_destination_id ON notifications (destination_id);
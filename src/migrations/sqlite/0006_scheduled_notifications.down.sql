@@ -0,0 +1,56 @@
+-- SQLite can't drop a column, so tables are rebuilt without it
+
+DROP INDEX IF EXISTS idx_notifications_deliver_at;
+
+CREATE TABLE notifications_without_deliver_at (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    tenant_id text NOT NULL,
+    event_id text NOT NULL,
+    source_id text NOT NULL,
+    destination_id text NOT NULL,
+    data text NOT NULL,
+    created_at datetime,
+    read_at datetime,
+    collapse_key text NOT NULL DEFAULT ''
+);
+
+INSERT INTO notifications_without_deliver_at (id, tenant_id, event_id, source_id, destination_id, data, created_at, read_at, collapse_key)
+SELECT id, tenant_id, event_id, source_id, destination_id, data, created_at, read_at, collapse_key FROM notifications;
+
+DROP TABLE notifications;
+
+ALTER TABLE notifications_without_deliver_at RENAME TO notifications;
+
+CREATE INDEX idx_notifications_tenant_destination ON notifications (tenant_id, destination_id);
+CREATE INDEX idx_notifications_event_id ON notifications (event_id);
+CREATE INDEX idx_notifications_source_id ON notifications (source_id);
+CREATE INDEX idx_notifications_destination_id ON notifications (destination_id);
+CREATE INDEX idx_notifications_collapse_key ON notifications (tenant_id, destination_id, collapse_key);
+
+CREATE TABLE broadcast_jobs_without_deliver_at (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    tenant_id text NOT NULL,
+    node_id text NOT NULL,
+    event_id text NOT NULL,
+    source_id text NOT NULL,
+    destinations text NOT NULL,
+    data text NOT NULL,
+    status text NOT NULL,
+    total integer NOT NULL DEFAULT 0,
+    persisted integer NOT NULL DEFAULT 0,
+    delivered integer NOT NULL DEFAULT 0,
+    failed integer NOT NULL DEFAULT 0,
+    last_error text NOT NULL DEFAULT '',
+    created_at datetime,
+    updated_at datetime,
+    finished_at datetime
+);
+
+INSERT INTO broadcast_jobs_without_deliver_at (id, tenant_id, node_id, event_id, source_id, destinations, data, status, total, persisted, delivered, failed, last_error, created_at, updated_at, finished_at)
+SELECT id, tenant_id, node_id, event_id, source_id, destinations, data, status, total, persisted, delivered, failed, last_error, created_at, updated_at, finished_at FROM broadcast_jobs;
+
+DROP TABLE broadcast_jobs;
+
+ALTER TABLE broadcast_jobs_without_deliver_at RENAME TO broadcast_jobs;
+
+CREATE INDEX idx_broadcast_jobs_node_status ON broadcast_jobs (node_id, status);
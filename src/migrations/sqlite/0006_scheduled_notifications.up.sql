@@ -0,0 +1,8 @@
+-- Notifications may be scheduled for later: they stay hidden until deliver_at, which is cleared by the one service
+-- node releasing them to live streams
+
+ALTER TABLE notifications ADD COLUMN deliver_at datetime;
+
+CREATE INDEX idx_notifications_deliver_at ON notifications (deliver_at);
+
+ALTER TABLE broadcast_jobs ADD COLUMN deliver_at datetime;
@@ -0,0 +1,86 @@
+-- SQLite can't drop a column, so tables are rebuilt without it, and JSON strings are turned back into text as far as
+-- the usual escapes go
+
+DROP TABLE IF EXISTS event_types;
+
+CREATE TABLE notifications_without_event_type (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    tenant_id text NOT NULL,
+    event_id text NOT NULL,
+    source_id text NOT NULL,
+    destination_id text NOT NULL,
+    data text NOT NULL,
+    created_at datetime,
+    read_at datetime,
+    collapse_key text NOT NULL DEFAULT '',
+    deliver_at datetime,
+    expires_at datetime,
+    priority integer NOT NULL DEFAULT 0
+);
+
+INSERT INTO notifications_without_event_type (id, tenant_id, event_id, source_id, destination_id, data, created_at, read_at, collapse_key, deliver_at, expires_at, priority)
+SELECT id, tenant_id, event_id, source_id, destination_id, CASE WHEN substr(data, 1, 1) = '"' THEN replace(replace(replace(replace(replace(replace(substr(data, 2, length(data) - 2), '\\', char(1)), '\"', '"'), '\n', char(10)), '\r', char(13)), '\t', char(9)), char(1), '\') ELSE data END, created_at, read_at, collapse_key, deliver_at, expires_at, priority FROM notifications;
+
+DROP TABLE notifications;
+
+ALTER TABLE notifications_without_event_type RENAME TO notifications;
+
+CREATE INDEX idx_notifications_tenant_destination ON notifications (tenant_id, destination_id);
+CREATE INDEX idx_notifications_event_id ON notifications (event_id);
+CREATE INDEX idx_notifications_source_id ON notifications (source_id);
+CREATE INDEX idx_notifications_destination_id ON notifications (destination_id);
+CREATE INDEX idx_notifications_collapse_key ON notifications (tenant_id, destination_id, collapse_key);
+CREATE INDEX idx_notifications_deliver_at ON notifications (deliver_at);
+CREATE INDEX idx_notifications_expires_at ON notifications (expires_at);
+CREATE INDEX idx_notifications_read_at ON notifications (read_at);
+
+CREATE TABLE broadcasts_without_event_type (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    tenant_id text NOT NULL,
+    event_id text NOT NULL,
+    source_id text NOT NULL,
+    data text NOT NULL,
+    created_at datetime
+);
+
+INSERT INTO broadcasts_without_event_type (id, tenant_id, event_id, source_id, data, created_at)
+SELECT id, tenant_id, event_id, source_id, CASE WHEN substr(data, 1, 1) = '"' THEN replace(replace(replace(replace(replace(replace(substr(data, 2, length(data) - 2), '\\', char(1)), '\"', '"'), '\n', char(10)), '\r', char(13)), '\t', char(9)), char(1), '\') ELSE data END, created_at FROM broadcasts;
+
+DROP TABLE broadcasts;
+
+ALTER TABLE broadcasts_without_event_type RENAME TO broadcasts;
+
+CREATE INDEX idx_broadcasts_tenant_id ON broadcasts (tenant_id);
+
+CREATE TABLE broadcast_jobs_without_event_type (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    tenant_id text NOT NULL,
+    node_id text NOT NULL,
+    event_id text NOT NULL,
+    source_id text NOT NULL,
+    destinations text NOT NULL,
+    data text NOT NULL,
+    status text NOT NULL,
+    total integer NOT NULL DEFAULT 0,
+    persisted integer NOT NULL DEFAULT 0,
+    delivered integer NOT NULL DEFAULT 0,
+    failed integer NOT NULL DEFAULT 0,
+    last_error text NOT NULL DEFAULT '',
+    created_at datetime,
+    updated_at datetime,
+    finished_at datetime,
+    deliver_at datetime,
+    expires_at datetime,
+    priority integer NOT NULL DEFAULT 0
+);
+
+INSERT INTO broadcast_jobs_without_event_type (id, tenant_id, node_id, event_id, source_id, destinations, data, status, total, persisted, delivered, failed, last_error, created_at, updated_at, finished_at, deliver_at, expires_at, priority)
+SELECT id, tenant_id, node_id, event_id, source_id, destinations, CASE WHEN substr(data, 1, 1) = '"' THEN replace(replace(replace(replace(replace(replace(substr(data, 2, length(data) - 2), '\\', char(1)), '\"', '"'), '\n', char(10)), '\r', char(13)), '\t', char(9)), char(1), '\') ELSE data END, status, total, persisted, delivered, failed, last_error, created_at, updated_at, finished_at, deliver_at, expires_at, priority FROM broadcast_jobs;
+
+DROP TABLE broadcast_jobs;
+
+ALTER TABLE broadcast_jobs_without_event_type RENAME TO broadcast_jobs;
+
+CREATE INDEX idx_broadcast_jobs_node_status ON broadcast_jobs (node_id, status);
+
+UPDATE recurring_schedules SET data = CASE WHEN substr(data, 1, 1) = '"' THEN replace(replace(replace(replace(replace(replace(substr(data, 2, length(data) - 2), '\\', char(1)), '\"', '"'), '\n', char(10)), '\r', char(13)), '\t', char(9)), char(1), '\') ELSE data END;
@@ -0,0 +1,24 @@
+-- Event data is any JSON value, rather than a string, so whatever was stored so far becomes a JSON string. Events may
+-- be of a type, which may carry a JSON Schema their data must match. SQLite has no JSON type, nor JSON functions built
+-- in here, so strings are quoted the hard way, as far as the usual escapes go
+
+UPDATE notifications SET data = '"' || replace(replace(replace(replace(replace(data, '\', '\\'), '"', '\"'), char(10), '\n'), char(13), '\r'), char(9), '\t') || '"';
+UPDATE broadcasts SET data = '"' || replace(replace(replace(replace(replace(data, '\', '\\'), '"', '\"'), char(10), '\n'), char(13), '\r'), char(9), '\t') || '"';
+UPDATE broadcast_jobs SET data = '"' || replace(replace(replace(replace(replace(data, '\', '\\'), '"', '\"'), char(10), '\n'), char(13), '\r'), char(9), '\t') || '"';
+UPDATE recurring_schedules SET data = '"' || replace(replace(replace(replace(replace(data, '\', '\\'), '"', '\"'), char(10), '\n'), char(13), '\r'), char(9), '\t') || '"';
+
+ALTER TABLE notifications ADD COLUMN event_type text NOT NULL DEFAULT '';
+ALTER TABLE broadcasts ADD COLUMN event_type text NOT NULL DEFAULT '';
+ALTER TABLE broadcast_jobs ADD COLUMN event_type text NOT NULL DEFAULT '';
+
+CREATE TABLE event_types (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    tenant_id text NOT NULL,
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    json_schema text NOT NULL,
+    created_at datetime,
+    updated_at datetime
+);
+
+CREATE UNIQUE INDEX idx_event_types_tenant_name ON event_types (tenant_id, name);
@@ -0,0 +1,39 @@
+-- SQLite can't drop a column, so the table is rebuilt without it
+
+DROP TABLE IF EXISTS client_settings;
+
+DROP INDEX IF EXISTS idx_notifications_held_until;
+
+CREATE TABLE notifications_without_held_until (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    tenant_id text NOT NULL,
+    event_id text NOT NULL,
+    source_id text NOT NULL,
+    destination_id text NOT NULL,
+    data text NOT NULL,
+    created_at datetime,
+    read_at datetime,
+    collapse_key text NOT NULL DEFAULT '',
+    deliver_at datetime,
+    expires_at datetime,
+    priority integer NOT NULL DEFAULT 0,
+    event_type text NOT NULL DEFAULT '',
+    category text NOT NULL DEFAULT '',
+    topic text NOT NULL DEFAULT ''
+);
+
+INSERT INTO notifications_without_held_until (id, tenant_id, event_id, source_id, destination_id, data, created_at, read_at, collapse_key, deliver_at, expires_at, priority, event_type, category, topic)
+SELECT id, tenant_id, event_id, source_id, destination_id, data, created_at, read_at, collapse_key, deliver_at, expires_at, priority, event_type, category, topic FROM notifications;
+
+DROP TABLE notifications;
+
+ALTER TABLE notifications_without_held_until RENAME TO notifications;
+
+CREATE INDEX idx_notifications_tenant_destination ON notifications (tenant_id, destination_id);
+CREATE INDEX idx_notifications_event_id ON notifications (event_id);
+CREATE INDEX idx_notifications_source_id ON notifications (source_id);
+CREATE INDEX idx_notifications_destination_id ON notifications (destination_id);
+CREATE INDEX idx_notifications_collapse_key ON notifications (tenant_id, destination_id, collapse_key);
+CREATE INDEX idx_notifications_deliver_at ON notifications (deliver_at);
+CREATE INDEX idx_notifications_expires_at ON notifications (expires_at);
+CREATE INDEX idx_notifications_read_at ON notifications (read_at);
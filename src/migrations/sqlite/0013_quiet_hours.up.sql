@@ -0,0 +1,21 @@
+-- Clients may have quiet hours, or snooze everything for a while: notifications are persisted but held back from live
+-- streams until held_until, which is cleared by the one service node releasing them
+
+ALTER TABLE notifications ADD COLUMN held_until datetime;
+
+CREATE INDEX idx_notifications_held_until ON notifications (held_until);
+
+CREATE TABLE client_settings (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    tenant_id text NOT NULL,
+    client_id text NOT NULL,
+    time_zone text NOT NULL,
+    quiet_start text NOT NULL,
+    quiet_end text NOT NULL,
+    quiet_release text NOT NULL,
+    snoozed_until datetime,
+    created_at datetime,
+    updated_at datetime
+);
+
+CREATE UNIQUE INDEX idx_client_settings_tenant_client ON client_settings (tenant_id, client_id);
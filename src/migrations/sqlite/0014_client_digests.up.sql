@@ -0,0 +1,18 @@
+-- Clients may get notifications of a category in digests: they are persisted but kept from live streams until
+-- digest_at, when the one service node which clears it sums them up in a single notification
+
+ALTER TABLE notifications ADD COLUMN digest_at datetime;
+
+CREATE INDEX idx_notifications_digest_at ON notifications (digest_at);
+
+CREATE TABLE client_digests (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    tenant_id text NOT NULL,
+    client_id text NOT NULL,
+    category text NOT NULL,
+    interval_seconds integer NOT NULL,
+    created_at datetime,
+    updated_at datetime
+);
+
+CREATE UNIQUE INDEX idx_client_digests_tenant_client_category ON client_digests (tenant_id, client_id, category);
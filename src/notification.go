@@ -1,21 +1,77 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-// Notification is the persistent record of a known event (read/unread)
+// Notification is the persistent record of a known event (read/unread). Data is any JSON value, stored as JSON where
+// the database has such a type and sized so it maps to a large text column otherwise
 type Notification struct {
 	ID            uint       `json:"id,omitempty" gorm:"primaryKey"`
+	TenantID      string     `json:"tenantID,omitempty" gorm:"not null;index:idx_notifications_tenant_destination,priority:1"`
 	EventID       string     `json:"event,omitempty" gorm:"not null;index"`
 	SourceID      string     `json:"sourceID,omitempty" gorm:"not null;index"`
-	DestinationID string     `json:"destinationID,omitempty" gorm:"not null;index"`
-	Data          string     `json:"data,omitempty" gorm:"not null"`
+	DestinationID string     `json:"destinationID,omitempty" gorm:"not null;index;index:idx_notifications_tenant_destination,priority:2"`
+	Type          string     `json:"type,omitempty" gorm:"column:event_type;not null;default:''"`
+	Category      string     `json:"category,omitempty" gorm:"not null;default:''"`
+	Topic         string     `json:"topic,omitempty" gorm:"not null;default:''"`
+	Data          JSONData   `json:"data,omitempty" gorm:"not null;size:65536"`
 	CreatedAt     time.Time  `json:"createdAt,omitempty"`
 	ReadAt        *time.Time `json:"readAt,omitempty"`
+	CollapseKey   string     `json:"collapseKey,omitempty" gorm:"not null;default:''"`
+	Priority      Priority   `json:"priority,omitempty" gorm:"not null;default:0"`
+
+	// DeliverAt tells a notification is scheduled for later, which keeps it out of sight until then. It is cleared
+	// as soon as the notification is released to live delivery
+	DeliverAt *time.Time `json:"deliverAt,omitempty" gorm:"index"`
+
+	// ExpiresAt tells when a notification is no longer worth anything, which keeps it out of sight and from being
+	// delivered from then on, until it is purged
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" gorm:"index"`
+
+	// HeldUntil tells a notification is held back from live delivery while its destination is quiet, though it is in
+	// sight all along. It is cleared as soon as the notification is released to live delivery
+	HeldUntil *time.Time `json:"heldUntil,omitempty" gorm:"index"`
+
+	// DigestAt tells a notification is accumulated for a digest of its category, which keeps it from live delivery
+	// though it is in sight all along. It is cleared as soon as the digest is released, which it is summed up in
+	DigestAt *time.Time `json:"digestAt,omitempty" gorm:"index"`
+
+	// Updated tells a notification is the live delivery of an unread one updated in place by a newer event of the same
+	// collapse key, rather than a new one
+	Updated bool `json:"updated,omitempty" gorm:"-"`
+
+	// BroadcastID tells a notification is the live delivery of a broadcast to every client of the tenant, which is not
+	// persisted as a notification at all
+	BroadcastID uint `json:"broadcastID,omitempty" gorm:"-"`
+
+	// Suppressed tells the channels the preferences of its destination keep a notification out of: one kept out of
+	// the inbox is not persisted at all, and one kept out of the stream is never delivered live
+	Suppressed []string `json:"-" gorm:"-"`
+
+	// delivery is told whether the notification was delivered live by this service node, for whoever tracks it
+	delivery chan<- bool
+}
+
+// reportDelivery of the notification, if anyone tracks it. Trackers must not be held back, so they give room for it
+func (n Notification) reportDelivery(live bool) {
+	if n.delivery != nil {
+		n.delivery <- live
+	}
+}
+
+// suppresses tells whether a notification is kept out of a channel, see Suppressed
+func (n Notification) suppresses(channel string) bool {
+	for _, suppressed := range n.Suppressed {
+		if suppressed == channel {
+			return true
+		}
+	}
+	return false
 }
 
 // NewNotification creates a new notification for a given event
@@ -26,35 +82,161 @@ func NewNotification(event *Event) (*Notification, error) {
 	}
 
 	notification := &Notification{
+		TenantID:      event.TenantID,
 		EventID:       event.ID,
 		SourceID:      event.SourceID,
 		DestinationID: event.DestinationID,
+		Type:          event.Type,
+		Category:      event.Category,
+		Topic:         event.Topic,
 		Data:          event.Data,
+		CollapseKey:   event.CollapseKey,
+		Priority:      event.Priority,
+		DeliverAt:     scheduledTime(event.DeliverAt),
 	}
+	notification.ExpiresAt = expiryTime(event.ExpiresAt, event.TTL, notification.DeliverAt)
 
 	return notification, nil
 }
 
-// Event is something worth enough to be notified
+// Expired tells whether a notification is past its expiry at a given time
+func (n Notification) Expired(now time.Time) bool {
+	return n.ExpiresAt != nil && !n.ExpiresAt.After(now)
+}
+
+// scheduledTime of a notification, if it is for later at all. It is always in UTC, since some databases (i.e.
+// SQLite) compare times as they are written
+func scheduledTime(deliverAt *time.Time) *time.Time {
+	if deliverAt == nil || !deliverAt.After(time.Now()) {
+		return nil
+	}
+
+	utc := deliverAt.UTC()
+	return &utc
+}
+
+// expiryTime of a notification, if it expires at all: either when it is told to, or its time to live (in seconds)
+// after it is delivered. It is always in UTC, as scheduledTime
+func expiryTime(expiresAt *time.Time, ttl int, deliverAt *time.Time) *time.Time {
+	if expiresAt == nil && ttl <= 0 {
+		return nil
+	}
+
+	var expiry time.Time
+	if expiresAt != nil {
+		expiry = expiresAt.UTC()
+	} else {
+		from := time.Now()
+		if deliverAt != nil {
+			from = *deliverAt
+		}
+		expiry = from.Add(time.Duration(ttl) * time.Second).UTC()
+	}
+
+	return &expiry
+}
+
+// checkExpiry of an event, which is given either as a time or as a time to live (in seconds), and must come after
+// it is delivered
+func checkExpiry(expiresAt *time.Time, ttl int, deliverAt *time.Time) error {
+	if ttl < 0 {
+		return errors.New("ttl must not be negative")
+	}
+	if expiresAt == nil {
+		return nil
+	}
+
+	if ttl > 0 {
+		return errors.New("either expiresAt or ttl must be given, not both")
+	}
+	if !expiresAt.After(time.Now()) {
+		return errors.New("expiresAt must be in the future")
+	}
+	if deliverAt != nil && !expiresAt.After(*deliverAt) {
+		return errors.New("expiresAt must come after deliverAt")
+	}
+
+	return nil
+}
+
+// Event is something worth enough to be notified. Its tenant is never taken from the payload but from
+// the publisher credentials. Its type, if any, must be a registered one, whose schema its data must match. Its
+// category and topic, along with its source, are what client preferences go by
 type Event struct {
-	ID            string `json:"id,omitempty"`
-	SourceID      string `json:"sourceID,omitempty"`
-	DestinationID string `json:"destinationID,omitempty"`
-	Data          string `json:"data,omitempty"`
+	TenantID      string     `json:"-"`
+	ID            string     `json:"id,omitempty"`
+	SourceID      string     `json:"sourceID,omitempty"`
+	DestinationID string     `json:"destinationID,omitempty"`
+	Type          string     `json:"type,omitempty"`
+	Category      string     `json:"category,omitempty"`
+	Topic         string     `json:"topic,omitempty"`
+	Data          JSONData   `json:"data,omitempty"`
+	CollapseKey   string     `json:"collapseKey,omitempty"`
+	Priority      Priority   `json:"priority,omitempty"`
+	DeliverAt     *time.Time `json:"deliverAt,omitempty"`
+	ExpiresAt     *time.Time `json:"expiresAt,omitempty"`
+	TTL           int        `json:"ttl,omitempty"`
 }
 
-// BroadcastEvent is something worth enough to be broadcasted
+// BroadcastEvent is something worth enough to be broadcasted, either to the given destinations or to a whole
+// audience (i.e. BroadcastAudienceAll) without enumerating it
 type BroadcastEvent struct {
-	ID           string   `json:"id,omitempty"`
-	SourceID     string   `json:"sourceID,omitempty"`
-	Destinations []string `json:"destinations,omitempty"`
-	Data         string   `json:"data,omitempty"`
+	TenantID     string     `json:"-"`
+	ID           string     `json:"id,omitempty"`
+	SourceID     string     `json:"sourceID,omitempty"`
+	Destinations []string   `json:"destinations,omitempty"`
+	Audience     string     `json:"audience,omitempty"`
+	Mode         string     `json:"mode,omitempty"`
+	Type         string     `json:"type,omitempty"`
+	Category     string     `json:"category,omitempty"`
+	Topic        string     `json:"topic,omitempty"`
+	Data         JSONData   `json:"data,omitempty"`
+	Priority     Priority   `json:"priority,omitempty"`
+	DeliverAt    *time.Time `json:"deliverAt,omitempty"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	TTL          int        `json:"ttl,omitempty"`
+}
+
+var (
+	// BroadcastModeAtomic stands for a broadcast to destinations persisted all or nothing, before any live delivery
+	BroadcastModeAtomic = "atomic"
+
+	// BroadcastModePartial stands for a broadcast to destinations where each one succeeds or fails on its own
+	BroadcastModePartial = "partial"
+)
+
+// IsValidBroadcastMode tells whether a given broadcast mode string is a valid one. Missing means atomic
+func IsValidBroadcastMode(mode string) bool {
+	return mode == "" || mode == BroadcastModeAtomic || mode == BroadcastModePartial
+}
+
+// BroadcastResult is the outcome of a broadcast for one of its destinations
+type BroadcastResult struct {
+	DestinationID string
+	Notification  Notification
+	Suppressed    []string
+	Err           error
 }
 
 // Client is the target notification entity
 type Client struct {
-	ID      string
-	Channel chan Notification
+	TenantID string
+	ID       string
+	Queue    *OutboundQueue
+
+	// TokenID is the jti of the token the client session was opened with, if it had any
+	TokenID string
+
+	// Done is closed when the client session is over, either because the connection is gone or it was killed
+	Done <-chan struct{}
+
+	// Kill ends the client session from the server side
+	Kill func()
+}
+
+// Key uniquely identifies the client across tenants
+func (c Client) Key() string {
+	return ClientKey(c.TenantID, c.ID)
 }
 
 var (
@@ -73,16 +255,52 @@ func IsValidNotificationStatus(status string) bool {
 	return status == "" || status == StatusAllNotifications || status == StatusUnreadNotifications || status == StatusReadNotifications
 }
 
+var (
+	// NotificationSortCreated stands for notifications sorted by when they were created, which is the default
+	NotificationSortCreated = "created"
+
+	// NotificationSortPriority stands for notifications sorted by priority, the highest first, and then by when they
+	// were created
+	NotificationSortPriority = "priority"
+)
+
+// IsValidNotificationSort tells whether a given sort string is a valid one. Missing means by creation
+func IsValidNotificationSort(sortBy string) bool {
+	return sortBy == "" || sortBy == NotificationSortCreated || sortBy == NotificationSortPriority
+}
+
 // ErrNotificationNotFound is returned when, guess what, a notification doesn't exist in database
 var ErrNotificationNotFound = errors.New("notification not found")
 
-// NotificationRepository is the interface to notification datastore
+// NotificationRepository is the interface to notification datastore. Every operation is bound to one tenant,
+// the default one unless it was scoped by ForTenant, but ReleaseDue, ReleaseHeld, ReleaseDigests, DeleteExpired and
+// DeleteReadBefore; and gives up as soon as its context is done. Scheduled notifications are left out of GetAll,
+// GetByStatus and FilterBy until they are due, and expired ones from then on. GetUsage and EvictRead count every
+// notification of a destination, though. Held and accumulated notifications are in sight, they are only kept from live
+// delivery until ReleaseHeld or ReleaseDigests claims them
 type NotificationRepository interface {
-	Add(notification *Notification) error
-	Update(notification *Notification) error
-	Delete(id uint) error
-	Get(id uint) (Notification, error)
-	GetAll(destinationID string) ([]Notification, error)
-	GetByStatus(destinationID string, status string) ([]Notification, error)
-	FilterBy(destinationID string, criteria Notification) ([]Notification, error)
+	ForTenant(tenantID string) NotificationRepository
+	Add(ctx context.Context, notification *Notification) error
+	AddBatch(ctx context.Context, notifications []*Notification) error
+	Update(ctx context.Context, notification *Notification) error
+	Delete(ctx context.Context, id uint) error
+	Get(ctx context.Context, id uint) (Notification, error)
+	GetAll(ctx context.Context, destinationID string) ([]Notification, error)
+	GetByStatus(ctx context.Context, destinationID string, status string) ([]Notification, error)
+	FilterBy(ctx context.Context, destinationID string, criteria Notification) ([]Notification, error)
+	Notified(ctx context.Context, eventID string, destinationIDs []string) ([]string, error)
+	Collapse(ctx context.Context, notification *Notification) (bool, error)
+	Reschedule(ctx context.Context, sourceID string, eventID string, deliverAt time.Time) (int64, error)
+	CancelScheduled(ctx context.Context, sourceID string, eventID string) (int64, error)
+	ReleaseDue(ctx context.Context, now time.Time, limit int) ([]Notification, error)
+	Hold(ctx context.Context, id uint, until time.Time) error
+	RescheduleHeld(ctx context.Context, destinationID string, until time.Time) (int64, error)
+	ReleaseHeld(ctx context.Context, now time.Time, limit int) ([]Notification, error)
+	Accumulate(ctx context.Context, id uint, at time.Time) error
+	RescheduleDigests(ctx context.Context, destinationID string, at time.Time) (int64, error)
+	ReleaseDigests(ctx context.Context, now time.Time, limit int) ([]Notification, error)
+	DeleteExpired(ctx context.Context, now time.Time, limit int) (int64, error)
+	DeleteReadBefore(ctx context.Context, before time.Time, limit int) (int64, error)
+	GetUsage(ctx context.Context, destinationID string) (ClientUsage, error)
+	EvictRead(ctx context.Context, destinationID string, excess ClientUsage) (ClientUsage, error)
 }
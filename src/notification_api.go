@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -14,22 +17,48 @@ import (
 
 // NotificationAPI is the public HTTP interface for the Broker
 type NotificationAPI struct {
-	Broker     *Broker
-	Repository NotificationRepository
+	Broker      *Broker
+	Repository  NotificationRepository
+	Broadcasts  BroadcastRepository
+	Jobs        *JobRunner
+	Schedules   RecurringScheduleRepository
+	Topics      TopicSubscriptionRepository
+	Idempotency *IdempotencyGuard
+	Audit       AuditRepository
+	Validator   *EventValidator
+	Renderer    *TemplateRenderer
+	Preferences ClientPreferenceRepository
+	Settings    ClientSettingsRepository
+	Digests     ClientDigestRepository
 }
 
 // NewNotificationAPI creates an instance of the NotificationAPI
-func NewNotificationAPI(broker *Broker, repository NotificationRepository) (api NotificationAPI) {
+func NewNotificationAPI(broker *Broker, repository NotificationRepository, broadcasts BroadcastRepository, jobs *JobRunner, schedules RecurringScheduleRepository, topics TopicSubscriptionRepository, idempotency *IdempotencyGuard, audit AuditRepository, validator *EventValidator, renderer *TemplateRenderer, preferences ClientPreferenceRepository, settings ClientSettingsRepository, digests ClientDigestRepository) (api NotificationAPI) {
 	api = NotificationAPI{
-		Broker:     broker,
-		Repository: repository,
+		Broker:      broker,
+		Repository:  repository,
+		Broadcasts:  broadcasts,
+		Jobs:        jobs,
+		Schedules:   schedules,
+		Topics:      topics,
+		Idempotency: idempotency,
+		Audit:       audit,
+		Validator:   validator,
+		Renderer:    renderer,
+		Preferences: preferences,
+		Settings:    settings,
+		Digests:     digests,
 	}
 	return
 }
 
 type unicastEventResponse struct {
-	NotificationID uint   `json:"notificationID"`
-	EventID        string `json:"eventID"`
+	NotificationID uint       `json:"notificationID"`
+	EventID        string     `json:"eventID"`
+	Updated        bool       `json:"updated,omitempty"`
+	DeliverAt      *time.Time `json:"deliverAt,omitempty"`
+	ExpiresAt      *time.Time `json:"expiresAt,omitempty"`
+	Suppressed     []string   `json:"suppressed,omitempty"`
 }
 
 // UnicastEventHandler is the endpoint to publishs events from one source to one destination
@@ -43,25 +72,75 @@ func (api *NotificationAPI) UnicastEventHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	err = checkEventSource(r, &event.SourceID)
+	if err != nil {
+		respondWithForbidden(w, err.Error())
+		return
+	}
+
+	tenant := authorizedTenant(r)
+	if tenant.MaxDataSize > 0 && len(event.Data) > tenant.MaxDataSize {
+		respondWithError(w, fmt.Sprintf("data is larger than %d bytes", tenant.MaxDataSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	event.TenantID = tenant.ID
+
+	err = checkExpiry(event.ExpiresAt, event.TTL, event.DeliverAt)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	err = api.Validator.Validate(r.Context(), event.TenantID, event.Type, event.Data)
+	if err != nil {
+		respondWithEventError(w, err)
+		return
+	}
+
 	log.Printf("Receiving event for client %s from source %s", event.DestinationID, event.SourceID)
 
-	notification, err := api.Broker.NotifyEvent(event)
+	notification, err := api.Broker.NotifyEvent(r.Context(), event)
 	if err != nil {
-		respondWithInternalServerError(w, err.Error())
+		respondWithRepositoryError(w, err)
 		return
 	}
 
+	details := map[string]interface{}{
+		"sourceID":       notification.SourceID,
+		"destinationID":  notification.DestinationID,
+		"notificationID": notification.ID,
+	}
+	if notification.CollapseKey != "" {
+		details["collapseKey"] = notification.CollapseKey
+		details["updated"] = notification.Updated
+	}
+	if notification.DeliverAt != nil {
+		details["deliverAt"] = notification.DeliverAt
+	}
+	if len(notification.Suppressed) > 0 {
+		details["suppressed"] = notification.Suppressed
+	}
+	recordAudit(api.Audit, r, AuditActionUnicast, notification.EventID, details)
+
 	response := unicastEventResponse{
 		NotificationID: notification.ID,
 		EventID:        notification.EventID,
+		Updated:        notification.Updated,
+		DeliverAt:      notification.DeliverAt,
+		ExpiresAt:      notification.ExpiresAt,
+		Suppressed:     notification.Suppressed,
 	}
 
 	respondWithSuccess(w, response)
 }
 
 type broadcastEventResponse struct {
-	NotificationID uint   `json:"notificationID"`
-	EventID        string `json:"eventID"`
+	DestinationID  string     `json:"destinationID,omitempty"`
+	NotificationID uint       `json:"notificationID,omitempty"`
+	EventID        string     `json:"eventID,omitempty"`
+	DeliverAt      *time.Time `json:"deliverAt,omitempty"`
+	Suppressed     []string   `json:"suppressed,omitempty"`
+	Error          string     `json:"error,omitempty"`
 }
 
 // BroadcastEventHandler is the endpoint to publishs events from one source to many destinations
@@ -75,33 +154,367 @@ func (api *NotificationAPI) BroadcastEventHandler(w http.ResponseWriter, r *http
 		return
 	}
 
+	err = checkEventSource(r, &brodcastEvent.SourceID)
+	if err != nil {
+		respondWithForbidden(w, err.Error())
+		return
+	}
+
+	tenant := authorizedTenant(r)
+	if tenant.MaxDataSize > 0 && len(brodcastEvent.Data) > tenant.MaxDataSize {
+		respondWithError(w, fmt.Sprintf("data is larger than %d bytes", tenant.MaxDataSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if tenant.MaxBroadcastDestinations > 0 && len(brodcastEvent.Destinations) > tenant.MaxBroadcastDestinations {
+		respondWithBadRequest(w, fmt.Sprintf("broadcast is limited to %d destinations", tenant.MaxBroadcastDestinations))
+		return
+	}
+	brodcastEvent.TenantID = tenant.ID
+
+	err = checkExpiry(brodcastEvent.ExpiresAt, brodcastEvent.TTL, brodcastEvent.DeliverAt)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	err = api.Validator.Validate(r.Context(), brodcastEvent.TenantID, brodcastEvent.Type, brodcastEvent.Data)
+	if err != nil {
+		respondWithEventError(w, err)
+		return
+	}
+
+	async := r.URL.Query().Get("async") == "true"
+
+	if brodcastEvent.Audience != "" {
+		if async {
+			respondWithBadRequest(w, "broadcasts to an audience are stored once, there is nothing to run in the background")
+			return
+		}
+		if brodcastEvent.DeliverAt != nil {
+			respondWithBadRequest(w, "broadcasts to an audience can't be scheduled")
+			return
+		}
+		if brodcastEvent.ExpiresAt != nil || brodcastEvent.TTL > 0 {
+			respondWithBadRequest(w, "broadcasts to an audience don't expire")
+			return
+		}
+		if brodcastEvent.Priority != PriorityNormal {
+			respondWithBadRequest(w, "broadcasts to an audience are of normal priority")
+			return
+		}
+		api.announceEvent(w, r, brodcastEvent)
+		return
+	}
+
+	if !IsValidBroadcastMode(brodcastEvent.Mode) {
+		respondWithBadRequest(w, fmt.Sprintf("%s is not a valid mode", brodcastEvent.Mode))
+		return
+	}
+
+	if async {
+		api.broadcastEventAsync(w, r, brodcastEvent)
+		return
+	}
+
+	if brodcastEvent.Mode == BroadcastModePartial {
+		api.broadcastEventPartially(w, r, brodcastEvent)
+		return
+	}
+
 	log.Printf("Receiving event to broadcast from source %s to %s destinations", brodcastEvent.SourceID, brodcastEvent.Destinations)
 
-	notifications, err := api.Broker.BroadcastEvent(brodcastEvent)
+	notifications, err := api.Broker.BroadcastEvent(r.Context(), brodcastEvent)
 	if err != nil {
-		respondWithInternalServerError(w, err.Error())
+		respondWithRepositoryError(w, err)
 		return
 	}
 
 	response := []broadcastEventResponse{}
+	notificationIDs := []uint{}
 	for _, notification := range notifications {
 		response = append(response, broadcastEventResponse{
+			DestinationID:  notification.DestinationID,
 			NotificationID: notification.ID,
 			EventID:        notification.EventID,
+			DeliverAt:      notification.DeliverAt,
+			Suppressed:     notification.Suppressed,
+		})
+		notificationIDs = append(notificationIDs, notification.ID)
+	}
+
+	recordAudit(api.Audit, r, AuditActionBroadcast, brodcastEvent.ID, map[string]interface{}{
+		"sourceID":        brodcastEvent.SourceID,
+		"destinations":    brodcastEvent.Destinations,
+		"notificationIDs": notificationIDs,
+	})
+
+	respondWithSuccess(w, response)
+}
+
+// broadcastEventPartially publishes an event to many destinations, each one succeeding or failing on its own. When
+// any of them fails, it responds with 207 Multi-Status, so the publisher knows to retry those only
+func (api *NotificationAPI) broadcastEventPartially(w http.ResponseWriter, r *http.Request, brodcastEvent BroadcastEvent) {
+	log.Printf("Receiving event to broadcast partially from source %s to %s destinations", brodcastEvent.SourceID, brodcastEvent.Destinations)
+
+	results := api.Broker.BroadcastEventPartially(r.Context(), brodcastEvent)
+
+	response := []broadcastEventResponse{}
+	notificationIDs := []uint{}
+	failedDestinations := []string{}
+	for _, result := range results {
+		if result.Err != nil {
+			response = append(response, broadcastEventResponse{
+				DestinationID: result.DestinationID,
+				Error:         result.Err.Error(),
+			})
+			failedDestinations = append(failedDestinations, result.DestinationID)
+			continue
+		}
+
+		response = append(response, broadcastEventResponse{
+			DestinationID:  result.DestinationID,
+			NotificationID: result.Notification.ID,
+			EventID:        result.Notification.EventID,
+			DeliverAt:      result.Notification.DeliverAt,
+			Suppressed:     result.Suppressed,
 		})
+		notificationIDs = append(notificationIDs, result.Notification.ID)
+	}
+
+	recordAudit(api.Audit, r, AuditActionBroadcast, brodcastEvent.ID, map[string]interface{}{
+		"sourceID":           brodcastEvent.SourceID,
+		"destinations":       brodcastEvent.Destinations,
+		"mode":               BroadcastModePartial,
+		"notificationIDs":    notificationIDs,
+		"failedDestinations": failedDestinations,
+	})
+
+	if len(failedDestinations) > 0 {
+		respondWithJSON(w, response, http.StatusMultiStatus)
+		return
+	}
+
+	respondWithSuccess(w, response)
+}
+
+// broadcastEventAsync publishes an event to many destinations in the background, as a job whose progress may be
+// checked later on. Destinations are handled in chunks, so it is always partial
+func (api *NotificationAPI) broadcastEventAsync(w http.ResponseWriter, r *http.Request, brodcastEvent BroadcastEvent) {
+	if brodcastEvent.Mode == BroadcastModeAtomic {
+		respondWithBadRequest(w, "broadcasts in the background are partial, they can't be atomic")
+		return
+	}
+
+	log.Printf("Receiving event to broadcast in the background from source %s to %d destinations", brodcastEvent.SourceID, len(brodcastEvent.Destinations))
+
+	job, err := api.Jobs.StartBroadcast(r.Context(), brodcastEvent)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	recordAudit(api.Audit, r, AuditActionBroadcast, brodcastEvent.ID, map[string]interface{}{
+		"sourceID":     brodcastEvent.SourceID,
+		"destinations": brodcastEvent.Destinations,
+		"mode":         BroadcastModePartial,
+		"jobID":        job.ID,
+	})
+
+	respondWithJSON(w, job, http.StatusAccepted)
+}
+
+type rescheduleEventRequest struct {
+	DeliverAt *time.Time `json:"deliverAt"`
+}
+
+type scheduleEventResponse struct {
+	EventID       string     `json:"eventID"`
+	Notifications int64      `json:"notifications"`
+	DeliverAt     *time.Time `json:"deliverAt,omitempty"`
+}
+
+// RescheduleEventHandler is the endpoint to change when the notifications of an event published for later are
+// delivered, as long as they are yet to be
+func (api *NotificationAPI) RescheduleEventHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID := vars["eventID"]
+
+	var request rescheduleEventRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+	if request.DeliverAt == nil {
+		respondWithBadRequest(w, "deliverAt must be given")
+		return
+	}
+
+	sourceID, err := publishingSource(r)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+	repository := api.Repository.ForTenant(authorizedTenant(r).ID)
+
+	rescheduled, err := repository.Reschedule(r.Context(), sourceID, eventID, *request.DeliverAt)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+	if rescheduled == 0 {
+		respondWithNotFound(w, fmt.Sprintf("there is nothing scheduled for event %s", eventID))
+		return
+	}
+
+	log.Printf("Rescheduling %d notifications of event %s to %s", rescheduled, eventID, request.DeliverAt)
+
+	recordAudit(api.Audit, r, AuditActionReschedule, eventID, map[string]interface{}{
+		"notifications": rescheduled,
+		"deliverAt":     request.DeliverAt,
+	})
+
+	response := scheduleEventResponse{
+		EventID:       eventID,
+		Notifications: rescheduled,
+		DeliverAt:     request.DeliverAt,
+	}
+
+	respondWithSuccess(w, response)
+}
+
+// CancelScheduledEventHandler is the endpoint to cancel the notifications of an event published for later, as long
+// as they are yet to be delivered. Whatever was already delivered stays so
+func (api *NotificationAPI) CancelScheduledEventHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID := vars["eventID"]
+
+	sourceID, err := publishingSource(r)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+	repository := api.Repository.ForTenant(authorizedTenant(r).ID)
+
+	canceled, err := repository.CancelScheduled(r.Context(), sourceID, eventID)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+	if canceled == 0 {
+		respondWithNotFound(w, fmt.Sprintf("there is nothing scheduled for event %s", eventID))
+		return
+	}
+
+	log.Printf("Canceling %d scheduled notifications of event %s", canceled, eventID)
+
+	recordAudit(api.Audit, r, AuditActionCancelScheduled, eventID, map[string]interface{}{
+		"notifications": canceled,
+	})
+
+	response := scheduleEventResponse{
+		EventID:       eventID,
+		Notifications: canceled,
+	}
+
+	respondWithSuccess(w, response)
+}
+
+// publishingSource tells on behalf of which source a publisher changes the events it published, i.e. the allowed
+// source of its API key, if it authenticated by one, or the sourceID query parameter otherwise
+func publishingSource(r *http.Request) (string, error) {
+	if apiKey, ok := authorizedAPIKey(r); ok {
+		return apiKey.SourceID, nil
+	}
+
+	sourceID := r.URL.Query().Get("sourceID")
+	if sourceID == "" {
+		return "", errors.New("sourceID must be given")
+	}
+	return sourceID, nil
+}
+
+type announceEventResponse struct {
+	BroadcastID uint   `json:"broadcastID"`
+	EventID     string `json:"eventID"`
+	Audience    string `json:"audience"`
+}
+
+// announceEvent publishes a broadcast to a whole audience, which is stored once regardless of its size
+func (api *NotificationAPI) announceEvent(w http.ResponseWriter, r *http.Request, brodcastEvent BroadcastEvent) {
+	if brodcastEvent.Audience != BroadcastAudienceAll {
+		respondWithBadRequest(w, fmt.Sprintf("%s is not a valid audience", brodcastEvent.Audience))
+		return
+	}
+	if len(brodcastEvent.Destinations) > 0 {
+		respondWithBadRequest(w, "either destinations or audience must be given, not both")
+		return
+	}
+
+	log.Printf("Receiving event to broadcast from source %s to audience %s", brodcastEvent.SourceID, brodcastEvent.Audience)
+
+	broadcast, err := api.Broker.AnnounceEvent(r.Context(), brodcastEvent)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	recordAudit(api.Audit, r, AuditActionBroadcast, broadcast.EventID, map[string]interface{}{
+		"sourceID":    broadcast.SourceID,
+		"audience":    brodcastEvent.Audience,
+		"broadcastID": broadcast.ID,
+	})
+
+	response := announceEventResponse{
+		BroadcastID: broadcast.ID,
+		EventID:     broadcast.EventID,
+		Audience:    brodcastEvent.Audience,
 	}
 
 	respondWithSuccess(w, response)
 }
 
+// checkEventSource makes sure that a publisher authenticated by API key only publishes on behalf of its
+// allowed source. When the event has no source, it is taken from the API key
+func checkEventSource(r *http.Request, sourceID *string) error {
+	apiKey, ok := authorizedAPIKey(r)
+	if !ok {
+		return nil
+	}
+
+	if *sourceID == "" {
+		*sourceID = apiKey.SourceID
+	}
+
+	if *sourceID != apiKey.SourceID {
+		return fmt.Errorf("API key is not allowed to publish on behalf of source %s", *sourceID)
+	}
+
+	return nil
+}
+
 type streamNotificationsResponse struct {
-	NotificationID uint   `json:"notificationID,omitempty"`
-	EventID        string `json:"eventID,omitempty"`
-	SourceID       string `json:"sourceID,omitempty"`
-	ClientID       string `json:"clientID,omitempty"`
-	Data           string `json:"data,omitempty"`
+	NotificationID uint     `json:"notificationID,omitempty"`
+	BroadcastID    uint     `json:"broadcastID,omitempty"`
+	EventID        string   `json:"eventID,omitempty"`
+	SourceID       string   `json:"sourceID,omitempty"`
+	ClientID       string   `json:"clientID,omitempty"`
+	Type           string   `json:"type,omitempty"`
+	Category       string   `json:"category,omitempty"`
+	Topic          string   `json:"topic,omitempty"`
+	Data           JSONData `json:"data,omitempty"`
+	CollapseKey    string   `json:"collapseKey,omitempty"`
+	Priority       Priority `json:"priority,omitempty"`
+
+	Rendered *RenderedNotification `json:"rendered,omitempty"`
 }
 
+// StreamEventNotificationUpdated is the stream event for an unread notification updated in place, which comes with
+// the same notification ID as before. New notifications come as plain messages
+const StreamEventNotificationUpdated = "notification.updated"
+
 // StreamNotificationsHandler is the endpoint for clients listening for notifications
 func (api *NotificationAPI) StreamNotificationsHandler(w http.ResponseWriter, r *http.Request) {
 	// Checks if SSE is possible
@@ -120,51 +533,85 @@ func (api *NotificationAPI) StreamNotificationsHandler(w http.ResponseWriter, r
 	// Registers client connection with the Broker
 	vars := mux.Vars(r)
 	clientID := vars["clientID"]
-	clientChan := make(chan Notification)
+	queue := api.Broker.NewOutboundQueue()
+	locales := requestLocales(r)
+
+	// The session is over when either the connection is gone or the Broker kills it
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	tokenID, _ := decodeJWTClaims(r)["jti"].(string)
 
 	client := Client{
-		ID:      clientID,
-		Channel: clientChan,
+		TenantID: authorizedTenant(r).ID,
+		ID:       clientID,
+		Queue:    queue,
+		TokenID:  tokenID,
+		Done:     ctx.Done(),
+		Kill:     cancel,
 	}
 
 	api.Broker.NotifyClientConnected(client)
 
+	// Headers go out once the session is registered, so that a client knows it is up to get notifications
+	flusher.Flush()
+
 	// Remove this client from the map of connected clients when this handler exits
 	defer func() {
 		api.Broker.NotifyClientDisconnected(client)
 	}()
 
-	// Unregisters closed connections
-	notifyClosed := r.Context().Done()
-	go func() {
-		<-notifyClosed
-		api.Broker.NotifyClientDisconnected(client)
-	}()
-
 	for {
-		// Get event for client
-		notification := <-clientChan
-
-		// Encode the event
-		response := streamNotificationsResponse{
-			NotificationID: notification.ID,
-			EventID:        notification.EventID,
-			SourceID:       notification.SourceID,
-			ClientID:       notification.DestinationID,
-			Data:           notification.Data,
-		}
-		jsonResponse, err := json.Marshal(&response)
-		if err != nil {
-			respondWithInternalServerError(w, err.Error())
+		// Wait for events for client
+		select {
+		case <-ctx.Done():
+			if r.Context().Err() == nil {
+				log.Printf("Stream of client %s was killed", clientID)
+			}
 			return
+		case <-queue.Ready():
 		}
 
-		// Send it
-		fmt.Fprintf(w, "data: %s\n\n", string(jsonResponse))
+		// Whatever is queued goes out by priority, one at a time, so that a more urgent one pushed in the meantime
+		// goes ahead of the rest. Templates are looked up once for all of it, yet a stream still gets to see them change
+		session := api.Renderer.Session(client.TenantID, locales)
+		for {
+			notification, ok := queue.Pop()
+			if !ok {
+				break
+			}
+
+			// Encode the event
+			response := streamNotificationsResponse{
+				NotificationID: notification.ID,
+				BroadcastID:    notification.BroadcastID,
+				EventID:        notification.EventID,
+				SourceID:       notification.SourceID,
+				ClientID:       notification.DestinationID,
+				Type:           notification.Type,
+				Category:       notification.Category,
+				Topic:          notification.Topic,
+				Data:           notification.Data,
+				CollapseKey:    notification.CollapseKey,
+				Priority:       notification.Priority,
+				Rendered:       render(ctx, session, notification),
+			}
+			jsonResponse, err := json.Marshal(&response)
+			if err != nil {
+				respondWithInternalServerError(w, err.Error())
+				return
+			}
+
+			// Send it
+			if notification.Updated {
+				fmt.Fprintf(w, "event: %s\n", StreamEventNotificationUpdated)
+			}
+			fmt.Fprintf(w, "data: %s\n\n", string(jsonResponse))
 
-		// Flush the data immediatly instead of buffering it for later
-		// so client receives it right on
-		flusher.Flush()
+			// Flush the data immediatly instead of buffering it for later
+			// so client receives it right on
+			flusher.Flush()
+		}
 	}
 }
 
@@ -185,40 +632,127 @@ func (api *NotificationAPI) GetNotificationsHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
+	priorities := map[Priority]bool{}
+	if r.FormValue("priority") != "" {
+		for _, name := range strings.Split(r.FormValue("priority"), ",") {
+			priority, err := ParsePriority(name)
+			if err != nil {
+				respondWithBadRequest(w, err.Error())
+				return
+			}
+			priorities[priority] = true
+		}
+	}
+
+	sortBy := r.FormValue("sort")
+	if !IsValidNotificationSort(sortBy) {
+		respondWithBadRequest(w, fmt.Sprintf("%s is not a valid sort", sortBy))
+		return
+	}
+
 	log.Printf("Getting notifications of client %s", clientID)
 
-	notifications, err := api.Repository.GetByStatus(clientID, status)
+	tenantID := authorizedTenant(r).ID
+
+	notifications, err := api.Repository.ForTenant(tenantID).GetByStatus(r.Context(), clientID, status)
 	if err != nil {
-		respondWithInternalServerError(w, err.Error())
+		respondWithRepositoryError(w, err)
 		return
 	}
 
+	broadcasts, err := api.Broadcasts.ForTenant(tenantID).GetForClient(r.Context(), clientID, status)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	session := api.Renderer.Session(tenantID, requestLocales(r))
+
 	response := notificationsResponse{
 		ClientID:      clientID,
 		Notifications: []notificationResponse{},
 	}
 	for _, notification := range notifications {
+		if len(priorities) > 0 && !priorities[notification.Priority] {
+			continue
+		}
 		response.Notifications = append(response.Notifications, notificationResponse{
 			NotificationID: notification.ID,
 			EventID:        notification.EventID,
 			SourceID:       notification.SourceID,
+			Type:           notification.Type,
+			Category:       notification.Category,
+			Topic:          notification.Topic,
 			Data:           notification.Data,
+			CollapseKey:    notification.CollapseKey,
+			Priority:       notification.Priority,
 			CreatedAt:      notification.CreatedAt,
 			ReadAt:         notification.ReadAt,
+			Rendered:       render(r.Context(), session, notification),
 		})
 	}
 
+	// Broadcasts to the whole tenant are merged with the client's own notifications, in the order they were created
+	// unless told otherwise. They are of normal priority
+	if len(priorities) == 0 || priorities[PriorityNormal] {
+		for _, broadcast := range broadcasts {
+			response.Notifications = append(response.Notifications, notificationResponse{
+				BroadcastID: broadcast.ID,
+				EventID:     broadcast.EventID,
+				SourceID:    broadcast.SourceID,
+				Type:        broadcast.Type,
+				Data:        broadcast.Data,
+				Priority:    PriorityNormal,
+				CreatedAt:   broadcast.CreatedAt,
+				ReadAt:      broadcast.ReadAt,
+				Rendered: render(r.Context(), session, Notification{
+					EventID:       broadcast.EventID,
+					SourceID:      broadcast.SourceID,
+					DestinationID: clientID,
+					Type:          broadcast.Type,
+					Data:          broadcast.Data,
+					CreatedAt:     broadcast.CreatedAt,
+				}),
+			})
+		}
+	}
+	sort.SliceStable(response.Notifications, func(i, j int) bool {
+		if sortBy == NotificationSortPriority && response.Notifications[i].Priority != response.Notifications[j].Priority {
+			return response.Notifications[i].Priority > response.Notifications[j].Priority
+		}
+		return response.Notifications[i].CreatedAt.Before(response.Notifications[j].CreatedAt)
+	})
+
 	respondWithSuccess(w, response)
 }
 
+// render a notification for a client, if there is a template for it. It is left as it is when rendering fails, since
+// it is still worth something without
+func render(ctx context.Context, session *RenderSession, notification Notification) *RenderedNotification {
+	rendered, err := session.Render(ctx, notification)
+	if err != nil {
+		log.Printf("Failed to render notification of event %s due to: %s", notification.EventID, err)
+		return nil
+	}
+	return rendered
+}
+
 type notificationResponse struct {
 	NotificationID uint       `json:"notificationID,omitempty"`
+	BroadcastID    uint       `json:"broadcastID,omitempty"`
 	EventID        string     `json:"eventID,omitempty"`
 	SourceID       string     `json:"sourceID,omitempty"`
 	ClientID       string     `json:"clientID,omitempty"`
-	Data           string     `json:"data,omitempty"`
+	Type           string     `json:"type,omitempty"`
+	Category       string     `json:"category,omitempty"`
+	Topic          string     `json:"topic,omitempty"`
+	Data           JSONData   `json:"data,omitempty"`
+	CollapseKey    string     `json:"collapseKey,omitempty"`
+	Priority       Priority   `json:"priority"`
 	CreatedAt      time.Time  `json:"createdAt,omitempty"`
 	ReadAt         *time.Time `json:"readAt,omitempty"`
+
+	Rendered *RenderedNotification `json:"rendered,omitempty"`
 }
 
 // GetNotificationHandler responds with a event notification by its id
@@ -229,13 +763,15 @@ func (api *NotificationAPI) GetNotificationHandler(w http.ResponseWriter, r *htt
 
 	log.Printf("Getting notification %d of client %s", notificationID, clientID)
 
-	notification, err := api.Repository.Get(uint(notificationID))
+	repository := api.Repository.ForTenant(authorizedTenant(r).ID)
+
+	notification, err := repository.Get(r.Context(), uint(notificationID))
 	if err != nil {
 		if errors.Is(err, ErrNotificationNotFound) {
 			respondWithNotFound(w, err.Error())
 			return
 		}
-		respondWithInternalServerError(w, err.Error())
+		respondWithRepositoryError(w, err)
 		return
 	}
 
@@ -243,9 +779,15 @@ func (api *NotificationAPI) GetNotificationHandler(w http.ResponseWriter, r *htt
 		NotificationID: notification.ID,
 		EventID:        notification.EventID,
 		SourceID:       notification.SourceID,
+		Type:           notification.Type,
+		Category:       notification.Category,
+		Topic:          notification.Topic,
 		Data:           notification.Data,
+		CollapseKey:    notification.CollapseKey,
+		Priority:       notification.Priority,
 		CreatedAt:      notification.CreatedAt,
 		ReadAt:         notification.ReadAt,
+		Rendered:       render(r.Context(), api.Renderer.Session(authorizedTenant(r).ID, requestLocales(r)), notification),
 	}
 
 	respondWithSuccess(w, response)
@@ -261,13 +803,15 @@ func (api *NotificationAPI) MarkNotificationReadHandler(w http.ResponseWriter, r
 	clientID := vars["clientID"]
 	notificationID, _ := strconv.Atoi(vars["notificationID"])
 
-	notification, err := api.Repository.Get(uint(notificationID))
+	repository := api.Repository.ForTenant(authorizedTenant(r).ID)
+
+	notification, err := repository.Get(r.Context(), uint(notificationID))
 	if err != nil {
 		if errors.Is(err, ErrNotificationNotFound) {
 			respondWithNotFound(w, err.Error())
 			return
 		}
-		respondWithInternalServerError(w, err.Error())
+		respondWithRepositoryError(w, err)
 		return
 	}
 
@@ -275,14 +819,18 @@ func (api *NotificationAPI) MarkNotificationReadHandler(w http.ResponseWriter, r
 	readAt := time.Now()
 	notification.ReadAt = &readAt
 
-	err = api.Repository.Update(&notification)
+	err = repository.Update(r.Context(), &notification)
 	if err != nil {
-		respondWithInternalServerError(w, err.Error())
+		respondWithRepositoryError(w, err)
 		return
 	}
 
 	log.Printf("Marking notification %d of client %s as read", notificationID, clientID)
 
+	recordAudit(api.Audit, r, AuditActionMarkRead, strconv.Itoa(notificationID), map[string]interface{}{
+		"clientID": clientID,
+	})
+
 	response := changeNotificationStatusResponse{
 		Status: "read",
 	}
@@ -296,30 +844,844 @@ func (api *NotificationAPI) MarkNotificationUnreadHandler(w http.ResponseWriter,
 	clientID := vars["clientID"]
 	notificationID, _ := strconv.Atoi(vars["notificationID"])
 
-	notification, err := api.Repository.Get(uint(notificationID))
+	repository := api.Repository.ForTenant(authorizedTenant(r).ID)
+
+	notification, err := repository.Get(r.Context(), uint(notificationID))
 	if err != nil {
 		if errors.Is(err, ErrNotificationNotFound) {
 			respondWithNotFound(w, err.Error())
 			return
 		}
-		respondWithInternalServerError(w, err.Error())
+		respondWithRepositoryError(w, err)
 		return
 	}
 
 	// A read notification is simply one that does not have a read time
 	notification.ReadAt = nil
 
-	err = api.Repository.Update(&notification)
+	err = repository.Update(r.Context(), &notification)
 	if err != nil {
-		respondWithInternalServerError(w, err.Error())
+		respondWithRepositoryError(w, err)
 		return
 	}
 
 	log.Printf("Marking notification %d of client %s as unread", notificationID, clientID)
 
+	recordAudit(api.Audit, r, AuditActionMarkUnread, strconv.Itoa(notificationID), map[string]interface{}{
+		"clientID": clientID,
+	})
+
 	response := changeNotificationStatusResponse{
 		Status: "unread",
 	}
 
 	respondWithSuccess(w, response)
 }
+
+// MarkBroadcastReadHandler changes the status of a broadcast to read, for one client only
+func (api *NotificationAPI) MarkBroadcastReadHandler(w http.ResponseWriter, r *http.Request) {
+	api.changeBroadcastReceipt(w, r, AuditActionMarkBroadcastRead, "read", func(receipt *BroadcastReceipt) {
+		readAt := time.Now()
+		receipt.ReadAt = &readAt
+	})
+}
+
+// MarkBroadcastUnreadHandler changes the status of a broadcast to unread, for one client only
+func (api *NotificationAPI) MarkBroadcastUnreadHandler(w http.ResponseWriter, r *http.Request) {
+	api.changeBroadcastReceipt(w, r, AuditActionMarkBroadcastUnread, "unread", func(receipt *BroadcastReceipt) {
+		receipt.ReadAt = nil
+	})
+}
+
+// DismissBroadcastHandler hides a broadcast from one client for good
+func (api *NotificationAPI) DismissBroadcastHandler(w http.ResponseWriter, r *http.Request) {
+	api.changeBroadcastReceipt(w, r, AuditActionDismissBroadcast, "dismissed", func(receipt *BroadcastReceipt) {
+		dismissedAt := time.Now()
+		receipt.DismissedAt = &dismissedAt
+	})
+}
+
+// changeBroadcastReceipt applies a change to the client's receipt of a broadcast, which is created on the first change
+func (api *NotificationAPI) changeBroadcastReceipt(w http.ResponseWriter, r *http.Request, action string, status string, change func(receipt *BroadcastReceipt)) {
+	vars := mux.Vars(r)
+	clientID := vars["clientID"]
+	broadcastID, _ := strconv.Atoi(vars["broadcastID"])
+
+	broadcasts := api.Broadcasts.ForTenant(authorizedTenant(r).ID)
+
+	_, err := broadcasts.Get(r.Context(), uint(broadcastID))
+	if err != nil {
+		if errors.Is(err, ErrBroadcastNotFound) {
+			respondWithNotFound(w, err.Error())
+			return
+		}
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	receipt, err := broadcasts.GetReceipt(r.Context(), uint(broadcastID), clientID)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	change(&receipt)
+
+	err = broadcasts.SaveReceipt(r.Context(), &receipt)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	log.Printf("Marking broadcast %d of client %s as %s", broadcastID, clientID, status)
+
+	recordAudit(api.Audit, r, action, strconv.Itoa(broadcastID), map[string]interface{}{
+		"clientID": clientID,
+	})
+
+	response := changeNotificationStatusResponse{
+		Status: status,
+	}
+
+	respondWithSuccess(w, response)
+}
+
+type subscriptionsResponse struct {
+	ClientID      string              `json:"clientID"`
+	Subscriptions []TopicSubscription `json:"subscriptions"`
+}
+
+// GetSubscriptionsHandler responds with the topics a given client is subscribed to
+func (api *NotificationAPI) GetSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID := vars["clientID"]
+
+	subscriptions, err := api.Topics.ForTenant(authorizedTenant(r).ID).GetAll(r.Context(), clientID)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	response := subscriptionsResponse{
+		ClientID:      clientID,
+		Subscriptions: subscriptions,
+	}
+
+	respondWithSuccess(w, response)
+}
+
+// SubscribeHandler is the endpoint for a given client to subscribe to a topic, whether it already was or not
+func (api *NotificationAPI) SubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID := vars["clientID"]
+	topic := vars["topic"]
+
+	err := checkTopic(topic)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	subscription := TopicSubscription{
+		ClientID: clientID,
+		Topic:    topic,
+	}
+
+	err = api.Topics.ForTenant(authorizedTenant(r).ID).Subscribe(r.Context(), &subscription)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	log.Printf("Subscribing client %s to topic %s", clientID, topic)
+
+	recordAudit(api.Audit, r, AuditActionSubscribe, clientID, map[string]interface{}{
+		"topic": topic,
+	})
+
+	respondWithSuccess(w, subscription)
+}
+
+// UnsubscribeHandler is the endpoint for a given client to unsubscribe from a topic
+func (api *NotificationAPI) UnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID := vars["clientID"]
+	topic := vars["topic"]
+
+	subscription, err := api.Topics.ForTenant(authorizedTenant(r).ID).Unsubscribe(r.Context(), clientID, topic)
+	if err != nil {
+		if errors.Is(err, ErrTopicSubscriptionNotFound) {
+			respondWithNotFound(w, err.Error())
+			return
+		}
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	log.Printf("Unsubscribing client %s from topic %s", clientID, topic)
+
+	recordAudit(api.Audit, r, AuditActionUnsubscribe, clientID, map[string]interface{}{
+		"topic": topic,
+	})
+
+	respondWithSuccess(w, subscription)
+}
+
+type preferencesResponse struct {
+	ClientID    string             `json:"clientID"`
+	Preferences []ClientPreference `json:"preferences"`
+}
+
+// GetPreferencesHandler responds with the preferences of a given client
+func (api *NotificationAPI) GetPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID := vars["clientID"]
+
+	preferences, err := api.Preferences.ForTenant(authorizedTenant(r).ID).GetAll(r.Context(), clientID)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	response := preferencesResponse{
+		ClientID:    clientID,
+		Preferences: preferences,
+	}
+
+	respondWithSuccess(w, response)
+}
+
+// preferenceRequest is one preference of a client, whose channels are enabled unless told otherwise
+type preferenceRequest struct {
+	Scope   string `json:"scope"`
+	Key     string `json:"key"`
+	Stream  *bool  `json:"stream"`
+	Inbox   *bool  `json:"inbox"`
+	Email   *bool  `json:"email"`
+	Webhook *bool  `json:"webhook"`
+}
+
+type updatePreferencesRequest struct {
+	Preferences []preferenceRequest `json:"preferences"`
+}
+
+// UpdatePreferencesHandler is the endpoint to replace every preference of a given client
+func (api *NotificationAPI) UpdatePreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID := vars["clientID"]
+
+	var request updatePreferencesRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	enabled := func(channel *bool) bool {
+		return channel == nil || *channel
+	}
+
+	preferences := []ClientPreference{}
+	seen := map[string]bool{}
+	for _, requested := range request.Preferences {
+		preference, err := NewClientPreference(requested.Scope, requested.Key, Channels{
+			Stream:  enabled(requested.Stream),
+			Inbox:   enabled(requested.Inbox),
+			Email:   enabled(requested.Email),
+			Webhook: enabled(requested.Webhook),
+		})
+		if err != nil {
+			respondWithBadRequest(w, err.Error())
+			return
+		}
+
+		if seen[preference.Scope+"/"+preference.Key] {
+			respondWithBadRequest(w, fmt.Sprintf("preference on %s %s is given more than once", preference.Scope, preference.Key))
+			return
+		}
+		seen[preference.Scope+"/"+preference.Key] = true
+
+		preferences = append(preferences, *preference)
+	}
+
+	repository := api.Preferences.ForTenant(authorizedTenant(r).ID)
+
+	err = repository.Replace(r.Context(), clientID, preferences)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	log.Printf("Replacing preferences of client %s (%d preferences)", clientID, len(preferences))
+
+	recordAudit(api.Audit, r, AuditActionUpdatePreferences, clientID, map[string]interface{}{
+		"preferences": preferences,
+	})
+
+	saved, err := repository.GetAll(r.Context(), clientID)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	response := preferencesResponse{
+		ClientID:    clientID,
+		Preferences: saved,
+	}
+
+	respondWithSuccess(w, response)
+}
+
+type quietTimeResponse struct {
+	ClientSettings
+	QuietUntil *time.Time `json:"quietUntil,omitempty"`
+}
+
+// GetQuietHoursHandler responds with the quiet hours of a given client, whether it snoozed, and when its quiet time
+// is over if it is quiet right now
+func (api *NotificationAPI) GetQuietHoursHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID := vars["clientID"]
+
+	settings, err := api.clientSettings(r, clientID)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	response := quietTimeResponse{
+		ClientSettings: settings,
+		QuietUntil:     settings.QuietUntil(time.Now()),
+	}
+
+	respondWithSuccess(w, response)
+}
+
+type updateQuietHoursRequest struct {
+	TimeZone string `json:"timeZone"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Release  string `json:"release"`
+}
+
+// UpdateQuietHoursHandler is the endpoint to change the quiet hours of a given client, or to do away with them
+func (api *NotificationAPI) UpdateQuietHoursHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID := vars["clientID"]
+
+	var request updateQuietHoursRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	settings, err := api.clientSettings(r, clientID)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	err = settings.SetQuietHours(request.TimeZone, request.Start, request.End, request.Release)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	api.saveClientSettings(w, r, settings, AuditActionUpdateQuietHours, map[string]interface{}{
+		"timeZone": settings.TimeZone,
+		"start":    settings.QuietStart,
+		"end":      settings.QuietEnd,
+		"release":  settings.QuietRelease,
+	})
+}
+
+type snoozeRequest struct {
+	Duration string     `json:"duration"`
+	Until    *time.Time `json:"until"`
+}
+
+// SnoozeHandler is the endpoint for a given client to snooze every notification, but urgent ones, either for a while
+// (e.g. 2h) or until a given time
+func (api *NotificationAPI) SnoozeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID := vars["clientID"]
+
+	var request snoozeRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	var until time.Time
+	switch {
+	case request.Duration != "" && request.Until != nil:
+		respondWithBadRequest(w, "either duration or until must be given, not both")
+		return
+	case request.Duration != "":
+		duration, err := time.ParseDuration(request.Duration)
+		if err != nil || duration <= 0 {
+			respondWithBadRequest(w, fmt.Sprintf("%s is not a valid duration", request.Duration))
+			return
+		}
+		until = time.Now().Add(duration)
+	case request.Until != nil:
+		until = *request.Until
+	default:
+		respondWithBadRequest(w, "either duration or until must be given")
+		return
+	}
+	if !until.After(time.Now()) {
+		respondWithBadRequest(w, "until must be in the future")
+		return
+	}
+
+	settings, err := api.clientSettings(r, clientID)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	settings.SnoozedUntil = &until
+
+	api.saveClientSettings(w, r, settings, AuditActionSnooze, map[string]interface{}{
+		"until": until.UTC(),
+	})
+}
+
+// UnsnoozeHandler is the endpoint for a given client to be done snoozing, which releases whatever was held back unless
+// it is in its quiet hours
+func (api *NotificationAPI) UnsnoozeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID := vars["clientID"]
+
+	settings, err := api.clientSettings(r, clientID)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	settings.SnoozedUntil = nil
+
+	api.saveClientSettings(w, r, settings, AuditActionUnsnooze, map[string]interface{}{})
+}
+
+// clientSettings of a client, which are the default ones when it has none
+func (api *NotificationAPI) clientSettings(r *http.Request, clientID string) (ClientSettings, error) {
+	settings, err := api.Settings.ForTenant(authorizedTenant(r).ID).Get(r.Context(), clientID)
+	if errors.Is(err, ErrClientSettingsNotFound) {
+		return *NewClientSettings(clientID), nil
+	}
+
+	return settings, err
+}
+
+// saveClientSettings of a client, releasing the notifications held back from it as soon as they are due as per the
+// changed settings, and responds with them
+func (api *NotificationAPI) saveClientSettings(w http.ResponseWriter, r *http.Request, settings ClientSettings, action string, details map[string]interface{}) {
+	tenantID := authorizedTenant(r).ID
+
+	err := api.Settings.ForTenant(tenantID).Save(r.Context(), &settings)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	err = api.Broker.ApplyClientSettings(r.Context(), tenantID, settings)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	log.Printf("Changing quiet time of client %s", settings.ClientID)
+
+	recordAudit(api.Audit, r, action, settings.ClientID, details)
+
+	response := quietTimeResponse{
+		ClientSettings: settings,
+		QuietUntil:     settings.QuietUntil(time.Now()),
+	}
+
+	respondWithSuccess(w, response)
+}
+
+type digestsResponse struct {
+	ClientID string         `json:"clientID"`
+	Digests  []ClientDigest `json:"digests"`
+}
+
+// GetDigestsHandler responds with the digests of a given client
+func (api *NotificationAPI) GetDigestsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID := vars["clientID"]
+
+	digests, err := api.Digests.ForTenant(authorizedTenant(r).ID).GetAll(r.Context(), clientID)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	response := digestsResponse{
+		ClientID: clientID,
+		Digests:  digests,
+	}
+
+	respondWithSuccess(w, response)
+}
+
+// digestRequest is one digest of a client, how often it gets a category summed up in seconds
+type digestRequest struct {
+	Category string `json:"category"`
+	Interval int    `json:"interval"`
+}
+
+type updateDigestsRequest struct {
+	Digests []digestRequest `json:"digests"`
+}
+
+// UpdateDigestsHandler is the endpoint to replace every digest of a given client. Whatever was accumulated for the
+// ones it had goes out right away
+func (api *NotificationAPI) UpdateDigestsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID := vars["clientID"]
+
+	var request updateDigestsRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	digests := []ClientDigest{}
+	seen := map[string]bool{}
+	for _, requested := range request.Digests {
+		digest, err := NewClientDigest(requested.Category, requested.Interval)
+		if err != nil {
+			respondWithBadRequest(w, err.Error())
+			return
+		}
+
+		if seen[digest.Category] {
+			respondWithBadRequest(w, fmt.Sprintf("digest of category %s is given more than once", digest.Category))
+			return
+		}
+		seen[digest.Category] = true
+
+		digests = append(digests, *digest)
+	}
+
+	tenantID := authorizedTenant(r).ID
+	repository := api.Digests.ForTenant(tenantID)
+
+	err = repository.Replace(r.Context(), clientID, digests)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	err = api.Broker.ApplyClientDigests(r.Context(), tenantID, clientID)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	log.Printf("Replacing digests of client %s (%d digests)", clientID, len(digests))
+
+	recordAudit(api.Audit, r, AuditActionUpdateDigests, clientID, map[string]interface{}{
+		"digests": digests,
+	})
+
+	saved, err := repository.GetAll(r.Context(), clientID)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	response := digestsResponse{
+		ClientID: clientID,
+		Digests:  saved,
+	}
+
+	respondWithSuccess(w, response)
+}
+
+// GetJobHandler is the endpoint to check on the progress of a broadcast job of the publisher's source
+func (api *NotificationAPI) GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobID, _ := strconv.Atoi(vars["jobID"])
+
+	sourceID, err := publishingSource(r)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	job, err := api.Jobs.Get(r.Context(), authorizedTenant(r).ID, uint(jobID))
+	if err == nil && job.SourceID != sourceID {
+		err = ErrJobNotFound
+	}
+	if err != nil {
+		if errors.Is(err, ErrJobNotFound) {
+			respondWithNotFound(w, err.Error())
+			return
+		}
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	respondWithSuccess(w, job)
+}
+
+// CancelJobHandler is the endpoint to cancel a broadcast job. Whatever was already persisted stays so
+func (api *NotificationAPI) CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobID, _ := strconv.Atoi(vars["jobID"])
+	tenantID := authorizedTenant(r).ID
+
+	sourceID, err := publishingSource(r)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	job, err := api.Jobs.Get(r.Context(), tenantID, uint(jobID))
+	if err == nil && job.SourceID != sourceID {
+		err = ErrJobNotFound
+	}
+	if err == nil {
+		job, err = api.Jobs.Cancel(r.Context(), tenantID, uint(jobID))
+	}
+	if err != nil {
+		if errors.Is(err, ErrJobNotFound) {
+			respondWithNotFound(w, err.Error())
+			return
+		}
+		if errors.Is(err, ErrJobFinished) {
+			respondWithError(w, fmt.Sprintf("job is already %s", job.Status), http.StatusConflict)
+			return
+		}
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	log.Printf("Canceling job %d at %d of %d destinations", jobID, job.Persisted+job.Failed, job.Total)
+
+	recordAudit(api.Audit, r, AuditActionCancelJob, strconv.Itoa(jobID), map[string]interface{}{
+		"sourceID":  job.SourceID,
+		"persisted": job.Persisted,
+		"failed":    job.Failed,
+	})
+
+	respondWithSuccess(w, job)
+}
+
+type scheduleRequest struct {
+	SourceID      string   `json:"sourceID,omitempty"`
+	Cron          string   `json:"cron"`
+	TimeZone      string   `json:"timeZone,omitempty"`
+	DestinationID string   `json:"destinationID,omitempty"`
+	Audience      string   `json:"audience,omitempty"`
+	Topic         string   `json:"topic,omitempty"`
+	Data          JSONData `json:"data,omitempty"`
+	MisfirePolicy string   `json:"misfirePolicy,omitempty"`
+}
+
+// decodeScheduleRequest into what a recurring schedule publishes and when, planning its next fire time
+func decodeScheduleRequest(w http.ResponseWriter, r *http.Request, schedule *RecurringSchedule) bool {
+	var request scheduleRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return false
+	}
+
+	// Changes keep the source of a schedule as long as none is given
+	if request.SourceID == "" {
+		request.SourceID = schedule.SourceID
+	}
+
+	err = checkEventSource(r, &request.SourceID)
+	if err != nil {
+		respondWithForbidden(w, err.Error())
+		return false
+	}
+	if schedule.ID != 0 && request.SourceID != schedule.SourceID {
+		respondWithBadRequest(w, "source of a schedule can't be changed")
+		return false
+	}
+
+	tenant := authorizedTenant(r)
+	if tenant.MaxDataSize > 0 && len(request.Data) > tenant.MaxDataSize {
+		respondWithError(w, fmt.Sprintf("data is larger than %d bytes", tenant.MaxDataSize), http.StatusRequestEntityTooLarge)
+		return false
+	}
+
+	schedule.SourceID = request.SourceID
+	schedule.Cron = request.Cron
+	schedule.TimeZone = request.TimeZone
+	schedule.DestinationID = request.DestinationID
+	schedule.Audience = request.Audience
+	schedule.Topic = request.Topic
+	schedule.Data = request.Data
+	schedule.MisfirePolicy = request.MisfirePolicy
+
+	err = schedule.Plan(time.Now())
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return false
+	}
+
+	return true
+}
+
+// CreateScheduleHandler is the endpoint to publish an event over and over, as per a cron expression
+func (api *NotificationAPI) CreateScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	var schedule RecurringSchedule
+	if !decodeScheduleRequest(w, r, &schedule) {
+		return
+	}
+
+	err := api.Schedules.ForTenant(authorizedTenant(r).ID).Add(r.Context(), &schedule)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	log.Printf("Creating recurring schedule %d from source %s, next fire at %s", schedule.ID, schedule.SourceID, schedule.NextFireAt)
+
+	recordAudit(api.Audit, r, AuditActionCreateSchedule, strconv.Itoa(int(schedule.ID)), map[string]interface{}{
+		"sourceID":      schedule.SourceID,
+		"cron":          schedule.Cron,
+		"timeZone":      schedule.TimeZone,
+		"destinationID": schedule.DestinationID,
+		"audience":      schedule.Audience,
+	})
+
+	respondWithCreated(w, schedule)
+}
+
+// GetSchedulesHandler is the endpoint to list the recurring schedules of a source
+func (api *NotificationAPI) GetSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	sourceID, err := publishingSource(r)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return
+	}
+
+	schedules, err := api.Schedules.ForTenant(authorizedTenant(r).ID).GetAll(r.Context(), sourceID)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	respondWithSuccess(w, schedules)
+}
+
+// GetScheduleHandler is the endpoint to get a recurring schedule, e.g. to check when it fires next
+func (api *NotificationAPI) GetScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := api.getSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	respondWithSuccess(w, schedule)
+}
+
+// UpdateScheduleHandler is the endpoint to change what a recurring schedule publishes and when. It goes on from now
+func (api *NotificationAPI) UpdateScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := api.getSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	if !decodeScheduleRequest(w, r, &schedule) {
+		return
+	}
+
+	updated, err := api.Schedules.ForTenant(schedule.TenantID).Update(r.Context(), &schedule)
+	if err != nil {
+		respondWithRepositoryError(w, err)
+		return
+	}
+	if !updated {
+		respondWithError(w, "schedule was changed in the meantime, try again", http.StatusConflict)
+		return
+	}
+
+	log.Printf("Updating recurring schedule %d, next fire at %s", schedule.ID, schedule.NextFireAt)
+
+	recordAudit(api.Audit, r, AuditActionUpdateSchedule, strconv.Itoa(int(schedule.ID)), map[string]interface{}{
+		"sourceID":      schedule.SourceID,
+		"cron":          schedule.Cron,
+		"timeZone":      schedule.TimeZone,
+		"destinationID": schedule.DestinationID,
+		"audience":      schedule.Audience,
+	})
+
+	respondWithSuccess(w, schedule)
+}
+
+// DeleteScheduleHandler is the endpoint to stop a recurring schedule for good. Whatever it published stays so
+func (api *NotificationAPI) DeleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := api.getSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	err := api.Schedules.ForTenant(schedule.TenantID).Delete(r.Context(), schedule.ID)
+	if err != nil {
+		if errors.Is(err, ErrRecurringScheduleNotFound) {
+			respondWithNotFound(w, err.Error())
+			return
+		}
+		respondWithRepositoryError(w, err)
+		return
+	}
+
+	log.Printf("Deleting recurring schedule %d", schedule.ID)
+
+	recordAudit(api.Audit, r, AuditActionDeleteSchedule, strconv.Itoa(int(schedule.ID)), map[string]interface{}{
+		"sourceID": schedule.SourceID,
+	})
+
+	respondWithSuccess(w, schedule)
+}
+
+// getSchedule of the request, as long as it is of the source the publisher acts on behalf of. Otherwise it responds
+// as if there was none
+func (api *NotificationAPI) getSchedule(w http.ResponseWriter, r *http.Request) (RecurringSchedule, bool) {
+	vars := mux.Vars(r)
+	scheduleID, _ := strconv.Atoi(vars["scheduleID"])
+
+	sourceID, err := publishingSource(r)
+	if err != nil {
+		respondWithBadRequest(w, err.Error())
+		return RecurringSchedule{}, false
+	}
+
+	schedule, err := api.Schedules.ForTenant(authorizedTenant(r).ID).Get(r.Context(), uint(scheduleID))
+	if err == nil && schedule.SourceID != sourceID {
+		err = ErrRecurringScheduleNotFound
+	}
+	if err != nil {
+		if errors.Is(err, ErrRecurringScheduleNotFound) {
+			respondWithNotFound(w, err.Error())
+			return RecurringSchedule{}, false
+		}
+		respondWithRepositoryError(w, err)
+		return RecurringSchedule{}, false
+	}
+
+	return schedule, true
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	jwt "github.com/form3tech-oss/jwt-go"
 	"github.com/gorilla/mux"
+	"github.com/urfave/negroni"
+	"gorm.io/gorm"
 )
 
 const (
@@ -19,9 +25,10 @@ const (
 )
 
 var (
-	jwtAuth JWTAuthMiddleware
-	broker  *Broker
-	api     NotificationAPI
+	jwtAuth  JWTAuthMiddleware
+	broker   *Broker
+	api      NotificationAPI
+	adminAPI AdminAPI
 )
 
 // Setup
@@ -29,9 +36,7 @@ var (
 
 func TestMain(m *testing.M) {
 	setup()
-	code := m.Run()
-	shutdown()
-	os.Exit(code)
+	os.Exit(m.Run())
 }
 
 func setup() {
@@ -39,8 +44,6 @@ func setup() {
 
 	LoadEnvironmentVars()
 
-	deleteTestDatabase()
-
 	// Gets the broker entity up & running
 	mercurio, err := NewMercurio()
 	if err != nil {
@@ -49,12 +52,12 @@ func setup() {
 
 	jwtAuth = mercurio.JWTAuth
 	api = mercurio.API
+	adminAPI = mercurio.AdminAPI
 	broker = mercurio.Broker
 	broker.Run()
-}
-
-func shutdown() {
-	deleteTestDatabase()
+	mercurio.Jobs.Run()
+	mercurio.Recurring.Run()
+	mercurio.Purger.Run()
 }
 
 // General helpers
@@ -72,12 +75,25 @@ func ensureEnvironmentVars() {
 	fmt.Printf(">> environment '%s' loaded from '%s'\n", os.Getenv("MERCURIO_ENV"), os.Getenv("MERCURIO_ENV_DIR"))
 }
 
-func deleteTestDatabase() {
-	databasePath, err := GetDatabaseConnectionString()
+// newTestSqliteDatabase gives a brand new SQLite database of the test, with every migration applied, which is gone
+// along with the test
+func newTestSqliteDatabase(t testing.TB) *gorm.DB {
+	db, err := ConnectSqliteDatabase(filepath.Join(t.TempDir(), "mercurio.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrator, err := NewMigrator(db, DatabaseDriverSqlite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = migrator.Up()
 	if err != nil {
-		panic(fmt.Sprintf("failed to delete test database '%s' due to: %s", databasePath, err.Error()))
+		t.Fatal(err)
 	}
-	os.Remove(databasePath)
+
+	return db
 }
 
 // HTTP req/res helpers
@@ -263,3 +279,1290 @@ func TestHappyPathForUser123(t *testing.T) {
 	object = unmarshalBodyContent(t, rr)
 	assertBodyContent(t, rr, `{"status":"unread"}`)
 }
+
+func TestUnicastEventHandler_WithAPIKey(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/unicast", jwtAuth.Secure(api.UnicastEventHandler).ServeHTTP)
+
+	apiKey, plainKey, err := NewAPIKey("", "test", "backend", []string{ScopeEventsUnicast})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = adminAPI.APIKeys.Add(apiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1- Publishes on behalf of the allowed source
+	payload := `{"sourceID":"backend","destinationID":"123","data":"published with an API key"}`
+	r, _ := http.NewRequest("POST", baseEventsURL+"/unicast", strings.NewReader(payload))
+	r.Header.Add(APIKeyHeader, plainKey)
+	rr := serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+
+	// 2- Publishes on behalf of another source
+	payload = `{"sourceID":"someone-else","destinationID":"123","data":"published with an API key"}`
+	r, _ = http.NewRequest("POST", baseEventsURL+"/unicast", strings.NewReader(payload))
+	r.Header.Add(APIKeyHeader, plainKey)
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusForbidden)
+
+	// 3- Last usage is tracked
+	touched, err := adminAPI.APIKeys.Get(apiKey.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if touched.LastUsedAt == nil {
+		t.Errorf("API key last usage was not tracked")
+	}
+
+	// 4- Publishes with a revoked key
+	_, err = RevokeAPIKey(adminAPI.APIKeys, apiKey.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload = `{"sourceID":"backend","destinationID":"123","data":"published with an API key"}`
+	r, _ = http.NewRequest("POST", baseEventsURL+"/unicast", strings.NewReader(payload))
+	r.Header.Add(APIKeyHeader, plainKey)
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusUnauthorized)
+}
+
+func TestCreateRevocationHandler_ShouldBlockTokenAndKillStream(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc("/api/admin/revocations", jwtAuth.SecureAdmin(adminAPI.CreateRevocationHandler).ServeHTTP).Methods("POST")
+	rt.HandleFunc(baseNotificationsURL+"/stream", jwtAuth.Secure(api.StreamNotificationsHandler).ServeHTTP)
+	rt.HandleFunc(baseNotificationsURL, jwtAuth.Secure(api.GetNotificationsHandler).ServeHTTP)
+
+	server := httptest.NewServer(rt)
+	t.Cleanup(server.Close)
+
+	baseNotificationsURL456 := strings.Replace(baseNotificationsURL, "{clientID}", "456", 1)
+
+	// 1- Opens a stream for user 456
+	streamClosed := openTestStream(t, server, "456", os.Getenv("TEST_TOKEN_USER_456"))
+
+	// 2- Only admins are allowed to revoke tokens
+	payload := `{"subject":"456","reason":"compromised"}`
+	r, _ := http.NewRequest("POST", "/api/admin/revocations", strings.NewReader(payload))
+	addUserAuthorization(r, "123")
+	rr := serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusForbidden)
+
+	// 3- Revokes every token of user 456
+	r = createPublisherRequest(t, "POST", "/api/admin/revocations", strings.NewReader(payload))
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusCreated)
+
+	// 4- Stream is killed
+	select {
+	case <-streamClosed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream of client 456 was not killed")
+	}
+
+	// 5- Token can't be used anymore
+	r, _ = http.NewRequest("GET", baseNotificationsURL456, nil)
+	addUserAuthorization(r, "456")
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusUnauthorized)
+}
+
+func TestCreateRevocationHandler_ShouldKillStreamOfToken(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc("/api/admin/revocations", jwtAuth.SecureAdmin(adminAPI.CreateRevocationHandler).ServeHTTP).Methods("POST")
+	rt.HandleFunc(baseNotificationsURL+"/stream", jwtAuth.Secure(api.StreamNotificationsHandler).ServeHTTP)
+
+	server := httptest.NewServer(rt)
+	t.Cleanup(server.Close)
+
+	// 1- Opens a stream for two users, each one with a token of its own
+	revokedToken := signTestToken(t, jwt.MapClaims{"user_id": "5201", "jti": "5201-compromised"})
+	revokedStreamClosed := openTestStream(t, server, "5201", revokedToken)
+	keptStreamClosed := openTestStream(t, server, "5202", signTestToken(t, jwt.MapClaims{"user_id": "5202", "jti": "5202-fine"}))
+
+	// 2- Revokes the token of one of them
+	r := createPublisherRequest(t, "POST", "/api/admin/revocations", strings.NewReader(`{"jti":"5201-compromised","reason":"compromised"}`))
+	rr := serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusCreated)
+
+	// 3- Only the stream opened with it is killed
+	select {
+	case <-revokedStreamClosed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream of client 5201 was not killed")
+	}
+
+	select {
+	case <-keptStreamClosed:
+		t.Fatal("stream of client 5202 was killed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// 4- Revoked token can't be used anymore
+	r, _ = http.NewRequest("GET", server.URL+"/api/clients/5201/notifications/stream", nil)
+	r.Header.Add("Authorization", "Bearer "+revokedToken)
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusUnauthorized)
+}
+
+// openTestStream of a client through a test server, which is up to get notifications as soon as it is given back.
+// The channel is closed once the server ends the stream, if not the test itself
+func openTestStream(t *testing.T, server *httptest.Server, clientID string, token string) <-chan struct{} {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	r, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/clients/"+clientID+"/notifications/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Add("Authorization", "Bearer "+token)
+
+	response, err := server.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, response.StatusCode, http.StatusOK)
+
+	closed := make(chan struct{})
+	go func() {
+		defer response.Body.Close()
+		io.Copy(io.Discard, response.Body)
+		close(closed)
+	}()
+
+	return closed
+}
+
+// signTestToken with the given claims, as the authority of tokens does
+func signTestToken(t *testing.T, claims jwt.MapClaims) string {
+	privateKey, err := GetAuthPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func createTenantUserRequest(t *testing.T, method string, url string, payload io.Reader, tenantID string, userID string) *http.Request {
+	r, err := http.NewRequest(method, url, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := signTestToken(t, jwt.MapClaims{"user_id": userID, "tenant_id": tenantID})
+	r.Header.Add("Authorization", "Bearer "+token)
+
+	return r
+}
+
+func TestTenantIsolation(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/unicast", jwtAuth.Secure(api.UnicastEventHandler).ServeHTTP)
+	rt.HandleFunc(baseNotificationsURL, jwtAuth.Secure(api.GetNotificationsHandler).ServeHTTP)
+	rt.HandleFunc(baseNotificationsURL+"/{notificationID}", jwtAuth.Secure(api.GetNotificationHandler).ServeHTTP)
+
+	baseNotificationsURL123 := strings.Replace(baseNotificationsURL, "{clientID}", "123", 1)
+
+	// 1- Publishes one event to user 123 of tenant acme
+	payload := `{"sourceID":"test","destinationID":"123","data":"acme only"}`
+	r := createTenantUserRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(payload), "acme", "666")
+	rr := serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+
+	object := unmarshalBodyContent(t, rr)
+	notificationID := object["notificationID"]
+
+	// 2- User 123 of tenant acme sees it
+	r = createTenantUserRequest(t, "GET", baseNotificationsURL123, nil, "acme", "123")
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+
+	object = unmarshalBodyContent(t, rr)
+	notifications := object["notifications"].([]interface{})
+	assertContent(t, len(notifications), 1)
+
+	// 3- User 123 of the default tenant can neither list nor get it
+	r = createUserRequest(t, "GET", baseNotificationsURL123, nil)
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+
+	object = unmarshalBodyContent(t, rr)
+	for _, notification := range object["notifications"].([]interface{}) {
+		if notification.(map[string]interface{})["notificationID"] == notificationID {
+			t.Errorf("notification %v of tenant acme leaked to default tenant", notificationID)
+		}
+	}
+
+	r = createUserRequest(t, "GET", fmt.Sprintf("%s/%v", baseNotificationsURL123, notificationID), nil)
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusNotFound)
+}
+
+func TestGetNotificationsHandler_WhenContextIsDone(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseNotificationsURL, jwtAuth.Secure(api.GetNotificationsHandler).ServeHTTP)
+
+	baseNotificationsURL123 := strings.Replace(baseNotificationsURL, "{clientID}", "123", 1)
+
+	// 1- Query runs out of time
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	r := createUserRequest(t, "GET", baseNotificationsURL123, nil).WithContext(ctx)
+	rr := serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusGatewayTimeout)
+
+	// 2- Request is given up on
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	r = createUserRequest(t, "GET", baseNotificationsURL123, nil).WithContext(ctx)
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusServiceUnavailable)
+}
+
+func TestGetAuditHandler(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/unicast", jwtAuth.Secure(api.UnicastEventHandler).ServeHTTP)
+	rt.HandleFunc("/api/admin/audit", jwtAuth.SecureAdmin(adminAPI.GetAuditHandler).ServeHTTP)
+
+	// 1- Publishes one event, which must be audited
+	payload := `{"id":"audited-event","sourceID":"test","destinationID":"123","data":"some blah blah blah kind of thing"}`
+	r := createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(payload))
+	r.RemoteAddr = "10.1.2.3:4567"
+	rr := serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+
+	// 2- Exports the audit trail as JSON Lines
+	r = createPublisherRequest(t, "GET", "/api/admin/audit?action="+AuditActionUnicast+"&format=jsonl", nil)
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+
+	var found *AuditEntry
+	for _, line := range strings.Split(strings.TrimSpace(rr.Body.String()), "\n") {
+		var entry AuditEntry
+		err := json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, entry.Action, AuditActionUnicast)
+		if entry.Target == "audited-event" {
+			found = &entry
+		}
+	}
+
+	if found == nil {
+		t.Fatal("published event was not audited")
+	}
+	assertContent(t, found.ActorType, AuditActorUser)
+	assertContent(t, found.ActorID, "666")
+	assertContent(t, found.SourceIP, "10.1.2.3")
+}
+
+func TestSourceIPMiddleware(t *testing.T) {
+	os.Setenv("MERCURIO_TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1")
+	defer os.Unsetenv("MERCURIO_TRUSTED_PROXIES")
+
+	trustedProxies, err := GetTrustedProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := negroni.New(SourceIPMiddleware(trustedProxies))
+	n.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, requestSourceIP(r))
+	})
+
+	for _, test := range []struct {
+		remoteAddr   string
+		forwardedFor string
+		expected     string
+	}{
+		// Nobody but a trusted proxy tells where a request comes from
+		{"203.0.113.9:4567", "198.51.100.1", "203.0.113.9"},
+		{"10.1.2.3:4567", "", "10.1.2.3"},
+		{"10.1.2.3:4567", "198.51.100.1", "198.51.100.1"},
+
+		// Whatever the client made up before it got to the proxies is left out
+		{"10.1.2.3:4567", "6.6.6.6, 198.51.100.1, 192.0.2.1", "198.51.100.1"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		rr := httptest.NewRecorder()
+		n.ServeHTTP(rr, r)
+
+		assertContent(t, rr.Body.String(), test.expected)
+	}
+}
+
+func TestBroadcastEventHandler_ToAudienceAll(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/broadcast", jwtAuth.Secure(api.BroadcastEventHandler).ServeHTTP)
+	rt.HandleFunc(baseNotificationsURL, jwtAuth.Secure(api.GetNotificationsHandler).ServeHTTP)
+	rt.HandleFunc("/api/clients/{clientID}/broadcasts/{broadcastID}/read", jwtAuth.Secure(api.MarkBroadcastReadHandler).ServeHTTP)
+	rt.HandleFunc("/api/clients/{clientID}/broadcasts/{broadcastID}/dismiss", jwtAuth.Secure(api.DismissBroadcastHandler).ServeHTTP)
+
+	findBroadcast := func(rr *httptest.ResponseRecorder, broadcastID interface{}) map[string]interface{} {
+		object := unmarshalBodyContent(t, rr)
+		for _, notification := range object["notifications"].([]interface{}) {
+			if notification.(map[string]interface{})["broadcastID"] == broadcastID {
+				return notification.(map[string]interface{})
+			}
+		}
+		return nil
+	}
+
+	// 1- Publishes one announcement to everyone, without knowing who they are
+	payload := `{"sourceID":"test","audience":"all","data":"maintenance tonight"}`
+	r := createPublisherRequest(t, "POST", baseEventsURL+"/broadcast", strings.NewReader(payload))
+	rr := serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+
+	object := unmarshalBodyContent(t, rr)
+	broadcastID := object["broadcastID"]
+	assertContent(t, object["audience"], "all")
+
+	// 2- Both users 123 & 789 see it unread
+	for _, userID := range []string{"123", "789"} {
+		r = createTenantUserRequest(t, "GET", "/api/clients/"+userID+"/notifications?status=unread", nil, DefaultTenantID, userID)
+		rr = serveHTTPRequest(rt, r)
+
+		assertStatusCode(t, rr, http.StatusOK)
+		broadcast := findBroadcast(rr, broadcastID)
+		if broadcast == nil {
+			t.Fatalf("user %s did not get broadcast %v", userID, broadcastID)
+		}
+		assertContent(t, broadcast["data"], "maintenance tonight")
+	}
+
+	// 3- User 123 reads it, which doesn't change a thing for user 789
+	r = createUserRequest(t, "PUT", fmt.Sprintf("/api/clients/123/broadcasts/%v/read", broadcastID), nil)
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+
+	r = createUserRequest(t, "GET", "/api/clients/123/notifications?status=unread", nil)
+	rr = serveHTTPRequest(rt, r)
+	assertContent(t, findBroadcast(rr, broadcastID) == nil, true)
+
+	r = createUserRequest(t, "GET", "/api/clients/123/notifications?status=read", nil)
+	rr = serveHTTPRequest(rt, r)
+	assertContent(t, findBroadcast(rr, broadcastID) != nil, true)
+
+	r = createTenantUserRequest(t, "GET", "/api/clients/789/notifications?status=unread", nil, DefaultTenantID, "789")
+	rr = serveHTTPRequest(rt, r)
+	assertContent(t, findBroadcast(rr, broadcastID) != nil, true)
+
+	// 4- User 123 dismisses it for good
+	r = createUserRequest(t, "PUT", fmt.Sprintf("/api/clients/123/broadcasts/%v/dismiss", broadcastID), nil)
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+
+	r = createUserRequest(t, "GET", "/api/clients/123/notifications", nil)
+	rr = serveHTTPRequest(rt, r)
+	assertContent(t, findBroadcast(rr, broadcastID) == nil, true)
+
+	// 5- Another tenant never sees it
+	r = createTenantUserRequest(t, "GET", "/api/clients/789/notifications", nil, "acme", "789")
+	rr = serveHTTPRequest(rt, r)
+	assertContent(t, findBroadcast(rr, broadcastID) == nil, true)
+
+	r = createTenantUserRequest(t, "PUT", fmt.Sprintf("/api/clients/789/broadcasts/%v/read", broadcastID), nil, "acme", "789")
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusNotFound)
+}
+
+func TestBroadcastEventHandler_ToAudienceAll_ScreenedPerClient(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/broadcast", jwtAuth.Secure(api.BroadcastEventHandler).ServeHTTP)
+	ctx := context.Background()
+
+	// 1- One client keeps the source out of its stream, another one is snoozed, and the last one is fine
+	preference, err := NewClientPreference(PreferenceScopeSource, "announcements", Channels{Stream: false, Inbox: true, Email: true, Webhook: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = api.Preferences.Replace(ctx, "5301", []ClientPreference{*preference})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snoozedUntil := time.Now().Add(time.Hour)
+	snoozed := NewClientSettings("5302")
+	snoozed.SnoozedUntil = &snoozedUntil
+	err = api.Settings.Save(ctx, snoozed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queues := map[string]*OutboundQueue{}
+	for _, clientID := range []string{"5301", "5302", "5303"} {
+		client := Client{TenantID: DefaultTenantID, ID: clientID, Queue: NewOutboundQueue(10), Done: make(chan struct{})}
+		api.Broker.NotifyClientConnected(client)
+		defer api.Broker.NotifyClientDisconnected(client)
+		queues[clientID] = client.Queue
+	}
+
+	// 2- Only the last one gets the announcement live
+	payload := `{"sourceID":"announcements","audience":"all","data":"maintenance tonight"}`
+	r := createPublisherRequest(t, "POST", baseEventsURL+"/broadcast", strings.NewReader(payload))
+	rr := serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+	broadcastID := unmarshalBodyContent(t, rr)["broadcastID"]
+
+	delivered, ok := nextNotification(queues["5303"], time.Second)
+	assertContent(t, ok, true)
+	assertContent(t, float64(delivered.BroadcastID), broadcastID)
+
+	for _, clientID := range []string{"5301", "5302"} {
+		_, ok = nextNotification(queues[clientID], 100*time.Millisecond)
+		assertContent(t, ok, false)
+	}
+
+	// 3- Every one of them still finds it when it gets its notifications
+	for _, clientID := range []string{"5301", "5302", "5303"} {
+		broadcasts, err := api.Broadcasts.GetForClient(ctx, clientID, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found := false
+		for _, broadcast := range broadcasts {
+			found = found || float64(broadcast.ID) == broadcastID
+		}
+		assertContent(t, found, true)
+	}
+}
+func TestBroadcastEventHandler_Modes(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/broadcast", jwtAuth.Secure(api.BroadcastEventHandler).ServeHTTP)
+
+	// 1- Partial mode tells the outcome of each destination
+	payload := `{"sourceID":"test","destinations":["123","789"],"data":"one by one","mode":"partial"}`
+	r := createPublisherRequest(t, "POST", baseEventsURL+"/broadcast", strings.NewReader(payload))
+	rr := serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+
+	var results []map[string]interface{}
+	err := json.Unmarshal(rr.Body.Bytes(), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(results), 2)
+	for i, destinationID := range []string{"123", "789"} {
+		assertContent(t, results[i]["destinationID"], destinationID)
+		assertContent(t, results[i]["notificationID"] != nil, true)
+		assertContent(t, results[i]["error"], nil)
+	}
+
+	// 2- Anything but atomic or partial is refused
+	payload = `{"sourceID":"test","destinations":["123"],"data":"whatever","mode":"eventually"}`
+	r = createPublisherRequest(t, "POST", baseEventsURL+"/broadcast", strings.NewReader(payload))
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusBadRequest)
+}
+
+func TestBroadcastEventHandler_Async(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/broadcast", jwtAuth.Secure(api.BroadcastEventHandler).ServeHTTP)
+	rt.HandleFunc("/api/jobs/{jobID}", jwtAuth.SecurePublisher(api.GetJobHandler).ServeHTTP)
+	rt.HandleFunc("/api/jobs/{jobID}/cancel", jwtAuth.SecurePublisher(api.CancelJobHandler).ServeHTTP)
+
+	// 1- Publishes in the background, which gives a job right away
+	payload := `{"sourceID":"test","destinations":["123","789","999"],"data":"in the background"}`
+	r := createPublisherRequest(t, "POST", baseEventsURL+"/broadcast?async=true", strings.NewReader(payload))
+	rr := serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusAccepted)
+
+	object := unmarshalBodyContent(t, rr)
+	jobID := object["id"]
+	assertContent(t, object["total"], float64(3))
+
+	// 2- Checks on it until it is done
+	for i := 0; i < 100 && object["status"] != JobStatusCompleted; i++ {
+		time.Sleep(10 * time.Millisecond)
+
+		r = createPublisherRequest(t, "GET", fmt.Sprintf("/api/jobs/%v?sourceID=test", jobID), nil)
+		rr = serveHTTPRequest(rt, r)
+
+		assertStatusCode(t, rr, http.StatusOK)
+		object = unmarshalBodyContent(t, rr)
+	}
+
+	assertContent(t, object["status"], JobStatusCompleted)
+	assertContent(t, object["persisted"], float64(3))
+	assertContent(t, object["failed"], float64(0))
+	assertContent(t, object["finishedAt"] != nil, true)
+
+	notifications, err := api.Repository.GetAll(context.Background(), "999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, notifications[len(notifications)-1].Data, JSONData(`"in the background"`))
+
+	// 3- Once done, there is nothing to cancel
+	r = createPublisherRequest(t, "PUT", fmt.Sprintf("/api/jobs/%v/cancel?sourceID=test", jobID), nil)
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusConflict)
+
+	// 4- End users may not see it, nor is it to be found on another source or tenant
+	r = createUserRequest(t, "GET", fmt.Sprintf("/api/jobs/%v?sourceID=test", jobID), nil)
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusForbidden)
+
+	r = createPublisherRequest(t, "GET", fmt.Sprintf("/api/jobs/%v", jobID), nil)
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusBadRequest)
+
+	r = createPublisherRequest(t, "PUT", fmt.Sprintf("/api/jobs/%v/cancel?sourceID=spam", jobID), nil)
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusNotFound)
+
+	r = createTenantUserRequest(t, "GET", fmt.Sprintf("/api/jobs/%v?sourceID=test", jobID), nil, "acme", "666")
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusNotFound)
+}
+
+func TestUnicastEventHandler_WithIdempotencyKey(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/unicast", jwtAuth.Secure(api.Idempotency.Guard(api.UnicastEventHandler)).ServeHTTP)
+
+	publish := func(payload string) *httptest.ResponseRecorder {
+		r := createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(payload))
+		r.Header.Set(IdempotencyKeyHeader, "retry-me")
+		return serveHTTPRequest(rt, r)
+	}
+
+	// 1- The first request notifies the client
+	payload := `{"sourceID":"test","destinationID":"789","data":"just once"}`
+	rr := publish(payload)
+
+	assertStatusCode(t, rr, http.StatusOK)
+	first := unmarshalBodyContent(t, rr)
+
+	// 2- Its retry gets the very same response, and nobody is notified twice
+	rr = publish(payload)
+
+	assertStatusCode(t, rr, http.StatusOK)
+	assertContent(t, rr.Header().Get(IdempotencyReplayedHeader), "true")
+	assertContent(t, unmarshalBodyContent(t, rr)["notificationID"], first["notificationID"])
+
+	notifications, err := api.Repository.FilterBy(context.Background(), "789", Notification{Data: `"just once"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(notifications), 1)
+
+	// 3- The key can't be used for anything else
+	rr = publish(`{"sourceID":"test","destinationID":"789","data":"something else"}`)
+
+	assertStatusCode(t, rr, http.StatusUnprocessableEntity)
+}
+
+func TestUnicastEventHandler_WithCollapseKey(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/unicast", jwtAuth.Secure(api.UnicastEventHandler).ServeHTTP)
+
+	// 1- The first event of a key is a new notification
+	payload := `{"sourceID":"chat","destinationID":"789","data":"1 new comment","collapseKey":"post-42"}`
+	r := createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(payload))
+	rr := serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+	first := unmarshalBodyContent(t, rr)
+	assertContent(t, first["updated"], nil)
+
+	// 2- The next one updates it in place
+	payload = `{"sourceID":"chat","destinationID":"789","data":"2 new comments","collapseKey":"post-42"}`
+	r = createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(payload))
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+	second := unmarshalBodyContent(t, rr)
+	assertContent(t, second["updated"], true)
+	assertContent(t, second["notificationID"], first["notificationID"])
+
+	notifications, err := api.Repository.FilterBy(context.Background(), "789", Notification{CollapseKey: "post-42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(notifications), 1)
+	assertContent(t, notifications[0].Data, JSONData(`"2 new comments"`))
+}
+
+func TestScheduleEventHandlers(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/unicast", jwtAuth.Secure(api.UnicastEventHandler).ServeHTTP)
+	rt.HandleFunc(baseEventsURL+"/{eventID}/schedule", jwtAuth.SecurePublisher(api.RescheduleEventHandler).ServeHTTP).Methods("PUT")
+	rt.HandleFunc(baseEventsURL+"/{eventID}/schedule", jwtAuth.SecurePublisher(api.CancelScheduledEventHandler).ServeHTTP).Methods("DELETE")
+
+	ctx := context.Background()
+	deliverAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	// 1- Scheduled notifications are out of sight until due
+	for _, eventID := range []string{"reminder-1", "reminder-2"} {
+		payload := fmt.Sprintf(`{"id":"%s","sourceID":"reminders","destinationID":"789","data":"later","deliverAt":"%s"}`, eventID, deliverAt)
+		r := createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(payload))
+		rr := serveHTTPRequest(rt, r)
+
+		assertStatusCode(t, rr, http.StatusOK)
+		response := unmarshalBodyContent(t, rr)
+		assertContent(t, response["deliverAt"], deliverAt)
+	}
+
+	notifications, err := api.Repository.FilterBy(ctx, "789", Notification{SourceID: "reminders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(notifications), 0)
+
+	// 2- End users may not touch them, and a publisher only touches those of the source it tells
+	payload := fmt.Sprintf(`{"deliverAt":"%s"}`, time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+	r := createUserRequest(t, "PUT", baseEventsURL+"/reminder-1/schedule?sourceID=reminders", strings.NewReader(payload))
+	rr := serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusForbidden)
+
+	r = createPublisherRequest(t, "DELETE", baseEventsURL+"/reminder-1/schedule", nil)
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusBadRequest)
+
+	r = createPublisherRequest(t, "DELETE", baseEventsURL+"/reminder-1/schedule?sourceID=spam", nil)
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusNotFound)
+
+	// 3- Rescheduling to the past makes it due right away
+	r = createPublisherRequest(t, "PUT", baseEventsURL+"/reminder-1/schedule?sourceID=reminders", strings.NewReader(payload))
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+	response := unmarshalBodyContent(t, rr)
+	assertContent(t, response["notifications"], float64(1))
+
+	notifications, err = api.Repository.FilterBy(ctx, "789", Notification{SourceID: "reminders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(notifications), 1)
+	assertContent(t, notifications[0].EventID, "reminder-1")
+
+	// 4- Canceling takes it away for good
+	r = createPublisherRequest(t, "DELETE", baseEventsURL+"/reminder-2/schedule?sourceID=reminders", nil)
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	r = createPublisherRequest(t, "DELETE", baseEventsURL+"/reminder-2/schedule?sourceID=reminders", nil)
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusNotFound)
+}
+
+func TestScheduleHandlers(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc("/api/schedules", jwtAuth.SecurePublisher(api.CreateScheduleHandler).ServeHTTP).Methods("POST")
+	rt.HandleFunc("/api/schedules", jwtAuth.SecurePublisher(api.GetSchedulesHandler).ServeHTTP).Methods("GET")
+	rt.HandleFunc("/api/schedules/{scheduleID:[0-9]+}", jwtAuth.SecurePublisher(api.GetScheduleHandler).ServeHTTP).Methods("GET")
+	rt.HandleFunc("/api/schedules/{scheduleID:[0-9]+}", jwtAuth.SecurePublisher(api.UpdateScheduleHandler).ServeHTTP).Methods("PUT")
+	rt.HandleFunc("/api/schedules/{scheduleID:[0-9]+}", jwtAuth.SecurePublisher(api.DeleteScheduleHandler).ServeHTTP).Methods("DELETE")
+
+	// 1- Invalid schedules are refused
+	for _, payload := range []string{
+		`{"sourceID":"reports","cron":"0 9 * * MON","destinationID":"789","audience":"all"}`,
+		`{"sourceID":"reports","cron":"0 9 * * XYZ","destinationID":"789"}`,
+		`{"sourceID":"reports","cron":"0 9 * * MON","timeZone":"Mars/Olympus","destinationID":"789"}`,
+		`{"sourceID":"reports","cron":"0 9 * * MON","destinationID":"789","misfirePolicy":"never"}`,
+	} {
+		r := createPublisherRequest(t, "POST", "/api/schedules", strings.NewReader(payload))
+		rr := serveHTTPRequest(rt, r)
+		assertStatusCode(t, rr, http.StatusBadRequest)
+	}
+
+	// 2- A valid one is planned in its time zone
+	payload := `{"sourceID":"reports","cron":"0 9 * * MON","timeZone":"America/Sao_Paulo","destinationID":"789","data":"weekly report ready"}`
+	r := createPublisherRequest(t, "POST", "/api/schedules", strings.NewReader(payload))
+	rr := serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusCreated)
+	created := unmarshalBodyContent(t, rr)
+	assertContent(t, created["misfirePolicy"], MisfirePolicyFireOnce)
+
+	nextFireAt, err := time.Parse(time.RFC3339, created["nextFireAt"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saoPaulo, _ := time.LoadLocation("America/Sao_Paulo")
+	assertContent(t, nextFireAt.In(saoPaulo).Weekday(), time.Monday)
+	assertContent(t, nextFireAt.In(saoPaulo).Hour(), 9)
+
+	scheduleURL := fmt.Sprintf("/api/schedules/%v?sourceID=reports", created["id"])
+
+	// 3- It may be changed, and goes on from now
+	payload = `{"cron":"0 18 * * FRI","timeZone":"America/Sao_Paulo","destinationID":"789","data":"weekly report ready","misfirePolicy":"skip"}`
+	r = createPublisherRequest(t, "PUT", scheduleURL, strings.NewReader(payload))
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+	updated := unmarshalBodyContent(t, rr)
+	assertContent(t, updated["sourceID"], "reports")
+	assertContent(t, updated["misfirePolicy"], MisfirePolicySkip)
+
+	r = createPublisherRequest(t, "GET", scheduleURL, nil)
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+	got := unmarshalBodyContent(t, rr)
+	assertContent(t, got["cron"], "0 18 * * FRI")
+	assertContent(t, got["nextFireAt"], updated["nextFireAt"])
+
+	r = createPublisherRequest(t, "GET", "/api/schedules?sourceID=reports", nil)
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	// 4- End users may not touch it, and a publisher only touches those of the source it tells
+	r = createUserRequest(t, "GET", "/api/schedules?sourceID=reports", nil)
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusForbidden)
+
+	r = createUserRequest(t, "DELETE", scheduleURL, nil)
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusForbidden)
+
+	payload = `{"sourceID":"reports","cron":"@daily","destinationID":"123","data":"not for you"}`
+	r = createUserRequest(t, "POST", "/api/schedules", strings.NewReader(payload))
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusForbidden)
+
+	r = createPublisherRequest(t, "GET", "/api/schedules", nil)
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusBadRequest)
+
+	r = createPublisherRequest(t, "GET", fmt.Sprintf("/api/schedules/%v?sourceID=spam", created["id"]), nil)
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusNotFound)
+
+	// 5- Once deleted, it is gone
+	r = createPublisherRequest(t, "DELETE", scheduleURL, nil)
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	r = createPublisherRequest(t, "GET", scheduleURL, nil)
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusNotFound)
+}
+
+func TestTopicScheduleHandlers(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc("/api/schedules", jwtAuth.SecurePublisher(api.CreateScheduleHandler).ServeHTTP).Methods("POST")
+	rt.HandleFunc("/api/clients/{clientID}/topics", jwtAuth.Secure(api.GetSubscriptionsHandler).ServeHTTP).Methods("GET")
+	rt.HandleFunc("/api/clients/{clientID}/topics/{topic}", jwtAuth.Secure(api.SubscribeHandler).ServeHTTP).Methods("PUT")
+	rt.HandleFunc("/api/clients/{clientID}/topics/{topic}", jwtAuth.Secure(api.UnsubscribeHandler).ServeHTTP).Methods("DELETE")
+
+	// 1- Clients subscribe to topics on their own, as many times as they like
+	for _, clientID := range []string{"5101", "5102", "5102"} {
+		r := createTenantUserRequest(t, "PUT", "/api/clients/"+clientID+"/topics/weekly-reports", nil, DefaultTenantID, clientID)
+		rr := serveHTTPRequest(rt, r)
+		assertStatusCode(t, rr, http.StatusOK)
+	}
+
+	r := createTenantUserRequest(t, "PUT", "/api/clients/5102/topics/weekly-reports", nil, DefaultTenantID, "5101")
+	rr := serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusUnauthorized)
+
+	r = createTenantUserRequest(t, "GET", "/api/clients/5102/topics", nil, DefaultTenantID, "5102")
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+	assertContent(t, len(unmarshalBodyContent(t, rr)["subscriptions"].([]interface{})), 1)
+
+	// 2- A schedule publishes to one target only
+	r = createPublisherRequest(t, "POST", "/api/schedules", strings.NewReader(`{"sourceID":"topic-reports","cron":"0 9 * * MON","destinationID":"5101","topic":"weekly-reports"}`))
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusBadRequest)
+
+	r = createPublisherRequest(t, "POST", "/api/schedules", strings.NewReader(`{"sourceID":"topic-reports","cron":"0 9 * * MON","topic":"weekly-reports","data":"weekly report ready"}`))
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusCreated)
+	assertContent(t, unmarshalBodyContent(t, rr)["topic"], "weekly-reports")
+
+	// 3- Once it fires, every subscriber gets it
+	schedules, err := api.Schedules.GetAll(context.Background(), "topic-reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(schedules), 1)
+
+	scheduler := NewRecurringScheduler(api.Broker, api.Schedules, api.Topics, SchedulerSettings{MisfireGrace: time.Minute})
+	assertContent(t, scheduler.fireSchedule(schedules[0], time.Now()), true)
+
+	for _, clientID := range []string{"5101", "5102"} {
+		notifications, err := api.Repository.FilterBy(context.Background(), clientID, Notification{SourceID: "topic-reports"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(notifications), 1)
+		assertContent(t, notifications[0].Topic, "weekly-reports")
+	}
+
+	// 4- Unsubscribing twice is one time too many
+	for _, status := range []int{http.StatusOK, http.StatusNotFound} {
+		r = createTenantUserRequest(t, "DELETE", "/api/clients/5102/topics/weekly-reports", nil, DefaultTenantID, "5102")
+		rr = serveHTTPRequest(rt, r)
+		assertStatusCode(t, rr, status)
+	}
+}
+
+func TestUnicastEventHandler_WithExpiry(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/unicast", jwtAuth.Secure(api.UnicastEventHandler).ServeHTTP)
+
+	// 1- Expiry is either a time to come or a time to live
+	past := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	for _, payload := range []string{
+		`{"sourceID":"rides","destinationID":"789","data":"your driver is 2 minutes away","ttl":-1}`,
+		fmt.Sprintf(`{"sourceID":"rides","destinationID":"789","data":"your driver is 2 minutes away","expiresAt":"%s"}`, past),
+		fmt.Sprintf(`{"sourceID":"rides","destinationID":"789","data":"your driver is 2 minutes away","expiresAt":"%s","ttl":60}`, future),
+		fmt.Sprintf(`{"sourceID":"rides","destinationID":"789","data":"your driver is 2 minutes away","expiresAt":"%s","deliverAt":"%s"}`, future, future),
+	} {
+		r := createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(payload))
+		rr := serveHTTPRequest(rt, r)
+		assertStatusCode(t, rr, http.StatusBadRequest)
+	}
+
+	// 2- A time to live runs from now on
+	payload := `{"sourceID":"rides","destinationID":"789","data":"your driver is 2 minutes away","ttl":120}`
+	r := createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(payload))
+	rr := serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+	response := unmarshalBodyContent(t, rr)
+
+	expiresAt, err := time.Parse(time.RFC3339, response["expiresAt"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, expiresAt.After(time.Now().Add(time.Minute)), true)
+	assertContent(t, expiresAt.After(time.Now().Add(2*time.Minute)), false)
+}
+
+func TestGetNotificationsHandler_ByPriority(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/unicast", jwtAuth.Secure(api.UnicastEventHandler).ServeHTTP)
+	rt.HandleFunc("/api/clients/{clientID}/notifications", jwtAuth.Secure(api.GetNotificationsHandler).ServeHTTP)
+
+	// 1- Priorities go by name
+	payload := `{"sourceID":"alerts","destinationID":"4501","data":"whatever","priority":"highest"}`
+	r := createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(payload))
+	rr := serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusBadRequest)
+
+	for _, priority := range []string{"low", "urgent", "", "urgent", "high"} {
+		payload = fmt.Sprintf(`{"sourceID":"alerts","destinationID":"4501","data":"%s"}`, priority)
+		if priority != "" {
+			payload = fmt.Sprintf(`{"sourceID":"alerts","destinationID":"4501","data":"%s","priority":"%s"}`, priority, priority)
+		}
+		r = createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(payload))
+		rr = serveHTTPRequest(rt, r)
+		assertStatusCode(t, rr, http.StatusOK)
+	}
+
+	priorities := func(rr *httptest.ResponseRecorder) []interface{} {
+		result := []interface{}{}
+		for _, notification := range unmarshalBodyContent(t, rr)["notifications"].([]interface{}) {
+			if notification.(map[string]interface{})["sourceID"] == "alerts" {
+				result = append(result, notification.(map[string]interface{})["priority"])
+			}
+		}
+		return result
+	}
+
+	// 2- Sorted by priority, the highest first
+	r = createTenantUserRequest(t, "GET", "/api/clients/4501/notifications?sort=priority", nil, DefaultTenantID, "4501")
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+	assertContent(t, fmt.Sprint(priorities(rr)), "[urgent urgent high normal low]")
+
+	// 3- Filtered by priority
+	r = createTenantUserRequest(t, "GET", "/api/clients/4501/notifications?priority=urgent,low", nil, DefaultTenantID, "4501")
+	rr = serveHTTPRequest(rt, r)
+
+	assertStatusCode(t, rr, http.StatusOK)
+	assertContent(t, fmt.Sprint(priorities(rr)), "[low urgent urgent]")
+
+	r = createTenantUserRequest(t, "GET", "/api/clients/4501/notifications?priority=highest", nil, DefaultTenantID, "4501")
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusBadRequest)
+}
+
+func TestUnicastEventHandler_WithEventType(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/unicast", jwtAuth.Secure(api.UnicastEventHandler).ServeHTTP)
+	rt.HandleFunc("/api/clients/{clientID}/notifications", jwtAuth.Secure(api.GetNotificationsHandler).ServeHTTP)
+	rt.HandleFunc("/api/admin/event-types/{name}", jwtAuth.SecureAdmin(adminAPI.SaveEventTypeHandler).ServeHTTP).Methods("PUT")
+	rt.HandleFunc("/api/admin/event-types/{name}", jwtAuth.SecureAdmin(adminAPI.DeleteEventTypeHandler).ServeHTTP).Methods("DELETE")
+
+	// 1- Events of a type nobody registered are refused
+	payload := `{"sourceID":"orders","destinationID":"4601","type":"order.shipped","data":{"orderID":42}}`
+	r := createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(payload))
+	rr := serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusUnprocessableEntity)
+
+	// 2- Schemas must be valid ones, referring to nothing but themselves
+	schema := `{"schema":{"type":"object","properties":{"orderID":{"$ref":"#/definitions/id"}}}}`
+	r = createPublisherRequest(t, "PUT", "/api/admin/event-types/order.shipped", strings.NewReader(schema))
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusBadRequest)
+
+	schema = `{"description":"An order is on its way","schema":{"type":"object","required":["orderID","carrier"],"properties":{"orderID":{"type":"integer","minimum":1},"carrier":{"type":"string"}}}}`
+	r = createPublisherRequest(t, "PUT", "/api/admin/event-types/order.shipped", strings.NewReader(schema))
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+	defer serveHTTPRequest(rt, createPublisherRequest(t, "DELETE", "/api/admin/event-types/order.shipped", nil))
+
+	// 3- Data must match the schema of its type, and every violation is told
+	r = createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(payload))
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusUnprocessableEntity)
+
+	violations := unmarshalBodyContent(t, rr)["violations"].([]interface{})
+	assertContent(t, len(violations), 1)
+	assertContent(t, violations[0].(map[string]interface{})["path"], "")
+	assertContent(t, violations[0].(map[string]interface{})["message"], "missing properties: 'carrier'")
+
+	// 4- Data goes back as it came, JSON and all
+	payload = `{"sourceID":"orders","destinationID":"4601","type":"order.shipped","data":{"orderID": 42, "carrier":"ACME"}}`
+	r = createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(payload))
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	r = createTenantUserRequest(t, "GET", "/api/clients/4601/notifications", nil, DefaultTenantID, "4601")
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	var notification map[string]interface{}
+	for _, found := range unmarshalBodyContent(t, rr)["notifications"].([]interface{}) {
+		if found.(map[string]interface{})["sourceID"] == "orders" {
+			notification = found.(map[string]interface{})
+		}
+	}
+	if notification == nil {
+		t.Fatal("published event was not notified")
+	}
+	assertContent(t, notification["type"], "order.shipped")
+	assertContent(t, notification["data"].(map[string]interface{})["carrier"], "ACME")
+}
+
+func TestGetNotificationsHandler_Rendered(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/unicast", jwtAuth.Secure(api.UnicastEventHandler).ServeHTTP)
+	rt.HandleFunc("/api/clients/{clientID}/notifications", jwtAuth.Secure(api.GetNotificationsHandler).ServeHTTP)
+	rt.HandleFunc("/api/admin/event-types/{name}", jwtAuth.SecureAdmin(adminAPI.SaveEventTypeHandler).ServeHTTP).Methods("PUT")
+	rt.HandleFunc("/api/admin/event-types/{name}", jwtAuth.SecureAdmin(adminAPI.DeleteEventTypeHandler).ServeHTTP).Methods("DELETE")
+	rt.HandleFunc("/api/admin/event-types/{name}/templates/{locale}", jwtAuth.SecureAdmin(adminAPI.SaveTemplateHandler).ServeHTTP).Methods("PUT")
+
+	// 1- Templates are for registered event types only, and must parse
+	template := `{"title":"{{.Data.count}} new comments","body":"On {{.Data.post}}"}`
+	r := createPublisherRequest(t, "PUT", "/api/admin/event-types/comment.added/templates/en", strings.NewReader(template))
+	rr := serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusNotFound)
+
+	r = createPublisherRequest(t, "PUT", "/api/admin/event-types/comment.added", strings.NewReader(`{}`))
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+	defer serveHTTPRequest(rt, createPublisherRequest(t, "DELETE", "/api/admin/event-types/comment.added", nil))
+
+	r = createPublisherRequest(t, "PUT", "/api/admin/event-types/comment.added/templates/en", strings.NewReader(`{"title":"{{.Data.count"}`))
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusBadRequest)
+
+	for locale, template := range map[string]string{
+		"en":    `{"title":"{{.Data.count}} new comments","body":"On {{.Data.post}}"}`,
+		"pt-br": `{"title":"{{.Data.count}} novos comentários","body":"Em {{.Data.post}}"}`,
+	} {
+		r = createPublisherRequest(t, "PUT", "/api/admin/event-types/comment.added/templates/"+locale, strings.NewReader(template))
+		rr = serveHTTPRequest(rt, r)
+		assertStatusCode(t, rr, http.StatusOK)
+	}
+
+	payload := `{"sourceID":"comments","destinationID":"4701","type":"comment.added","data":{"count":3,"post":"Hello"}}`
+	r = createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(payload))
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	rendered := func(acceptLanguage string) map[string]interface{} {
+		r := createTenantUserRequest(t, "GET", "/api/clients/4701/notifications", nil, DefaultTenantID, "4701")
+		r.Header.Set("Accept-Language", acceptLanguage)
+		rr := serveHTTPRequest(rt, r)
+		assertStatusCode(t, rr, http.StatusOK)
+
+		for _, notification := range unmarshalBodyContent(t, rr)["notifications"].([]interface{}) {
+			if notification.(map[string]interface{})["sourceID"] == "comments" {
+				return notification.(map[string]interface{})["rendered"].(map[string]interface{})
+			}
+		}
+		t.Fatal("published event was not notified")
+		return nil
+	}
+
+	// 2- Rendered in the locale the client prefers, or else in the default one
+	notification := rendered("pt-BR,pt;q=0.9")
+	assertContent(t, notification["locale"], "pt-BR")
+	assertContent(t, notification["title"], "3 novos comentários")
+	assertContent(t, notification["body"], "Em Hello")
+
+	notification = rendered("fr")
+	assertContent(t, notification["locale"], "en")
+	assertContent(t, notification["title"], "3 new comments")
+
+	// 3- Copy changes apply to what was published already
+	r = createPublisherRequest(t, "PUT", "/api/admin/event-types/comment.added/templates/en", strings.NewReader(`{"title":"{{.Data.count}} more comments"}`))
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	notification = rendered("")
+	assertContent(t, notification["title"], "3 more comments")
+}
+
+func TestUpdatePreferencesHandler(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/unicast", jwtAuth.Secure(api.UnicastEventHandler).ServeHTTP)
+	rt.HandleFunc("/api/clients/{clientID}/notifications", jwtAuth.Secure(api.GetNotificationsHandler).ServeHTTP)
+	rt.HandleFunc("/api/clients/{clientID}/preferences", jwtAuth.Secure(api.GetPreferencesHandler).ServeHTTP).Methods("GET")
+	rt.HandleFunc("/api/clients/{clientID}/preferences", jwtAuth.Secure(api.UpdatePreferencesHandler).ServeHTTP).Methods("PUT")
+
+	// 1- Preferences must be of a valid scope, and on each key once
+	for _, payload := range []string{
+		`{"preferences":[{"scope":"channel","key":"promotions"}]}`,
+		`{"preferences":[{"scope":"source","key":""}]}`,
+		`{"preferences":[{"scope":"source","key":"promotions"},{"scope":"source","key":"promotions","email":false}]}`,
+	} {
+		r := createTenantUserRequest(t, "PUT", "/api/clients/4801/preferences", strings.NewReader(payload), DefaultTenantID, "4801")
+		rr := serveHTTPRequest(rt, r)
+		assertStatusCode(t, rr, http.StatusBadRequest)
+	}
+
+	payload := `{"preferences":[{"scope":"source","key":"promotions","stream":false,"inbox":false},{"scope":"category","key":"billing","email":false}]}`
+	r := createTenantUserRequest(t, "PUT", "/api/clients/4801/preferences", strings.NewReader(payload), DefaultTenantID, "4801")
+	rr := serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	r = createTenantUserRequest(t, "GET", "/api/clients/4801/preferences", nil, DefaultTenantID, "4801")
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	preferences := unmarshalBodyContent(t, rr)["preferences"].([]interface{})
+	assertContent(t, len(preferences), 2)
+	billing := preferences[0].(map[string]interface{})
+	assertContent(t, billing["scope"], "category")
+	assertContent(t, billing["email"], false)
+	assertContent(t, billing["inbox"], true)
+
+	// 2- Muted sources are neither persisted nor delivered, and publishers are told so
+	r = createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(`{"sourceID":"promotions","destinationID":"4801","data":"50% off"}`))
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	response := unmarshalBodyContent(t, rr)
+	assertContent(t, response["notificationID"], float64(0))
+	assertContent(t, fmt.Sprint(response["suppressed"]), "[stream inbox]")
+
+	// 3- Channels left to publishers are suppressed without holding the notification back
+	r = createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(`{"sourceID":"invoices","destinationID":"4801","category":"billing","data":"Invoice due"}`))
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	response = unmarshalBodyContent(t, rr)
+	assertContent(t, response["notificationID"] != float64(0), true)
+	assertContent(t, fmt.Sprint(response["suppressed"]), "[email]")
+
+	r = createTenantUserRequest(t, "GET", "/api/clients/4801/notifications", nil, DefaultTenantID, "4801")
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	sources := []string{}
+	for _, notification := range unmarshalBodyContent(t, rr)["notifications"].([]interface{}) {
+		notification := notification.(map[string]interface{})
+		if notification["sourceID"] == "promotions" || notification["sourceID"] == "invoices" {
+			sources = append(sources, notification["sourceID"].(string))
+			assertContent(t, notification["category"], "billing")
+		}
+	}
+	assertContent(t, fmt.Sprint(sources), "[invoices]")
+
+	// 4- Preferences are replaced as a whole
+	r = createTenantUserRequest(t, "PUT", "/api/clients/4801/preferences", strings.NewReader(`{"preferences":[]}`), DefaultTenantID, "4801")
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+	assertContent(t, len(unmarshalBodyContent(t, rr)["preferences"].([]interface{})), 0)
+}
+
+func TestSnoozeHandler(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/unicast", jwtAuth.Secure(api.UnicastEventHandler).ServeHTTP)
+	rt.HandleFunc("/api/clients/{clientID}/quiet-hours", jwtAuth.Secure(api.GetQuietHoursHandler).ServeHTTP).Methods("GET")
+	rt.HandleFunc("/api/clients/{clientID}/quiet-hours", jwtAuth.Secure(api.UpdateQuietHoursHandler).ServeHTTP).Methods("PUT")
+	rt.HandleFunc("/api/clients/{clientID}/snooze", jwtAuth.Secure(api.SnoozeHandler).ServeHTTP).Methods("PUT")
+	rt.HandleFunc("/api/clients/{clientID}/snooze", jwtAuth.Secure(api.UnsnoozeHandler).ServeHTTP).Methods("DELETE")
+
+	// 1- Nobody is quiet unless told so, and quiet hours must make sense
+	r := createTenantUserRequest(t, "GET", "/api/clients/4901/quiet-hours", nil, DefaultTenantID, "4901")
+	rr := serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+	assertContent(t, unmarshalBodyContent(t, rr)["quietUntil"], nil)
+
+	r = createTenantUserRequest(t, "PUT", "/api/clients/4901/quiet-hours", strings.NewReader(`{"timeZone":"Nowhere/Else","start":"22:00","end":"07:00"}`), DefaultTenantID, "4901")
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusBadRequest)
+
+	for _, payload := range []string{`{}`, `{"duration":"-2h"}`, `{"duration":"2h","until":"2030-01-01T00:00:00Z"}`} {
+		r = createTenantUserRequest(t, "PUT", "/api/clients/4901/snooze", strings.NewReader(payload), DefaultTenantID, "4901")
+		rr = serveHTTPRequest(rt, r)
+		assertStatusCode(t, rr, http.StatusBadRequest)
+	}
+
+	// 2- Snoozing holds notifications back from live delivery, though they are persisted
+	r = createTenantUserRequest(t, "PUT", "/api/clients/4901/snooze", strings.NewReader(`{"duration":"2h"}`), DefaultTenantID, "4901")
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+	assertContent(t, unmarshalBodyContent(t, rr)["quietUntil"] != nil, true)
+
+	r = createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(`{"sourceID":"newsletter","destinationID":"4901","data":"Weekly digest"}`))
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	held := func() *time.Time {
+		notifications, err := api.Repository.FilterBy(context.Background(), "4901", Notification{SourceID: "newsletter"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(notifications), 1)
+		return notifications[0].HeldUntil
+	}
+	assertContent(t, held() != nil, true)
+
+	// 3- Nobody but the client itself is done snoozing for it
+	r = createTenantUserRequest(t, "DELETE", "/api/clients/4901/snooze", nil, DefaultTenantID, "4902")
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusUnauthorized)
+
+	settings, err := api.Settings.Get(context.Background(), "4901")
+	assertContent(t, err, nil)
+	assertContent(t, settings.SnoozedUntil != nil, true)
+
+	// 4- Once done snoozing, whatever was held back is released
+	r = createTenantUserRequest(t, "DELETE", "/api/clients/4901/snooze", nil, DefaultTenantID, "4901")
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+	assertContent(t, unmarshalBodyContent(t, rr)["snoozedUntil"], nil)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for held() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("held notification was never released")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestUpdateDigestsHandler(t *testing.T) {
+	rt := mux.NewRouter()
+	rt.HandleFunc(baseEventsURL+"/unicast", jwtAuth.Secure(api.UnicastEventHandler).ServeHTTP)
+	rt.HandleFunc("/api/clients/{clientID}/digests", jwtAuth.Secure(api.GetDigestsHandler).ServeHTTP).Methods("GET")
+	rt.HandleFunc("/api/clients/{clientID}/digests", jwtAuth.Secure(api.UpdateDigestsHandler).ServeHTTP).Methods("PUT")
+
+	// 1- Digests must be of a category, once each, and neither too often nor too seldom
+	for _, payload := range []string{
+		`{"digests":[{"category":"","interval":3600}]}`,
+		`{"digests":[{"category":"social","interval":10}]}`,
+		`{"digests":[{"category":"social","interval":3600},{"category":"social","interval":86400}]}`,
+	} {
+		r := createTenantUserRequest(t, "PUT", "/api/clients/5001/digests", strings.NewReader(payload), DefaultTenantID, "5001")
+		rr := serveHTTPRequest(rt, r)
+		assertStatusCode(t, rr, http.StatusBadRequest)
+	}
+
+	r := createTenantUserRequest(t, "PUT", "/api/clients/5001/digests", strings.NewReader(`{"digests":[{"category":"social","interval":3600}]}`), DefaultTenantID, "5001")
+	rr := serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	r = createTenantUserRequest(t, "GET", "/api/clients/5001/digests", nil, DefaultTenantID, "5001")
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	digests := unmarshalBodyContent(t, rr)["digests"].([]interface{})
+	assertContent(t, len(digests), 1)
+	assertContent(t, digests[0].(map[string]interface{})["interval"], float64(3600))
+
+	// 2- Notifications of the category are persisted, but accumulated for the next digest
+	r = createPublisherRequest(t, "POST", baseEventsURL+"/unicast", strings.NewReader(`{"sourceID":"likes","destinationID":"5001","category":"social","data":"Ann likes your post"}`))
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	notifications, err := api.Repository.FilterBy(context.Background(), "5001", Notification{SourceID: "likes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(notifications), 1)
+	assertContent(t, notifications[0].DigestAt != nil, true)
+
+	// 3- Once digests change, whatever was accumulated goes out in a digest right away
+	r = createTenantUserRequest(t, "PUT", "/api/clients/5001/digests", strings.NewReader(`{"digests":[]}`), DefaultTenantID, "5001")
+	rr = serveHTTPRequest(rt, r)
+	assertStatusCode(t, rr, http.StatusOK)
+
+	digested := func() bool {
+		notifications, err := api.Repository.GetAll(context.Background(), "5001")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, notification := range notifications {
+			if notification.Type == DigestType {
+				return true
+			}
+		}
+		return false
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !digested() {
+		if time.Now().After(deadline) {
+			t.Fatal("accumulated notification was never digested")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testNotificationRepository is the conformance suite every NotificationRepository implementation must pass. The
+// given function must give a brand new, empty repository bound to the default tenant every time it is called
+func testNotificationRepository(t *testing.T, newRepository func(t *testing.T) NotificationRepository) {
+	ctx := context.Background()
+
+	addNotifications := func(t *testing.T, repository NotificationRepository, notifications ...*Notification) {
+		for _, notification := range notifications {
+			err := repository.Add(ctx, notification)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	t.Run("AddAndGet", func(t *testing.T) {
+		repository := newRepository(t)
+
+		notification := &Notification{EventID: "e1", SourceID: "test", DestinationID: "123", Data: `"hello"`}
+		addNotifications(t, repository, notification)
+
+		assertContent(t, notification.ID != 0, true)
+		assertContent(t, notification.TenantID, DefaultTenantID)
+		assertContent(t, notification.CreatedAt.IsZero(), false)
+
+		got, err := repository.Get(ctx, notification.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, got.EventID, "e1")
+		assertContent(t, got.SourceID, "test")
+		assertContent(t, got.DestinationID, "123")
+		assertContent(t, got.Data, JSONData(`"hello"`))
+		assertContent(t, got.ReadAt == nil, true)
+
+		_, err = repository.Get(ctx, notification.ID+1000)
+		assertContent(t, err, ErrNotificationNotFound)
+	})
+
+	t.Run("AddBatch", func(t *testing.T) {
+		repository := newRepository(t)
+
+		notifications := []*Notification{}
+		for i := 0; i < 250; i++ {
+			notifications = append(notifications, &Notification{EventID: "e", SourceID: "test", DestinationID: "123", Data: `"batched"`})
+		}
+
+		err := repository.ForTenant("acme").AddBatch(ctx, notifications)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, notification := range notifications {
+			assertContent(t, notification.TenantID, "acme")
+			if i > 0 && notification.ID <= notifications[i-1].ID {
+				t.Fatalf("notification %d got ID %d, which is not after %d", i, notification.ID, notifications[i-1].ID)
+			}
+		}
+
+		got, err := repository.ForTenant("acme").Get(ctx, notifications[249].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, got.Data, JSONData(`"batched"`))
+
+		all, err := repository.ForTenant("acme").GetAll(ctx, "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(all), 250)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		repository := newRepository(t)
+
+		notification := &Notification{EventID: "e1", SourceID: "test", DestinationID: "123", Data: `"hello"`}
+		addNotifications(t, repository, notification)
+
+		readAt := time.Now()
+		notification.ReadAt = &readAt
+		err := repository.Update(ctx, notification)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := repository.Get(ctx, notification.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, got.ReadAt != nil, true)
+
+		got.ReadAt = nil
+		err = repository.Update(ctx, &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err = repository.Get(ctx, notification.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, got.ReadAt == nil, true)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		repository := newRepository(t)
+
+		notification := &Notification{EventID: "e1", SourceID: "test", DestinationID: "123", Data: `"hello"`}
+		addNotifications(t, repository, notification)
+
+		err := repository.Delete(ctx, notification.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = repository.Get(ctx, notification.ID)
+		assertContent(t, err, ErrNotificationNotFound)
+	})
+
+	t.Run("GetAllOrderedByID", func(t *testing.T) {
+		repository := newRepository(t)
+
+		addNotifications(t, repository,
+			&Notification{EventID: "e1", SourceID: "test", DestinationID: "123", Data: `"first"`},
+			&Notification{EventID: "e2", SourceID: "test", DestinationID: "456", Data: `"someone else's"`},
+			&Notification{EventID: "e3", SourceID: "test", DestinationID: "123", Data: `"second"`})
+
+		notifications, err := repository.GetAll(ctx, "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(notifications), 2)
+		assertContent(t, notifications[0].Data, JSONData(`"first"`))
+		assertContent(t, notifications[1].Data, JSONData(`"second"`))
+
+		notifications, err = repository.GetAll(ctx, "789")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(notifications), 0)
+	})
+
+	t.Run("GetByStatus", func(t *testing.T) {
+		repository := newRepository(t)
+
+		readAt := time.Now()
+		unread := &Notification{EventID: "e1", SourceID: "test", DestinationID: "123", Data: `"unread"`}
+		read := &Notification{EventID: "e2", SourceID: "test", DestinationID: "123", Data: `"read"`}
+		addNotifications(t, repository, unread, read)
+
+		read.ReadAt = &readAt
+		err := repository.Update(ctx, read)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for status, expected := range map[string]int{"": 2, StatusAllNotifications: 2, StatusUnreadNotifications: 1, StatusReadNotifications: 1} {
+			notifications, err := repository.GetByStatus(ctx, "123", status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertContent(t, len(notifications), expected)
+		}
+	})
+
+	t.Run("FilterBy", func(t *testing.T) {
+		repository := newRepository(t)
+
+		addNotifications(t, repository,
+			&Notification{EventID: "e1", SourceID: "billing", DestinationID: "123", Data: `"invoice"`},
+			&Notification{EventID: "e2", SourceID: "chat", DestinationID: "123", Data: `"hi"`},
+			&Notification{EventID: "e3", SourceID: "billing", DestinationID: "123", Data: `"receipt"`},
+			&Notification{EventID: "e4", SourceID: "billing", DestinationID: "456", Data: `"invoice"`})
+
+		notifications, err := repository.FilterBy(ctx, "123", Notification{SourceID: "billing"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(notifications), 2)
+		assertContent(t, notifications[0].EventID, "e1")
+		assertContent(t, notifications[1].EventID, "e3")
+
+		notifications, err = repository.FilterBy(ctx, "123", Notification{SourceID: "billing", EventID: "e3"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(notifications), 1)
+	})
+
+	t.Run("TenantIsolation", func(t *testing.T) {
+		repository := newRepository(t)
+
+		notification := &Notification{EventID: "e1", SourceID: "test", DestinationID: "123", Data: `"acme only"`}
+		addNotifications(t, repository.ForTenant("acme"), notification)
+		assertContent(t, notification.TenantID, "acme")
+
+		_, err := repository.Get(ctx, notification.ID)
+		assertContent(t, err, ErrNotificationNotFound)
+
+		err = repository.Update(ctx, notification)
+		assertContent(t, err, ErrNotificationNotFound)
+
+		notifications, err := repository.GetAll(ctx, "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(notifications), 0)
+
+		err = repository.Delete(ctx, notification.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = repository.ForTenant("acme").Get(ctx, notification.ID)
+		assertContent(t, err, nil)
+	})
+
+	t.Run("Collapse", func(t *testing.T) {
+		repository := newRepository(t)
+
+		first := &Notification{EventID: "e1", SourceID: "chat", DestinationID: "123", Data: `"1 new comment"`, CollapseKey: "post-1"}
+		collapsed, err := repository.Collapse(ctx, first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, collapsed, false)
+		assertContent(t, first.ID != 0, true)
+
+		second := &Notification{EventID: "e2", SourceID: "chat", DestinationID: "123", Data: `"2 new comments"`, CollapseKey: "post-1"}
+		collapsed, err = repository.Collapse(ctx, second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, collapsed, true)
+		assertContent(t, second.ID, first.ID)
+
+		got, err := repository.Get(ctx, first.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, got.EventID, "e2")
+		assertContent(t, got.Data, JSONData(`"2 new comments"`))
+		assertContent(t, got.CollapseKey, "post-1")
+		assertContent(t, got.CreatedAt.Before(first.CreatedAt), false)
+
+		// Another key, destination or tenant is another story
+		for _, notification := range []*Notification{
+			{EventID: "e3", SourceID: "chat", DestinationID: "123", Data: `"1 new comment"`, CollapseKey: "post-2"},
+			{EventID: "e4", SourceID: "chat", DestinationID: "456", Data: `"1 new comment"`, CollapseKey: "post-1"},
+		} {
+			collapsed, err = repository.Collapse(ctx, notification)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertContent(t, collapsed, false)
+		}
+
+		collapsed, err = repository.ForTenant("acme").Collapse(ctx, &Notification{EventID: "e5", SourceID: "chat", DestinationID: "123", Data: `"1 new comment"`, CollapseKey: "post-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, collapsed, false)
+
+		// Once read, it is left alone
+		readAt := time.Now()
+		got.ReadAt = &readAt
+		err = repository.Update(ctx, &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		third := &Notification{EventID: "e6", SourceID: "chat", DestinationID: "123", Data: `"1 new comment"`, CollapseKey: "post-1"}
+		collapsed, err = repository.Collapse(ctx, third)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, collapsed, false)
+		assertContent(t, third.ID != first.ID, true)
+
+		notifications, err := repository.FilterBy(ctx, "123", Notification{CollapseKey: "post-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(notifications), 2)
+	})
+
+	t.Run("Schedule", func(t *testing.T) {
+		repository := newRepository(t)
+
+		later := time.Now().Add(time.Hour).UTC()
+		addNotifications(t, repository,
+			&Notification{EventID: "e1", SourceID: "test", DestinationID: "123", Data: `"now"`},
+			&Notification{EventID: "e2", SourceID: "test", DestinationID: "123", Data: `"later"`, DeliverAt: &later},
+			&Notification{EventID: "e2", SourceID: "test", DestinationID: "456", Data: `"later"`, DeliverAt: &later},
+			&Notification{EventID: "e3", SourceID: "test", DestinationID: "123", Data: `"much later"`, DeliverAt: &later},
+		)
+
+		// Nothing is seen before it is due
+		notifications, err := repository.GetAll(ctx, "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(notifications), 1)
+		assertContent(t, notifications[0].EventID, "e1")
+
+		rescheduled, err := repository.Reschedule(ctx, "other", "e2", time.Now().Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, rescheduled, int64(0))
+
+		rescheduled, err = repository.Reschedule(ctx, "test", "e2", time.Now().Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, rescheduled, int64(2))
+
+		canceled, err := repository.ForTenant("acme").CancelScheduled(ctx, "test", "e3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, canceled, int64(0))
+
+		canceled, err = repository.CancelScheduled(ctx, "test", "e3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, canceled, int64(1))
+
+		// What is due is released once only
+		released, err := repository.ReleaseDue(ctx, time.Now(), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(released), 2)
+		assertContent(t, released[0].EventID, "e2")
+		assertContent(t, released[0].DeliverAt == nil, true)
+
+		released, err = repository.ReleaseDue(ctx, time.Now(), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(released), 0)
+
+		notifications, err = repository.GetAll(ctx, "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(notifications), 2)
+
+		// Once released, it is no longer scheduled
+		canceled, err = repository.CancelScheduled(ctx, "test", "e2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, canceled, int64(0))
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		repository := newRepository(t)
+
+		past := time.Now().Add(-time.Minute).UTC()
+		future := time.Now().Add(time.Hour).UTC()
+		readLongAgo := time.Now().Add(-100 * 24 * time.Hour)
+		readJustNow := time.Now()
+		addNotifications(t, repository,
+			&Notification{EventID: "e1", SourceID: "test", DestinationID: "123", Data: `"expired"`, ExpiresAt: &past},
+			&Notification{EventID: "e2", SourceID: "test", DestinationID: "123", Data: `"expiring"`, ExpiresAt: &future},
+			&Notification{EventID: "e3", SourceID: "test", DestinationID: "123", Data: `"read long ago"`, ReadAt: &readLongAgo},
+			&Notification{EventID: "e4", SourceID: "test", DestinationID: "123", Data: `"read just now"`, ReadAt: &readJustNow},
+		)
+		addNotifications(t, repository.ForTenant("acme"),
+			&Notification{EventID: "e5", SourceID: "test", DestinationID: "123", Data: `"expired"`, ExpiresAt: &past},
+		)
+
+		// Nothing expired is seen
+		notifications, err := repository.GetAll(ctx, "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(notifications), 3)
+		assertContent(t, notifications[0].EventID, "e2")
+
+		notifications, err = repository.GetByStatus(ctx, "123", StatusUnreadNotifications)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(notifications), 1)
+
+		// Expired ones are deleted for every tenant, a batch at a time
+		deleted, err := repository.DeleteExpired(ctx, time.Now(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, deleted, int64(1))
+
+		deleted, err = repository.DeleteExpired(ctx, time.Now(), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, deleted, int64(1))
+
+		deleted, err = repository.DeleteReadBefore(ctx, time.Now().Add(-90*24*time.Hour), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, deleted, int64(1))
+
+		notifications, err = repository.GetAll(ctx, "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(notifications), 2)
+		assertContent(t, notifications[1].EventID, "e4")
+	})
+
+	t.Run("Usage", func(t *testing.T) {
+		repository := newRepository(t)
+
+		read := time.Now()
+		addNotifications(t, repository,
+			&Notification{EventID: "e1", SourceID: "test", DestinationID: "123", Data: `"read"`, ReadAt: &read},
+			&Notification{EventID: "e2", SourceID: "test", DestinationID: "123", Data: `"unread"`},
+			&Notification{EventID: "e3", SourceID: "test", DestinationID: "123", Data: `"olá"`, ReadAt: &read},
+			&Notification{EventID: "e4", SourceID: "test", DestinationID: "456", Data: `"other"`},
+		)
+		addNotifications(t, repository.ForTenant("acme"),
+			&Notification{EventID: "e5", SourceID: "test", DestinationID: "123", Data: `"read"`, ReadAt: &read},
+		)
+
+		usage, err := repository.GetUsage(ctx, "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, usage, ClientUsage{Notifications: 3, DataSize: 20})
+
+		// The oldest read ones go first, only as many as it takes
+		freed, err := repository.EvictRead(ctx, "123", ClientUsage{DataSize: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, freed, ClientUsage{Notifications: 1, DataSize: 6})
+
+		// Unread ones are never evicted, however much is left to free
+		freed, err = repository.EvictRead(ctx, "123", ClientUsage{Notifications: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, freed, ClientUsage{Notifications: 1, DataSize: 6})
+
+		notifications, err := repository.GetAll(ctx, "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(notifications), 1)
+		assertContent(t, notifications[0].EventID, "e2")
+
+		usage, err = repository.ForTenant("acme").GetUsage(ctx, "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, usage, ClientUsage{Notifications: 1, DataSize: 6})
+	})
+
+	t.Run("ContextDone", func(t *testing.T) {
+		repository := newRepository(t)
+
+		canceledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := repository.Add(canceledCtx, &Notification{EventID: "e1", SourceID: "test", DestinationID: "123", Data: `"never"`})
+		assertContent(t, errors.Is(err, context.Canceled), true)
+
+		_, err = repository.GetByStatus(canceledCtx, "123", StatusAllNotifications)
+		assertContent(t, errors.Is(err, context.Canceled), true)
+
+		notifications, err := repository.GetAll(ctx, "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(notifications), 0)
+	})
+}
+
+func TestMemoryNotificationRepository(t *testing.T) {
+	testNotificationRepository(t, func(t *testing.T) NotificationRepository {
+		return NewMemoryNotificationRepository()
+	})
+}
+
+func TestMemoryNotificationRepository_ConcurrentAdd(t *testing.T) {
+	repository := NewMemoryNotificationRepository()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			repository.Add(context.Background(), &Notification{EventID: "e", SourceID: "test", DestinationID: "123", Data: `"hi"`})
+		}()
+	}
+	wg.Wait()
+
+	notifications, err := repository.GetAll(context.Background(), "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(notifications), 50)
+	assertContent(t, notifications[49].ID, uint(50))
+}
+
+func TestSQLNotificationRepository(t *testing.T) {
+	testNotificationRepository(t, func(t *testing.T) NotificationRepository {
+		repository, err := NewSQLNotificationRepository(newTestSqliteDatabase(t), 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return repository
+	})
+}
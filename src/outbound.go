@@ -0,0 +1,147 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// StreamSettings tells how many notifications a client session queues up before the client is overloaded
+type StreamSettings struct {
+	QueueSize int
+}
+
+// OutboundQueue holds in notifications on their way to a client session, higher priorities first and, within the
+// same priority, in the order they came. Once it holds as many as its size, the client is overloaded: a notification
+// takes the place of the oldest one of the lowest priority, even an urgent one, unless it is of an even lower priority
+// itself, in which case it is held back (i.e. the client finds it when it gets its notifications). Pushing never
+// waits, so a slow client never holds up anyone else
+type OutboundQueue struct {
+	mutex    sync.Mutex
+	items    outboundItems
+	size     int
+	sequence uint64
+	dropped  uint64
+
+	// ready is signaled whenever a notification is pushed
+	ready chan struct{}
+}
+
+// NewOutboundQueue creates a new, empty OutboundQueue of a given size
+func NewOutboundQueue(size int) *OutboundQueue {
+	if size < 1 {
+		size = 1
+	}
+
+	queue := &OutboundQueue{
+		items: outboundItems{},
+		size:  size,
+		ready: make(chan struct{}, 1),
+	}
+
+	return queue
+}
+
+// Push a notification, evicting the oldest one of the lowest priority if the queue is full. It tells whether it was
+// pushed, which it is not when it is held back
+func (q *OutboundQueue) Push(notification Notification) bool {
+	q.mutex.Lock()
+	if len(q.items) >= q.size {
+		evicted := q.items.lowest()
+		if q.items[evicted].notification.Priority > notification.Priority {
+			q.dropped++
+			q.mutex.Unlock()
+			return false
+		}
+		heap.Remove(&q.items, evicted)
+		q.dropped++
+	}
+	q.sequence++
+	heap.Push(&q.items, outboundItem{notification: notification, sequence: q.sequence})
+	q.mutex.Unlock()
+
+	notify(q.ready)
+	return true
+}
+
+// Pop the notification which goes next, telling whether there was any
+func (q *OutboundQueue) Pop() (Notification, bool) {
+	q.mutex.Lock()
+	if len(q.items) == 0 {
+		q.mutex.Unlock()
+		return Notification{}, false
+	}
+	item := heap.Pop(&q.items).(outboundItem)
+	q.mutex.Unlock()
+
+	return item.notification, true
+}
+
+// Ready is signaled whenever there may be notifications to pop
+func (q *OutboundQueue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// Len is how many notifications are waiting
+func (q *OutboundQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.items)
+}
+
+// Dropped is how many notifications were held back from, or evicted from, the queue so far
+func (q *OutboundQueue) Dropped() uint64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.dropped
+}
+
+// notify a channel with room for one signal, unless it was already
+func notify(channel chan struct{}) {
+	select {
+	case channel <- struct{}{}:
+	default:
+	}
+}
+
+type outboundItem struct {
+	notification Notification
+	sequence     uint64
+}
+
+// outboundItems is a heap of notifications, as per container/heap
+type outboundItems []outboundItem
+
+func (items outboundItems) Len() int { return len(items) }
+
+func (items outboundItems) Less(i, j int) bool {
+	if items[i].notification.Priority != items[j].notification.Priority {
+		return items[i].notification.Priority > items[j].notification.Priority
+	}
+	return items[i].sequence < items[j].sequence
+}
+
+// lowest is the index of the item to evict first, i.e. the oldest one of the lowest priority, which is more likely
+// to be stale than the others
+func (items outboundItems) lowest() int {
+	lowest := 0
+	for i, item := range items {
+		priority := items[lowest].notification.Priority
+		if item.notification.Priority < priority || (item.notification.Priority == priority && item.sequence < items[lowest].sequence) {
+			lowest = i
+		}
+	}
+	return lowest
+}
+
+func (items outboundItems) Swap(i, j int) { items[i], items[j] = items[j], items[i] }
+
+func (items *outboundItems) Push(item interface{}) {
+	*items = append(*items, item.(outboundItem))
+}
+
+func (items *outboundItems) Pop() interface{} {
+	old := *items
+	item := old[len(old)-1]
+	*items = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestOutboundQueue_ByPriority(t *testing.T) {
+	queue := NewOutboundQueue(10)
+
+	for i, priority := range []Priority{PriorityNormal, PriorityLow, PriorityNormal, PriorityUrgent, PriorityHigh} {
+		assertContent(t, queue.Push(Notification{ID: uint(i + 1), Priority: priority}), true)
+	}
+
+	// Higher priorities go first, and the same priority goes in the order it came
+	for _, expected := range []uint{4, 5, 1, 3, 2} {
+		notification, ok := queue.Pop()
+		assertContent(t, ok, true)
+		assertContent(t, notification.ID, expected)
+	}
+
+	_, ok := queue.Pop()
+	assertContent(t, ok, false)
+}
+
+func TestOutboundQueue_Overloaded(t *testing.T) {
+	queue := NewOutboundQueue(2)
+
+	assertContent(t, queue.Push(Notification{ID: 1}), true)
+	assertContent(t, queue.Push(Notification{ID: 2}), true)
+
+	// Nothing goes after a low priority one, so it is held back right away
+	assertContent(t, queue.Push(Notification{ID: 3, Priority: PriorityLow}), false)
+
+	// Others take the place of the oldest one of the lowest priority, without waiting for room
+	assertContent(t, queue.Push(Notification{ID: 4}), true)
+	assertContent(t, queue.Push(Notification{ID: 5, Priority: PriorityUrgent}), true)
+	assertContent(t, queue.Len(), 2)
+	assertContent(t, queue.Dropped(), uint64(3))
+
+	for _, expected := range []uint{5, 4} {
+		notification, ok := queue.Pop()
+		assertContent(t, ok, true)
+		assertContent(t, notification.ID, expected)
+	}
+}
+
+func TestOutboundQueue_OverloadedWithUrgent(t *testing.T) {
+	queue := NewOutboundQueue(2)
+
+	assertContent(t, queue.Push(Notification{ID: 1, Priority: PriorityUrgent}), true)
+	assertContent(t, queue.Push(Notification{ID: 2, Priority: PriorityUrgent}), true)
+
+	// An urgent one is never held back, even when every one before it is urgent as well
+	assertContent(t, queue.Push(Notification{ID: 3, Priority: PriorityUrgent}), true)
+	assertContent(t, queue.Push(Notification{ID: 4}), false)
+	assertContent(t, queue.Dropped(), uint64(2))
+
+	for _, expected := range []uint{2, 3} {
+		notification, ok := queue.Pop()
+		assertContent(t, ok, true)
+		assertContent(t, notification.ID, expected)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrPersistenceStopped is returned when submitting notifications to a pipeline which is no longer running
+var ErrPersistenceStopped = errors.New("persistence pipeline is stopped")
+
+// PersistenceSettings tells how the persistence pipeline batches notifications: a batch is written as soon as it has
+// BatchSize notifications or its oldest one has waited for BatchDelay, whatever comes first. QueueSize is how many
+// notifications may wait to be written before publishers are held back
+type PersistenceSettings struct {
+	BatchSize  int
+	BatchDelay time.Duration
+	QueueSize  int
+}
+
+// persistenceRequest is one notification waiting to be written, and whoever is waiting for it
+type persistenceRequest struct {
+	ctx          context.Context
+	notification *Notification
+	done         chan error
+}
+
+// PersistencePipeline writes notifications to the repository in batches (i.e. multi-row inserts in a transaction),
+// which is way faster than one insert per notification when publishing a lot of them
+type PersistencePipeline struct {
+	repository  NotificationRepository
+	settings    PersistenceSettings
+	requests    chan persistenceRequest
+	onPersisted func(notification Notification)
+	stopping    chan struct{}
+	stopped     chan struct{}
+}
+
+// NewPersistencePipeline creates a new PersistencePipeline on top of a repository. Every notification written is handed
+// to onPersisted right after its batch is committed, e.g. for live delivery
+func NewPersistencePipeline(repository NotificationRepository, settings PersistenceSettings, onPersisted func(notification Notification)) *PersistencePipeline {
+	if settings.BatchSize < 1 {
+		settings.BatchSize = 1
+	}
+
+	pipeline := &PersistencePipeline{
+		repository:  repository,
+		settings:    settings,
+		requests:    make(chan persistenceRequest, settings.QueueSize),
+		onPersisted: onPersisted,
+		stopping:    make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+
+	return pipeline
+}
+
+// Submit a notification to be written. It blocks while the queue is full (i.e. backpressure) until the context is
+// done. Once submitted, the returned channel tells how writing it went; the notification must not be touched until then
+func (p *PersistencePipeline) Submit(ctx context.Context, notification *Notification) (<-chan error, error) {
+	request := persistenceRequest{
+		ctx:          ctx,
+		notification: notification,
+		done:         make(chan error, 1),
+	}
+
+	select {
+	case p.requests <- request:
+		return request.done, nil
+	case <-p.stopping:
+		return nil, ErrPersistenceStopped
+	case <-ctx.Done():
+		return nil, fmt.Errorf("persistence queue is full: %w", ctx.Err())
+	}
+}
+
+// Persist submits a notification and waits for it to be written
+func (p *PersistencePipeline) Persist(ctx context.Context, notification *Notification) error {
+	done, err := p.Submit(ctx, notification)
+	if err != nil {
+		return err
+	}
+
+	return p.Wait(ctx, done)
+}
+
+// Wait for a submitted notification to be written. Giving up on waiting doesn't take it back from the pipeline
+func (p *PersistencePipeline) Wait(ctx context.Context, done <-chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("gave up on waiting for notification to be written: %w", ctx.Err())
+	}
+}
+
+// Run starts the goroutine writing batches
+func (p *PersistencePipeline) Run() {
+	go func() {
+		defer close(p.stopped)
+
+		batch := []persistenceRequest{}
+		timer := time.NewTimer(p.settings.BatchDelay)
+		timer.Stop()
+
+		for {
+			select {
+			case request := <-p.requests:
+				if len(batch) == 0 {
+					timer.Reset(p.settings.BatchDelay)
+				}
+
+				batch = append(batch, request)
+				if len(batch) < p.settings.BatchSize {
+					continue
+				}
+
+			case <-timer.C:
+
+			case <-p.stopping:
+				// Whatever was already submitted is still written before stopping
+				for len(p.requests) > 0 {
+					batch = append(batch, <-p.requests)
+				}
+				p.write(batch)
+				return
+			}
+
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+
+			p.write(batch)
+			batch = []persistenceRequest{}
+		}
+	}()
+}
+
+// Stop writes what is pending and waits for the pipeline to finish
+func (p *PersistencePipeline) Stop() {
+	close(p.stopping)
+	<-p.stopped
+}
+
+// write a batch, one transaction per tenant, since a repository is bound to one
+func (p *PersistencePipeline) write(batch []persistenceRequest) {
+	tenants := map[string][]persistenceRequest{}
+	for _, request := range batch {
+		// No point in writing what nobody waits for anymore
+		if request.ctx.Err() != nil {
+			request.done <- fmt.Errorf("notification was not written: %w", request.ctx.Err())
+			continue
+		}
+
+		tenants[request.notification.TenantID] = append(tenants[request.notification.TenantID], request)
+	}
+
+	for tenantID, requests := range tenants {
+		notifications := []*Notification{}
+		for _, request := range requests {
+			notifications = append(notifications, request.notification)
+		}
+
+		// A batch serves many requests, so it can't be bound to any of them; the repository query timeout applies
+		repository := p.repository.ForTenant(tenantID)
+		errs := make([]error, len(requests))
+		err := repository.AddBatch(context.Background(), notifications)
+		if err != nil && len(notifications) > 1 {
+			// One bad notification must not fail the others, so each one is written on its own to tell which
+			log.Printf("Failed to write batch of %d notifications of tenant %s due to: %s, writing them one by one...", len(notifications), tenantID, err)
+			for i, notification := range notifications {
+				notification.ID = 0
+				errs[i] = repository.Add(context.Background(), notification)
+			}
+		} else {
+			for i := range errs {
+				errs[i] = err
+			}
+		}
+
+		// Copies are taken before anyone is told, as they may change their notifications right after
+		persisted := []Notification{}
+		for i, notification := range notifications {
+			if errs[i] != nil {
+				log.Printf("Failed to write notification for client %s of tenant %s due to: %s", notification.DestinationID, tenantID, errs[i])
+				continue
+			}
+			persisted = append(persisted, *notification)
+		}
+
+		for i, request := range requests {
+			request.done <- errs[i]
+		}
+
+		if p.onPersisted != nil {
+			for _, notification := range persisted {
+				p.onPersisted(notification)
+			}
+		}
+	}
+}
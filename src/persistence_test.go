@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPersistencePipeline_BatchesAndHandsOver(t *testing.T) {
+	repository := NewMemoryNotificationRepository()
+	persisted := make(chan Notification, 10)
+
+	pipeline := NewPersistencePipeline(repository, PersistenceSettings{BatchSize: 3, BatchDelay: time.Hour, QueueSize: 10}, func(notification Notification) {
+		persisted <- notification
+	})
+	pipeline.Run()
+	defer pipeline.Stop()
+
+	ctx := context.Background()
+	dones := []<-chan error{}
+	for i := 0; i < 3; i++ {
+		done, err := pipeline.Submit(ctx, &Notification{TenantID: "acme", EventID: "e", SourceID: "test", DestinationID: fmt.Sprint(i), Data: `"hi"`})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dones = append(dones, done)
+	}
+
+	// A full batch is written right away, despite of the delay
+	for _, done := range dones {
+		err := pipeline.Wait(ctx, done)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		notification := <-persisted
+		assertContent(t, notification.ID != 0, true)
+		assertContent(t, notification.TenantID, "acme")
+	}
+}
+
+func TestPersistencePipeline_WritesOnDelay(t *testing.T) {
+	repository := NewMemoryNotificationRepository()
+
+	pipeline := NewPersistencePipeline(repository, PersistenceSettings{BatchSize: 100, BatchDelay: 5 * time.Millisecond, QueueSize: 10}, nil)
+	pipeline.Run()
+	defer pipeline.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	notification := &Notification{EventID: "e", SourceID: "test", DestinationID: "123", Data: `"hi"`}
+	err := pipeline.Persist(ctx, notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, notification.ID, uint(1))
+}
+
+func TestPersistencePipeline_BackpressureWhenFull(t *testing.T) {
+	repository := NewMemoryNotificationRepository()
+
+	// Not running, so nothing leaves the queue
+	pipeline := NewPersistencePipeline(repository, PersistenceSettings{BatchSize: 10, BatchDelay: time.Millisecond, QueueSize: 1}, nil)
+
+	_, err := pipeline.Submit(context.Background(), &Notification{DestinationID: "123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = pipeline.Submit(ctx, &Notification{DestinationID: "123"})
+	assertContent(t, errors.Is(err, context.DeadlineExceeded), true)
+}
+
+// rejectingRepository fails to write notifications for a given destination, as a database does with a bad row
+type rejectingRepository struct {
+	NotificationRepository
+	rejected string
+}
+
+func (repository rejectingRepository) ForTenant(tenantID string) NotificationRepository {
+	return rejectingRepository{repository.NotificationRepository.ForTenant(tenantID), repository.rejected}
+}
+
+func (repository rejectingRepository) Add(ctx context.Context, notification *Notification) error {
+	if notification.DestinationID == repository.rejected {
+		return errors.New("bad row")
+	}
+	return repository.NotificationRepository.Add(ctx, notification)
+}
+
+func (repository rejectingRepository) AddBatch(ctx context.Context, notifications []*Notification) error {
+	for _, notification := range notifications {
+		if notification.DestinationID == repository.rejected {
+			return errors.New("bad row")
+		}
+	}
+	return repository.NotificationRepository.AddBatch(ctx, notifications)
+}
+
+func TestPersistencePipeline_FailsOnlyBadNotifications(t *testing.T) {
+	repository := rejectingRepository{NewMemoryNotificationRepository(), "bad"}
+	persisted := make(chan Notification, 10)
+
+	pipeline := NewPersistencePipeline(repository, PersistenceSettings{BatchSize: 3, BatchDelay: time.Hour, QueueSize: 10}, func(notification Notification) {
+		persisted <- notification
+	})
+	pipeline.Run()
+	defer pipeline.Stop()
+
+	ctx := context.Background()
+	notifications := []*Notification{}
+	dones := []<-chan error{}
+	for _, destinationID := range []string{"123", "bad", "456"} {
+		notification := &Notification{EventID: "e", SourceID: "test", DestinationID: destinationID, Data: `"hi"`}
+		done, err := pipeline.Submit(ctx, notification)
+		if err != nil {
+			t.Fatal(err)
+		}
+		notifications = append(notifications, notification)
+		dones = append(dones, done)
+	}
+
+	// Each publisher is told how its own notification went, regardless of the batch it shared
+	assertContent(t, pipeline.Wait(ctx, dones[0]), nil)
+	assertContent(t, pipeline.Wait(ctx, dones[1]) != nil, true)
+	assertContent(t, pipeline.Wait(ctx, dones[2]), nil)
+
+	assertContent(t, notifications[0].ID != 0, true)
+	assertContent(t, notifications[2].ID != 0, true)
+	assertContent(t, (<-persisted).DestinationID, "123")
+	assertContent(t, (<-persisted).DestinationID, "456")
+	assertContent(t, len(persisted), 0)
+}
+
+// Benchmarks writing 1000 notifications (e.g. a broadcast) one insert at a time vs. through the pipeline,
+// on an SQLite database file
+//
+//	$ go test -run XXX -bench NotificationWrites ./src
+func benchmarkSqliteRepository(b *testing.B) NotificationRepository {
+	repository, err := NewSQLNotificationRepository(newTestSqliteDatabase(b), 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return repository
+}
+
+const benchmarkNotifications = 1000
+
+func BenchmarkNotificationWrites_OneByOne(b *testing.B) {
+	repository := benchmarkSqliteRepository(b)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchmarkNotifications; j++ {
+			err := repository.Add(ctx, &Notification{EventID: "e", SourceID: "test", DestinationID: fmt.Sprint(j), Data: `"hi"`})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkNotificationWrites_Pipeline(b *testing.B) {
+	repository := benchmarkSqliteRepository(b)
+	ctx := context.Background()
+
+	pipeline := NewPersistencePipeline(repository, PersistenceSettings{BatchSize: 500, BatchDelay: 10 * time.Millisecond, QueueSize: 10000}, nil)
+	pipeline.Run()
+	defer pipeline.Stop()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dones := []<-chan error{}
+		for j := 0; j < benchmarkNotifications; j++ {
+			done, err := pipeline.Submit(ctx, &Notification{EventID: "e", SourceID: "test", DestinationID: fmt.Sprint(j), Data: `"hi"`})
+			if err != nil {
+				b.Fatal(err)
+			}
+			dones = append(dones, done)
+		}
+
+		for _, done := range dones {
+			err := pipeline.Wait(ctx, done)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// PreferenceScopeCategory stands for a preference on notifications of a category
+	PreferenceScopeCategory = "category"
+
+	// PreferenceScopeSource stands for a preference on notifications from a source
+	PreferenceScopeSource = "source"
+
+	// PreferenceScopeTopic stands for a preference on notifications of a topic
+	PreferenceScopeTopic = "topic"
+)
+
+// preferenceKeyMaxLength is as long as the key of a preference goes, which is as much as every database indexes
+const preferenceKeyMaxLength = 191
+
+// IsValidPreferenceScope tells whether a given preference scope string is a valid one
+func IsValidPreferenceScope(scope string) bool {
+	return scope == PreferenceScopeCategory || scope == PreferenceScopeSource || scope == PreferenceScopeTopic
+}
+
+const (
+	// ChannelStream stands for notifications delivered live to a client
+	ChannelStream = "stream"
+
+	// ChannelInbox stands for notifications persisted for a client to get them later
+	ChannelInbox = "inbox"
+
+	// ChannelEmail stands for notifications sent to a client by email
+	ChannelEmail = "email"
+
+	// ChannelWebhook stands for notifications sent to a client by webhook
+	ChannelWebhook = "webhook"
+)
+
+// Channels tells which ways a notification may reach a client: live through its stream, persisted in its inbox, and
+// by email or webhook, which are up to whoever delivers notifications outside of Mercurio
+type Channels struct {
+	Stream  bool `json:"stream" gorm:"not null"`
+	Inbox   bool `json:"inbox" gorm:"not null"`
+	Email   bool `json:"email" gorm:"not null"`
+	Webhook bool `json:"webhook" gorm:"not null"`
+}
+
+// AllChannels is how notifications reach a client which has no preference on them
+var AllChannels = Channels{Stream: true, Inbox: true, Email: true, Webhook: true}
+
+// Restrict channels to the ones the given ones enable too
+func (c Channels) Restrict(other Channels) Channels {
+	return Channels{
+		Stream:  c.Stream && other.Stream,
+		Inbox:   c.Inbox && other.Inbox,
+		Email:   c.Email && other.Email,
+		Webhook: c.Webhook && other.Webhook,
+	}
+}
+
+// Disabled channels, by name
+func (c Channels) Disabled() []string {
+	disabled := []string{}
+	for _, channel := range []struct {
+		name    string
+		enabled bool
+	}{
+		{ChannelStream, c.Stream},
+		{ChannelInbox, c.Inbox},
+		{ChannelEmail, c.Email},
+		{ChannelWebhook, c.Webhook},
+	} {
+		if !channel.enabled {
+			disabled = append(disabled, channel.name)
+		}
+	}
+	return disabled
+}
+
+// ClientPreference is the persistent record of the channels a client wants notifications of a category, from a source
+// or of a topic through, as told by its scope and key
+type ClientPreference struct {
+	ID        uint   `json:"-" gorm:"primaryKey"`
+	TenantID  string `json:"-" gorm:"not null"`
+	ClientID  string `json:"-" gorm:"not null"`
+	Scope     string `json:"scope" gorm:"not null"`
+	Key       string `json:"key" gorm:"column:match_key;not null"`
+	Channels  `gorm:"embedded"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// NewClientPreference creates a new ClientPreference, making sure its scope is a valid one and its key is given
+func NewClientPreference(scope string, key string, channels Channels) (*ClientPreference, error) {
+	if !IsValidPreferenceScope(scope) {
+		return nil, fmt.Errorf("%s is not a valid preference scope", scope)
+	}
+	if key == "" {
+		return nil, errors.New("key must be given")
+	}
+	if len(key) > preferenceKeyMaxLength {
+		return nil, fmt.Errorf("key must not be longer than %d characters", preferenceKeyMaxLength)
+	}
+
+	preference := &ClientPreference{
+		Scope:    scope,
+		Key:      key,
+		Channels: channels,
+	}
+
+	return preference, nil
+}
+
+// PreferenceSubject is what preferences on a notification go by
+type PreferenceSubject struct {
+	Category string
+	SourceID string
+	Topic    string
+}
+
+// Matches tells whether a preference is on a subject
+func (subject PreferenceSubject) Matches(preference ClientPreference) bool {
+	switch preference.Scope {
+	case PreferenceScopeCategory:
+		return subject.Category != "" && preference.Key == subject.Category
+	case PreferenceScopeSource:
+		return subject.SourceID != "" && preference.Key == subject.SourceID
+	case PreferenceScopeTopic:
+		return subject.Topic != "" && preference.Key == subject.Topic
+	default:
+		return false
+	}
+}
+
+// preferenceSubject of a notification
+func preferenceSubject(notification Notification) PreferenceSubject {
+	return PreferenceSubject{
+		Category: notification.Category,
+		SourceID: notification.SourceID,
+		Topic:    notification.Topic,
+	}
+}
+
+// ClientPreferenceRepository is the interface to client preference datastore. Every operation is bound to one tenant,
+// the default one unless it was scoped by ForTenant. Replace swaps every preference of a client for the given ones, and
+// Match tells the channels of each of the given clients with any preference on a subject: a channel is enabled as
+// long as every such preference of the client enables it
+type ClientPreferenceRepository interface {
+	ForTenant(tenantID string) ClientPreferenceRepository
+	GetAll(ctx context.Context, clientID string) ([]ClientPreference, error)
+	Replace(ctx context.Context, clientID string, preferences []ClientPreference) error
+	Match(ctx context.Context, clientIDs []string, subject PreferenceSubject) (map[string]Channels, error)
+}
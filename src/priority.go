@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Priority of a notification, which tells how it goes ahead of others on its way to a client. It is a number in the
+// database, so that it sorts, but goes by name anywhere else (e.g. "urgent")
+type Priority int
+
+const (
+	// PriorityLow stands for notifications which may be held back from a client overloaded with others
+	PriorityLow Priority = -1
+
+	// PriorityNormal stands for notifications of no particular priority, which is the default
+	PriorityNormal Priority = 0
+
+	// PriorityHigh stands for notifications which go ahead of normal and low ones
+	PriorityHigh Priority = 1
+
+	// PriorityUrgent stands for notifications which go ahead of any other, and are never held back
+	PriorityUrgent Priority = 2
+)
+
+var priorityNames = map[Priority]string{
+	PriorityLow:    "low",
+	PriorityNormal: "normal",
+	PriorityHigh:   "high",
+	PriorityUrgent: "urgent",
+}
+
+// ParsePriority by its name. Missing means normal
+func ParsePriority(name string) (Priority, error) {
+	if name == "" {
+		return PriorityNormal, nil
+	}
+
+	for priority, priorityName := range priorityNames {
+		if priorityName == name {
+			return priority, nil
+		}
+	}
+
+	return PriorityNormal, fmt.Errorf("%s is not a valid priority", name)
+}
+
+// String is the name of the priority
+func (p Priority) String() string {
+	name, ok := priorityNames[p]
+	if !ok {
+		return fmt.Sprintf("priority(%d)", int(p))
+	}
+	return name
+}
+
+// MarshalJSON as the name of the priority
+func (p Priority) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+// UnmarshalJSON from the name of a priority
+func (p *Priority) UnmarshalJSON(data []byte) error {
+	var name string
+	err := json.Unmarshal(data, &name)
+	if err != nil {
+		return err
+	}
+
+	*p, err = ParsePriority(name)
+	return err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// PurgeSettings tells how often the purger deletes notifications which are no longer worth keeping, how many of them
+// at a time, and how long read ones are kept (0 means for good)
+type PurgeSettings struct {
+	Interval      time.Duration
+	BatchSize     int
+	ReadRetention time.Duration
+}
+
+// Purger deletes expired notifications, as well as whatever is past the retention policy, in the background. Batches
+// keep every delete short, so that it never holds the database for long
+type Purger struct {
+	repository NotificationRepository
+	settings   PurgeSettings
+	stopping   chan struct{}
+	stopped    chan struct{}
+}
+
+// NewPurger creates a new Purger on top of a repository
+func NewPurger(repository NotificationRepository, settings PurgeSettings) *Purger {
+	if settings.Interval <= 0 {
+		settings.Interval = time.Minute
+	}
+	if settings.BatchSize < 1 {
+		settings.BatchSize = 1
+	}
+
+	purger := &Purger{
+		repository: repository,
+		settings:   settings,
+		stopping:   make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	return purger
+}
+
+// Run starts the goroutine purging notifications
+func (p *Purger) Run() {
+	go func() {
+		defer close(p.stopped)
+
+		ticker := time.NewTicker(p.settings.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.purge()
+			case <-p.stopping:
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for the purger to finish the batch at hand
+func (p *Purger) Stop() {
+	close(p.stopping)
+	<-p.stopped
+}
+
+// purge whatever expired, and whatever was read longer ago than the retention policy allows
+func (p *Purger) purge() {
+	now := time.Now()
+
+	p.purgeBatches("expired", func(ctx context.Context) (int64, error) {
+		return p.repository.DeleteExpired(ctx, now, p.settings.BatchSize)
+	})
+
+	if p.settings.ReadRetention > 0 {
+		readBefore := now.Add(-p.settings.ReadRetention)
+		p.purgeBatches("read", func(ctx context.Context) (int64, error) {
+			return p.repository.DeleteReadBefore(ctx, readBefore, p.settings.BatchSize)
+		})
+	}
+}
+
+// purgeBatches a batch after another until there is nothing left
+func (p *Purger) purgeBatches(kind string, deleteBatch func(ctx context.Context) (int64, error)) {
+	var purged int64
+	for {
+		deleted, err := deleteBatch(context.Background())
+		purged += deleted
+		if err != nil {
+			log.Printf("Failed to purge %s notifications due to: %s", kind, err)
+			break
+		}
+
+		if deleted < int64(p.settings.BatchSize) {
+			break
+		}
+
+		select {
+		case <-p.stopping:
+			log.Printf("Purged %d %s notifications so far", purged, kind)
+			return
+		default:
+		}
+	}
+
+	if purged > 0 {
+		log.Printf("Purged %d %s notifications", purged, kind)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPurger_DeletesExpiredAndPastRetention(t *testing.T) {
+	ctx := context.Background()
+	repository := NewMemoryNotificationRepository()
+
+	past := time.Now().Add(-time.Minute).UTC()
+	readLongAgo := time.Now().Add(-100 * 24 * time.Hour)
+	readLately := time.Now().Add(-24 * time.Hour)
+	for _, notification := range []*Notification{
+		{EventID: "e1", SourceID: "test", DestinationID: "123", Data: `"your driver is 2 minutes away"`, ExpiresAt: &past},
+		{EventID: "e2", SourceID: "test", DestinationID: "123", Data: `"your driver is here"`, ExpiresAt: &past},
+		{EventID: "e3", SourceID: "test", DestinationID: "123", Data: `"rate your ride"`, ReadAt: &readLongAgo},
+		{EventID: "e4", SourceID: "test", DestinationID: "123", Data: `"receipt"`, ReadAt: &readLately},
+		{EventID: "e5", SourceID: "test", DestinationID: "123", Data: `"promo"`},
+	} {
+		err := repository.Add(ctx, notification)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	purger := NewPurger(repository, PurgeSettings{BatchSize: 1, ReadRetention: 90 * 24 * time.Hour})
+	purger.purge()
+
+	for id, exists := range map[uint]bool{1: false, 2: false, 3: false, 4: true, 5: true} {
+		_, err := repository.Get(ctx, id)
+		assertContent(t, err == nil, exists)
+	}
+}
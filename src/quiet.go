@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrClientSettingsNotFound is returned when a client has no settings in database
+var ErrClientSettingsNotFound = errors.New("client settings not found")
+
+var (
+	// QuietReleaseAll stands for notifications held back during quiet time delivered one by one once it is over
+	QuietReleaseAll = "release"
+
+	// QuietReleaseSummary stands for notifications held back during quiet time summed up in a single one once it is
+	// over, see QuietSummaryType
+	QuietReleaseSummary = "summary"
+)
+
+// QuietSummaryType is the event type of the summary of notifications held back during quiet time, whose data tells
+// how many of them there are and their IDs. It may have templates, as long as it is registered
+const QuietSummaryType = "mercurio.quiet-summary"
+
+// IsValidQuietRelease tells whether a given quiet release string is a valid one. Missing means release
+func IsValidQuietRelease(release string) bool {
+	return release == "" || release == QuietReleaseAll || release == QuietReleaseSummary
+}
+
+// ClientSettings is the persistent record of how a client wants notifications delivered, as far as quiet time goes:
+// quiet hours, from QuietStart to QuietEnd (e.g. 22:00 to 07:00) every day in its time zone, and snoozing everything
+// until SnoozedUntil. Urgent notifications are never held back
+type ClientSettings struct {
+	ID           uint       `json:"-" gorm:"primaryKey"`
+	TenantID     string     `json:"-" gorm:"not null"`
+	ClientID     string     `json:"clientID" gorm:"not null"`
+	TimeZone     string     `json:"timeZone" gorm:"not null"`
+	QuietStart   string     `json:"quietStart,omitempty" gorm:"not null"`
+	QuietEnd     string     `json:"quietEnd,omitempty" gorm:"not null"`
+	QuietRelease string     `json:"quietRelease" gorm:"not null"`
+	SnoozedUntil *time.Time `json:"snoozedUntil,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt,omitempty"`
+	UpdatedAt    time.Time  `json:"updatedAt,omitempty"`
+}
+
+// NewClientSettings creates the default settings of a client, i.e. never quiet
+func NewClientSettings(clientID string) *ClientSettings {
+	return &ClientSettings{
+		ClientID:     clientID,
+		TimeZone:     "UTC",
+		QuietRelease: QuietReleaseAll,
+	}
+}
+
+// SetQuietHours of a client, making sure its time zone is a known one and both ends are given as HH:MM, or neither
+// when it has no quiet hours at all
+func (settings *ClientSettings) SetQuietHours(timeZone string, start string, end string, release string) error {
+	if timeZone == "" {
+		timeZone = "UTC"
+	}
+	_, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid time zone", timeZone)
+	}
+
+	if (start == "") != (end == "") {
+		return errors.New("either both quietStart and quietEnd must be given, or none")
+	}
+	for _, clock := range []string{start, end} {
+		if clock == "" {
+			continue
+		}
+		_, err := parseClock(clock)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !IsValidQuietRelease(release) {
+		return fmt.Errorf("%s is not a valid quiet release", release)
+	}
+	if release == "" {
+		release = QuietReleaseAll
+	}
+
+	settings.TimeZone = timeZone
+	settings.QuietStart = start
+	settings.QuietEnd = end
+	settings.QuietRelease = release
+	return nil
+}
+
+// QuietUntil tells when the quiet time a client is in at a given time is over, which is nil when it is not quiet at
+// all. Snoozing and quiet hours right after one another make one quiet time
+func (settings ClientSettings) QuietUntil(now time.Time) *time.Time {
+	var until *time.Time
+
+	at := now
+	for {
+		end := settings.quietEnd(at)
+		if end == nil || (until != nil && !end.After(*until)) {
+			break
+		}
+
+		until = end
+		at = *end
+	}
+
+	if until != nil {
+		utc := until.UTC()
+		until = &utc
+	}
+	return until
+}
+
+// quietEnd of whatever makes a client quiet at a given time, the later one when it is both snoozed and in its quiet
+// hours
+func (settings ClientSettings) quietEnd(at time.Time) *time.Time {
+	var end *time.Time
+	if settings.SnoozedUntil != nil && settings.SnoozedUntil.After(at) {
+		snoozedUntil := *settings.SnoozedUntil
+		end = &snoozedUntil
+	}
+
+	hoursEnd := settings.quietHoursEnd(at)
+	if hoursEnd != nil && (end == nil || hoursEnd.After(*end)) {
+		end = hoursEnd
+	}
+
+	return end
+}
+
+// quietHoursEnd tells when the quiet hours a client is in at a given time are over, if it is in them at all. Quiet
+// hours which start later than they end go past midnight
+func (settings ClientSettings) quietHoursEnd(at time.Time) *time.Time {
+	if settings.QuietStart == "" || settings.QuietStart == settings.QuietEnd {
+		return nil
+	}
+
+	location, err := time.LoadLocation(settings.TimeZone)
+	if err != nil {
+		return nil
+	}
+	start, err := parseClock(settings.QuietStart)
+	if err != nil {
+		return nil
+	}
+	end, err := parseClock(settings.QuietEnd)
+	if err != nil {
+		return nil
+	}
+
+	local := at.In(location)
+	minute := local.Hour()*60 + local.Minute()
+
+	var days int
+	switch {
+	case start < end && minute >= start && minute < end:
+		days = 0
+	case start > end && minute >= start:
+		days = 1
+	case start > end && minute < end:
+		days = 0
+	default:
+		return nil
+	}
+
+	until := time.Date(local.Year(), local.Month(), local.Day()+days, end/60, end%60, 0, 0, location)
+	return &until
+}
+
+// parseClock given as HH:MM into minutes since midnight
+func parseClock(clock string) (int, error) {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid time of day, which goes as HH:MM", clock)
+	}
+
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// ClientSettingsRepository is the interface to client settings datastore. Every operation is bound to one tenant,
+// the default one unless it was scoped by ForTenant. Save adds the settings of a client, or replaces them, and Find
+// gives the settings of whichever of the given clients has any
+type ClientSettingsRepository interface {
+	ForTenant(tenantID string) ClientSettingsRepository
+	Save(ctx context.Context, settings *ClientSettings) error
+	Get(ctx context.Context, clientID string) (ClientSettings, error)
+	Find(ctx context.Context, clientIDs []string) (map[string]ClientSettings, error)
+}
+
+// newQuietSummary of the notifications held back from a client during its quiet time, which is delivered live in
+// their stead. It goes ahead as the most important of them does
+func newQuietSummary(notifications []Notification) (*Notification, error) {
+	notificationIDs := []uint{}
+	priority := PriorityLow
+	for _, notification := range notifications {
+		notificationIDs = append(notificationIDs, notification.ID)
+		if notification.Priority > priority {
+			priority = notification.Priority
+		}
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"count":           len(notifications),
+		"notificationIDs": notificationIDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &Notification{
+		TenantID:      notifications[0].TenantID,
+		EventID:       uuid.New().String(),
+		DestinationID: notifications[0].DestinationID,
+		Type:          QuietSummaryType,
+		Data:          JSONData(data),
+		Priority:      priority,
+		CreatedAt:     time.Now(),
+	}
+
+	return summary, nil
+}
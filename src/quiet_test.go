@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientSettings_QuietUntil(t *testing.T) {
+	settings := NewClientSettings("123")
+	err := settings.SetQuietHours("America/Sao_Paulo", "22:00", "07:00", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2021, time.March, day, hour, minute, 0, 0, location)
+	}
+
+	// Quiet hours go past midnight, in the time zone of the client
+	assertContent(t, settings.QuietUntil(at(10, 21, 59)) == nil, true)
+	assertContent(t, settings.QuietUntil(at(10, 23, 30)).Equal(at(11, 7, 0)), true)
+	assertContent(t, settings.QuietUntil(at(11, 6, 59)).Equal(at(11, 7, 0)), true)
+	assertContent(t, settings.QuietUntil(at(11, 7, 0)) == nil, true)
+
+	// Snoozing right into the quiet hours makes one quiet time
+	snoozedUntil := at(10, 22, 30)
+	settings.SnoozedUntil = &snoozedUntil
+	assertContent(t, settings.QuietUntil(at(10, 20, 0)).Equal(at(11, 7, 0)), true)
+
+	snoozedUntil = at(11, 9, 0)
+	assertContent(t, settings.QuietUntil(at(11, 6, 0)).Equal(at(11, 9, 0)), true)
+}
+
+func TestClientSettings_SetQuietHours(t *testing.T) {
+	for _, quietHours := range [][]string{
+		{"Mars/Olympus_Mons", "22:00", "07:00", ""},
+		{"UTC", "22:00", "", ""},
+		{"UTC", "10pm", "7am", ""},
+		{"UTC", "22:00", "07:00", "digest"},
+	} {
+		err := NewClientSettings("123").SetQuietHours(quietHours[0], quietHours[1], quietHours[2], quietHours[3])
+		assertContent(t, err != nil, true)
+	}
+
+	settings := NewClientSettings("123")
+	err := settings.SetQuietHours("", "", "", "summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, settings.TimeZone, "UTC")
+	assertContent(t, settings.QuietUntil(time.Now()) == nil, true)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// QuotaPolicyReject stands for turning down whatever is published to a client over its quota
+	QuotaPolicyReject = "reject"
+
+	// QuotaPolicyEvict stands for making room for what is published to a client over its quota by deleting its oldest
+	// read notifications, and turning it down only when that is not enough
+	QuotaPolicyEvict = "evict"
+)
+
+// IsValidQuotaPolicy tells whether a given quota policy string is a valid one. Missing means reject
+func IsValidQuotaPolicy(policy string) bool {
+	return policy == "" || policy == QuotaPolicyReject || policy == QuotaPolicyEvict
+}
+
+// ErrQuotaExceeded is returned when a notification doesn't fit in the quota of its destination
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaSettings caps how many notifications each client keeps, and how large their data is altogether, as well as
+// what to do once a client is at its cap. Zero caps mean no caps at all
+type QuotaSettings struct {
+	MaxNotifications int
+	MaxDataSize      int
+	Policy           string
+}
+
+// ClientUsage is how much of the notification datastore a client takes, whatever the state of its notifications
+type ClientUsage struct {
+	Notifications int64 `json:"notifications"`
+	DataSize      int64 `json:"dataSize"`
+}
+
+// Enabled tells whether there is any cap at all
+func (quota QuotaSettings) Enabled() bool {
+	return quota.MaxNotifications > 0 || quota.MaxDataSize > 0
+}
+
+// Excess of a client over its caps once a notification with data of a given size is added, which is how much must be
+// freed for it to fit. It is zero when it fits as is
+func (quota QuotaSettings) Excess(usage ClientUsage, dataSize int) ClientUsage {
+	var excess ClientUsage
+	if quota.MaxNotifications > 0 && usage.Notifications+1 > int64(quota.MaxNotifications) {
+		excess.Notifications = usage.Notifications + 1 - int64(quota.MaxNotifications)
+	}
+	if quota.MaxDataSize > 0 && usage.DataSize+int64(dataSize) > int64(quota.MaxDataSize) {
+		excess.DataSize = usage.DataSize + int64(dataSize) - int64(quota.MaxDataSize)
+	}
+	return excess
+}
+
+// Covers tells whether what was freed is as much as an excess, if not more
+func (usage ClientUsage) Covers(excess ClientUsage) bool {
+	return usage.Notifications >= excess.Notifications && usage.DataSize >= excess.DataSize
+}
+
+// quotaExceeded tells which client is over its quota, as ErrQuotaExceeded
+func quotaExceeded(destinationID string, usage ClientUsage) error {
+	return fmt.Errorf("%w: client %s has %d notifications taking %d bytes", ErrQuotaExceeded, destinationID, usage.Notifications, usage.DataSize)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	// Time zones of schedules must be known wherever Mercurio runs, even on a bare container
+	_ "time/tzdata"
+)
+
+var (
+	// MisfirePolicyFireOnce stands for firing once for however many times a schedule missed while no service node was
+	// up, and going on from then
+	MisfirePolicyFireOnce = "fire_once"
+
+	// MisfirePolicySkip stands for leaving out whatever times a schedule missed, and going on from then
+	MisfirePolicySkip = "skip"
+)
+
+// IsValidMisfirePolicy tells whether a given misfire policy string is a valid one. Missing means fire once
+func IsValidMisfirePolicy(policy string) bool {
+	return policy == "" || policy == MisfirePolicyFireOnce || policy == MisfirePolicySkip
+}
+
+// ErrRecurringScheduleNotFound is returned when a recurring schedule doesn't exist in database
+var ErrRecurringScheduleNotFound = errors.New("recurring schedule not found")
+
+// RecurringSchedule is the persistent record of an event published over and over, as per a cron expression in a
+// given time zone, either to one destination, to a whole audience or to the subscribers of a topic. NextFireAt is nil
+// once it never fires again
+type RecurringSchedule struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	TenantID      string     `json:"tenantID,omitempty" gorm:"not null"`
+	SourceID      string     `json:"sourceID,omitempty" gorm:"not null"`
+	Cron          string     `json:"cron" gorm:"not null"`
+	TimeZone      string     `json:"timeZone" gorm:"not null"`
+	DestinationID string     `json:"destinationID,omitempty" gorm:"not null;default:''"`
+	Audience      string     `json:"audience,omitempty" gorm:"not null;default:''"`
+	Topic         string     `json:"topic,omitempty" gorm:"not null;default:''"`
+	Data          JSONData   `json:"data,omitempty" gorm:"not null;size:65536"`
+	MisfirePolicy string     `json:"misfirePolicy" gorm:"not null"`
+	NextFireAt    *time.Time `json:"nextFireAt,omitempty"`
+	LastFiredAt   *time.Time `json:"lastFiredAt,omitempty"`
+	Revision      int        `json:"-" gorm:"not null;default:0"`
+	CreatedAt     time.Time  `json:"createdAt,omitempty"`
+	UpdatedAt     time.Time  `json:"updatedAt,omitempty"`
+}
+
+// Plan the next time a schedule fires after a given one, making sure the schedule is a valid one along the way
+func (schedule *RecurringSchedule) Plan(after time.Time) error {
+	targets := 0
+	for _, target := range []string{schedule.DestinationID, schedule.Audience, schedule.Topic} {
+		if target != "" {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return errors.New("either destinationID, audience or topic must be given, only one of them")
+	}
+	if schedule.Audience != "" && schedule.Audience != BroadcastAudienceAll {
+		return fmt.Errorf("%s is not a valid audience", schedule.Audience)
+	}
+	if schedule.Topic != "" {
+		err := checkTopic(schedule.Topic)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !IsValidMisfirePolicy(schedule.MisfirePolicy) {
+		return fmt.Errorf("%s is not a valid misfire policy", schedule.MisfirePolicy)
+	}
+	if schedule.MisfirePolicy == "" {
+		schedule.MisfirePolicy = MisfirePolicyFireOnce
+	}
+
+	if schedule.TimeZone == "" {
+		schedule.TimeZone = "UTC"
+	}
+	location, err := time.LoadLocation(schedule.TimeZone)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid time zone", schedule.TimeZone)
+	}
+
+	cron, err := ParseCronExpression(schedule.Cron)
+	if err != nil {
+		return err
+	}
+
+	next := cron.Next(after, location)
+	if next.IsZero() {
+		return fmt.Errorf("cron expression %q never fires", schedule.Cron)
+	}
+
+	schedule.setNextFireAt(next)
+	return nil
+}
+
+// setNextFireAt in UTC, since some databases (i.e. SQLite) compare times as they are written. Zero means never
+func (schedule *RecurringSchedule) setNextFireAt(next time.Time) {
+	if next.IsZero() {
+		schedule.NextFireAt = nil
+		return
+	}
+
+	utc := next.UTC()
+	schedule.NextFireAt = &utc
+}
+
+// EventID of the time a schedule fires at, which tells fires apart while being the same on every service node
+func (schedule RecurringSchedule) EventID(fireAt time.Time) string {
+	return fmt.Sprintf("schedule-%d-%d", schedule.ID, fireAt.Unix())
+}
+
+// RecurringScheduleRepository is the interface to recurring schedule datastore. Every operation is bound to one
+// tenant, the default one unless it was scoped by ForTenant, but GetDue. Advance and Update only change a schedule
+// as long as it is at the same revision, which they move on
+type RecurringScheduleRepository interface {
+	ForTenant(tenantID string) RecurringScheduleRepository
+	Add(ctx context.Context, schedule *RecurringSchedule) error
+	Get(ctx context.Context, id uint) (RecurringSchedule, error)
+	GetAll(ctx context.Context, sourceID string) ([]RecurringSchedule, error)
+	Update(ctx context.Context, schedule *RecurringSchedule) (bool, error)
+	Delete(ctx context.Context, id uint) error
+	GetDue(ctx context.Context, now time.Time, limit int) ([]RecurringSchedule, error)
+	Advance(ctx context.Context, schedule *RecurringSchedule, firedAt *time.Time) (bool, error)
+}
+
+// RecurringScheduler fires recurring schedules once they are due, on top of the Broker: what they materialize goes
+// through it as any other event. Each fire is claimed in the repository first, so that exactly one service node
+// takes it when many share a database
+type RecurringScheduler struct {
+	broker     *Broker
+	repository RecurringScheduleRepository
+	topics     TopicSubscriptionRepository
+	settings   SchedulerSettings
+	stopping   chan struct{}
+	stopped    chan struct{}
+}
+
+// NewRecurringScheduler creates a new RecurringScheduler, which finds the subscribers of topics in the given repository
+func NewRecurringScheduler(broker *Broker, repository RecurringScheduleRepository, topics TopicSubscriptionRepository, settings SchedulerSettings) *RecurringScheduler {
+	if settings.Interval <= 0 {
+		settings.Interval = time.Second
+	}
+	if settings.BatchSize < 1 {
+		settings.BatchSize = 1
+	}
+
+	scheduler := &RecurringScheduler{
+		broker:     broker,
+		repository: repository,
+		topics:     topics,
+		settings:   settings,
+		stopping:   make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	return scheduler
+}
+
+// Run starts the goroutine firing schedules. Whatever was missed while no service node was up is handled on the
+// first go, as per the misfire policy of each schedule
+func (s *RecurringScheduler) Run() {
+	go func() {
+		defer close(s.stopped)
+
+		ticker := time.NewTicker(s.settings.Interval)
+		defer ticker.Stop()
+
+		for {
+			s.fire()
+
+			select {
+			case <-ticker.C:
+			case <-s.stopping:
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for the scheduler to finish
+func (s *RecurringScheduler) Stop() {
+	close(s.stopping)
+	<-s.stopped
+}
+
+// fire whatever is due, a batch after another until there is nothing left
+func (s *RecurringScheduler) fire() {
+	for {
+		now := time.Now()
+		due, err := s.repository.GetDue(context.Background(), now, s.settings.BatchSize)
+		if err != nil {
+			log.Printf("Failed to get due recurring schedules due to: %s", err)
+			return
+		}
+
+		claimed := 0
+		for _, schedule := range due {
+			if s.fireSchedule(schedule, now) {
+				claimed++
+			}
+		}
+
+		// Whatever is left may as well wait for the next round when none of these could be claimed
+		if len(due) < s.settings.BatchSize || claimed == 0 {
+			return
+		}
+
+		select {
+		case <-s.stopping:
+			return
+		default:
+		}
+	}
+}
+
+// fireSchedule claims the time a schedule is due at, and publishes its event unless it is a misfire to skip. Either
+// way, it goes on from now: however many times were missed, they never fire more than once. It tells whether it was
+// claimed
+func (s *RecurringScheduler) fireSchedule(schedule RecurringSchedule, now time.Time) bool {
+	ctx := context.Background()
+	repository := s.repository.ForTenant(schedule.TenantID)
+	dueAt := *schedule.NextFireAt
+
+	location, err := time.LoadLocation(schedule.TimeZone)
+	if err == nil {
+		var cron CronExpression
+		cron, err = ParseCronExpression(schedule.Cron)
+		schedule.setNextFireAt(cron.Next(now, location))
+	}
+	if err != nil {
+		// It was valid when it was saved, so it is not worth firing again and again
+		log.Printf("Failed to plan recurring schedule %d due to: %s", schedule.ID, err)
+		schedule.setNextFireAt(time.Time{})
+	}
+
+	missed := now.Sub(dueAt) > s.settings.MisfireGrace
+	fires := err == nil && (!missed || schedule.MisfirePolicy != MisfirePolicySkip)
+
+	var firedAt *time.Time
+	if fires {
+		firedAt = &now
+	}
+
+	claimed, err := repository.Advance(ctx, &schedule, firedAt)
+	if err != nil {
+		log.Printf("Failed to claim recurring schedule %d due to: %s", schedule.ID, err)
+		return false
+	}
+	if !claimed {
+		// Another service node got it, or it was changed in the meantime
+		return false
+	}
+
+	if !fires {
+		log.Printf("Skipping recurring schedule %d which missed %s", schedule.ID, dueAt)
+		return true
+	}
+
+	log.Printf("Firing recurring schedule %d due at %s", schedule.ID, dueAt)
+
+	switch {
+	case schedule.Topic != "":
+		err = s.publishToTopic(ctx, schedule, dueAt)
+	case schedule.Audience != "":
+		_, err = s.broker.AnnounceEvent(ctx, BroadcastEvent{
+			TenantID: schedule.TenantID,
+			ID:       schedule.EventID(dueAt),
+			SourceID: schedule.SourceID,
+			Audience: schedule.Audience,
+			Data:     schedule.Data,
+		})
+	default:
+		_, err = s.broker.NotifyEvent(ctx, Event{
+			TenantID:      schedule.TenantID,
+			ID:            schedule.EventID(dueAt),
+			SourceID:      schedule.SourceID,
+			DestinationID: schedule.DestinationID,
+			Data:          schedule.Data,
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to publish event of recurring schedule %d due to: %s", schedule.ID, err)
+	}
+
+	return true
+}
+
+// publishToTopic the event of a schedule, i.e. broadcast it to every client subscribed to its topic as they are when
+// it fires. Each subscriber succeeds or fails on its own, and it fails as long as any of them does
+func (s *RecurringScheduler) publishToTopic(ctx context.Context, schedule RecurringSchedule, dueAt time.Time) error {
+	subscribers, err := s.topics.ForTenant(schedule.TenantID).Subscribers(ctx, schedule.Topic)
+	if err != nil {
+		return fmt.Errorf("failed to get subscribers of topic %s due to: %s", schedule.Topic, err)
+	}
+	if len(subscribers) == 0 {
+		log.Printf("Recurring schedule %d has no subscriber of topic %s to publish to", schedule.ID, schedule.Topic)
+		return nil
+	}
+
+	results := s.broker.BroadcastEventPartially(ctx, BroadcastEvent{
+		TenantID:     schedule.TenantID,
+		ID:           schedule.EventID(dueAt),
+		SourceID:     schedule.SourceID,
+		Destinations: subscribers,
+		Topic:        schedule.Topic,
+		Mode:         BroadcastModePartial,
+		Data:         schedule.Data,
+	})
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d subscribers of topic %s failed", failed, len(results), schedule.Topic)
+	}
+
+	return nil
+}
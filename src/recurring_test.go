@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRecurringScheduleRepository(t *testing.T) *SQLRecurringScheduleRepository {
+	repository, err := NewSQLRecurringScheduleRepository(newTestSqliteDatabase(t), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repository
+}
+
+func TestRecurringScheduler_HandlesMisfires(t *testing.T) {
+	ctx := context.Background()
+	notifications := NewMemoryNotificationRepository()
+	broker := runTestBroker(t, notifications)
+	repository := newTestRecurringScheduleRepository(t)
+
+	// Schedules missed for an hour, but the last one which is just a little late
+	now := time.Now()
+	for _, schedule := range []*RecurringSchedule{
+		{SourceID: "reports", Cron: "* * * * *", DestinationID: "1", Data: `"fire once"`, MisfirePolicy: MisfirePolicyFireOnce},
+		{SourceID: "reports", Cron: "* * * * *", DestinationID: "2", Data: `"skipped"`, MisfirePolicy: MisfirePolicySkip},
+		{SourceID: "reports", Cron: "* * * * *", DestinationID: "3", Data: `"late"`, MisfirePolicy: MisfirePolicySkip},
+	} {
+		err := schedule.Plan(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dueAt := now.Add(-time.Hour).UTC()
+		if schedule.DestinationID == "3" {
+			dueAt = now.Add(-time.Second).UTC()
+		}
+		schedule.NextFireAt = &dueAt
+
+		err = repository.ForTenant("acme").Add(ctx, schedule)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scheduler := NewRecurringScheduler(broker, repository, nil, SchedulerSettings{BatchSize: 2, MisfireGrace: time.Minute})
+	scheduler.fire()
+
+	for destinationID, expected := range map[string]int{"1": 1, "2": 0, "3": 1} {
+		fired, err := notifications.ForTenant("acme").FilterBy(ctx, destinationID, Notification{SourceID: "reports"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(fired), expected)
+	}
+
+	// They all go on from now, so nothing is due anymore
+	due, err := repository.GetDue(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(due), 0)
+
+	schedules, err := repository.ForTenant("acme").GetAll(ctx, "reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(schedules), 3)
+	assertContent(t, schedules[0].LastFiredAt != nil, true)
+	assertContent(t, schedules[1].LastFiredAt == nil, true)
+	assertContent(t, schedules[1].NextFireAt.After(now), true)
+}
+
+func TestRecurringScheduler_FiresEachTimeOnce(t *testing.T) {
+	ctx := context.Background()
+	notifications := NewMemoryNotificationRepository()
+	broker := runTestBroker(t, notifications)
+	repository := newTestRecurringScheduleRepository(t)
+
+	schedule := &RecurringSchedule{SourceID: "reports", Cron: "@hourly", TimeZone: "Europe/Lisbon", DestinationID: "1", Data: `"weekly report ready"`}
+	err := schedule.Plan(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dueAt := time.Now().Add(-time.Second).UTC()
+	schedule.NextFireAt = &dueAt
+
+	err = repository.Add(ctx, schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two service nodes got the very same schedule due
+	first := NewRecurringScheduler(broker, repository, nil, SchedulerSettings{MisfireGrace: time.Minute})
+	second := NewRecurringScheduler(broker, repository, nil, SchedulerSettings{MisfireGrace: time.Minute})
+	assertContent(t, first.fireSchedule(*schedule, time.Now()), true)
+	assertContent(t, second.fireSchedule(*schedule, time.Now()), false)
+
+	fired, err := notifications.FilterBy(ctx, "1", Notification{SourceID: "reports"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(fired), 1)
+	assertContent(t, fired[0].EventID, schedule.EventID(dueAt))
+}
+
+func TestRecurringScheduler_FiresToTopicSubscribers(t *testing.T) {
+	ctx := context.Background()
+	notifications := NewMemoryNotificationRepository()
+	broker := runTestBroker(t, notifications)
+	repository := newTestRecurringScheduleRepository(t)
+
+	topics, err := NewSQLTopicSubscriptionRepository(repository.db, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A schedule publishes to one target only
+	for _, invalid := range []*RecurringSchedule{
+		{SourceID: "reports", Cron: "@hourly", DestinationID: "1", Topic: "weekly-reports", Data: `"both"`},
+		{SourceID: "reports", Cron: "@hourly", Audience: BroadcastAudienceAll, Topic: "weekly-reports", Data: `"both"`},
+		{SourceID: "reports", Cron: "@hourly", Topic: strings.Repeat("x", topicMaxLength+1), Data: `"too long"`},
+	} {
+		assertContent(t, invalid.Plan(time.Now()) != nil, true)
+	}
+
+	for _, clientID := range []string{"1", "2"} {
+		err = topics.ForTenant("acme").Subscribe(ctx, &TopicSubscription{ClientID: clientID, Topic: "weekly-reports"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = topics.Subscribe(ctx, &TopicSubscription{ClientID: "3", Topic: "weekly-reports"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schedule := &RecurringSchedule{SourceID: "reports", Cron: "@hourly", Topic: "weekly-reports", Data: `"weekly report ready"`}
+	err = schedule.Plan(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dueAt := time.Now().Add(-time.Second).UTC()
+	schedule.NextFireAt = &dueAt
+
+	err = repository.ForTenant("acme").Add(ctx, schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scheduler := NewRecurringScheduler(broker, repository, topics, SchedulerSettings{MisfireGrace: time.Minute})
+	assertContent(t, scheduler.fireSchedule(*schedule, time.Now()), true)
+
+	// Every subscriber of the tenant gets it once, as part of the same event, and nobody else does
+	for destinationID, expected := range map[string]int{"1": 1, "2": 1, "3": 0} {
+		fired, err := notifications.ForTenant("acme").FilterBy(ctx, destinationID, Notification{SourceID: "reports"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, len(fired), expected)
+		if expected > 0 {
+			assertContent(t, fired[0].EventID, schedule.EventID(dueAt))
+			assertContent(t, fired[0].Topic, "weekly-reports")
+		}
+	}
+
+	fired, err := notifications.FilterBy(ctx, "3", Notification{SourceID: "reports"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(fired), 0)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultRevocationTTL is how long a revocation lasts when no expiry is given. It should be at least
+// as long as the longest lived JWT, otherwise a revoked token would get back to life
+var DefaultRevocationTTL = 24 * time.Hour
+
+// Revocation is the persistent record of a revoked JWT, either a single token (by its jti claim) or
+// every token of a subject (by its user_id claim) of a tenant, until it expires
+type Revocation struct {
+	ID        uint      `json:"id,omitempty" gorm:"primaryKey"`
+	TenantID  string    `json:"tenantID,omitempty" gorm:"not null;index"`
+	JTI       string    `json:"jti,omitempty" gorm:"index"`
+	Subject   string    `json:"subject,omitempty" gorm:"index"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty" gorm:"not null;index"`
+}
+
+// NewRevocation creates a new revocation for a token ID and/or a subject. When expiresAt is not given,
+// it defaults to DefaultRevocationTTL from now
+func NewRevocation(tenantID string, jti string, subject string, reason string, expiresAt *time.Time) (*Revocation, error) {
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+
+	if jti == "" && subject == "" {
+		return nil, errors.New("either jti or subject must be provided")
+	}
+
+	expiry := time.Now().Add(DefaultRevocationTTL)
+	if expiresAt != nil {
+		if !expiresAt.After(time.Now()) {
+			return nil, errors.New("expiresAt must be in the future")
+		}
+		expiry = *expiresAt
+	}
+
+	revocation := &Revocation{
+		TenantID:  tenantID,
+		JTI:       jti,
+		Subject:   subject,
+		Reason:    reason,
+		ExpiresAt: expiry,
+	}
+
+	return revocation, nil
+}
+
+// RevocationRepository is the interface to revocation datastore
+type RevocationRepository interface {
+	Add(revocation *Revocation) error
+	GetActive(at time.Time) ([]Revocation, error)
+	IsRevoked(tenantID string, jti string, subject string, at time.Time) (bool, error)
+	DeleteExpired(at time.Time) error
+}
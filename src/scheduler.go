@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// SchedulerSettings tells how often the scheduler looks for notifications which are due, and how many of them it
+// releases at a time. Recurring schedules go by the same settings, and whatever fires later than MisfireGrace is
+// a misfire
+type SchedulerSettings struct {
+	Interval     time.Duration
+	BatchSize    int
+	MisfireGrace time.Duration
+}
+
+// Scheduler releases scheduled notifications to live delivery once they are due, held ones once the quiet time of
+// their destinations is over, and accumulated ones once their digests are. Each one is claimed in the repository
+// before it is released, so that exactly one service node fires it when many share a database
+type Scheduler struct {
+	repository NotificationRepository
+	settings   SchedulerSettings
+	onDue      func(notification Notification)
+	onQuietEnd func(notifications []Notification)
+	onDigest   func(notifications []Notification)
+	stopping   chan struct{}
+	stopped    chan struct{}
+}
+
+// NewScheduler creates a new Scheduler on top of a repository. Every scheduled notification released is handed to
+// onDue, and held and accumulated ones to onQuietEnd and onDigest, respectively, a batch at a time
+func NewScheduler(repository NotificationRepository, settings SchedulerSettings, onDue func(notification Notification), onQuietEnd func(notifications []Notification), onDigest func(notifications []Notification)) *Scheduler {
+	if settings.Interval <= 0 {
+		settings.Interval = time.Second
+	}
+	if settings.BatchSize < 1 {
+		settings.BatchSize = 1
+	}
+
+	scheduler := &Scheduler{
+		repository: repository,
+		settings:   settings,
+		onDue:      onDue,
+		onQuietEnd: onQuietEnd,
+		onDigest:   onDigest,
+		stopping:   make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	return scheduler
+}
+
+// Run starts the goroutine releasing notifications
+func (s *Scheduler) Run() {
+	go func() {
+		defer close(s.stopped)
+
+		ticker := time.NewTicker(s.settings.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.release()
+				s.releaseHeld()
+				s.releaseDigests()
+			case <-s.stopping:
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for the scheduler to finish
+func (s *Scheduler) Stop() {
+	close(s.stopping)
+	<-s.stopped
+}
+
+// release whatever is due
+func (s *Scheduler) release() {
+	s.drain("scheduled", s.repository.ReleaseDue, func(notifications []Notification) {
+		for _, notification := range notifications {
+			log.Printf("Releasing scheduled notification %d for client %s of tenant %s", notification.ID, notification.DestinationID, notification.TenantID)
+			s.onDue(notification)
+		}
+	})
+}
+
+// releaseHeld whatever was held back during a quiet time which is over
+func (s *Scheduler) releaseHeld() {
+	s.drain("held", s.repository.ReleaseHeld, func(notifications []Notification) {
+		log.Printf("Releasing %d held notifications", len(notifications))
+		s.onQuietEnd(notifications)
+	})
+}
+
+// releaseDigests whatever was accumulated for digests which are due
+func (s *Scheduler) releaseDigests() {
+	s.drain("accumulated", s.repository.ReleaseDigests, func(notifications []Notification) {
+		log.Printf("Releasing %d notifications accumulated for digests", len(notifications))
+		s.onDigest(notifications)
+	})
+}
+
+// drain notifications claimed, a batch after another until there is nothing left
+func (s *Scheduler) drain(kind string, claim func(ctx context.Context, now time.Time, limit int) ([]Notification, error), release func(notifications []Notification)) {
+	for {
+		// Whatever was claimed is released, even when claiming the rest failed
+		notifications, err := claim(context.Background(), time.Now(), s.settings.BatchSize)
+		if len(notifications) > 0 {
+			release(notifications)
+		}
+
+		if err != nil {
+			log.Printf("Failed to release %s notifications due to: %s", kind, err)
+			return
+		}
+
+		if len(notifications) < s.settings.BatchSize {
+			return
+		}
+
+		select {
+		case <-s.stopping:
+			return
+		default:
+		}
+	}
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/rs/cors"
@@ -101,6 +105,15 @@ func GetAuthPrivateKey() ([]byte, error) {
 	return nil, errors.New("somehow failed to get a private key")
 }
 
+// GetDatabaseDriver as per MERCURIO_DB_DRIVER environment variable. When not provided, defaults to sqlite
+func GetDatabaseDriver() string {
+	driver := strings.ToLower(os.Getenv("MERCURIO_DB_DRIVER"))
+	if driver == "" {
+		driver = DatabaseDriverSqlite
+	}
+	return driver
+}
+
 // GetDatabaseConnectionString returns the content of MERCURIO_DB_CONN
 func GetDatabaseConnectionString() (string, error) {
 	databaseConn := os.Getenv("MERCURIO_DB_CONN")
@@ -110,6 +123,217 @@ func GetDatabaseConnectionString() (string, error) {
 	return databaseConn, nil
 }
 
+// GetDatabaseQueryTimeout as per MERCURIO_DB_QUERY_TIMEOUT (e.g. 5s), which is how long a notification query may take
+// before it is interrupted. Defaults to 5s; 0 means it is up to the request alone
+func GetDatabaseQueryTimeout() (time.Duration, error) {
+	return getEnvDuration("MERCURIO_DB_QUERY_TIMEOUT", 5*time.Second)
+}
+
+// GetPersistenceSettings builds from the content of MERCURIO_PERSISTENCE_BATCH_SIZE, MERCURIO_PERSISTENCE_BATCH_DELAY
+// (e.g. 10ms) and MERCURIO_PERSISTENCE_QUEUE_SIZE, i.e. how notifications are batched before being written
+func GetPersistenceSettings() (PersistenceSettings, error) {
+	batchSize, err := getEnvInt("MERCURIO_PERSISTENCE_BATCH_SIZE", 500)
+	if err != nil {
+		return PersistenceSettings{}, err
+	}
+
+	batchDelay, err := getEnvDuration("MERCURIO_PERSISTENCE_BATCH_DELAY", 10*time.Millisecond)
+	if err != nil {
+		return PersistenceSettings{}, err
+	}
+
+	queueSize, err := getEnvInt("MERCURIO_PERSISTENCE_QUEUE_SIZE", 10000)
+	if err != nil {
+		return PersistenceSettings{}, err
+	}
+
+	settings := PersistenceSettings{
+		BatchSize:  batchSize,
+		BatchDelay: batchDelay,
+		QueueSize:  queueSize,
+	}
+
+	return settings, nil
+}
+
+// GetJobSettings builds from the content of MERCURIO_JOB_WORKERS, MERCURIO_JOB_CHUNK_SIZE and MERCURIO_JOB_QUEUE_SIZE,
+// i.e. how broadcast jobs run in the background
+func GetJobSettings() (JobSettings, error) {
+	workers, err := getEnvInt("MERCURIO_JOB_WORKERS", 4)
+	if err != nil {
+		return JobSettings{}, err
+	}
+
+	chunkSize, err := getEnvInt("MERCURIO_JOB_CHUNK_SIZE", 1000)
+	if err != nil {
+		return JobSettings{}, err
+	}
+
+	queueSize, err := getEnvInt("MERCURIO_JOB_QUEUE_SIZE", 100)
+	if err != nil {
+		return JobSettings{}, err
+	}
+
+	settings := JobSettings{
+		Workers:   workers,
+		ChunkSize: chunkSize,
+		QueueSize: queueSize,
+	}
+
+	return settings, nil
+}
+
+// GetSchedulerSettings builds from the content of MERCURIO_SCHEDULER_INTERVAL (e.g. 1s), MERCURIO_SCHEDULER_BATCH_SIZE
+// and MERCURIO_SCHEDULER_MISFIRE_GRACE (e.g. 1m), i.e. how scheduled notifications are released once they are due and
+// how late a recurring schedule may fire before it is a misfire
+func GetSchedulerSettings() (SchedulerSettings, error) {
+	interval, err := getEnvDuration("MERCURIO_SCHEDULER_INTERVAL", time.Second)
+	if err != nil {
+		return SchedulerSettings{}, err
+	}
+
+	batchSize, err := getEnvInt("MERCURIO_SCHEDULER_BATCH_SIZE", 500)
+	if err != nil {
+		return SchedulerSettings{}, err
+	}
+
+	misfireGrace, err := getEnvDuration("MERCURIO_SCHEDULER_MISFIRE_GRACE", time.Minute)
+	if err != nil {
+		return SchedulerSettings{}, err
+	}
+
+	settings := SchedulerSettings{
+		Interval:     interval,
+		BatchSize:    batchSize,
+		MisfireGrace: misfireGrace,
+	}
+
+	return settings, nil
+}
+
+// GetPurgeSettings builds from the content of MERCURIO_PURGE_INTERVAL (e.g. 1m), MERCURIO_PURGE_BATCH_SIZE and
+// MERCURIO_RETENTION_READ (e.g. 2160h, i.e. 90 days), i.e. how notifications which are expired or were read long ago
+// are deleted. Read ones are kept for 90 days by default; 0 means for good
+func GetPurgeSettings() (PurgeSettings, error) {
+	interval, err := getEnvDuration("MERCURIO_PURGE_INTERVAL", time.Minute)
+	if err != nil {
+		return PurgeSettings{}, err
+	}
+
+	batchSize, err := getEnvInt("MERCURIO_PURGE_BATCH_SIZE", 1000)
+	if err != nil {
+		return PurgeSettings{}, err
+	}
+
+	readRetention, err := getEnvDuration("MERCURIO_RETENTION_READ", 90*24*time.Hour)
+	if err != nil {
+		return PurgeSettings{}, err
+	}
+
+	settings := PurgeSettings{
+		Interval:      interval,
+		BatchSize:     batchSize,
+		ReadRetention: readRetention,
+	}
+
+	return settings, nil
+}
+
+// GetQuotaSettings builds from the content of MERCURIO_QUOTA_MAX_NOTIFICATIONS, MERCURIO_QUOTA_MAX_DATA_SIZE (bytes)
+// and MERCURIO_QUOTA_POLICY (reject or evict), i.e. how much of the datastore each client takes at most and what to do
+// once it is at its cap. There are no caps by default
+func GetQuotaSettings() (QuotaSettings, error) {
+	maxNotifications, err := getEnvInt("MERCURIO_QUOTA_MAX_NOTIFICATIONS", 0)
+	if err != nil {
+		return QuotaSettings{}, err
+	}
+
+	maxDataSize, err := getEnvInt("MERCURIO_QUOTA_MAX_DATA_SIZE", 0)
+	if err != nil {
+		return QuotaSettings{}, err
+	}
+
+	policy := strings.ToLower(os.Getenv("MERCURIO_QUOTA_POLICY"))
+	if !IsValidQuotaPolicy(policy) {
+		return QuotaSettings{}, fmt.Errorf("environment variable MERCURIO_QUOTA_POLICY must be either %s or %s", QuotaPolicyReject, QuotaPolicyEvict)
+	}
+	if policy == "" {
+		policy = QuotaPolicyReject
+	}
+
+	settings := QuotaSettings{
+		MaxNotifications: maxNotifications,
+		MaxDataSize:      maxDataSize,
+		Policy:           policy,
+	}
+
+	return settings, nil
+}
+
+// GetStreamSettings builds from the content of MERCURIO_STREAM_QUEUE_SIZE, i.e. how many notifications a client
+// session queues up before low priority ones are held back. It is 100 by default
+func GetStreamSettings() (StreamSettings, error) {
+	queueSize, err := getEnvInt("MERCURIO_STREAM_QUEUE_SIZE", 100)
+	if err != nil {
+		return StreamSettings{}, err
+	}
+
+	settings := StreamSettings{
+		QueueSize: queueSize,
+	}
+
+	return settings, nil
+}
+
+// GetDefaultLocale as per MERCURIO_DEFAULT_LOCALE (e.g. pt-BR), which notifications are rendered in when there is no
+// template in a locale the client prefers. Defaults to en
+func GetDefaultLocale() string {
+	locale := os.Getenv("MERCURIO_DEFAULT_LOCALE")
+	if locale == "" {
+		locale = "en"
+	}
+	return locale
+}
+
+// GetIdempotencyRetention as per MERCURIO_IDEMPOTENCY_RETENTION (e.g. 24h), which is how long the response to a
+// request with an idempotency key is given back on retry. Defaults to 24h
+func GetIdempotencyRetention() (time.Duration, error) {
+	return getEnvDuration("MERCURIO_IDEMPOTENCY_RETENTION", 24*time.Hour)
+}
+
+// AutoMigrateDatabase as per MERCURIO_DB_AUTO_MIGRATE equals to "on", which applies pending migrations at startup.
+// It is off by default so that production schema changes happen on purpose, i.e. `mercurio migrate up`
+func AutoMigrateDatabase() bool {
+	return strings.ToLower(os.Getenv("MERCURIO_DB_AUTO_MIGRATE")) == "on"
+}
+
+// GetDatabasePoolSettings builds from the content of MERCURIO_DB_MAX_OPEN_CONNS, MERCURIO_DB_MAX_IDLE_CONNS and
+// MERCURIO_DB_CONN_MAX_LIFETIME (e.g. 5m), with conservative defaults when missing
+func GetDatabasePoolSettings() (DatabasePoolSettings, error) {
+	maxOpenConns, err := getEnvInt("MERCURIO_DB_MAX_OPEN_CONNS", 25)
+	if err != nil {
+		return DatabasePoolSettings{}, err
+	}
+
+	maxIdleConns, err := getEnvInt("MERCURIO_DB_MAX_IDLE_CONNS", 5)
+	if err != nil {
+		return DatabasePoolSettings{}, err
+	}
+
+	connMaxLifetime, err := getEnvDuration("MERCURIO_DB_CONN_MAX_LIFETIME", 5*time.Minute)
+	if err != nil {
+		return DatabasePoolSettings{}, err
+	}
+
+	settings := DatabasePoolSettings{
+		MaxOpenConns:    maxOpenConns,
+		MaxIdleConns:    maxIdleConns,
+		ConnMaxLifetime: connMaxLifetime,
+	}
+
+	return settings, nil
+}
+
 // GetHTTPServerAddress from the environment variables MERCURIO_HTTP_HOST and MERCURIO_HTTP_PORT
 func GetHTTPServerAddress() string {
 	httpHost := os.Getenv("MERCURIO_HTTP_HOST")
@@ -152,6 +376,36 @@ func GetCORSOptions() cors.Options {
 	return options
 }
 
+// GetTrustedProxies builds from the content of MERCURIO_TRUSTED_PROXIES, i.e. a comma-separated list of addresses
+// or CIDR blocks (e.g. 10.0.0.0/8) of the proxies whose X-Forwarded-For is taken into account. None by default
+func GetTrustedProxies() ([]*net.IPNet, error) {
+	trustedProxies := []*net.IPNet{}
+
+	for _, trustedProxy := range strings.Split(os.Getenv("MERCURIO_TRUSTED_PROXIES"), ",") {
+		trustedProxy = strings.TrimSpace(trustedProxy)
+		if trustedProxy == "" {
+			continue
+		}
+
+		if !strings.Contains(trustedProxy, "/") {
+			ip := net.ParseIP(trustedProxy)
+			if ip == nil {
+				return nil, fmt.Errorf("environment variable MERCURIO_TRUSTED_PROXIES has an invalid address: %s", trustedProxy)
+			}
+			trustedProxies = append(trustedProxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		_, block, err := net.ParseCIDR(trustedProxy)
+		if err != nil {
+			return nil, fmt.Errorf("environment variable MERCURIO_TRUSTED_PROXIES has an invalid CIDR block: %s", trustedProxy)
+		}
+		trustedProxies = append(trustedProxies, block)
+	}
+
+	return trustedProxies, nil
+}
+
 // UseMQ as per MERCURIO_MQ missing or equals to "on"
 func UseMQ() bool {
 	mq := strings.ToLower(os.Getenv("MERCURIO_MQ"))
@@ -189,3 +443,81 @@ func GetMQSettings() (MessageQueueSettings, error) {
 
 	return settings, nil
 }
+
+// GetAdminUserIDs as per MERCURIO_ADMIN_USER_IDS (comma separated), which are the JWT user IDs allowed to reach
+// admin endpoints. When not provided, nobody is an administrator
+func GetAdminUserIDs() []string {
+	adminUserIDs := os.Getenv("MERCURIO_ADMIN_USER_IDS")
+	if adminUserIDs == "" {
+		return []string{}
+	}
+	return strings.Split(adminUserIDs, ",")
+}
+
+// GetTenantSettings as per MERCURIO_TENANTS (comma separated tenant IDs) and, for each one of them,
+// MERCURIO_TENANT_<ID>_CORS_ALLOWED_ORIGINS, MERCURIO_TENANT_<ID>_MAX_BROADCAST_DESTINATIONS and
+// MERCURIO_TENANT_<ID>_MAX_DATA_SIZE. When MERCURIO_TENANTS is not provided, any tenant is accepted with no limits
+func GetTenantSettings() ([]TenantSettings, error) {
+	tenantIDs := os.Getenv("MERCURIO_TENANTS")
+	if tenantIDs == "" {
+		return []TenantSettings{}, nil
+	}
+
+	settings := []TenantSettings{}
+	for _, tenantID := range strings.Split(tenantIDs, ",") {
+		prefix := "MERCURIO_TENANT_" + strings.ToUpper(tenantID) + "_"
+
+		tenant := TenantSettings{
+			ID: tenantID,
+		}
+
+		allowedOrigins := os.Getenv(prefix + "CORS_ALLOWED_ORIGINS")
+		if allowedOrigins != "" {
+			tenant.AllowedOrigins = strings.Split(allowedOrigins, ",")
+		}
+
+		maxBroadcastDestinations, err := getEnvInt(prefix+"MAX_BROADCAST_DESTINATIONS", 0)
+		if err != nil {
+			return nil, err
+		}
+		tenant.MaxBroadcastDestinations = maxBroadcastDestinations
+
+		maxDataSize, err := getEnvInt(prefix+"MAX_DATA_SIZE", 0)
+		if err != nil {
+			return nil, err
+		}
+		tenant.MaxDataSize = maxDataSize
+
+		settings = append(settings, tenant)
+	}
+
+	return settings, nil
+}
+
+func getEnvInt(name string, defaultValue int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("environment variable %s must be a number", name)
+	}
+
+	return number, nil
+}
+
+func getEnvDuration(name string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("environment variable %s must be a duration (e.g. 30s, 5m)", name)
+	}
+
+	return duration, nil
+}
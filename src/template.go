@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// ErrNotificationTemplateNotFound is returned when a notification template doesn't exist in database
+var ErrNotificationTemplateNotFound = errors.New("notification template not found")
+
+// localePattern tells what a locale looks like, as far as language tags go, e.g. en, pt-BR or zh-Hant-TW
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// NotificationTemplate is the persistent record of how notifications of an event type read in a given locale. Title,
+// body and action URL are Go text/template templates, which see the notification as TemplateData
+type NotificationTemplate struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	TenantID  string    `json:"tenantID,omitempty" gorm:"not null"`
+	EventType string    `json:"eventType" gorm:"not null"`
+	Locale    string    `json:"locale" gorm:"not null"`
+	Title     string    `json:"title" gorm:"not null"`
+	Body      string    `json:"body" gorm:"not null"`
+	ActionURL string    `json:"actionURL,omitempty" gorm:"column:action_url;not null"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// NewNotificationTemplate creates a new NotificationTemplate, making sure its locale is a valid one and its
+// templates parse
+func NewNotificationTemplate(eventType string, locale string, title string, body string, actionURL string) (*NotificationTemplate, error) {
+	if !localePattern.MatchString(canonicalLocale(locale)) {
+		return nil, fmt.Errorf("%s is not a valid locale", locale)
+	}
+	if title == "" && body == "" {
+		return nil, errors.New("either title or body must be given")
+	}
+
+	notificationTemplate := &NotificationTemplate{
+		EventType: eventType,
+		Locale:    canonicalLocale(locale),
+		Title:     title,
+		Body:      body,
+		ActionURL: actionURL,
+	}
+
+	_, err := notificationTemplate.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return notificationTemplate, nil
+}
+
+// NotificationTemplateRepository is the interface to notification template datastore. Every operation is bound to
+// one tenant, the default one unless it was scoped by ForTenant. Save adds a template, or replaces the one of the same
+// event type and locale, and Find gives the templates of an event type in any of the given locales
+type NotificationTemplateRepository interface {
+	ForTenant(tenantID string) NotificationTemplateRepository
+	Save(ctx context.Context, notificationTemplate *NotificationTemplate) error
+	Find(ctx context.Context, eventType string, locales []string) ([]NotificationTemplate, error)
+	GetAll(ctx context.Context, eventType string) ([]NotificationTemplate, error)
+	Delete(ctx context.Context, eventType string, locale string) error
+	DeleteAll(ctx context.Context, eventType string) error
+}
+
+// TemplateData is what templates see of a notification, e.g. {{.Data.orderID}}
+type TemplateData struct {
+	EventID   string
+	Type      string
+	SourceID  string
+	ClientID  string
+	Locale    string
+	CreatedAt time.Time
+	Data      interface{}
+}
+
+// RenderedNotification is a notification as people read it, in the locale it was rendered in
+type RenderedNotification struct {
+	Locale    string `json:"locale"`
+	Title     string `json:"title,omitempty"`
+	Body      string `json:"body,omitempty"`
+	ActionURL string `json:"actionURL,omitempty"`
+}
+
+type compiledTemplate struct {
+	updatedAt time.Time
+	locale    string
+	title     *template.Template
+	body      *template.Template
+	actionURL *template.Template
+}
+
+func (notificationTemplate NotificationTemplate) compile() (*compiledTemplate, error) {
+	compiled := &compiledTemplate{
+		updatedAt: notificationTemplate.UpdatedAt,
+		locale:    notificationTemplate.Locale,
+	}
+
+	parts := []struct {
+		name   string
+		text   string
+		target **template.Template
+	}{
+		{"title", notificationTemplate.Title, &compiled.title},
+		{"body", notificationTemplate.Body, &compiled.body},
+		{"actionURL", notificationTemplate.ActionURL, &compiled.actionURL},
+	}
+	for _, part := range parts {
+		parsed, err := template.New(part.name).Option("missingkey=error").Parse(part.text)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid template: %s", part.name, err)
+		}
+		*part.target = parsed
+	}
+
+	return compiled, nil
+}
+
+func (compiled *compiledTemplate) render(data TemplateData) (*RenderedNotification, error) {
+	data.Locale = compiled.locale
+	rendered := &RenderedNotification{Locale: compiled.locale}
+
+	parts := []struct {
+		template *template.Template
+		target   *string
+	}{
+		{compiled.title, &rendered.Title},
+		{compiled.body, &rendered.Body},
+		{compiled.actionURL, &rendered.ActionURL},
+	}
+	for _, part := range parts {
+		var buffer bytes.Buffer
+		err := part.template.Execute(&buffer, data)
+		if err != nil {
+			return nil, err
+		}
+		*part.target = buffer.String()
+	}
+
+	return rendered, nil
+}
+
+// TemplateRenderer renders notifications as per the templates of their event types, in the locale closest to the
+// ones a client prefers. Compiled templates are kept as long as they don't change
+type TemplateRenderer struct {
+	repository    NotificationTemplateRepository
+	defaultLocale string
+
+	mutex    sync.Mutex
+	compiled map[string]*compiledTemplate
+}
+
+// NewTemplateRenderer creates a new TemplateRenderer on top of a notification template repository, falling back to
+// the given default locale when none of a client suits
+func NewTemplateRenderer(repository NotificationTemplateRepository, defaultLocale string) *TemplateRenderer {
+	return &TemplateRenderer{
+		repository:    repository,
+		defaultLocale: canonicalLocale(defaultLocale),
+		compiled:      map[string]*compiledTemplate{},
+	}
+}
+
+// Session to render notifications of a tenant for a client which prefers the given locales, the most preferred
+// first. It looks templates up once per event type, so it is meant for one request, or one flush of a stream
+func (renderer *TemplateRenderer) Session(tenantID string, locales []string) *RenderSession {
+	return &RenderSession{
+		renderer:  renderer,
+		tenantID:  tenantID,
+		locales:   renderer.candidateLocales(locales),
+		templates: map[string]*compiledTemplate{},
+	}
+}
+
+// candidateLocales to look templates up in, in order: each given locale followed by its language, and then the
+// default locale likewise
+func (renderer *TemplateRenderer) candidateLocales(locales []string) []string {
+	candidates := []string{}
+	seen := map[string]bool{}
+	add := func(locale string) {
+		if locale != "" && !seen[locale] {
+			seen[locale] = true
+			candidates = append(candidates, locale)
+		}
+	}
+
+	for _, locale := range append(append([]string{}, locales...), renderer.defaultLocale) {
+		locale = canonicalLocale(locale)
+		if !localePattern.MatchString(locale) {
+			continue
+		}
+		add(locale)
+		add(strings.SplitN(locale, "-", 2)[0])
+	}
+
+	return candidates
+}
+
+func (renderer *TemplateRenderer) compile(tenantID string, notificationTemplate NotificationTemplate) (*compiledTemplate, error) {
+	key := tenantID + "/" + notificationTemplate.EventType + "/" + notificationTemplate.Locale
+
+	renderer.mutex.Lock()
+	defer renderer.mutex.Unlock()
+
+	compiled, ok := renderer.compiled[key]
+	if ok && compiled.updatedAt.Equal(notificationTemplate.UpdatedAt) {
+		return compiled, nil
+	}
+
+	compiled, err := notificationTemplate.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	renderer.compiled[key] = compiled
+	return compiled, nil
+}
+
+// RenderSession renders notifications for one client, see TemplateRenderer.Session
+type RenderSession struct {
+	renderer  *TemplateRenderer
+	tenantID  string
+	locales   []string
+	templates map[string]*compiledTemplate
+}
+
+// Render a notification, which is nil when its event type has no template in any suitable locale
+func (session *RenderSession) Render(ctx context.Context, notification Notification) (*RenderedNotification, error) {
+	if notification.Type == "" {
+		return nil, nil
+	}
+
+	compiled, err := session.template(ctx, notification.Type)
+	if err != nil || compiled == nil {
+		return nil, err
+	}
+
+	data := TemplateData{
+		EventID:   notification.EventID,
+		Type:      notification.Type,
+		SourceID:  notification.SourceID,
+		ClientID:  notification.DestinationID,
+		CreatedAt: notification.CreatedAt,
+	}
+	if notification.Data != "" {
+		err = json.Unmarshal([]byte(notification.Data), &data.Data)
+		if err != nil {
+			// Data stored before it was JSON is nothing but text
+			data.Data = string(notification.Data)
+		}
+	}
+
+	rendered, err := compiled.render(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render notification of event type %s due to: %s", notification.Type, err)
+	}
+
+	return rendered, nil
+}
+
+// template of an event type in the most suitable locale, if any, which is looked up once per session
+func (session *RenderSession) template(ctx context.Context, eventType string) (*compiledTemplate, error) {
+	compiled, ok := session.templates[eventType]
+	if ok {
+		return compiled, nil
+	}
+
+	templates, err := session.renderer.repository.ForTenant(session.tenantID).Find(ctx, eventType, session.locales)
+	if err != nil {
+		return nil, err
+	}
+
+	byLocale := map[string]NotificationTemplate{}
+	for _, notificationTemplate := range templates {
+		byLocale[notificationTemplate.Locale] = notificationTemplate
+	}
+
+	for _, locale := range session.locales {
+		notificationTemplate, ok := byLocale[locale]
+		if !ok {
+			continue
+		}
+
+		compiled, err = session.renderer.compile(session.tenantID, notificationTemplate)
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	session.templates[eventType] = compiled
+	return compiled, nil
+}
+
+// canonicalLocale spells a locale the usual way, e.g. pt_br is pt-BR
+func canonicalLocale(locale string) string {
+	subtags := strings.Split(strings.ReplaceAll(locale, "_", "-"), "-")
+	for i, subtag := range subtags {
+		switch {
+		case i == 0:
+			subtags[i] = strings.ToLower(subtag)
+		case len(subtag) == 2:
+			subtags[i] = strings.ToUpper(subtag)
+		case len(subtag) == 4:
+			subtags[i] = strings.ToUpper(subtag[:1]) + strings.ToLower(subtag[1:])
+		default:
+			subtags[i] = strings.ToLower(subtag)
+		}
+	}
+	return strings.Join(subtags, "-")
+}
+
+// requestLocales a client prefers, the most preferred first: the one of the query string locale, if given, or else
+// the ones of the Accept-Language header, as per their weight
+func requestLocales(r *http.Request) []string {
+	if locale := r.URL.Query().Get("locale"); locale != "" {
+		return []string{locale}
+	}
+
+	type weighted struct {
+		locale string
+		weight float64
+	}
+
+	ranges := []weighted{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		locale := strings.TrimSpace(fields[0])
+		if locale == "" || locale == "*" {
+			continue
+		}
+
+		weight := 1.0
+		for _, parameter := range fields[1:] {
+			parameter = strings.TrimSpace(parameter)
+			if strings.HasPrefix(parameter, "q=") {
+				parsed, err := strconv.ParseFloat(parameter[2:], 64)
+				if err == nil {
+					weight = parsed
+				}
+			}
+		}
+		if weight > 0 {
+			ranges = append(ranges, weighted{locale: locale, weight: weight})
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].weight > ranges[j].weight
+	})
+
+	locales := []string{}
+	for _, languageRange := range ranges {
+		locales = append(locales, languageRange.locale)
+	}
+	return locales
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestRequestLocales(t *testing.T) {
+	tests := []struct {
+		url            string
+		acceptLanguage string
+		expected       string
+	}{
+		{"/", "", "[]"},
+		{"/", "pt-BR,pt;q=0.9,en;q=0.8", "[pt-BR pt en]"},
+		{"/", "en;q=0.5, fr, *;q=0.1, de;q=0", "[fr en]"},
+		{"/?locale=es", "pt-BR", "[es]"},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", test.url, nil)
+		r.Header.Set("Accept-Language", test.acceptLanguage)
+		assertContent(t, fmt.Sprint(requestLocales(r)), test.expected)
+	}
+}
+
+func TestTemplateRenderer_CandidateLocales(t *testing.T) {
+	renderer := NewTemplateRenderer(nil, "en-US")
+
+	assertContent(t, fmt.Sprint(renderer.candidateLocales([]string{"pt_br", "fr", "not a locale"})), "[pt-BR pt fr en-US en]")
+	assertContent(t, fmt.Sprint(renderer.candidateLocales(nil)), "[en-US en]")
+}
+
+func TestNotificationTemplate_Render(t *testing.T) {
+	_, err := NewNotificationTemplate("order.shipped", "en", "", "", "")
+	if err == nil {
+		t.Error("template with neither title nor body should be refused")
+	}
+
+	_, err = NewNotificationTemplate("order.shipped", "en", "Order {{.Data.orderID", "", "")
+	if err == nil {
+		t.Error("template which doesn't parse should be refused")
+	}
+
+	notificationTemplate, err := NewNotificationTemplate("order.shipped", "pt_br", "Pedido {{.Data.orderID}} enviado", "Pela {{.Data.carrier}}", "https://example.com/orders/{{.Data.orderID}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, notificationTemplate.Locale, "pt-BR")
+
+	compiled, err := notificationTemplate.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rendered, err := compiled.render(TemplateData{Data: map[string]interface{}{"orderID": 42, "carrier": "ACME"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, *rendered, RenderedNotification{Locale: "pt-BR", Title: "Pedido 42 enviado", Body: "Pela ACME", ActionURL: "https://example.com/orders/42"})
+
+	// Missing variables are an error rather than a "<no value>" for people to read
+	_, err = compiled.render(TemplateData{Data: map[string]interface{}{"orderID": 42}})
+	if err == nil {
+		t.Error("rendering with a missing variable should fail")
+	}
+}
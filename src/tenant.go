@@ -0,0 +1,74 @@
+package main
+
+// DefaultTenantID is the tenant of credentials that don't tell one, which is what a single tenant deployment looks like
+const DefaultTenantID = "default"
+
+// TenantSettings holds in per-tenant settings. Zero limits mean no limits at all
+type TenantSettings struct {
+	ID                       string
+	AllowedOrigins           []string
+	MaxBroadcastDestinations int
+	MaxDataSize              int
+}
+
+// IsOriginAllowed tells whether a request coming from the given origin (i.e. Origin header) is allowed for
+// the tenant. Requests without an origin (i.e. not from browsers) are always allowed
+func (t TenantSettings) IsOriginAllowed(origin string) bool {
+	if origin == "" || len(t.AllowedOrigins) == 0 {
+		return true
+	}
+
+	for _, allowedOrigin := range t.AllowedOrigins {
+		if allowedOrigin == "*" || allowedOrigin == origin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TenantRegistry holds in the known tenants. An empty registry means any tenant is known and has no limits
+type TenantRegistry struct {
+	tenants map[string]TenantSettings
+}
+
+// NewTenantRegistry creates a new TenantRegistry for the given tenant settings
+func NewTenantRegistry(settings []TenantSettings) *TenantRegistry {
+	registry := &TenantRegistry{
+		tenants: make(map[string]TenantSettings),
+	}
+
+	for _, tenant := range settings {
+		registry.tenants[tenant.ID] = tenant
+	}
+
+	return registry
+}
+
+// Get the settings of a tenant, also telling whether it is a known one
+func (r *TenantRegistry) Get(tenantID string) (TenantSettings, bool) {
+	if len(r.tenants) == 0 {
+		return TenantSettings{ID: tenantID}, true
+	}
+
+	tenant, exists := r.tenants[tenantID]
+	return tenant, exists
+}
+
+// AllowedOrigins gives every origin allowed by any tenant, which the CORS layer must let in before we get
+// to know the tenant of a request
+func (r *TenantRegistry) AllowedOrigins() []string {
+	origins := []string{}
+	for _, tenant := range r.tenants {
+		origins = append(origins, tenant.AllowedOrigins...)
+	}
+	return origins
+}
+
+// ClientKey is how a client is uniquely identified across tenants, e.g. on the Broker's client registry
+func ClientKey(tenantID string, clientID string) string {
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+	return tenantID + "/" + clientID
+}
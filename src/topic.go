@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// topicMaxLength is as long as a topic gets, so that every database indexes it
+const topicMaxLength = 191
+
+// ErrTopicSubscriptionNotFound is returned when a client is not subscribed to a topic
+var ErrTopicSubscriptionNotFound = errors.New("topic subscription not found")
+
+// TopicSubscription is the persistent record of a client subscribed to a topic, which gets whatever is published to
+// the topic (e.g. by a recurring schedule)
+type TopicSubscription struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	TenantID  string    `json:"-" gorm:"not null"`
+	ClientID  string    `json:"clientID" gorm:"not null"`
+	Topic     string    `json:"topic" gorm:"not null"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+}
+
+// checkTopic makes sure a topic is given and is not too long
+func checkTopic(topic string) error {
+	if topic == "" {
+		return errors.New("topic must be given")
+	}
+	if len(topic) > topicMaxLength {
+		return fmt.Errorf("topic must not be longer than %d characters", topicMaxLength)
+	}
+	return nil
+}
+
+// TopicSubscriptionRepository is the interface to topic subscription datastore. Every operation is bound to one
+// tenant, the default one unless it was scoped by ForTenant. Subscribe leaves a client subscribed to a topic, whether
+// it already was or not, and Subscribers gives the ID of every client subscribed to a topic
+type TopicSubscriptionRepository interface {
+	ForTenant(tenantID string) TopicSubscriptionRepository
+	Subscribe(ctx context.Context, subscription *TopicSubscription) error
+	Unsubscribe(ctx context.Context, clientID string, topic string) (TopicSubscription, error)
+	GetAll(ctx context.Context, clientID string) ([]TopicSubscription, error)
+	Subscribers(ctx context.Context, topic string) ([]string, error)
+}
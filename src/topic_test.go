@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestTopicSubscriptionRepository(t *testing.T) *SQLTopicSubscriptionRepository {
+	repository, err := NewSQLTopicSubscriptionRepository(newTestSqliteDatabase(t), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repository
+}
+
+func TestSQLTopicSubscriptionRepository(t *testing.T) {
+	ctx := context.Background()
+	repository := newTestTopicSubscriptionRepository(t)
+
+	// Subscribing over and over leaves a client subscribed once
+	ids := []uint{}
+	for _, clientID := range []string{"1", "2", "2"} {
+		subscription := &TopicSubscription{ClientID: clientID, Topic: "news"}
+		err := repository.Subscribe(ctx, subscription)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContent(t, subscription.TenantID, DefaultTenantID)
+		ids = append(ids, subscription.ID)
+	}
+	assertContent(t, ids[1] != ids[0], true)
+	assertContent(t, ids[2], ids[1])
+
+	err := repository.Subscribe(ctx, &TopicSubscription{ClientID: "2", Topic: "sports"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repository.ForTenant("acme").Subscribe(ctx, &TopicSubscription{ClientID: "3", Topic: "news"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tenants never see each other's subscribers
+	subscribers, err := repository.Subscribers(ctx, "news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(subscribers), 2)
+	assertContent(t, subscribers[0], "1")
+	assertContent(t, subscribers[1], "2")
+
+	subscribers, err = repository.ForTenant("acme").Subscribers(ctx, "news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(subscribers), 1)
+	assertContent(t, subscribers[0], "3")
+
+	subscriptions, err := repository.GetAll(ctx, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(subscriptions), 2)
+	assertContent(t, subscriptions[0].Topic, "news")
+	assertContent(t, subscriptions[1].Topic, "sports")
+
+	// Unsubscribing twice is one time too many
+	subscription, err := repository.Unsubscribe(ctx, "2", "news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, subscription.ID, ids[1])
+
+	_, err = repository.Unsubscribe(ctx, "2", "news")
+	assertContent(t, err, ErrTopicSubscriptionNotFound)
+
+	_, err = repository.ForTenant("acme").Unsubscribe(ctx, "1", "news")
+	assertContent(t, err, ErrTopicSubscriptionNotFound)
+
+	subscribers, err = repository.Subscribers(ctx, "news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, len(subscribers), 1)
+	assertContent(t, subscribers[0], "1")
+}